@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var copyUpCommand = cli.Command{
+	Name:      "copy-up",
+	Usage:     "Copy up additional directories",
+	ArgsUsage: "[flags] DIR [DIR...]",
+	Action:    copyUpAction,
+}
+
+func copyUpAction(clicontext *cli.Context) error {
+	dirs := clicontext.Args()
+	if len(dirs) == 0 {
+		return errors.Errorf("no directory specified")
+	}
+	c, err := newClient(clicontext)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	copied, err := c.CopyUpManager().CopyUp(ctx, dirs)
+	for _, d := range copied {
+		fmt.Println(d)
+	}
+	return err
+}
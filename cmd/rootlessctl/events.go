@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/rootless-containers/rootlesskit/pkg/api"
+)
+
+var eventsCommand = cli.Command{
+	Name:      "events",
+	Usage:     "Stream events (port added/removed, child started/exited, network ready) until the child exits",
+	ArgsUsage: "[flags]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Prints as JSON, one event per line",
+		},
+		cli.Uint64Flag{
+			Name:  "since",
+			Usage: "Replay the retained events after the event ID (0 for all the retained events) before streaming new events",
+		},
+	},
+	Action: eventsAction,
+}
+
+func eventsAction(clicontext *cli.Context) error {
+	c, err := newClient(clicontext)
+	if err != nil {
+		return err
+	}
+	var since *uint64
+	if clicontext.IsSet("since") {
+		s := clicontext.Uint64("since")
+		since = &s
+	}
+	ctx := context.Background()
+	return c.Events(ctx, since, func(ev api.Event) error {
+		if clicontext.Bool("json") {
+			m, err := json.Marshal(ev)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(m))
+			return nil
+		}
+		fmt.Printf("%d\t%s\t%s%s\n", ev.ID, ev.Time.Format(time.RFC3339), ev.Type, eventDetails(ev))
+		return nil
+	})
+}
+
+// eventDetails returns the details of the event for the human-readable output, e.g. " pid=42 exitCode=0"
+func eventDetails(ev api.Event) string {
+	var s string
+	if ev.PID != 0 {
+		s += fmt.Sprintf(" pid=%d", ev.PID)
+	}
+	if ev.ExitCode != nil {
+		s += fmt.Sprintf(" exitCode=%d", *ev.ExitCode)
+	}
+	if p := ev.Port; p != nil {
+		s += fmt.Sprintf(" id=%d", p.ID)
+		if p.Spec.Proto != "" {
+			s += fmt.Sprintf(" %s:%s:%s/%s", p.Spec.ParentIP, portRangeString(p.Spec.ParentPort, p.Spec.PortCount),
+				portRangeString(p.Spec.ChildPort, p.Spec.PortCount), p.Spec.Proto)
+		}
+	}
+	return s
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"github.com/rootless-containers/rootlesskit/pkg/child"
+	"github.com/rootless-containers/rootlesskit/pkg/instance"
+	"github.com/rootless-containers/rootlesskit/pkg/parent"
+)
+
+var execCommand = cli.Command{
+	Name:      "exec",
+	Usage:     "Execute a command in the namespaces of the child, with the environment written by \"rootlesskit --export-env\"",
+	ArgsUsage: "COMMAND [ARG...]",
+	// the flags are passed to COMMAND
+	SkipFlagParsing: true,
+	Action:          execAction,
+}
+
+func execAction(clicontext *cli.Context) error {
+	args := clicontext.Args()
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return errors.New("no command specified")
+	}
+	stateDir, err := stateDir(clicontext)
+	if err != nil {
+		return err
+	}
+	pidPath := filepath.Join(stateDir, parent.StateFileChildPID)
+	b, err := ioutil.ReadFile(pidPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the PID of the child (the child may not be ready yet)")
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse %s", pidPath)
+	}
+	env, err := child.ReadEnvFile(stateDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		logrus.Warnf("%s is not found in %s (\"rootlesskit --export-env\" is not specified?), using the current environment", child.StateFileEnv, stateDir)
+		env = os.Environ()
+	} else {
+		env = unmaskEnv(env, os.Environ())
+	}
+	nsenter, err := exec.LookPath("nsenter")
+	if err != nil {
+		return err
+	}
+	nsenterArgs, err := nsenterArgs(pid, args)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("executing %v", nsenterArgs)
+	return syscall.Exec(nsenter, nsenterArgs, env)
+}
+
+// stateDir returns the state directory of the instance specified with --name, or $ROOTLESSKIT_STATE_DIR.
+func stateDir(clicontext *cli.Context) (string, error) {
+	if name := clicontext.GlobalString("name"); name != "" {
+		return instance.StateDir(name)
+	}
+	if stateDir := os.Getenv("ROOTLESSKIT_STATE_DIR"); stateDir != "" {
+		return stateDir, nil
+	}
+	return "", errors.New("please specify --name or set $ROOTLESSKIT_STATE_DIR")
+}
+
+// unmaskEnv returns env with the variables masked by "rootlesskit --mask-env" taken from current.
+// The masked variables that are not set in current are removed.
+func unmaskEnv(env, current []string) []string {
+	values := make(map[string]string, len(current))
+	for _, kv := range current {
+		if i := strings.Index(kv, "="); i >= 0 {
+			values[kv[:i]] = kv[i+1:]
+		}
+	}
+	res := make([]string, 0, len(env))
+	for _, kv := range env {
+		i := strings.Index(kv, "=")
+		if i < 0 || kv[i+1:] != child.MaskedEnvValue {
+			res = append(res, kv)
+			continue
+		}
+		if v, ok := values[kv[:i]]; ok {
+			res = append(res, kv[:i]+"="+v)
+		}
+	}
+	return res
+}
+
+// nsenterArgs returns the argv of nsenter(1) for executing args in the namespaces of pid.
+// The namespaces shared with the current process are not entered, e.g. the network namespace for "--net=host",
+// as setns(2) into them fails after entering the user namespace of pid.
+func nsenterArgs(pid int, args []string) ([]string, error) {
+	res := []string{"nsenter", "-t", strconv.Itoa(pid)}
+	for _, ns := range []struct {
+		name string
+		flag string
+	}{
+		{"user", "-U"},
+		{"mnt", "-m"},
+		{"net", "-n"},
+		{"pid", "-p"},
+		{"ipc", "-i"},
+		{"uts", "-u"},
+		{"cgroup", "-C"},
+	} {
+		self, err := os.Readlink("/proc/self/ns/" + ns.name)
+		if os.IsNotExist(err) {
+			// not supported by the kernel
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		target, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/%s", pid, ns.name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to inspect the %s namespace of the child", ns.name)
+		}
+		if self == target {
+			continue
+		}
+		res = append(res, ns.flag)
+		if ns.name == "user" {
+			res = append(res, "--preserve-credentials")
+		}
+	}
+	res = append(res, "--")
+	return append(res, args...), nil
+}
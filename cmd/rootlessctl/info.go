@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/urfave/cli"
+)
+
+var infoCommand = cli.Command{
+	Name:      "info",
+	Usage:     "Show info",
+	ArgsUsage: "[flags]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Prints as JSON",
+		},
+	},
+	Action: infoAction,
+}
+
+func infoAction(clicontext *cli.Context) error {
+	c, err := newClient(clicontext)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	info, err := c.Info(ctx)
+	if err != nil {
+		return err
+	}
+	if clicontext.Bool("json") {
+		m, err := json.MarshalIndent(info, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(m))
+		return nil
+	}
+	fmt.Printf("- REST API version: %s\n", info.APIVersion)
+	fmt.Printf("- Implementation version: %s\n", info.Version)
+	if info.GitCommit != "" {
+		fmt.Printf("- Git commit: %s\n", info.GitCommit)
+	}
+	fmt.Printf("- Go version: %s\n", info.GoVersion)
+	fmt.Printf("- State Directory: %s\n", info.StateDir)
+	fmt.Printf("- Child PID: %d\n", info.ChildPID)
+	if u := info.ChildUsage; u != nil {
+		fmt.Printf("  - Processes: %d\n", u.Processes)
+		fmt.Printf("  - RSS: %d KiB\n", u.RSS/1024)
+		fmt.Printf("  - CPU time: %.2fs (user), %.2fs (system)\n", u.UserCPUSeconds, u.SystemCPUSeconds)
+	}
+	if info.NetworkDriver != nil {
+		fmt.Printf("- Network Driver: %s\n", info.NetworkDriver.Driver)
+		if info.NetworkDriver.HelperVersion != "" {
+			fmt.Printf("  - Helper version: %s\n", info.NetworkDriver.HelperVersion)
+		}
+		if h := info.NetworkDriver.Helper; h != nil {
+			fmt.Printf("  - Helper PID: %d\n", h.PID)
+			fmt.Printf("  - Helper RSS: %d KiB\n", h.RSS/1024)
+			fmt.Printf("  - Helper CPU time: %.2fs (user), %.2fs (system)\n", h.UserCPUSeconds, h.SystemCPUSeconds)
+		}
+		if s := info.NetworkDriver.DevStats; s != nil {
+			// not the statistics of the helper, which no supported helper provides
+			fmt.Printf("  - Device in the child: %s (counted by the kernel in the child network namespace)\n", s.Dev)
+			fmt.Printf("  - RX: %d packets, %d bytes, %d errors, %d dropped\n", s.RxPackets, s.RxBytes, s.RxErrors, s.RxDropped)
+			fmt.Printf("  - TX: %d packets, %d bytes, %d errors, %d dropped\n", s.TxPackets, s.TxBytes, s.TxErrors, s.TxDropped)
+		}
+	}
+	return nil
+}
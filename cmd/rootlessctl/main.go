@@ -10,6 +10,7 @@ import (
 	"github.com/urfave/cli"
 
 	"github.com/rootless-containers/rootlesskit/pkg/api/client"
+	"github.com/rootless-containers/rootlesskit/pkg/instance"
 	"github.com/rootless-containers/rootlesskit/pkg/version"
 )
 
@@ -25,15 +26,31 @@ func main() {
 			Usage:       "debug mode",
 			Destination: &debug,
 		},
+		cli.StringFlag{
+			Name:  "name",
+			Usage: "Name of the instance (\"rootlesskit --name\"), using $XDG_RUNTIME_DIR/rootlesskit/<name>/api.sock as the socket",
+		},
 		cli.StringFlag{
 			Name:  "socket",
-			Usage: "Path to api.sock (under the \"rootlesskit --state-dir\" directory), defaults to $ROOTLESSKIT_STATE_DIR/api.sock",
+			Usage: "Path to api.sock (under the \"rootlesskit --state-dir\" directory), defaults to $ROOTLESSKIT_API_SOCKET, then $ROOTLESSKIT_STATE_DIR/api.sock. \"tcp://host:port\" is also accepted.",
+		},
+		cli.StringFlag{
+			Name:   "token",
+			Usage:  "Bearer token for the API (\"rootlesskit --api-token\")",
+			EnvVar: "ROOTLESSKIT_API_TOKEN",
 		},
 	}
 	app.Commands = []cli.Command{
 		listPortsCommand,
 		addPortsCommand,
 		removePortsCommand,
+		infoCommand,
+		copyUpCommand,
+		getMTUCommand,
+		setMTUCommand,
+		startCommand,
+		eventsCommand,
+		execCommand,
 	}
 	app.Before = func(clicontext *cli.Context) error {
 		if debug {
@@ -53,12 +70,25 @@ func main() {
 
 func newClient(clicontext *cli.Context) (client.Client, error) {
 	socketPath := clicontext.GlobalString("socket")
+	if name := clicontext.GlobalString("name"); name != "" {
+		if socketPath != "" {
+			return nil, errors.New("--name cannot be combined with --socket")
+		}
+		stateDir, err := instance.StateDir(name)
+		if err != nil {
+			return nil, err
+		}
+		socketPath = filepath.Join(stateDir, "api.sock")
+	}
+	if socketPath == "" {
+		socketPath = os.Getenv("ROOTLESSKIT_API_SOCKET")
+	}
 	if socketPath == "" {
 		stateDir := os.Getenv("ROOTLESSKIT_STATE_DIR")
 		if stateDir == "" {
-			return nil, errors.New("please specify --socket or set $ROOTLESSKIT_STATE_DIR")
+			return nil, errors.New("please specify --socket or set $ROOTLESSKIT_API_SOCKET or $ROOTLESSKIT_STATE_DIR")
 		}
 		socketPath = filepath.Join(stateDir, "api.sock")
 	}
-	return client.New(socketPath)
+	return client.NewWithToken(socketPath, clicontext.GlobalString("token"))
 }
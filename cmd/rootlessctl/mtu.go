@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var getMTUCommand = cli.Command{
+	Name:      "get-mtu",
+	Usage:     "Show the MTU of the network interface in the child",
+	ArgsUsage: "[flags]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Prints as JSON",
+		},
+	},
+	Action: getMTUAction,
+}
+
+func getMTUAction(clicontext *cli.Context) error {
+	c, err := newClient(clicontext)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	mtu, err := c.MTU(ctx)
+	if err != nil {
+		return err
+	}
+	if clicontext.Bool("json") {
+		m, err := json.MarshalIndent(mtu, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(m))
+		return nil
+	}
+	fmt.Println(mtu.MTU)
+	return nil
+}
+
+var setMTUCommand = cli.Command{
+	Name:      "set-mtu",
+	Usage:     "Set the MTU of the network interface in the child",
+	ArgsUsage: "[flags] MTU",
+	Action:    setMTUAction,
+}
+
+func setMTUAction(clicontext *cli.Context) error {
+	if clicontext.NArg() != 1 {
+		return errors.New("expected exactly one MTU")
+	}
+	mtu, err := strconv.Atoi(clicontext.Args().First())
+	if err != nil {
+		return errors.Wrapf(err, "invalid MTU %q", clicontext.Args().First())
+	}
+	c, err := newClient(clicontext)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	return c.SetMTU(ctx, mtu)
+}
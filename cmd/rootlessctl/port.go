@@ -56,24 +56,64 @@ func listPortsAction(clicontext *cli.Context) error {
 		return err
 	}
 	for _, p := range portStatuses {
-		if _, err := fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t\n",
-			p.ID, p.Spec.Proto, p.Spec.ParentIP, p.Spec.ParentPort, p.Spec.ChildPort); err != nil {
+		if _, err := fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t\n",
+			p.ID, p.Spec.Proto, p.Spec.ParentIP, portRangeString(p.Spec.ParentPort, p.Spec.PortCount), portRangeString(p.Spec.ChildPort, p.Spec.PortCount)); err != nil {
 			return err
 		}
 	}
 	return w.Flush()
 }
 
+// portRangeString returns "10000-20000" for (10000, 10001), and "8080" for (8080, 0)
+func portRangeString(start, count int) string {
+	if count <= 1 {
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d-%d", start, start+count-1)
+}
+
 var addPortsCommand = cli.Command{
 	Name:        "add-ports",
 	Usage:       "Add ports",
 	ArgsUsage:   "[flags] PARENTIP:PARENTPORT:CHILDPORT/PROTO [PARENTIP:PARENTPORT:CHILDPORT/PROTO...]",
-	Description: "Add exposed ports. The port spec is similar to `docker run -p`. e.g. \"127.0.0.1:8080:80/tcp\".",
+	Description: "Add exposed ports. The port spec is similar to `docker run -p`. e.g. \"127.0.0.1:8080:80/tcp\".\n   The child IP can be optionally specified before the child port. e.g. \"127.0.0.1:8080:10.0.99.1:80/tcp\".\n   UNIX sockets can be specified for either side (builtin port driver, tcp only). e.g. \"127.0.0.1:2375:unix:///run/docker.sock\", \"unix:///tmp/foo.sock:80/tcp\".\n   Abstract UNIX sockets in the child can be forwarded to the parent (builtin port driver). e.g. \"@/tmp/.X11-unix/X0\", \"@/tmp/.X11-unix/X0=/tmp/.X11-unix/X0\".\n   vsock ports can be specified with the CID to listen on, or \"any\" (vsock port driver, tcp only). e.g. \"vsock://any:8080:80/tcp\".",
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "json",
 			Usage: "Prints as JSON",
 		},
+		cli.StringFlag{
+			Name:  "proxy-protocol",
+			Usage: "Send HAProxy PROXY protocol header to the child [v1, v2] (builtin port driver, tcp only)",
+		},
+		cli.BoolFlag{
+			Name:  "tcp-keepalive",
+			Usage: "Enable TCP keepalive on the forwarded connections (builtin port driver, tcp only)",
+		},
+		cli.IntFlag{
+			Name:  "tcp-keepalive-interval",
+			Usage: "TCP keepalive interval in seconds (default: 60)",
+		},
+		cli.IntFlag{
+			Name:  "max-connections",
+			Usage: "Maximum number of the simultaneous connections, 0 for unlimited (builtin port driver, tcp only)",
+		},
+		cli.BoolFlag{
+			Name:  "reuse-port",
+			Usage: "Set SO_REUSEPORT on the parent socket (builtin port driver only)",
+		},
+		cli.BoolFlag{
+			Name:  "tcp-fast-open",
+			Usage: "Enable TCP Fast Open on the parent socket and the child-side connections (builtin port driver, tcp only)",
+		},
+		cli.IntFlag{
+			Name:  "dial-timeout",
+			Usage: "Timeout in seconds for connecting to the child (default: 10) (builtin port driver only)",
+		},
+		cli.IntFlag{
+			Name:  "idle-timeout",
+			Usage: "Close the connections that transfer no byte for the timeout in seconds (default: 0, no timeout) (builtin port driver, tcp only)",
+		},
 	},
 	Action: addPortsAction,
 }
@@ -88,6 +128,14 @@ func addPortsAction(clicontext *cli.Context) error {
 		if err != nil {
 			return err
 		}
+		sp.ProxyProtocol = clicontext.String("proxy-protocol")
+		sp.TCPKeepAlive = clicontext.Bool("tcp-keepalive")
+		sp.TCPKeepAliveInterval = clicontext.Int("tcp-keepalive-interval")
+		sp.MaxConnections = clicontext.Int("max-connections")
+		sp.ReusePort = clicontext.Bool("reuse-port")
+		sp.TCPFastOpen = clicontext.Bool("tcp-fast-open")
+		sp.DialTimeout = clicontext.Int("dial-timeout")
+		sp.IdleTimeout = clicontext.Int("idle-timeout")
 		portSpecs = append(portSpecs, *sp)
 	}
 
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+
+	"github.com/urfave/cli"
+)
+
+var startCommand = cli.Command{
+	Name:      "start",
+	Usage:     "Start the command of \"rootlesskit --wait-start\"",
+	ArgsUsage: "[flags]",
+	Action:    startAction,
+}
+
+func startAction(clicontext *cli.Context) error {
+	c, err := newClient(clicontext)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	return c.Start(ctx)
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"github.com/rootless-containers/rootlesskit/pkg/api"
+	"github.com/rootless-containers/rootlesskit/pkg/common"
+	"github.com/rootless-containers/rootlesskit/pkg/copyup"
+	"github.com/rootless-containers/rootlesskit/pkg/network"
+	"github.com/rootless-containers/rootlesskit/pkg/network/bridge"
+	"github.com/rootless-containers/rootlesskit/pkg/network/lxcusernic"
+	"github.com/rootless-containers/rootlesskit/pkg/network/slirp4netns"
+	"github.com/rootless-containers/rootlesskit/pkg/network/socket"
+	"github.com/rootless-containers/rootlesskit/pkg/network/vdeplugslirp"
+	"github.com/rootless-containers/rootlesskit/pkg/network/vpnkit"
+	"github.com/rootless-containers/rootlesskit/pkg/parent"
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+	"github.com/rootless-containers/rootlesskit/pkg/port/builtin"
+	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/parent/accesslog"
+	slirp4netns_port "github.com/rootless-containers/rootlesskit/pkg/port/slirp4netns"
+	"github.com/rootless-containers/rootlesskit/pkg/port/socat"
+	"github.com/rootless-containers/rootlesskit/pkg/port/vsock"
+)
+
+// netParams are the network parameters parsed by createParentOpt, for the network and port drivers.
+type netParams struct {
+	mtu                      int
+	ipnet                    *net.IPNet
+	disableHostLoopback      bool
+	ipv6                     bool
+	ipv6Only                 bool
+	readyTimeout             time.Duration
+	slirp4netnsAPISocketPath string
+	netnsPath                string
+	ifname                   string
+	mac                      net.HardwareAddr
+	helperSeccomp            string
+}
+
+// netDriver is a value of --net.
+type netDriver struct {
+	api.Driver
+	// newParent returns nil for the host network.
+	newParent func(clicontext *cli.Context, p *netParams) (network.ParentDriver, error)
+	// newChild returns nil for the host network.
+	newChild func(clicontext *cli.Context) (network.ChildDriver, error)
+}
+
+// netDrivers are the values of --net, in the order of the flag usage.
+var netDrivers = []netDriver{
+	{
+		Driver:    api.Driver{Name: "host", Status: api.DriverStatusStable},
+		newParent: newHostParentDriver,
+		newChild: func(*cli.Context) (network.ChildDriver, error) {
+			return nil, nil
+		},
+	},
+	{
+		Driver:    api.Driver{Name: "slirp4netns", Status: api.DriverStatusStable},
+		newParent: newSlirp4netnsParentDriver,
+		newChild: func(*cli.Context) (network.ChildDriver, error) {
+			return slirp4netns.NewChildDriver(), nil
+		},
+	},
+	{
+		Driver:    api.Driver{Name: "vpnkit", Status: api.DriverStatusStable},
+		newParent: newVPNKitParentDriver,
+		newChild: func(clicontext *cli.Context) (network.ChildDriver, error) {
+			retries := clicontext.Int("vpnkit-reconnect-retries")
+			if retries < 0 {
+				return nil, errors.Errorf("negative --vpnkit-reconnect-retries: %d", retries)
+			}
+			return vpnkit.NewChildDriver(retries), nil
+		},
+	},
+	{
+		Driver:    api.Driver{Name: "lxc-user-nic", Status: api.DriverStatusExperimental},
+		newParent: newLXCUserNicParentDriver,
+		newChild: func(*cli.Context) (network.ChildDriver, error) {
+			return lxcusernic.NewChildDriver(), nil
+		},
+	},
+	{
+		Driver:    api.Driver{Name: "bridge", Status: api.DriverStatusExperimental},
+		newParent: newBridgeParentDriver,
+		newChild: func(*cli.Context) (network.ChildDriver, error) {
+			return bridge.NewChildDriver(), nil
+		},
+	},
+	{
+		Driver:    api.Driver{Name: "socket", Status: api.DriverStatusExperimental},
+		newParent: newSocketParentDriver,
+		newChild: func(*cli.Context) (network.ChildDriver, error) {
+			return socket.NewChildDriver(), nil
+		},
+	},
+	{
+		Driver:    api.Driver{Name: "vdeplug_slirp", Status: api.DriverStatusDeprecated},
+		newParent: newVDEPlugSlirpParentDriver,
+		newChild: func(*cli.Context) (network.ChildDriver, error) {
+			return vdeplugslirp.NewChildDriver(), nil
+		},
+	},
+}
+
+// lookupNetDriver returns the value of --net named name.
+func lookupNetDriver(name string) (*netDriver, error) {
+	for i := range netDrivers {
+		if netDrivers[i].Name == name {
+			return &netDrivers[i], nil
+		}
+	}
+	return nil, errors.Errorf("unknown network mode: %s", name)
+}
+
+// netDriverList returns the values of --net.
+func netDriverList() []api.Driver {
+	var res []api.Driver
+	for _, d := range netDrivers {
+		res = append(res, d.Driver)
+	}
+	return res
+}
+
+// portDriver is a value of --port-driver.
+type portDriver struct {
+	api.Driver
+	// newParent returns nil for --port-driver=none.
+	newParent func(clicontext *cli.Context, opt *parent.Opt, p *netParams) (port.ParentDriver, error)
+	// newChild returns nil for --port-driver=none.
+	newChild func() port.ChildDriver
+}
+
+// portDrivers are the values of --port-driver, in the order of the flag usage.
+var portDrivers = []portDriver{
+	{
+		Driver: api.Driver{Name: "none", Status: api.DriverStatusStable},
+		newParent: func(*cli.Context, *parent.Opt, *netParams) (port.ParentDriver, error) {
+			return nil, nil
+		},
+		newChild: func() port.ChildDriver {
+			return nil
+		},
+	},
+	{
+		Driver:    api.Driver{Name: "builtin", Status: api.DriverStatusStable},
+		newParent: newBuiltinPortParentDriver,
+		newChild: func() port.ChildDriver {
+			return builtin.NewChildDriver(&logrusDebugWriter{})
+		},
+	},
+	{
+		Driver:    api.Driver{Name: "vsock", Status: api.DriverStatusExperimental},
+		newParent: newVsockPortParentDriver,
+		newChild: func() port.ChildDriver {
+			return vsock.NewChildDriver(&logrusDebugWriter{})
+		},
+	},
+	{
+		Driver:    api.Driver{Name: "socat", Status: api.DriverStatusDeprecated},
+		newParent: newSocatPortParentDriver,
+		newChild:  socat.NewChildDriver,
+	},
+	{
+		Driver:    api.Driver{Name: "slirp4netns", Status: api.DriverStatusDeprecated},
+		newParent: newSlirp4netnsPortParentDriver,
+		newChild:  slirp4netns_port.NewChildDriver,
+	},
+}
+
+// lookupPortDriver returns the value of --port-driver named name.
+func lookupPortDriver(name string) (*portDriver, error) {
+	for i := range portDrivers {
+		if portDrivers[i].Name == name {
+			return &portDrivers[i], nil
+		}
+	}
+	return nil, errors.Errorf("unknown port driver: %s", name)
+}
+
+// portDriverList returns the values of --port-driver.
+func portDriverList() []api.Driver {
+	var res []api.Driver
+	for _, d := range portDrivers {
+		res = append(res, d.Driver)
+	}
+	return res
+}
+
+// warnDriverStatus warns if d is not stable. kind is like "network driver".
+func warnDriverStatus(kind string, d api.Driver) {
+	if d.Status != api.DriverStatusStable {
+		logrus.Warnf("%q %s is %s", d.Name, kind, d.Status)
+	}
+}
+
+// copyUpModes returns the modes registered to the copyup registry.
+func copyUpModes() []api.Driver {
+	var res []api.Driver
+	for _, m := range copyup.Modes() {
+		res = append(res, api.Driver{Name: m, Status: api.DriverStatusStable})
+	}
+	return res
+}
+
+func supportedDrivers() *api.Drivers {
+	return &api.Drivers{
+		Net:        netDriverList(),
+		PortDriver: portDriverList(),
+		CopyUpMode: copyUpModes(),
+	}
+}
+
+// driversUsage returns a string like "host, lxc-user-nic(experimental)".
+func driversUsage(drivers []api.Driver) string {
+	var ss []string
+	for _, d := range drivers {
+		s := d.Name
+		if d.Status != api.DriverStatusStable {
+			s += "(" + d.Status + ")"
+		}
+		ss = append(ss, s)
+	}
+	return strings.Join(ss, ", ")
+}
+
+var driversCommand = cli.Command{
+	Name:      "drivers",
+	Usage:     "Show the supported drivers",
+	ArgsUsage: "[flags]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Prints as JSON",
+		},
+	},
+	Action: driversAction,
+}
+
+func driversAction(clicontext *cli.Context) error {
+	drivers := supportedDrivers()
+	if clicontext.Bool("json") {
+		m, err := json.MarshalIndent(drivers, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(m))
+		return nil
+	}
+	fmt.Printf("net: %s\n", driversUsage(drivers.Net))
+	fmt.Printf("port-driver: %s\n", driversUsage(drivers.PortDriver))
+	fmt.Printf("copy-up-mode: %s\n", driversUsage(drivers.CopyUpMode))
+	return nil
+}
+
+func newHostParentDriver(clicontext *cli.Context, p *netParams) (network.ParentDriver, error) {
+	if p.mtu != 0 {
+		logrus.Warnf("unsupported mtu for --net=host: %d", p.mtu)
+	}
+	if p.ipnet != nil {
+		return nil, errors.New("custom cidr is supported only for --net=slirp4netns (with slirp4netns v0.3.0+)")
+	}
+	return nil, nil
+}
+
+func newSlirp4netnsParentDriver(clicontext *cli.Context, p *netParams) (network.ParentDriver, error) {
+	binary := clicontext.String("slirp4netns-binary")
+	if _, err := exec.LookPath(binary); err != nil {
+		return nil, &common.HelperNotFoundError{Helper: binary, Err: err}
+	}
+	features, err := slirp4netns.DetectFeatures(binary)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("slirp4netns features %+v", features)
+	if p.disableHostLoopback && !features.SupportsDisableHostLoopback {
+		return nil, errors.New("unsupported slirp4netns version: lacks SupportsDisableHostLoopback, please install v0.3.0+")
+	}
+	if p.ipv6 && !features.SupportsEnableIPv6 {
+		return nil, errors.New("unsupported slirp4netns version: lacks SupportsEnableIPv6")
+	}
+	if p.slirp4netnsAPISocketPath != "" && !features.SupportsAPISocket {
+		return nil, errors.New("unsupported slirp4netns version: lacks SupportsAPISocket, please install v0.3.0+")
+	}
+	enableSandbox := false
+	switch s := clicontext.String("slirp4netns-sandbox"); s {
+	case "auto":
+		// this might not work when /etc/resolv.conf is a symlink to a file outside /etc or /run
+		// https://github.com/rootless-containers/slirp4netns/issues/116
+		enableSandbox = features.SupportsEnableSandbox
+	case "true":
+		enableSandbox = true
+		if !features.SupportsEnableSandbox {
+			return nil, errors.New("unsupported slirp4netns version: lacks SupportsEnableSandbox, please install v0.4.0+")
+		}
+	case "false", "": // default
+		// NOP
+	default:
+		return nil, errors.Errorf("unsupported slirp4netns-sandbox mode: %q", s)
+	}
+	enableSeccomp := false
+	switch s := clicontext.String("slirp4netns-seccomp"); s {
+	case "auto":
+		enableSeccomp = features.SupportsEnableSeccomp && features.KernelSupportsEnableSeccomp
+	case "true":
+		enableSeccomp = true
+		if !features.SupportsEnableSeccomp {
+			return nil, errors.New("unsupported slirp4netns version: lacks SupportsEnableSeccomp, please install v0.4.0+")
+		}
+		if !features.KernelSupportsEnableSeccomp {
+			return nil, errors.New("kernel doesn't support seccomp")
+		}
+	case "false", "": // default
+		// NOP
+	default:
+		return nil, errors.Errorf("unsupported slirp4netns-seccomp mode: %q", s)
+	}
+	if p.netnsPath != "" && !features.SupportsNetnsType {
+		return nil, errors.New("unsupported slirp4netns version: lacks SupportsNetnsType, please install v0.4.0+")
+	}
+	if p.mac != nil && !features.SupportsMACAddress {
+		return nil, errors.New("unsupported slirp4netns version: lacks SupportsMACAddress, please install v1.1.0+")
+	}
+	readyTimeout := p.readyTimeout
+	if clicontext.IsSet("slirp4netns-ready-timeout") {
+		if clicontext.IsSet("net-ready-timeout") {
+			return nil, errors.New("--slirp4netns-ready-timeout and --net-ready-timeout are exclusive")
+		}
+		readyTimeout = clicontext.Duration("slirp4netns-ready-timeout")
+		if readyTimeout < 0 {
+			return nil, errors.Errorf("invalid --slirp4netns-ready-timeout: %v", readyTimeout)
+		}
+	}
+	return slirp4netns.NewParentDriver(binary, p.mtu, p.ipnet, p.disableHostLoopback, p.slirp4netnsAPISocketPath, enableSandbox, enableSeccomp, p.ipv6, p.ipv6Only, readyTimeout, p.netnsPath, p.ifname, p.mac, p.helperSeccomp), nil
+}
+
+func newVPNKitParentDriver(clicontext *cli.Context, p *netParams) (network.ParentDriver, error) {
+	if p.ipnet != nil {
+		return nil, errors.New("custom cidr is supported only for --net=slirp4netns (with slirp4netns v0.3.0+)")
+	}
+	binary := clicontext.String("vpnkit-binary")
+	if _, err := exec.LookPath(binary); err != nil {
+		return nil, &common.HelperNotFoundError{Helper: binary, Err: err}
+	}
+	return vpnkit.NewParentDriver(binary, p.mtu, p.disableHostLoopback, p.readyTimeout, p.helperSeccomp), nil
+}
+
+func newLXCUserNicParentDriver(clicontext *cli.Context, p *netParams) (network.ParentDriver, error) {
+	if p.ipnet != nil {
+		return nil, errors.New("custom cidr is supported only for --net=slirp4netns (with slirp4netns v0.3.0+)")
+	}
+	if !p.disableHostLoopback {
+		logrus.Warn("--disable-host-loopback is implicitly set for lxc-user-nic")
+	}
+	binary := clicontext.String("lxc-user-nic-binary")
+	if _, err := exec.LookPath(binary); err != nil {
+		return nil, &common.HelperNotFoundError{Helper: binary, Err: err}
+	}
+	return lxcusernic.NewParentDriver(binary, p.mtu, clicontext.String("lxc-user-nic-bridge"), p.mac)
+}
+
+func newBridgeParentDriver(clicontext *cli.Context, p *netParams) (network.ParentDriver, error) {
+	if p.ipnet != nil {
+		return nil, errors.New("custom cidr is supported only for --net=slirp4netns (with slirp4netns v0.3.0+), use --ip for --net=bridge")
+	}
+	if !p.disableHostLoopback {
+		logrus.Warn("--disable-host-loopback is implicitly set for bridge")
+	}
+	return bridge.NewParentDriver(p.mtu, clicontext.String("bridge-name"), clicontext.String("ip"), clicontext.String("bridge-gateway"), p.mac)
+}
+
+func newSocketParentDriver(clicontext *cli.Context, p *netParams) (network.ParentDriver, error) {
+	if p.ipnet != nil {
+		return nil, errors.New("custom cidr is supported only for --net=slirp4netns (with slirp4netns v0.3.0+), use --ip for --net=socket")
+	}
+	if !p.disableHostLoopback {
+		logrus.Warn("--disable-host-loopback is implicitly set for socket")
+	}
+	if !clicontext.IsSet("net-fd") {
+		return nil, errors.New("--net=socket requires --net-fd")
+	}
+	fd := clicontext.Int("net-fd")
+	if fd < 3 {
+		return nil, errors.Errorf("invalid --net-fd %d, must be >= 3", fd)
+	}
+	return socket.NewParentDriver(os.NewFile(uintptr(fd), "net-fd"), p.mtu, clicontext.String("ip"), clicontext.String("socket-gateway"), p.mac)
+}
+
+func newVDEPlugSlirpParentDriver(clicontext *cli.Context, p *netParams) (network.ParentDriver, error) {
+	if p.ipnet != nil {
+		return nil, errors.New("custom cidr is supported only for --net=slirp4netns (with slirp4netns v0.3.0+)")
+	}
+	if p.disableHostLoopback {
+		return nil, errors.New("--disable-host-loopback is not supported for vdeplug_slirp")
+	}
+	return vdeplugslirp.NewParentDriver(p.mtu), nil
+}
+
+func newBuiltinPortParentDriver(clicontext *cli.Context, opt *parent.Opt, p *netParams) (port.ParentDriver, error) {
+	if opt.NetworkDriver == nil {
+		return nil, errors.New("port driver requires non-host network")
+	}
+	backlog := clicontext.Int("builtin-port-backlog")
+	if backlog < 0 {
+		return nil, errors.Errorf("invalid --builtin-port-backlog: %d", backlog)
+	}
+	relayWorkers := clicontext.Int("builtin-port-relay-workers")
+	if relayWorkers < 0 {
+		return nil, errors.Errorf("invalid --builtin-port-relay-workers: %d", relayWorkers)
+	}
+	var accessLog *accesslog.Logger
+	if s := clicontext.String("port-access-log"); s != "" {
+		var err error
+		accessLog, err = accesslog.New(s, clicontext.Int64("port-access-log-max-size"), clicontext.Int("port-access-log-max-files"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return builtin.NewParentDriver(&logrusDebugWriter{}, opt.StateDir, backlog, relayWorkers, accessLog)
+}
+
+func newVsockPortParentDriver(clicontext *cli.Context, opt *parent.Opt, p *netParams) (port.ParentDriver, error) {
+	if opt.NetworkDriver == nil {
+		return nil, errors.New("port driver requires non-host network")
+	}
+	return vsock.NewParentDriver(&logrusDebugWriter{}, opt.StateDir)
+}
+
+func newSocatPortParentDriver(clicontext *cli.Context, opt *parent.Opt, p *netParams) (port.ParentDriver, error) {
+	if opt.NetworkDriver == nil {
+		return nil, errors.New("port driver requires non-host network")
+	}
+	return socat.NewParentDriver(&logrusDebugWriter{}, clicontext.Int("socat-port-block-size"), clicontext.Int("socat-port-sockbuf-size"))
+}
+
+func newSlirp4netnsPortParentDriver(clicontext *cli.Context, opt *parent.Opt, p *netParams) (port.ParentDriver, error) {
+	if clicontext.String("net") != "slirp4netns" {
+		return nil, errors.New("port driver requires slirp4netns network")
+	}
+	return slirp4netns_port.NewParentDriver(&logrusDebugWriter{}, p.slirp4netnsAPISocketPath)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+// testCreateParentOpt calls createParentOpt with the flags of the app.
+func testCreateParentOpt(t *testing.T, netDriver, portDriver string) error {
+	stateDir, err := ioutil.TempDir("", "test-drivers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	var createErr error
+	app := cli.NewApp()
+	app.Flags = appFlags(new(bool))
+	app.Action = func(clicontext *cli.Context) error {
+		_, createErr = createParentOpt(clicontext, "", "")
+		return nil
+	}
+	args := []string{"rootlesskit", "--state-dir=" + stateDir, "--net=" + netDriver, "--port-driver=" + portDriver, "true"}
+	if err := app.Run(args); err != nil {
+		t.Fatal(err)
+	}
+	return createErr
+}
+
+func isUnknownDriverError(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "unknown ")
+}
+
+func TestNetDrivers(t *testing.T) {
+	for _, d := range supportedDrivers().Net {
+		// fails for lacking the helpers or the flags, but not for the unknown driver
+		err := testCreateParentOpt(t, d.Name, "none")
+		if isUnknownDriverError(err) {
+			t.Errorf("--net=%s is listed but not accepted: %v", d.Name, err)
+		}
+	}
+	if err := testCreateParentOpt(t, "nonexistent", "none"); !isUnknownDriverError(err) {
+		t.Errorf("--net=nonexistent is not listed but accepted: %v", err)
+	}
+}
+
+func TestPortDrivers(t *testing.T) {
+	for _, d := range supportedDrivers().PortDriver {
+		err := testCreateParentOpt(t, "host", d.Name)
+		if isUnknownDriverError(err) {
+			t.Errorf("--port-driver=%s is listed but not accepted: %v", d.Name, err)
+		}
+	}
+	if err := testCreateParentOpt(t, "host", "nonexistent"); !isUnknownDriverError(err) {
+		t.Errorf("--port-driver=nonexistent is not listed but accepted: %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+
+	"github.com/urfave/cli"
+
+	"github.com/rootless-containers/rootlesskit/pkg/parent"
+)
+
+var gcCommand = cli.Command{
+	Name:      "gc",
+	Usage:     "Remove the state directories left by crashed RootlessKit instances",
+	ArgsUsage: "[flags]",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "base",
+			Usage: "directory containing state directories, in addition to $TMPDIR (e.g. \"/run/user/1001/rootlesskit\")",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "print the stale state directories without removing them",
+		},
+	},
+	Action: gcAction,
+}
+
+func gcAction(clicontext *cli.Context) error {
+	opt := parent.GCOpt{
+		BaseDirs: clicontext.StringSlice("base"),
+		DryRun:   clicontext.Bool("dry-run"),
+	}
+	return parent.GC(opt, os.Stdout)
+}
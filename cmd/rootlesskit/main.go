@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -14,47 +15,171 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
+	"github.com/rootless-containers/rootlesskit/pkg/api"
 	"github.com/rootless-containers/rootlesskit/pkg/child"
 	"github.com/rootless-containers/rootlesskit/pkg/common"
+	"github.com/rootless-containers/rootlesskit/pkg/copyup"
+	"github.com/rootless-containers/rootlesskit/pkg/copyup/remote"
 	"github.com/rootless-containers/rootlesskit/pkg/copyup/tmpfssymlink"
-	"github.com/rootless-containers/rootlesskit/pkg/network/lxcusernic"
+	"github.com/rootless-containers/rootlesskit/pkg/firewall"
+	"github.com/rootless-containers/rootlesskit/pkg/instance"
+	"github.com/rootless-containers/rootlesskit/pkg/network"
+	"github.com/rootless-containers/rootlesskit/pkg/network/bridge"
+	"github.com/rootless-containers/rootlesskit/pkg/network/hostloopback"
+	"github.com/rootless-containers/rootlesskit/pkg/network/parentutils"
 	"github.com/rootless-containers/rootlesskit/pkg/network/slirp4netns"
-	"github.com/rootless-containers/rootlesskit/pkg/network/vdeplugslirp"
-	"github.com/rootless-containers/rootlesskit/pkg/network/vpnkit"
 	"github.com/rootless-containers/rootlesskit/pkg/parent"
-	"github.com/rootless-containers/rootlesskit/pkg/port/builtin"
 	"github.com/rootless-containers/rootlesskit/pkg/port/portutil"
-	slirp4netns_port "github.com/rootless-containers/rootlesskit/pkg/port/slirp4netns"
-	"github.com/rootless-containers/rootlesskit/pkg/port/socat"
+	"github.com/rootless-containers/rootlesskit/pkg/seccomp"
 	"github.com/rootless-containers/rootlesskit/pkg/version"
 )
 
 func main() {
 	const (
-		pipeFDEnvKey   = "_ROOTLESSKIT_PIPEFD_UNDOCUMENTED"
-		stateDirEnvKey = "ROOTLESSKIT_STATE_DIR" // documented
+		pipeFDEnvKey    = "_ROOTLESSKIT_PIPEFD_UNDOCUMENTED"
+		stateDirEnvKey  = "ROOTLESSKIT_STATE_DIR"  // documented
+		apiSocketEnvKey = "ROOTLESSKIT_API_SOCKET" // documented
+		apiTokenEnvKey  = "ROOTLESSKIT_API_TOKEN"  // documented, not propagated to the child
 	)
+	if tap := os.Getenv(parentutils.OpenTapEnvKey); tap != "" {
+		// re-executed by parentutils.OpenTap
+		if err := parentutils.OpenTapHelper(tap); err != nil {
+			fmt.Fprintf(os.Stderr, "[rootlesskit:opentap] error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if os.Getenv(child.SchedShimEnvKey) != "" {
+		// re-executed by the child for --cpu-affinity and --sched-policy
+		err := child.SchedShim(os.Args)
+		fmt.Fprintf(os.Stderr, "[rootlesskit:shim  ] error: %v\n", err)
+		os.Exit(1)
+	}
+	if os.Getenv(child.CapDropShimEnvKey) != "" {
+		// re-executed by the child for --cap-drop
+		err := child.CapDropShim(os.Args)
+		fmt.Fprintf(os.Stderr, "[rootlesskit:shim  ] error: %v\n", err)
+		os.Exit(1)
+	}
+	if os.Getenv(seccomp.ShimEnvKey) != "" {
+		// re-executed by the network driver for --helper-seccomp
+		err := seccomp.Shim(os.Args)
+		fmt.Fprintf(os.Stderr, "[rootlesskit:shim  ] error: %v\n", err)
+		os.Exit(1)
+	}
+	if os.Getenv(child.ListenPIDShimEnvKey) != "" {
+		// re-executed by the child for --preserve-fd
+		err := child.ListenPIDShim(os.Args)
+		fmt.Fprintf(os.Stderr, "[rootlesskit:shim  ] error: %v\n", err)
+		os.Exit(1)
+	}
 	iAmChild := os.Getenv(pipeFDEnvKey) != ""
 	debug := false
 	app := cli.NewApp()
 	app.Name = "rootlesskit"
 	app.Version = version.Version
 	app.Usage = "the gate to the rootless world"
-	app.Flags = []cli.Flag{
+	app.Flags = appFlags(&debug)
+	app.Commands = []cli.Command{
+		versionCommand,
+		gcCommand,
+		driversCommand,
+	}
+	app.Before = func(context *cli.Context) error {
+		if debug {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		return nil
+	}
+	app.Action = func(clicontext *cli.Context) error {
+		if clicontext.NArg() < 1 && clicontext.String("exec-script") == "" && !clicontext.Bool("exec-stdin") {
+			return errors.New("no command specified")
+		}
+		if iAmChild {
+			childOpt, err := createChildOpt(clicontext, pipeFDEnvKey, clicontext.Args())
+			if err != nil {
+				return err
+			}
+			return child.Child(childOpt)
+		}
+		parentOpt, err := createParentOpt(clicontext, pipeFDEnvKey, stateDirEnvKey)
+		if err != nil {
+			return err
+		}
+		parentOpt.APISocketEnvKey = apiSocketEnvKey
+		parentOpt.APITokenEnvKey = apiTokenEnvKey
+		return parent.Parent(parentOpt)
+	}
+	if err := app.Run(os.Args); err != nil {
+		id := "parent"
+		if iAmChild {
+			id = "child " // padded to len("parent")
+		}
+		if debug {
+			fmt.Fprintf(os.Stderr, "[rootlesskit:%s] error: %+v\n", id, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "[rootlesskit:%s] error: %v\n", id, err)
+		}
+		// propagate the exit code
+		code, ok := common.GetExecExitStatus(err)
+		if !ok {
+			code = 1
+		}
+		os.Exit(code)
+	}
+}
+
+// appFlags returns the flags of the app. debug is set by --debug.
+func appFlags(debug *bool) []cli.Flag {
+	return []cli.Flag{
 		cli.BoolFlag{
 			Name:        "debug",
 			Usage:       "debug mode",
-			Destination: &debug,
+			Destination: debug,
 		},
 		cli.StringFlag{
 			Name:  "state-dir",
-			Usage: "state directory",
+			Usage: "state directory. \"tmpfs:DIR\" (or \"tmpfs:\" for a temporary directory) mounts a private tmpfs on the directory (requires the root in the current user namespace, unless DIR is already on tmpfs)",
+		},
+		cli.StringFlag{
+			Name:  "name",
+			Usage: "name of the instance, using $XDG_RUNTIME_DIR/rootlesskit/<name> as the state directory (cannot be combined with --state-dir)",
 		},
 		cli.StringFlag{
 			Name:  "net",
-			Usage: "network driver [host, slirp4netns, vpnkit, lxc-user-nic(experimental), vdeplug_slirp(deprecated)]",
+			Usage: "network driver [" + driversUsage(netDriverList()) + "]",
 			Value: "host",
 		},
+		cli.StringFlag{
+			Name:  "netns",
+			Usage: "join an existing network namespace instead of creating a new one, e.g. \"/var/run/netns/foo\" (requires --net=slirp4netns and --inherit-userns)",
+		},
+		cli.BoolFlag{
+			Name:  "no-loopback-setup",
+			Usage: "do not bring up the loopback interface in the child network namespace (not supported for --net=host)",
+		},
+		cli.StringFlag{
+			Name:  "ifname",
+			Usage: "name of the tap device in the child for --net=slirp4netns",
+			Value: slirp4netns.DefaultIfName,
+		},
+		cli.StringSliceFlag{
+			Name:  "sysctl",
+			Usage: "netns-scoped sysctl applied in the child network namespace, e.g. \"net.ipv4.ip_local_port_range=1024 65535\" (can be specified multiple times, not supported for --net=host)",
+		},
+		cli.StringFlag{
+			Name:  "child-iptables-rules",
+			Usage: "apply the rules in the iptables-restore format in the child network namespace (not supported for --net=host)",
+		},
+		cli.StringFlag{
+			Name:  "firewall-backend",
+			Usage: "firewall backend for --child-iptables-rules (auto, nft, iptables)",
+			Value: string(firewall.BackendAuto),
+		},
+		cli.StringFlag{
+			Name:  "macaddress",
+			Usage: "locally administered unicast MAC address of the interface in the child, e.g. \"02:42:c0:a8:00:02\" (supported for slirp4netns, lxc-user-nic, bridge, and socket)",
+		},
 		cli.StringFlag{
 			Name:  "slirp4netns-binary",
 			Usage: "path of slirp4netns binary for --net=slirp4netns",
@@ -70,11 +195,28 @@ func main() {
 			Usage: "enable slirp4netns seccomp (experimental) [auto, true, false] (the default is planned to be \"auto\" in future)",
 			Value: "false",
 		},
+		cli.StringFlag{
+			Name:  "helper-seccomp",
+			Usage: "apply the seccomp profile (JSON, without argument conditions) to the network helper (slirp4netns or vpnkit) (experimental)",
+		},
+		cli.DurationFlag{
+			Name:  "net-ready-timeout",
+			Usage: "timeout for waiting for the network driver (slirp4netns or vpnkit) to be ready, e.g. \"30s\" (0 for the driver default)",
+		},
+		cli.DurationFlag{
+			Name:  "slirp4netns-ready-timeout",
+			Usage: "timeout for waiting for slirp4netns to be ready, e.g. \"30s\" (0 for no timeout). Alias of --net-ready-timeout for slirp4netns",
+		},
 		cli.StringFlag{
 			Name:  "vpnkit-binary",
 			Usage: "path of VPNKit binary for --net=vpnkit",
 			Value: "vpnkit",
 		},
+		cli.IntFlag{
+			Name:  "vpnkit-reconnect-retries",
+			Usage: "number of retries for reconnecting to VPNKit when the connection is lost (0 to disable)",
+			Value: 5,
+		},
 		cli.StringFlag{
 			Name:  "lxc-user-nic-binary",
 			Usage: "path of lxc-user-nic binary for --net=lxc-user-nic",
@@ -85,6 +227,27 @@ func main() {
 			Usage: "lxc-user-nic bridge name",
 			Value: "lxcbr0",
 		},
+		cli.StringFlag{
+			Name:  "bridge-name",
+			Usage: "bridge name for --net=bridge, created in the parent network namespace if missing",
+			Value: bridge.DefaultBridge,
+		},
+		cli.StringFlag{
+			Name:  "ip",
+			Usage: "static IP of the child in CIDR notation for --net=bridge and --net=socket, e.g. \"10.0.100.2/24\"",
+		},
+		cli.StringFlag{
+			Name:  "bridge-gateway",
+			Usage: "default gateway of the child for --net=bridge, assigned to the bridge on creating the bridge (optional)",
+		},
+		cli.IntFlag{
+			Name:  "net-fd",
+			Usage: "FD of the connected socket for --net=socket, over which the Ethernet frames of the child are exchanged",
+		},
+		cli.StringFlag{
+			Name:  "socket-gateway",
+			Usage: "default gateway of the child for --net=socket (optional)",
+		},
 		cli.IntFlag{
 			Name:  "mtu",
 			Usage: "MTU for non-host network (default: 65520 for slirp4netns, 1500 for others)",
@@ -94,99 +257,668 @@ func main() {
 			Name:  "cidr",
 			Usage: "CIDR for slirp4netns network (default: 10.0.2.0/24, requires slirp4netns v0.3.0+ for custom CIDR)",
 		},
+		cli.BoolFlag{
+			Name:  "ipv6",
+			Usage: "enable IPv6 routing for slirp4netns network (experimental)",
+		},
+		cli.BoolFlag{
+			Name:  "ipv6-only",
+			Usage: "configure only IPv6 for slirp4netns network, requires --ipv6 (experimental)",
+		},
 		cli.BoolFlag{
 			Name:  "disable-host-loopback",
 			Usage: "prohibit connecting to 127.0.0.1:* on the host namespace",
 		},
+		cli.BoolFlag{
+			Name:  "disable-host-loopback-tcp",
+			Usage: "prohibit connecting to 127.0.0.1:* on the host namespace via TCP (emulated with --allow-host-loopback=IP:PORT/udp)",
+		},
+		cli.BoolFlag{
+			Name:  "disable-host-loopback-udp",
+			Usage: "prohibit connecting to 127.0.0.1:* on the host namespace via UDP (emulated with --allow-host-loopback=IP:PORT/tcp)",
+		},
+		cli.StringFlag{
+			Name:  "resolv-conf",
+			Usage: "configuration of /etc/resolv.conf for non-host network: \"rewrite\" (write the nameserver of the network driver), \"bind-host\" (bind-mount the host file read-only), or \"none\" (leave as is)",
+			Value: string(child.ResolvConfRewrite),
+		},
+		cli.StringSliceFlag{
+			Name:  "dns-search",
+			Usage: "DNS search domain for non-host network, written to /etc/resolv.conf when /etc is copied up (can be specified multiple times)",
+		},
+		cli.StringSliceFlag{
+			Name:  "dns-option",
+			Usage: "DNS option for non-host network, e.g. \"ndots:2\", written to /etc/resolv.conf when /etc is copied up (can be specified multiple times)",
+		},
+		cli.BoolFlag{
+			Name:  "write-nsswitch",
+			Usage: "write the minimal /etc/nsswitch.conf (\"hosts: files dns\") when /etc is copied up, unless the existing file is non-trivial",
+		},
+		cli.BoolFlag{
+			Name:  "write-nsswitch-force",
+			Usage: "overwrite the existing /etc/nsswitch.conf on --write-nsswitch",
+		},
+		cli.BoolFlag{
+			Name:  "copy-localtime",
+			Usage: "write the host /etc/localtime as a real file when /etc is copied up (no-op otherwise)",
+		},
+		cli.BoolFlag{
+			Name:  "export-tz",
+			Usage: "set TZ to the zone of the host /etc/localtime unless already set (requires --copy-localtime)",
+		},
+		cli.StringSliceFlag{
+			Name:  "allow-host-loopback",
+			Usage: "allow connecting to the \"ip:port[/proto]\" on the host loopback via the same address in the namespace, even with --disable-host-loopback",
+		},
 		cli.StringSliceFlag{
 			Name:  "copy-up",
 			Usage: "mount a filesystem and copy-up the contents. e.g. \"--copy-up=/etc\" (typically required for non-host network)",
 		},
+		cli.StringSliceFlag{
+			Name:  "copy-up-exclude",
+			Usage: "exclude the entries matching the absolute path glob from copy-up. e.g. \"--copy-up-exclude=/etc/ssl/certs\"",
+		},
+		cli.StringSliceFlag{
+			Name:  "copy-up-real-files",
+			Usage: "copy the files matching the absolute path glob as real files rather than symlinks (\"" + strings.Join(copyup.DefaultRealFiles, "\", \"") + "\" are always copied). e.g. \"--copy-up-real-files=/etc/localtime\"",
+		},
+		cli.BoolFlag{
+			Name:  "copy-up-strict",
+			Usage: "abort if any entry in the copied-up directories is not reachable in the copied-up view",
+		},
+		cli.BoolFlag{
+			Name:  "copy-up-create-missing",
+			Usage: "create the copy-up directories that do not exist, as empty directories",
+		},
+		cli.BoolFlag{
+			Name:  "copy-up-skip-missing",
+			Usage: "skip the copy-up directories that do not exist, with a warning",
+		},
+		cli.StringFlag{
+			Name:  "copy-up-persist",
+			Usage: "store the copied-up directories in the directory owned by the current user, instead of tmpfs, and reuse them on the next run",
+		},
 		cli.StringFlag{
 			Name:  "copy-up-mode",
-			Usage: "copy-up mode [tmpfs+symlink]",
-			Value: "tmpfs+symlink",
+			Usage: "copy-up mode [" + driversUsage(copyUpModes()) + "]",
+			Value: tmpfssymlink.Mode,
 		},
 		cli.StringFlag{
 			Name:  "port-driver",
-			Usage: "port driver for non-host network. [none, builtin, socat(deprecated), slirp4netns(deprecated)]",
+			Usage: "port driver for non-host network. [" + driversUsage(portDriverList()) + "]",
 			Value: "none",
 		},
+		cli.IntFlag{
+			Name:  "builtin-port-backlog",
+			Usage: "listen backlog of TCP ports for --port-driver=builtin (default: 0, the kernel default net.core.somaxconn)",
+		},
+		cli.IntFlag{
+			Name:  "builtin-port-relay-workers",
+			Usage: "cap on the number of TCP connections relayed concurrently on each port for --port-driver=builtin. Each relayed connection still runs on its own goroutines. The excess connections are left in the listen backlog (default: 0, unlimited)",
+		},
+		cli.IntFlag{
+			Name:  "socat-port-block-size",
+			Usage: "block size in bytes of socat (\"-b\") for --port-driver=socat (default: 0, 8192)",
+		},
+		cli.IntFlag{
+			Name:  "socat-port-sockbuf-size",
+			Usage: "SO_RCVBUF and SO_SNDBUF in bytes of the sockets for --port-driver=socat (default: 0, 65536)",
+		},
+		cli.StringFlag{
+			Name:  "port-access-log",
+			Usage: "append the TCP connection records of --port-driver=builtin to the file",
+		},
+		cli.Int64Flag{
+			Name:  "port-access-log-max-size",
+			Usage: "size in bytes for rotating --port-access-log (0 to disable rotation)",
+			Value: 10 * 1024 * 1024,
+		},
+		cli.IntFlag{
+			Name:  "port-access-log-max-files",
+			Usage: "number of the rotated --port-access-log files to keep",
+			Value: 5,
+		},
 		cli.StringSliceFlag{
 			Name:  "publish,p",
-			Usage: "publish ports. e.g. \"127.0.0.1:8080:80/tcp\"",
+			Usage: "publish ports. e.g. \"127.0.0.1:8080:80/tcp\", \"8080\" (shorthand for \"0.0.0.0:8080:8080/tcp\")",
+		},
+		cli.StringFlag{
+			Name:  "publish-file",
+			Usage: "publish ports listed in the file, one per line. The file is reloaded on SIGUSR1",
+		},
+		cli.StringFlag{
+			Name:  "child-ip-check",
+			Usage: "action on publishing a port with the child IP outside the subnets of the child: \"strict\" (fail) or \"warn\" (e.g. for advanced routing in the child)",
+			Value: "strict",
+		},
+		cli.StringSliceFlag{
+			Name:  "nat-1to1",
+			Usage: "forward all the TCP and UDP ports of the dedicated host IPv4 address to the child IP, e.g. \"192.168.1.10:10.0.2.100\" or \"192.168.1.10:10.0.2.100:1024-65535\" (requires --port-driver=builtin, resource-heavy). The range defaults to net.ipv4.ip_unprivileged_port_start-65535",
+		},
+		cli.BoolFlag{
+			Name:  "auto-publish",
+			Usage: "publish the listening TCP ports of the child on the same ports of the parent automatically (requires --port-driver=builtin)",
+		},
+		cli.DurationFlag{
+			Name:  "auto-publish-interval",
+			Usage: "interval of polling the listening TCP ports of the child for --auto-publish",
+			Value: parent.DefaultAutoPublishInterval,
+		},
+		cli.StringFlag{
+			Name:  "auto-publish-ports",
+			Usage: "ports to be published by --auto-publish, e.g. \"80,8000-8999\" (default: all)",
+		},
+		cli.BoolFlag{
+			Name:  "restore-ports",
+			Usage: "re-publish the ports left in the state directory by the previous crashed execution (requires --state-dir)",
 		},
 		cli.BoolFlag{
 			Name:  "pidns",
 			Usage: "create a PID namespace",
 		},
+		cli.BoolFlag{
+			Name:  "cgroupns",
+			Usage: "create a cgroup namespace",
+		},
+		cli.StringSliceFlag{
+			Name:  "join-ns",
+			Usage: "join an existing namespace for the command, e.g. \"ipc:/proc/42/ns/ipc\" [ipc, uts, net, pid, cgroup]",
+		},
+		cli.BoolFlag{
+			Name:  "mount-cgroup2",
+			Usage: "mount cgroup2 on /sys/fs/cgroup, scoped to the delegated cgroup (requires --cgroupns and cgroup v2 delegation)",
+		},
+		cli.StringFlag{
+			Name:  "memory",
+			Usage: "memory limit of the child in bytes, with an optional suffix [k, m, g], e.g. \"512m\" (requires cgroup v2 delegation with the memory controller)",
+		},
+		cli.StringFlag{
+			Name:  "rootfs",
+			Usage: "pivot the root of the target command into the directory (experimental)",
+		},
+		cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "make the rootfs read-only, with tmpfs on /tmp and /run (requires --rootfs)",
+		},
+		cli.BoolFlag{
+			Name:  "no-pivot",
+			Usage: "fall back to chroot when pivot_root fails (less secure, requires --rootfs)",
+		},
+		cli.StringFlag{
+			Name:  "dev-mode",
+			Usage: "setup of /dev in the rootfs: \"bind\" (bind-mount the host /dev), \"minimal\" (tmpfs with the standard device nodes), or \"host\" (leave as is) (requires --rootfs)",
+			Value: string(child.DevModeBind),
+		},
+		cli.BoolFlag{
+			Name:  "ro-host",
+			Usage: "remount the host filesystems read-only for the command, except /dev, /proc, /sys, and the copied-up directories (cannot be combined with --rootfs)",
+		},
+		cli.StringSliceFlag{
+			Name:  "ro-host-writable",
+			Usage: "keep the host directory writable on --ro-host (can be specified multiple times)",
+		},
+		cli.BoolFlag{
+			Name:  "bind-runtime-dir",
+			Usage: "bind-mount the runtime directory of the user (/run/user/<uid>) on the same path, and set $XDG_RUNTIME_DIR",
+		},
+		cli.StringFlag{
+			Name:  "cwd",
+			Usage: "working directory of the command (absolute path in the rootfs when --rootfs is specified)",
+		},
+		cli.BoolFlag{
+			Name:  "exit-on-child-death",
+			Usage: "terminate all the processes in the PID namespace as soon as a direct child of the command exits with a non-zero status (requires --pidns)",
+		},
+		cli.BoolFlag{
+			Name:  "bind-sys",
+			Usage: "bind-mount the host /sys as read-only (network entries such as /sys/class/net reflect the host network namespace)",
+		},
+		cli.StringSliceFlag{
+			Name:  "exec",
+			Usage: "execute a setup command with \"/bin/sh -c\" before the command, in the same namespaces (can be specified multiple times)",
+		},
+		cli.StringFlag{
+			Name:  "exec-script",
+			Usage: "execute the script file with the interpreter in the shebang line (default: /bin/sh), instead of the command. The arguments are passed to the script",
+		},
+		cli.BoolFlag{
+			Name:  "exec-stdin",
+			Usage: "execute the script read from stdin, instead of the command. The arguments are passed to the script",
+		},
+		cli.StringSliceFlag{
+			Name:  "parent-pre-exec",
+			Usage: "execute a command with \"/bin/sh -c\" in the host namespaces before creating the namespaces (can be specified multiple times)",
+		},
+		cli.StringSliceFlag{
+			Name:  "parent-post-stop",
+			Usage: "execute a command with \"/bin/sh -c\" in the host namespaces after the child exited and the namespaces were torn down (can be specified multiple times)",
+		},
+		cli.BoolFlag{
+			Name:  "strict-teardown",
+			Usage: "exit with an error when any teardown step (e.g. unmounting the state dir, or --parent-post-stop) fails, even if the command exited successfully",
+		},
+		cli.StringSliceFlag{
+			Name:  "notify",
+			Usage: "notify the readiness of the network and the ports: \"fd:N\", \"file:PATH\", or \"exec:CMD\" (can be specified multiple times)",
+		},
+		cli.BoolFlag{
+			Name:  "wait-start",
+			Usage: "wait for \"rootlessctl start\" (or SIGUSR2) after the setup commands, before executing the command",
+		},
+		cli.DurationFlag{
+			Name:  "wait-start-timeout",
+			Usage: "timeout for --wait-start, e.g. \"30s\" (0 for no timeout)",
+		},
+		cli.StringFlag{
+			Name:  "umask",
+			Usage: "umask of the command in octal, e.g. \"0022\" (default: inherited)",
+		},
+		cli.BoolFlag{
+			Name:  "inherit-userns",
+			Usage: "reuse the current user namespace and its mapping instead of creating a new user namespace, for running inside another user namespace (requires root in the current user namespace)",
+		},
+		cli.BoolFlag{
+			Name:  "single-mapping",
+			Usage: "map only the current user and group to root, without newuidmap, newgidmap, /etc/subuid, and /etc/subgid (setgroups(2) is denied)",
+		},
+		cli.BoolFlag{
+			Name:  "export-env",
+			Usage: "write the environment variables of the command to $STATE_DIR/child_env, for executing other processes in the namespaces with the same environment (\"rootlessctl exec\")",
+		},
+		cli.StringSliceFlag{
+			Name:  "mask-env",
+			Usage: "mask the value of the environment variable in $STATE_DIR/child_env (can be specified multiple times)",
+		},
+		cli.StringFlag{
+			Name:  "process-name",
+			Usage: "comm of the RootlessKit child process shown in ps and top (max 15 bytes, argv is not changed)",
+		},
+		cli.IntFlag{
+			Name:  "oom-score-adj",
+			Usage: "oom_score_adj of the command [-1000..1000] (default: inherited; lowering the value requires CAP_SYS_RESOURCE)",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-drop",
+			Usage: "drop the capability from the bounding set and the inheritable set of the command, e.g. \"CAP_NET_RAW\", or \"ALL\" (can be specified multiple times)",
+		},
+		cli.StringSliceFlag{
+			Name:  "rlimit",
+			Usage: "resource limit of the command, e.g. \"nofile=1024:65536\" (can be specified multiple times)",
+		},
+		cli.StringFlag{
+			Name:  "cpu-affinity",
+			Usage: "CPUs that the command runs on, e.g. \"0-3,8\" (default: inherited)",
+		},
+		cli.StringFlag{
+			Name:  "sched-policy",
+			Usage: "scheduling policy of the command [other, batch, idle, fifo:PRIORITY, rr:PRIORITY] (default: inherited)",
+		},
+		cli.StringFlag{
+			Name:  "restart",
+			Usage: "restart policy of the command in the same namespaces [no, on-failure[:max], always]",
+			Value: "no",
+		},
+		cli.IntSliceFlag{
+			Name:  "preserve-fd",
+			Usage: "pass the FD to the command as FD 3, 4, ... with LISTEN_FDS and LISTEN_PID, e.g. for systemd socket activation (can be specified multiple times)",
+		},
+		cli.BoolFlag{
+			Name:  "tty",
+			Usage: "allocate a pseudo-TTY for the command (requires stdin to be a terminal)",
+		},
+		cli.BoolFlag{
+			Name:  "setsid",
+			Usage: "run the command as the leader of a new session, without the controlling terminal (implied by --tty)",
+		},
+		cli.StringFlag{
+			Name:  "stdin",
+			Usage: "redirect the stdin of the command from the file",
+		},
+		cli.StringFlag{
+			Name:  "stdout",
+			Usage: "redirect the stdout of the command to the file",
+		},
+		cli.StringFlag{
+			Name:  "stderr",
+			Usage: "redirect the stderr of the command to the file (can be same as --stdout)",
+		},
+		cli.BoolFlag{
+			Name:  "stdio-append",
+			Usage: "append to --stdout and --stderr instead of truncating",
+		},
+		cli.StringFlag{
+			Name:  "api-socket",
+			Usage: "REST API socket, either a path, \"unix:///path\", or \"tcp://host:port\" (default: $STATE_DIR/api.sock)",
+		},
+		cli.StringFlag{
+			Name:   "api-token",
+			Usage:  "bearer token for the REST API, mandatory for \"tcp://\" API socket. Not propagated to the child",
+			EnvVar: "ROOTLESSKIT_API_TOKEN",
+		},
+	}
+}
+
+// validateCopyUpPersistDir validates that dir is a directory owned by the current user.
+func validateCopyUpPersistDir(dir string) error {
+	st, err := os.Stat(dir)
+	if err != nil {
+		return errors.Wrap(err, "invalid --copy-up-persist (the directory needs to be created beforehand)")
+	}
+	if !st.IsDir() {
+		return errors.Errorf("invalid --copy-up-persist: %s is not a directory", dir)
+	}
+	if stat, ok := st.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Geteuid() {
+		return errors.Errorf("invalid --copy-up-persist: %s is not owned by the current user (UID %d)", dir, os.Geteuid())
+	}
+	return nil
+}
+
+// parseUmask parses an octal umask string such as "0022".
+func parseUmask(s string) (int, error) {
+	umask, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || umask > 0777 {
+		return 0, errors.Errorf("invalid umask %q, expected an octal number between 0000 and 0777", s)
+	}
+	return int(umask), nil
+}
+
+func parseCIDR(s string) (*net.IPNet, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	if !ip.Equal(ipnet.IP) {
+		return nil, errors.Errorf("cidr must be like 10.0.2.0/24, not like 10.0.2.100/24")
+	}
+	return ipnet, nil
+}
+
+// stateDirTmpfsPrefix is the prefix of --state-dir for mounting tmpfs on the state dir
+const stateDirTmpfsPrefix = "tmpfs:"
+
+func createParentOpt(clicontext *cli.Context, pipeFDEnvKey, stateDirEnvKey string) (parent.Opt, error) {
+	var err error
+	opt := parent.Opt{
+		PipeFDEnvKey:   pipeFDEnvKey,
+		StateDirEnvKey: stateDirEnvKey,
+		CreatePIDNS:    clicontext.Bool("pidns"),
+		CreateCgroupNS: clicontext.Bool("cgroupns"),
+		TTY:            clicontext.Bool("tty"),
+		PreserveFDs:    clicontext.IntSlice("preserve-fd"),
+		InheritUserNS:  clicontext.Bool("inherit-userns"),
+		SingleMapping:  clicontext.Bool("single-mapping"),
+		PreExecCmds:    clicontext.StringSlice("parent-pre-exec"),
+		PostStopCmds:   clicontext.StringSlice("parent-post-stop"),
+		StrictTeardown: clicontext.Bool("strict-teardown"),
+		WaitStart:      clicontext.Bool("wait-start"),
+		Drivers:        supportedDrivers(),
+	}
+	if opt.SingleMapping && opt.InheritUserNS {
+		return opt, errors.New("--single-mapping conflicts with --inherit-userns")
+	}
+	for _, s := range clicontext.StringSlice("notify") {
+		spec, err := parent.ParseNotifySpec(s)
+		if err != nil {
+			return opt, errors.Wrap(err, "invalid --notify")
+		}
+		if spec.Type == parent.NotifyFile {
+			if st, err := os.Stat(filepath.Dir(spec.Target)); err != nil || !st.IsDir() {
+				return opt, errors.Errorf("invalid --notify: the directory of %q does not exist", spec.Target)
+			}
+		}
+		opt.Notify = append(opt.Notify, *spec)
+	}
+	if clicontext.IsSet("wait-start-timeout") {
+		if !opt.WaitStart {
+			return opt, errors.New("--wait-start-timeout requires --wait-start")
+		}
+		opt.WaitStartTimeout = clicontext.Duration("wait-start-timeout")
+		if opt.WaitStartTimeout < 0 {
+			return opt, errors.Errorf("invalid --wait-start-timeout: %v", opt.WaitStartTimeout)
+		}
+	}
+	for _, fd := range opt.PreserveFDs {
+		if fd < 3 {
+			return opt, errors.Errorf("--preserve-fd needs to be 3 or larger, got %d", fd)
+		}
+	}
+	for _, f := range []string{"stdin", "stdout", "stderr"} {
+		if clicontext.String(f) != "" && opt.TTY {
+			return opt, errors.Errorf("--%s is not supported with --tty", f)
+		}
+	}
+	if s := clicontext.String("exec-script"); s != "" {
+		if clicontext.Bool("exec-stdin") {
+			return opt, errors.New("--exec-script and --exec-stdin are exclusive")
+		}
+		script, err := ioutil.ReadFile(s)
+		if err != nil {
+			return opt, errors.Wrap(err, "invalid --exec-script")
+		}
+		// the interpreter in the rootfs is validated in the child
+		if clicontext.String("rootfs") == "" {
+			if err := child.ValidateScriptInterpreter(script); err != nil {
+				return opt, errors.Wrap(err, "invalid --exec-script")
+			}
+		}
+	}
+	if clicontext.Bool("exec-stdin") && opt.TTY {
+		return opt, errors.New("--exec-stdin is not supported with --tty")
+	}
+	if clicontext.Bool("stdio-append") && clicontext.String("stdout") == "" && clicontext.String("stderr") == "" {
+		return opt, errors.New("--stdio-append requires --stdout or --stderr")
+	}
+	if clicontext.Bool("write-nsswitch-force") && !clicontext.Bool("write-nsswitch") {
+		return opt, errors.New("--write-nsswitch-force requires --write-nsswitch")
+	}
+	if clicontext.Bool("write-nsswitch") {
+		etcCopiedUp := false
+		for _, d := range clicontext.StringSlice("copy-up") {
+			if filepath.Clean(d) == "/etc" {
+				etcCopiedUp = true
+			}
+		}
+		if !etcCopiedUp {
+			return opt, errors.New("--write-nsswitch requires --copy-up=/etc")
+		}
+	}
+	if clicontext.Bool("export-tz") && !clicontext.Bool("copy-localtime") {
+		return opt, errors.New("--export-tz requires --copy-localtime")
+	}
+	if _, err := child.ParseResolvConfMode(clicontext.String("resolv-conf")); err != nil {
+		return opt, errors.Wrap(err, "invalid --resolv-conf")
+	}
+	if clicontext.IsSet("resolv-conf") && clicontext.String("net") == "host" {
+		return opt, errors.New("--resolv-conf is not supported for --net=host")
+	}
+	for _, d := range clicontext.StringSlice("dns-search") {
+		if err := child.ValidateDNSSearchDomain(d); err != nil {
+			return opt, err
+		}
+	}
+	for _, o := range clicontext.StringSlice("dns-option") {
+		if err := child.ValidateDNSOption(o); err != nil {
+			return opt, err
+		}
+	}
+	// parsed in createChildOpt
+	if _, err := child.ParseRestartPolicy(clicontext.String("restart")); err != nil {
+		return opt, err
+	}
+	if s := clicontext.String("umask"); s != "" {
+		if _, err := parseUmask(s); err != nil {
+			return opt, err
+		}
+	}
+	// parsed in createChildOpt
+	if _, err := child.ParseCapDrop(clicontext.StringSlice("cap-drop")); err != nil {
+		return opt, err
+	}
+	for _, s := range clicontext.StringSlice("rlimit") {
+		rlimit, err := child.ParseRlimit(s)
+		if err != nil {
+			return opt, err
+		}
+		// the hard limit cannot be raised in the child user namespace either
+		if err := rlimit.Validate(); err != nil {
+			return opt, err
+		}
 	}
-	app.Before = func(context *cli.Context) error {
-		if debug {
-			logrus.SetLevel(logrus.DebugLevel)
+	if s := clicontext.String("cpu-affinity"); s != "" {
+		cpus, err := child.ParseCPUList(s)
+		if err != nil {
+			return opt, err
+		}
+		if err := child.ValidateCPUAffinity(cpus); err != nil {
+			return opt, err
 		}
-		return nil
 	}
-	app.Action = func(clicontext *cli.Context) error {
-		if clicontext.NArg() < 1 {
-			return errors.New("no command specified")
+	if s := clicontext.String("sched-policy"); s != "" {
+		if _, err := child.ParseSchedPolicy(s); err != nil {
+			return opt, err
 		}
-		if iAmChild {
-			childOpt, err := createChildOpt(clicontext, pipeFDEnvKey, clicontext.Args())
-			if err != nil {
-				return err
-			}
-			return child.Child(childOpt)
+	}
+	if s := clicontext.String("process-name"); s != "" {
+		if err := child.ValidateProcessName(s); err != nil {
+			return opt, err
 		}
-		parentOpt, err := createParentOpt(clicontext, pipeFDEnvKey, stateDirEnvKey)
+	}
+	if len(clicontext.StringSlice("mask-env")) != 0 && !clicontext.Bool("export-env") {
+		return opt, errors.New("--mask-env requires --export-env")
+	}
+	if clicontext.IsSet("oom-score-adj") {
+		score := clicontext.Int("oom-score-adj")
+		if score < -1000 || score > 1000 {
+			return opt, errors.Errorf("--oom-score-adj needs to be between -1000 and 1000, got %d", score)
+		}
+		opt.OOMScoreAdj = &score
+	}
+	if clicontext.Bool("copy-up-create-missing") && clicontext.Bool("copy-up-skip-missing") {
+		return opt, errors.New("--copy-up-create-missing and --copy-up-skip-missing are exclusive")
+	}
+	if s := clicontext.String("copy-up-persist"); s != "" {
+		if len(clicontext.StringSlice("copy-up")) == 0 {
+			return opt, errors.New("--copy-up-persist requires --copy-up")
+		}
+		if clicontext.Bool("copy-up-strict") {
+			return opt, errors.New("--copy-up-persist conflicts with --copy-up-strict")
+		}
+		if err := validateCopyUpPersistDir(s); err != nil {
+			return opt, err
+		}
+	}
+	if clicontext.Bool("exit-on-child-death") && !opt.CreatePIDNS {
+		return opt, errors.New("--exit-on-child-death requires --pidns")
+	}
+	joinedNS := make(map[string]bool)
+	for _, s := range clicontext.StringSlice("join-ns") {
+		ns, err := child.ParseJoinNS(s)
 		if err != nil {
-			return err
+			return opt, errors.Wrap(err, "invalid --join-ns")
+		}
+		if joinedNS[ns.Type] {
+			return opt, errors.Errorf("--join-ns: %s namespace is specified multiple times", ns.Type)
+		}
+		joinedNS[ns.Type] = true
+		switch {
+		case ns.Type == "net" && clicontext.String("net") != "host":
+			return opt, errors.New("--join-ns=net:... conflicts with the network namespace created for --net, use --net=host")
+		case ns.Type == "pid" && opt.CreatePIDNS:
+			return opt, errors.New("--join-ns=pid:... conflicts with --pidns")
+		case ns.Type == "cgroup" && opt.CreateCgroupNS:
+			return opt, errors.New("--join-ns=cgroup:... conflicts with --cgroupns")
+		}
+		if _, err := os.Stat(ns.Path); err != nil {
+			return opt, errors.Wrap(err, "invalid --join-ns")
 		}
-		return parent.Parent(parentOpt)
 	}
-	if err := app.Run(os.Args); err != nil {
-		id := "parent"
-		if iAmChild {
-			id = "child " // padded to len("parent")
+	if clicontext.Bool("mount-cgroup2") {
+		if !opt.CreateCgroupNS {
+			return opt, errors.New("--mount-cgroup2 requires --cgroupns")
 		}
-		if debug {
-			fmt.Fprintf(os.Stderr, "[rootlesskit:%s] error: %+v\n", id, err)
-		} else {
-			fmt.Fprintf(os.Stderr, "[rootlesskit:%s] error: %v\n", id, err)
+		if err := parent.ValidateCgroup2Delegation(); err != nil {
+			return opt, err
 		}
-		// propagate the exit code
-		code, ok := common.GetExecExitStatus(err)
-		if !ok {
-			code = 1
+	}
+	if s := clicontext.String("memory"); s != "" {
+		opt.MemoryMax, err = parent.ParseMemoryMax(s)
+		if err != nil {
+			return opt, errors.Wrap(err, "invalid --memory")
+		}
+		if err := parent.ValidateCgroup2Delegation(); err != nil {
+			return opt, errors.Wrap(err, "--memory requires cgroup v2 delegation")
+		}
+		if err := parent.ValidateCgroup2Controller("memory"); err != nil {
+			return opt, errors.Wrap(err, "--memory requires the memory controller")
 		}
-		os.Exit(code)
 	}
-}
-
-func parseCIDR(s string) (*net.IPNet, error) {
-	if s == "" {
-		return nil, nil
+	if rootfs := clicontext.String("rootfs"); rootfs != "" {
+		if st, err := os.Stat(rootfs); err != nil {
+			return opt, errors.Wrap(err, "invalid --rootfs")
+		} else if !st.IsDir() {
+			return opt, errors.Errorf("--rootfs %q is not a directory", rootfs)
+		}
+		if clicontext.Bool("ro-host") {
+			return opt, errors.New("--ro-host cannot be combined with --rootfs, use --read-only instead")
+		}
+		if _, err := child.ParseDevMode(clicontext.String("dev-mode")); err != nil {
+			return opt, errors.Wrap(err, "invalid --dev-mode")
+		}
+	} else if clicontext.IsSet("dev-mode") {
+		return opt, errors.New("--dev-mode requires --rootfs")
+	} else if clicontext.Bool("read-only") {
+		return opt, errors.New("--read-only requires --rootfs")
+	} else if clicontext.Bool("no-pivot") {
+		return opt, errors.New("--no-pivot requires --rootfs")
 	}
-	ip, ipnet, err := net.ParseCIDR(s)
-	if err != nil {
-		return nil, err
+	for _, p := range clicontext.StringSlice("ro-host-writable") {
+		if !clicontext.Bool("ro-host") {
+			return opt, errors.New("--ro-host-writable requires --ro-host")
+		}
+		if st, err := os.Stat(p); err != nil {
+			return opt, errors.Wrap(err, "invalid --ro-host-writable")
+		} else if !st.IsDir() {
+			return opt, errors.Errorf("--ro-host-writable %q is not a directory", p)
+		}
 	}
-	if !ip.Equal(ipnet.IP) {
-		return nil, errors.Errorf("cidr must be like 10.0.2.0/24, not like 10.0.2.100/24")
+	if clicontext.Bool("bind-runtime-dir") {
+		if err := child.ValidateRuntimeDir(child.RuntimeDir(os.Geteuid()), os.Geteuid()); err != nil {
+			return opt, errors.Wrap(err, "invalid --bind-runtime-dir")
+		}
 	}
-	return ipnet, nil
-}
-
-func createParentOpt(clicontext *cli.Context, pipeFDEnvKey, stateDirEnvKey string) (parent.Opt, error) {
-	var err error
-	opt := parent.Opt{
-		PipeFDEnvKey:   pipeFDEnvKey,
-		StateDirEnvKey: stateDirEnvKey,
-		CreatePIDNS:    clicontext.Bool("pidns"),
+	if cwd := clicontext.String("cwd"); cwd != "" && clicontext.String("rootfs") != "" && !filepath.IsAbs(cwd) {
+		return opt, errors.Errorf("--cwd must be an absolute path when --rootfs is specified, got %q", cwd)
 	}
 	opt.StateDir = clicontext.String("state-dir")
+	if strings.HasPrefix(opt.StateDir, stateDirTmpfsPrefix) {
+		opt.StateDirTmpfs = true
+		opt.StateDir = strings.TrimPrefix(opt.StateDir, stateDirTmpfsPrefix)
+		if clicontext.Bool("restore-ports") {
+			return opt, errors.New("--restore-ports cannot be combined with a tmpfs state directory, as the state directory is removed on exit")
+		}
+	}
+	if name := clicontext.String("name"); name != "" {
+		if opt.StateDir != "" {
+			return opt, errors.New("--name cannot be combined with --state-dir")
+		}
+		opt.Name = name
+		opt.StateDir, err = instance.StateDir(name)
+		if err != nil {
+			return opt, errors.Wrap(err, "invalid --name")
+		}
+	}
 	if opt.StateDir == "" {
-		opt.StateDir, err = ioutil.TempDir("", "rootlesskit")
+		tempBase := ""
+		if opt.StateDirTmpfs {
+			// prefer $XDG_RUNTIME_DIR, which is typically on tmpfs, for the case where mounting tmpfs is not permitted
+			if base, err := instance.BaseDir(); err == nil && os.MkdirAll(base, 0700) == nil {
+				tempBase = base
+			}
+		}
+		opt.StateDir, err = ioutil.TempDir(tempBase, "rootlesskit")
 		if err != nil {
 			return opt, errors.Wrap(err, "creating a state directory")
 		}
@@ -195,166 +927,321 @@ func createParentOpt(clicontext *cli.Context, pipeFDEnvKey, stateDirEnvKey strin
 		if err != nil {
 			return opt, err
 		}
-		if err = os.MkdirAll(opt.StateDir, 0755); err != nil {
+		perm := os.FileMode(0755)
+		if opt.StateDirTmpfs {
+			perm = 0700
+		}
+		if err = os.MkdirAll(opt.StateDir, perm); err != nil {
 			return opt, errors.Wrapf(err, "creating a state directory %s", opt.StateDir)
 		}
 	}
 
+	opt.APISocket = clicontext.String("api-socket")
+	opt.APIToken = clicontext.String("api-token")
+	if opt.APISocket != "" {
+		apiNetwork, apiAddr, err := api.ParseSocket(opt.APISocket)
+		if err != nil {
+			return opt, err
+		}
+		switch apiNetwork {
+		case "unix":
+			apiAddr, err = filepath.Abs(apiAddr)
+			if err != nil {
+				return opt, err
+			}
+			opt.APISocket = apiAddr
+		case "tcp":
+			if opt.APIToken == "" {
+				return opt, errors.New("--api-token is required for \"tcp://\" API socket")
+			}
+		}
+	}
+
 	mtu := clicontext.Int("mtu")
-	if mtu < 0 || mtu > 65521 {
+	if mtu != 0 {
 		// 0 is ok (stands for the driver's default)
-		return opt, errors.Errorf("mtu must be <= 65521, got %d", mtu)
+		if err := network.ValidateMTU(mtu); err != nil {
+			return opt, err
+		}
 	}
 	ipnet, err := parseCIDR(clicontext.String("cidr"))
 	if err != nil {
 		return opt, err
 	}
+	ipv6Only := clicontext.Bool("ipv6-only")
+	ipv6 := clicontext.Bool("ipv6")
+	if ipv6Only && !ipv6 {
+		return opt, errors.New("--ipv6-only requires --ipv6")
+	}
+	if ipv6Only && ipnet != nil {
+		return opt, errors.New("--ipv6-only conflicts with --cidr")
+	}
+	if ipv6 && clicontext.String("net") != "slirp4netns" {
+		return opt, errors.New("--ipv6 and --ipv6-only are supported only for --net=slirp4netns")
+	}
+	if clicontext.String("ip") != "" && clicontext.String("net") != "bridge" && clicontext.String("net") != "socket" {
+		return opt, errors.New("--ip is supported only for --net=bridge and --net=socket")
+	}
+	if clicontext.String("net") != "socket" {
+		if clicontext.IsSet("net-fd") {
+			return opt, errors.New("--net-fd is supported only for --net=socket")
+		}
+		if clicontext.String("socket-gateway") != "" {
+			return opt, errors.New("--socket-gateway is supported only for --net=socket")
+		}
+	}
 	disableHostLoopback := clicontext.Bool("disable-host-loopback")
+	disableHostLoopbackTCP := clicontext.Bool("disable-host-loopback-tcp")
+	disableHostLoopbackUDP := clicontext.Bool("disable-host-loopback-udp")
+	// the protocol that remains reachable via the allow-list, when host loopback is disabled only for the other protocol
+	emulatedHostLoopbackProto := ""
+	if !disableHostLoopback && disableHostLoopbackTCP != disableHostLoopbackUDP {
+		// network drivers cannot disable host loopback per protocol
+		emulatedHostLoopbackProto = "udp"
+		if disableHostLoopbackUDP {
+			emulatedHostLoopbackProto = "tcp"
+		}
+	}
+	if disableHostLoopbackTCP || disableHostLoopbackUDP {
+		disableHostLoopback = true
+	}
 	if !disableHostLoopback && clicontext.String("net") != "host" {
 		logrus.Warn("specifying --disable-host-loopback is highly recommended to prohibit connecting to 127.0.0.1:* on the host namespace (requires slirp4netns v0.3.0+ or VPNKit)")
 	}
 
+	emulated := false
+	for _, addr := range clicontext.StringSlice("allow-host-loopback") {
+		if clicontext.String("net") == "host" {
+			return opt, errors.New("--allow-host-loopback requires non-host network")
+		}
+		if err := hostloopback.ValidateAddr(addr); err != nil {
+			return opt, errors.Wrap(err, "invalid --allow-host-loopback")
+		}
+		if _, proto := hostloopback.SplitProto(addr); proto == emulatedHostLoopbackProto {
+			emulated = true
+		}
+		opt.AllowHostLoopback = append(opt.AllowHostLoopback, addr)
+	}
+	if emulatedHostLoopbackProto != "" && !emulated {
+		return opt, errors.Errorf("host loopback cannot be disabled per protocol by the network driver, "+
+			"so the %s endpoints to be allowed need to be specified as --allow-host-loopback=IP:PORT/%s",
+			emulatedHostLoopbackProto, emulatedHostLoopbackProto)
+	}
+
+	// the target command in the pivoted rootfs cannot see the directories copied up at runtime
+	if clicontext.String("rootfs") == "" {
+		opt.CopyUpManager = remote.NewManager(remote.SocketPath(opt.StateDir))
+	}
+
 	slirp4netnsAPISocketPath := ""
 	if clicontext.String("port-driver") == "slirp4netns" {
 		slirp4netnsAPISocketPath = filepath.Join(opt.StateDir, ".s4nn.sock")
 	}
-	switch s := clicontext.String("net"); s {
-	case "host":
-		// NOP
-		if mtu != 0 {
-			logrus.Warnf("unsupported mtu for --net=host: %d", mtu)
-		}
-		if ipnet != nil {
-			return opt, errors.New("custom cidr is supported only for --net=slirp4netns (with slirp4netns v0.3.0+)")
+	netnsPath := clicontext.String("netns")
+	if netnsPath != "" {
+		if clicontext.String("net") != "slirp4netns" {
+			return opt, errors.New("--netns is supported only for --net=slirp4netns")
 		}
-	case "slirp4netns":
-		binary := clicontext.String("slirp4netns-binary")
-		if _, err := exec.LookPath(binary); err != nil {
-			return opt, err
+		if !opt.InheritUserNS {
+			return opt, errors.New("--netns requires --inherit-userns")
 		}
-		features, err := slirp4netns.DetectFeatures(binary)
+		netnsPath, err = filepath.Abs(netnsPath)
 		if err != nil {
 			return opt, err
 		}
-		logrus.Debugf("slirp4netns features %+v", features)
-		if disableHostLoopback && !features.SupportsDisableHostLoopback {
-			return opt, errors.New("unsupported slirp4netns version: lacks SupportsDisableHostLoopback, please install v0.3.0+")
+		if err := parent.ValidateNetNSPath(netnsPath); err != nil {
+			return opt, err
 		}
-		if slirp4netnsAPISocketPath != "" && !features.SupportsAPISocket {
-			return opt, errors.New("unsupported slirp4netns version: lacks SupportsAPISocket, please install v0.3.0+")
+		opt.NetNS = netnsPath
+	}
+	for _, s := range clicontext.StringSlice("sysctl") {
+		if clicontext.String("net") == "host" {
+			return opt, errors.New("--sysctl is not supported for --net=host")
 		}
-		enableSandbox := false
-		switch s := clicontext.String("slirp4netns-sandbox"); s {
-		case "auto":
-			// this might not work when /etc/resolv.conf is a symlink to a file outside /etc or /run
-			// https://github.com/rootless-containers/slirp4netns/issues/116
-			enableSandbox = features.SupportsEnableSandbox
-		case "true":
-			enableSandbox = true
-			if !features.SupportsEnableSandbox {
-				return opt, errors.New("unsupported slirp4netns version: lacks SupportsEnableSandbox, please install v0.4.0+")
-			}
-		case "false", "": // default
-			// NOP
-		default:
-			return opt, errors.Errorf("unsupported slirp4netns-sandbox mode: %q", s)
-		}
-		enableSeccomp := false
-		switch s := clicontext.String("slirp4netns-seccomp"); s {
-		case "auto":
-			enableSeccomp = features.SupportsEnableSeccomp && features.KernelSupportsEnableSeccomp
-		case "true":
-			enableSeccomp = true
-			if !features.SupportsEnableSeccomp {
-				return opt, errors.New("unsupported slirp4netns version: lacks SupportsEnableSeccomp, please install v0.4.0+")
-			}
-			if !features.KernelSupportsEnableSeccomp {
-				return opt, errors.New("kernel doesn't support seccomp")
-			}
-		case "false", "": // default
-			// NOP
-		default:
-			return opt, errors.Errorf("unsupported slirp4netns-seccomp mode: %q", s)
+		if _, err := child.ParseSysctl(s); err != nil {
+			return opt, err
 		}
-		opt.NetworkDriver = slirp4netns.NewParentDriver(binary, mtu, ipnet, disableHostLoopback, slirp4netnsAPISocketPath, enableSandbox, enableSeccomp)
-	case "vpnkit":
-		if ipnet != nil {
-			return opt, errors.New("custom cidr is supported only for --net=slirp4netns (with slirp4netns v0.3.0+)")
+	}
+	if clicontext.String("child-iptables-rules") != "" && clicontext.String("net") == "host" {
+		return opt, errors.New("--child-iptables-rules is not supported for --net=host")
+	}
+	if clicontext.IsSet("firewall-backend") {
+		if clicontext.String("child-iptables-rules") == "" {
+			return opt, errors.New("--firewall-backend requires --child-iptables-rules")
 		}
-		binary := clicontext.String("vpnkit-binary")
-		if _, err := exec.LookPath(binary); err != nil {
+		backend, err := firewall.ParseBackend(clicontext.String("firewall-backend"))
+		if err != nil {
 			return opt, err
 		}
-		opt.NetworkDriver = vpnkit.NewParentDriver(binary, mtu, disableHostLoopback)
-	case "lxc-user-nic":
-		logrus.Warn("\"lxc-user-nic\" network driver is experimental")
-		if ipnet != nil {
-			return opt, errors.New("custom cidr is supported only for --net=slirp4netns (with slirp4netns v0.3.0+)")
+		// detect in the parent as well, so as to fail early without forking the child
+		if _, err := firewall.Detect(backend); err != nil {
+			return opt, err
 		}
-		if !disableHostLoopback {
-			logrus.Warn("--disable-host-loopback is implicitly set for lxc-user-nic")
+	}
+	if clicontext.Bool("no-loopback-setup") && clicontext.String("net") == "host" {
+		return opt, errors.New("--no-loopback-setup is not supported for --net=host")
+	}
+	helperSeccomp := clicontext.String("helper-seccomp")
+	if helperSeccomp != "" {
+		switch n := clicontext.String("net"); n {
+		case "slirp4netns", "vpnkit":
+		default:
+			return opt, errors.Errorf("--helper-seccomp is not supported for --net=%s", n)
 		}
-		binary := clicontext.String("lxc-user-nic-binary")
-		if _, err := exec.LookPath(binary); err != nil {
+		// the profile is loaded again in the shim, with a different working directory
+		if helperSeccomp, err = filepath.Abs(helperSeccomp); err != nil {
 			return opt, err
 		}
-		opt.NetworkDriver, err = lxcusernic.NewParentDriver(binary, mtu, clicontext.String("lxc-user-nic-bridge"))
-		if err != nil {
+		if _, err := seccomp.LoadProfile(helperSeccomp); err != nil {
 			return opt, err
 		}
-	case "vdeplug_slirp":
-		logrus.Warn("\"vdeplug_slirp\" network driver is deprecated")
-		if ipnet != nil {
-			return opt, errors.New("custom cidr is supported only for --net=slirp4netns (with slirp4netns v0.3.0+)")
+	}
+	ifname := clicontext.String("ifname")
+	if clicontext.IsSet("ifname") {
+		if clicontext.String("net") != "slirp4netns" {
+			return opt, errors.New("--ifname is supported only for --net=slirp4netns")
 		}
-		if disableHostLoopback {
-			return opt, errors.New("--disable-host-loopback is not supported for vdeplug_slirp")
+		if err := parentutils.ValidateIfName(ifname); err != nil {
+			return opt, errors.Wrap(err, "invalid --ifname")
 		}
-		opt.NetworkDriver = vdeplugslirp.NewParentDriver(mtu)
-	default:
-		return opt, errors.Errorf("unknown network mode: %s", s)
 	}
-	switch s := clicontext.String("port-driver"); s {
-	case "none":
-		// NOP
-	case "socat":
-		logrus.Warn("\"socat\" port driver is deprecated")
-		if opt.NetworkDriver == nil {
-			return opt, errors.New("port driver requires non-host network")
+	var mac net.HardwareAddr
+	if s := clicontext.String("macaddress"); s != "" {
+		switch n := clicontext.String("net"); n {
+		case "slirp4netns", "lxc-user-nic", "bridge", "socket":
+		default:
+			return opt, errors.Errorf("--macaddress is not supported for --net=%s", n)
 		}
-		opt.PortDriver, err = socat.NewParentDriver(&logrusDebugWriter{})
+		mac, err = parentutils.ParseMAC(s)
 		if err != nil {
-			return opt, err
+			return opt, errors.Wrap(err, "invalid --macaddress")
 		}
-	case "slirp4netns":
-		logrus.Warn("\"slirp4netns\" port driver is deprecated")
-		if clicontext.String("net") != "slirp4netns" {
-			return opt, errors.New("port driver requires slirp4netns network")
+	}
+	netReadyTimeout := clicontext.Duration("net-ready-timeout")
+	if clicontext.IsSet("net-ready-timeout") {
+		if s := clicontext.String("net"); s != "slirp4netns" && s != "vpnkit" {
+			return opt, errors.Errorf("--net-ready-timeout is not supported for --net=%s", s)
+		}
+		if netReadyTimeout < 0 {
+			return opt, errors.Errorf("invalid --net-ready-timeout: %v", netReadyTimeout)
 		}
-		opt.PortDriver, err = slirp4netns_port.NewParentDriver(&logrusDebugWriter{}, slirp4netnsAPISocketPath)
+	}
+	netParams := &netParams{
+		mtu:                      mtu,
+		ipnet:                    ipnet,
+		disableHostLoopback:      disableHostLoopback,
+		ipv6:                     ipv6,
+		ipv6Only:                 ipv6Only,
+		readyTimeout:             netReadyTimeout,
+		slirp4netnsAPISocketPath: slirp4netnsAPISocketPath,
+		netnsPath:                netnsPath,
+		ifname:                   ifname,
+		mac:                      mac,
+		helperSeccomp:            helperSeccomp,
+	}
+	netDriver, err := lookupNetDriver(clicontext.String("net"))
+	if err != nil {
+		return opt, err
+	}
+	warnDriverStatus("network driver", netDriver.Driver)
+	opt.NetworkDriver, err = netDriver.newParent(clicontext, netParams)
+	if err != nil {
+		return opt, err
+	}
+	if clicontext.String("port-access-log") != "" && clicontext.String("port-driver") != "builtin" {
+		return opt, errors.New("--port-access-log requires --port-driver=builtin")
+	}
+	if (clicontext.IsSet("socat-port-block-size") || clicontext.IsSet("socat-port-sockbuf-size")) && clicontext.String("port-driver") != "socat" {
+		return opt, errors.New("--socat-port-block-size and --socat-port-sockbuf-size require --port-driver=socat")
+	}
+	portDriver, err := lookupPortDriver(clicontext.String("port-driver"))
+	if err != nil {
+		return opt, err
+	}
+	warnDriverStatus("port driver", portDriver.Driver)
+	opt.PortDriver, err = portDriver.newParent(clicontext, &opt, netParams)
+	if err != nil {
+		return opt, err
+	}
+	for _, s := range clicontext.StringSlice("publish") {
+		spec, err := portutil.ParsePortSpec(s)
 		if err != nil {
 			return opt, err
 		}
-	case "builtin":
-		if opt.NetworkDriver == nil {
-			return opt, errors.New("port driver requires non-host network")
+		resolved := *spec
+		if clicontext.String("port-driver") == "builtin" {
+			// validate the resolved address, but keep the hostname so that AddPort resolves it again
+			resolved, err = portutil.ResolveParentIP(context.TODO(), *spec)
+			if err != nil {
+				return opt, err
+			}
+		}
+		if err := portutil.ValidatePortSpec(resolved, nil); err != nil {
+			return opt, err
+		}
+		opt.PublishPorts = append(opt.PublishPorts, *spec)
+	}
+	natPorts := 0
+	for _, s := range clicontext.StringSlice("nat-1to1") {
+		if clicontext.String("port-driver") != "builtin" {
+			return opt, errors.New("--nat-1to1 requires --port-driver=builtin")
 		}
-		opt.PortDriver, err = builtin.NewParentDriver(&logrusDebugWriter{}, opt.StateDir)
+		specs, err := portutil.ParseNAT1to1(s, portutil.UnprivilegedPortStart())
 		if err != nil {
 			return opt, err
 		}
+		for _, sp := range specs {
+			natPorts += sp.PortCount
+		}
+		opt.PublishPorts = append(opt.PublishPorts, specs...)
+	}
+	if natPorts != 0 {
+		// a socket per port is bound in the parent. Fail before binding any of them.
+		if err := portutil.CheckNoFile(natPorts); err != nil {
+			return opt, errors.Wrapf(err, "--nat-1to1 binds %d ports (limit the range with HOSTIP:CHILDIP:START-END)", natPorts)
+		}
+	}
+	switch s := clicontext.String("child-ip-check"); s {
+	case "strict":
+	case "warn":
+		opt.WarnChildIP = true
 	default:
-		return opt, errors.Errorf("unknown port driver: %s", s)
+		return opt, errors.Errorf("unknown --child-ip-check: %q (must be either \"strict\" or \"warn\")", s)
 	}
-	for _, s := range clicontext.StringSlice("publish") {
-		spec, err := portutil.ParsePortSpec(s)
+	if opt.PublishFile = clicontext.String("publish-file"); opt.PublishFile != "" {
+		if opt.PortDriver == nil {
+			return opt, errors.New("--publish-file requires --port-driver")
+		}
+		opt.PublishFile, err = filepath.Abs(opt.PublishFile)
 		if err != nil {
 			return opt, err
 		}
-		if err := portutil.ValidatePortSpec(*spec, nil); err != nil {
-			return opt, err
+	}
+	if clicontext.Bool("auto-publish") {
+		if clicontext.String("port-driver") != "builtin" {
+			return opt, errors.New("--auto-publish requires --port-driver=builtin")
+		}
+		interval := clicontext.Duration("auto-publish-interval")
+		if interval <= 0 {
+			return opt, errors.New("--auto-publish-interval must be positive")
+		}
+		opt.AutoPublish = &parent.AutoPublishOpt{Interval: interval}
+		if s := clicontext.String("auto-publish-ports"); s != "" {
+			opt.AutoPublish.Ports, err = parent.ParsePortRanges(s)
+			if err != nil {
+				return opt, errors.Wrap(err, "invalid --auto-publish-ports")
+			}
+		}
+	} else if clicontext.IsSet("auto-publish-interval") || clicontext.IsSet("auto-publish-ports") {
+		return opt, errors.New("--auto-publish-interval and --auto-publish-ports require --auto-publish")
+	}
+	if opt.RestorePorts = clicontext.Bool("restore-ports"); opt.RestorePorts {
+		if opt.PortDriver == nil {
+			return opt, errors.New("--restore-ports requires --port-driver")
+		}
+		if !clicontext.IsSet("state-dir") && !clicontext.IsSet("name") {
+			return opt, errors.New("--restore-ports requires --state-dir or --name")
 		}
-		opt.PublishPorts = append(opt.PublishPorts, *spec)
 	}
 	return opt, nil
 }
@@ -370,44 +1257,181 @@ func (w *logrusDebugWriter) Write(p []byte) (int, error) {
 
 func createChildOpt(clicontext *cli.Context, pipeFDEnvKey string, targetCmd []string) (child.Opt, error) {
 	opt := child.Opt{
-		PipeFDEnvKey: pipeFDEnvKey,
-		TargetCmd:    targetCmd,
-		MountProcfs:  clicontext.Bool("pidns"),
-		Reaper:       clicontext.Bool("pidns"),
-	}
-	switch s := clicontext.String("net"); s {
-	case "host":
-		// NOP
-	case "slirp4netns":
-		opt.NetworkDriver = slirp4netns.NewChildDriver()
-	case "vpnkit":
-		opt.NetworkDriver = vpnkit.NewChildDriver()
-	case "lxc-user-nic":
-		opt.NetworkDriver = lxcusernic.NewChildDriver()
-	case "vdeplug_slirp":
-		opt.NetworkDriver = vdeplugslirp.NewChildDriver()
-	default:
-		return opt, errors.Errorf("unknown network mode: %s", s)
+		PipeFDEnvKey:       pipeFDEnvKey,
+		TargetCmd:          targetCmd,
+		MountProcfs:        clicontext.Bool("pidns"),
+		Reaper:             clicontext.Bool("pidns"),
+		ExitOnChildDeath:   clicontext.Bool("exit-on-child-death"), // validated in createParentOpt
+		ReadOnly:           clicontext.Bool("read-only"),           // validated in createParentOpt
+		NoPivot:            clicontext.Bool("no-pivot"),            // validated in createParentOpt
+		ROHost:             clicontext.Bool("ro-host"),             // validated in createParentOpt
+		SetupCmds:          clicontext.StringSlice("exec"),
+		Setsid:             clicontext.Bool("setsid"),
+		ExportEnv:          clicontext.Bool("export-env"),
+		MaskEnv:            clicontext.StringSlice("mask-env"),
+		ResolvConf:         child.ResolvConfMode(clicontext.String("resolv-conf")), // validated in createParentOpt
+		DNSSearch:          clicontext.StringSlice("dns-search"),                   // validated in createParentOpt
+		DNSOptions:         clicontext.StringSlice("dns-option"),                   // validated in createParentOpt
+		BindSys:            clicontext.Bool("bind-sys"),
+		NoLoopbackSetup:    clicontext.Bool("no-loopback-setup"),
+		MountCgroup2:       clicontext.Bool("mount-cgroup2"),
+		ProcessName:        clicontext.String("process-name"),
+		WriteNSSwitch:      clicontext.Bool("write-nsswitch"),       // validated in createParentOpt
+		WriteNSSwitchForce: clicontext.Bool("write-nsswitch-force"), // validated in createParentOpt
+		CopyLocaltime:      clicontext.Bool("copy-localtime"),
+		ExportTZ:           clicontext.Bool("export-tz"), // validated in createParentOpt
 	}
-	switch s := clicontext.String("copy-up-mode"); s {
-	case "tmpfs+symlink":
-		opt.CopyUpDriver = tmpfssymlink.NewChildDriver()
-	default:
-		return opt, errors.Errorf("unknown copy-up mode: %s", s)
+	var err error
+	opt.RestartPolicy, err = child.ParseRestartPolicy(clicontext.String("restart"))
+	if err != nil {
+		return opt, err
+	}
+	if s := clicontext.String("umask"); s != "" {
+		umask, err := parseUmask(s)
+		if err != nil {
+			return opt, err
+		}
+		opt.Umask = &umask
+	}
+	for _, s := range clicontext.StringSlice("rlimit") {
+		rlimit, err := child.ParseRlimit(s)
+		if err != nil {
+			return opt, err
+		}
+		opt.Rlimits = append(opt.Rlimits, rlimit)
+	}
+	if s := clicontext.String("cpu-affinity"); s != "" {
+		// validated in createParentOpt
+		opt.CPUAffinity, err = child.ParseCPUList(s)
+		if err != nil {
+			return opt, err
+		}
+	}
+	if s := clicontext.String("sched-policy"); s != "" {
+		// validated in createParentOpt
+		policy, err := child.ParseSchedPolicy(s)
+		if err != nil {
+			return opt, err
+		}
+		opt.SchedPolicy = &policy
+	}
+	opt.CapDrop, err = child.ParseCapDrop(clicontext.StringSlice("cap-drop"))
+	if err != nil {
+		return opt, err
+	}
+	for _, s := range clicontext.StringSlice("join-ns") {
+		// validated in createParentOpt
+		ns, err := child.ParseJoinNS(s)
+		if err != nil {
+			return opt, err
+		}
+		opt.JoinNS = append(opt.JoinNS, ns)
+	}
+	for _, p := range clicontext.StringSlice("ro-host-writable") {
+		// validated in createParentOpt
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return opt, err
+		}
+		opt.ROHostWritable = append(opt.ROHostWritable, abs)
+	}
+	for _, s := range clicontext.StringSlice("sysctl") {
+		// validated in createParentOpt
+		sc, _ := child.ParseSysctl(s)
+		opt.Sysctls = append(opt.Sysctls, sc)
+	}
+	if s := clicontext.String("child-iptables-rules"); s != "" {
+		// validated in createParentOpt
+		opt.IPTablesRules, err = filepath.Abs(s)
+		if err != nil {
+			return opt, err
+		}
+		// validated in createParentOpt
+		opt.FirewallBackend, _ = firewall.ParseBackend(clicontext.String("firewall-backend"))
+	}
+	// validated in createParentOpt
+	opt.Stdio.Append = clicontext.Bool("stdio-append")
+	for _, x := range []struct {
+		flag string
+		p    *string
+	}{
+		{"stdin", &opt.Stdio.Stdin},
+		{"stdout", &opt.Stdio.Stdout},
+		{"stderr", &opt.Stdio.Stderr},
+	} {
+		if s := clicontext.String(x.flag); s != "" {
+			*x.p, err = filepath.Abs(s)
+			if err != nil {
+				return opt, err
+			}
+		}
+	}
+	// validated in createParentOpt
+	opt.Script.Stdin = clicontext.Bool("exec-stdin")
+	if s := clicontext.String("exec-script"); s != "" {
+		// validated in createParentOpt
+		opt.Script.Path, err = filepath.Abs(s)
+		if err != nil {
+			return opt, err
+		}
+	}
+	if clicontext.Bool("bind-runtime-dir") {
+		// validated in createParentOpt
+		opt.RuntimeDir = child.RuntimeDir(os.Geteuid())
+	}
+	if rootfs := clicontext.String("rootfs"); rootfs != "" {
+		var err error
+		opt.Rootfs, err = filepath.Abs(rootfs)
+		if err != nil {
+			return opt, err
+		}
+		// validated in createParentOpt
+		opt.DevMode, _ = child.ParseDevMode(clicontext.String("dev-mode"))
+		opt.Cwd = clicontext.String("cwd")
+	} else if cwd := clicontext.String("cwd"); cwd != "" {
+		var err error
+		opt.Cwd, err = filepath.Abs(cwd)
+		if err != nil {
+			return opt, err
+		}
+	}
+	netDriver, err := lookupNetDriver(clicontext.String("net"))
+	if err != nil {
+		return opt, err
+	}
+	opt.NetworkDriver, err = netDriver.newChild(clicontext)
+	if err != nil {
+		return opt, err
+	}
+	var copyUpPersistDir string
+	if s := clicontext.String("copy-up-persist"); s != "" {
+		// validated in createParentOpt
+		copyUpPersistDir, err = filepath.Abs(s)
+		if err != nil {
+			return opt, err
+		}
+	}
+	opt.CopyUpDriver, err = copyup.New(clicontext.String("copy-up-mode"), copyup.Options{
+		Excludes:   clicontext.StringSlice("copy-up-exclude"),
+		RealFiles:  append(append([]string(nil), copyup.DefaultRealFiles...), clicontext.StringSlice("copy-up-real-files")...),
+		Strict:     clicontext.Bool("copy-up-strict"),
+		PersistDir: copyUpPersistDir,
+	})
+	if err != nil {
+		return opt, err
 	}
 	opt.CopyUpDirs = clicontext.StringSlice("copy-up")
-	switch s := clicontext.String("port-driver"); s {
-	case "none":
-		// NOP
-	case "socat":
-		opt.PortDriver = socat.NewChildDriver()
-	case "slirp4netns":
-		opt.PortDriver = slirp4netns_port.NewChildDriver()
-	case "builtin":
-		opt.PortDriver = builtin.NewChildDriver(&logrusDebugWriter{})
-	default:
-		return opt, errors.Errorf("unknown port driver: %s", s)
+	switch {
+	case clicontext.Bool("copy-up-create-missing"):
+		opt.CopyUpMissing = child.CopyUpMissingCreate
+	case clicontext.Bool("copy-up-skip-missing"):
+		opt.CopyUpMissing = child.CopyUpMissingSkip
+	}
+	portDriver, err := lookupPortDriver(clicontext.String("port-driver"))
+	if err != nil {
+		return opt, err
 	}
+	opt.PortDriver = portDriver.newChild()
 	return opt, nil
 }
 
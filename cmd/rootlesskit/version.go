@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"github.com/rootless-containers/rootlesskit/pkg/version"
+)
+
+var versionCommand = cli.Command{
+	Name:      "version",
+	Usage:     "Show the version",
+	ArgsUsage: "[flags]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "Prints as JSON, with the versions of the helper binaries",
+		},
+	},
+	Action: versionAction,
+}
+
+func versionAction(clicontext *cli.Context) error {
+	info := version.GetInfo()
+	if clicontext.Bool("json") {
+		// helpers are detected only for JSON, so as to avoid slowing down the plain output
+		info.Helpers = detectHelperVersions(map[string]string{
+			"slirp4netns": clicontext.GlobalString("slirp4netns-binary"),
+			"vpnkit":      clicontext.GlobalString("vpnkit-binary"),
+		})
+		m, err := json.MarshalIndent(info, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(m))
+		return nil
+	}
+	fmt.Printf("rootlesskit version %s\n", info.Version)
+	if info.GitCommit != "" {
+		fmt.Printf("commit: %s\n", info.GitCommit)
+	}
+	fmt.Printf("go: %s\n", info.GoVersion)
+	return nil
+}
+
+// detectHelperVersions takes a map from helper names to binaries.
+// Helpers that are not installed are omitted from the result.
+func detectHelperVersions(binaries map[string]string) map[string]string {
+	res := make(map[string]string)
+	for name, binary := range binaries {
+		v, err := version.DetectHelperVersion(binary)
+		if err != nil {
+			logrus.WithError(err).Debugf("failed to detect the version of %s", name)
+			continue
+		}
+		res[name] = v
+	}
+	return res
+}
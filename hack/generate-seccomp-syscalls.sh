@@ -0,0 +1,24 @@
+#!/bin/bash
+# Generates pkg/seccomp/syscalls_$GOARCH.go from the vendored golang.org/x/sys/unix.
+set -eu -o pipefail
+
+cd $(dirname $0)/..
+
+for arch in amd64 arm64; do
+	out=pkg/seccomp/syscalls_${arch}.go
+	{
+		echo "// Code generated by hack/generate-seccomp-syscalls.sh. DO NOT EDIT."
+		echo
+		echo "package seccomp"
+		echo
+		echo "import \"golang.org/x/sys/unix\""
+		echo
+		echo "var syscalls = map[string]uint32{"
+		sed -n -e 's/^\tSYS_\([A-Z0-9_]*\) *= [0-9]*$/\1/p' vendor/golang.org/x/sys/unix/zsysnum_linux_${arch}.go |
+			while read -r name; do
+				echo "	\"$(echo $name | tr A-Z a-z)\": unix.SYS_${name},"
+			done
+		echo "}"
+	} >$out
+	gofmt -w $out
+done
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+	"github.com/rootless-containers/rootlesskit/pkg/version"
+)
+
+// Version is the version of the REST API, not the version of RootlessKit.
+const Version = "1.11.0"
+
+// Info is the structure returned by `GET /info`
+type Info struct {
+	APIVersion string `json:"apiVersion"` // e.g. "1.1.0"
+	version.Info
+	StateDir      string             `json:"stateDir"`
+	Name          string             `json:"name,omitempty"` // empty for an unnamed instance
+	ChildPID      int                `json:"childPID"`
+	NetworkDriver *NetworkDriverInfo `json:"networkDriver,omitempty"` // nil for HostNetwork
+	// ChildUsage is the aggregate resource usage of the child and its descendants, read on every request.
+	// Nil if the usage could not be read, e.g. when the child has exited.
+	ChildUsage *ProcessTreeUsage `json:"childUsage,omitempty"`
+}
+
+// NetworkDriverInfo in Info
+type NetworkDriverInfo struct {
+	Driver string `json:"driver"`
+	// HelperVersion is the version of the helper binary, e.g. "0.4.2" for slirp4netns v0.4.2.
+	// Empty if the driver has no helper binary, or if the version could not be detected.
+	HelperVersion string `json:"helperVersion,omitempty"`
+	// Helper is the resource usage of the helper process, read on every request.
+	// Nil if the driver has no helper process, or if the helper is not running.
+	Helper *HelperInfo `json:"helper,omitempty"`
+	// DevStats is the statistics of the network interface in the child (e.g. "tap0"), read on every request.
+	// Nil if the statistics could not be read.
+	DevStats *NetworkDevStats `json:"devStats,omitempty"`
+}
+
+// NetworkDevStats in NetworkDriverInfo
+//
+// The statistics are counted by the kernel in the child network namespace, as the helpers do not provide their own statistics.
+// For the tap devices (e.g. slirp4netns), TxDropped counts the frames dropped because the helper did not read them in time.
+type NetworkDevStats struct {
+	Dev       string `json:"dev"`
+	RxBytes   uint64 `json:"rxBytes"`
+	RxPackets uint64 `json:"rxPackets"`
+	RxErrors  uint64 `json:"rxErrors"`
+	RxDropped uint64 `json:"rxDropped"`
+	TxBytes   uint64 `json:"txBytes"`
+	TxPackets uint64 `json:"txPackets"`
+	TxErrors  uint64 `json:"txErrors"`
+	TxDropped uint64 `json:"txDropped"`
+}
+
+// HelperInfo in NetworkDriverInfo
+type HelperInfo struct {
+	PID int `json:"pid"`
+	// RSS is the resident set size in bytes (VmRSS in /proc/PID/status).
+	RSS uint64 `json:"rss"`
+	// UserCPUSeconds and SystemCPUSeconds are the CPU time (utime and stime in /proc/PID/stat).
+	UserCPUSeconds   float64 `json:"userCPUSeconds"`
+	SystemCPUSeconds float64 `json:"systemCPUSeconds"`
+}
+
+// ProcessTreeUsage in Info
+type ProcessTreeUsage struct {
+	// Processes is the number of the running processes in the tree, including the root.
+	Processes int `json:"processes"`
+	// RSS is the sum of the resident set sizes in bytes. The shared pages are counted for each process.
+	RSS uint64 `json:"rss"`
+	// UserCPUSeconds and SystemCPUSeconds are the CPU time of the running processes,
+	// including the CPU time of the exited descendants that have been waited for (cutime and cstime in /proc/PID/stat).
+	UserCPUSeconds   float64 `json:"userCPUSeconds"`
+	SystemCPUSeconds float64 `json:"systemCPUSeconds"`
+}
+
+// CopyUpRequest is the request body of `POST /copy-up`
+type CopyUpRequest struct {
+	Dirs []string `json:"dirs"`
+}
+
+// CopyUpResponse is the response body of `POST /copy-up`
+type CopyUpResponse struct {
+	Copied []string `json:"copied"`
+}
+
+// MTU is the structure returned by `GET /mtu`, and the request body of `PUT /mtu`
+type MTU struct {
+	// Dev is the network interface in the child, e.g. "tap0". Ignored for `PUT /mtu`.
+	Dev string `json:"dev,omitempty"`
+	MTU int    `json:"mtu"`
+}
+
+// Driver status
+const (
+	DriverStatusStable       = "stable"
+	DriverStatusExperimental = "experimental"
+	DriverStatusDeprecated   = "deprecated"
+)
+
+// Driver in Drivers
+type Driver struct {
+	Name string `json:"name"`
+	// Status is either DriverStatusStable, DriverStatusExperimental, or DriverStatusDeprecated.
+	Status string `json:"status"`
+}
+
+// Drivers is the structure returned by `GET /drivers`
+type Drivers struct {
+	// Net are the values of `--net`
+	Net []Driver `json:"net"`
+	// PortDriver are the values of `--port-driver`
+	PortDriver []Driver `json:"portDriver"`
+	// CopyUpMode are the values of `--copy-up-mode`
+	CopyUpMode []Driver `json:"copyUpMode"`
+}
+
+// Event types
+const (
+	// EventNetworkReady is emitted when the network driver has configured the network of the child.
+	EventNetworkReady = "network-ready"
+	// EventChildStarted is emitted when the child has been fully configured, i.e. when child_pid is written.
+	EventChildStarted = "child-started"
+	// EventChildExited is emitted when the child has exited. The stream is closed after this event.
+	EventChildExited = "child-exited"
+	// EventPortAdded is emitted when a port is added, via the API or any other way.
+	EventPortAdded = "port-added"
+	// EventPortRemoved is emitted when a port is removed, via the API or any other way.
+	EventPortRemoved = "port-removed"
+)
+
+// Event is the structure streamed by `GET /events`, as the data of the server-sent events
+type Event struct {
+	// ID is assigned sequentially from 1.
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// PID is the PID of the child. Set for EventChildStarted and EventChildExited.
+	PID int `json:"pid,omitempty"`
+	// ExitCode is the exit code of the child, -1 if the child was killed by a signal. Set for EventChildExited.
+	ExitCode *int `json:"exitCode,omitempty"`
+	// Port is set for EventPortAdded and EventPortRemoved.
+	// Only ID is set for EventPortRemoved when the status of the removed port could not be obtained.
+	Port *port.Status `json:"port,omitempty"`
+}
+
+// ParseSocket parses the API socket string, which can be either a path of UNIX socket,
+// "unix:///path", or "tcp://host:port".
+// ParseSocket returns the network ("unix" or "tcp") and the address.
+func ParseSocket(s string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(s, "tcp://"):
+		addr := strings.TrimPrefix(s, "tcp://")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return "", "", errors.Wrapf(err, "invalid API socket %q", s)
+		}
+		return "tcp", addr, nil
+	case strings.HasPrefix(s, "unix://"):
+		s = strings.TrimPrefix(s, "unix://")
+	case strings.Contains(s, "://"):
+		return "", "", errors.Errorf("unsupported API socket %q, must be either a path, \"unix:///path\", or \"tcp://host:port\"", s)
+	}
+	return "unix", s, nil
+}
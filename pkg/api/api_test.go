@@ -0,0 +1,53 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestParseSocket(t *testing.T) {
+	type testCase struct {
+		s               string
+		expectedNetwork string
+		expectedAddr    string
+		// expectedNetwork is empty for invalid string
+	}
+	testCases := []testCase{
+		{
+			s:               "/run/user/1001/rootlesskit/default/api.sock",
+			expectedNetwork: "unix",
+			expectedAddr:    "/run/user/1001/rootlesskit/default/api.sock",
+		},
+		{
+			s:               "unix:///run/user/1001/rootlesskit/default/api.sock",
+			expectedNetwork: "unix",
+			expectedAddr:    "/run/user/1001/rootlesskit/default/api.sock",
+		},
+		{
+			s:               "tcp://127.0.0.1:8080",
+			expectedNetwork: "tcp",
+			expectedAddr:    "127.0.0.1:8080",
+		},
+		{
+			s: "tcp://127.0.0.1",
+			// port is missing
+		},
+		{
+			s: "http://127.0.0.1:8080",
+		},
+	}
+	for _, tc := range testCases {
+		network, addr, err := ParseSocket(tc.s)
+		if tc.expectedNetwork == "" {
+			if err == nil {
+				t.Fatalf("error is expected for %q", tc.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("got error for %q: %v", tc.s, err)
+		}
+		if network != tc.expectedNetwork || addr != tc.expectedAddr {
+			t.Fatalf("expected (%q, %q), got (%q, %q)", tc.expectedNetwork, tc.expectedAddr, network, addr)
+		}
+	}
+}
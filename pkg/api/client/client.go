@@ -1,6 +1,7 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -10,36 +11,101 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	"golang.org/x/net/context/ctxhttp"
+	"golang.org/x/sys/unix"
 
+	"github.com/rootless-containers/rootlesskit/pkg/api"
+	"github.com/rootless-containers/rootlesskit/pkg/copyup"
 	"github.com/rootless-containers/rootlesskit/pkg/port"
 )
 
 type Client interface {
 	HTTPClient() *http.Client
 	PortManager() port.Manager
+	CopyUpManager() copyup.Manager
+	Info(context.Context) (*api.Info, error)
+	MTU(context.Context) (*api.MTU, error)
+	SetMTU(ctx context.Context, mtu int) error
+	Start(context.Context) error
+	Drivers(context.Context) (*api.Drivers, error)
+	// NetNSFD returns the file descriptor of the network namespace of the child, received via SCM_RIGHTS.
+	// Supported only for the clients created with New or NewWithToken on a UNIX socket.
+	NetNSFD(context.Context) (*os.File, error)
+	// Events calls f for each event until ctx is cancelled, the child exits, or f returns an error.
+	// The retained events whose IDs are greater than *since are replayed first. nil since for the new events only.
+	Events(ctx context.Context, since *uint64, f func(api.Event) error) error
 }
 
 // New creates a client.
 // socketPath is a path to the UNIX socket, without unix:// prefix.
 func New(socketPath string) (Client, error) {
-	if _, err := os.Stat(socketPath); err != nil {
+	return NewWithToken(socketPath, "")
+}
+
+// NewWithToken creates a client.
+// socket can be either a path to the UNIX socket, "unix:///path", or "tcp://host:port".
+// token is sent as "Authorization: Bearer <token>" header, unless empty.
+func NewWithToken(socket, token string) (Client, error) {
+	network, addr, err := api.ParseSocket(socket)
+	if err != nil {
 		return nil, err
 	}
-	hc := &http.Client{
-		Transport: &http.Transport{
-			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
-				var d net.Dialer
-				return d.DialContext(ctx, "unix", socketPath)
-			},
+	if network == "unix" {
+		if _, err := os.Stat(addr); err != nil {
+			return nil, err
+		}
+	}
+	var rt http.RoundTripper = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
 		},
 	}
-	return NewWithHTTPClient(hc), nil
+	if token != "" {
+		rt = &tokenRoundTripper{
+			RoundTripper: rt,
+			token:        token,
+		}
+	}
+	hc := &http.Client{
+		Transport: rt,
+	}
+	c := newClient(hc)
+	if network == "unix" {
+		c.unixSocket = addr
+		c.token = token
+	}
+	return c, nil
+}
+
+// tokenRoundTripper sets "Authorization: Bearer <token>" header
+type tokenRoundTripper struct {
+	http.RoundTripper
+	token string
+}
+
+func (t *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrip must not modify the original request
+	req2 := new(http.Request)
+	*req2 = *req
+	req2.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		req2.Header[k] = v
+	}
+	req2.Header.Set("Authorization", "Bearer "+t.token)
+	return t.RoundTripper.RoundTrip(req2)
 }
 
 func NewWithHTTPClient(hc *http.Client) Client {
+	return newClient(hc)
+}
+
+func newClient(hc *http.Client) *client {
 	return &client{
 		Client:    hc,
 		version:   "v1",
@@ -53,6 +119,9 @@ type client struct {
 	// TODO(AkihiroSuda): negotiate the version
 	version   string
 	dummyHost string
+	// unixSocket and token are used for NetNSFD. unixSocket is empty unless the client is created with a UNIX socket.
+	unixSocket string
+	token      string
 }
 
 func (c *client) HTTPClient() *http.Client {
@@ -65,6 +134,224 @@ func (c *client) PortManager() port.Manager {
 	}
 }
 
+func (c *client) CopyUpManager() copyup.Manager {
+	return &copyUpManager{
+		client: c,
+	}
+}
+
+func (c *client) Info(ctx context.Context) (*api.Info, error) {
+	u := fmt.Sprintf("http://%s/%s/info", c.dummyHost, c.version)
+	resp, err := ctxhttp.Get(ctx, c.HTTPClient(), u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := successful(resp); err != nil {
+		return nil, err
+	}
+	var info api.Info
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (c *client) MTU(ctx context.Context) (*api.MTU, error) {
+	u := fmt.Sprintf("http://%s/%s/mtu", c.dummyHost, c.version)
+	resp, err := ctxhttp.Get(ctx, c.HTTPClient(), u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := successful(resp); err != nil {
+		return nil, err
+	}
+	var mtu api.MTU
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&mtu); err != nil {
+		return nil, err
+	}
+	return &mtu, nil
+}
+
+func (c *client) SetMTU(ctx context.Context, mtu int) error {
+	m, err := json.Marshal(&api.MTU{MTU: mtu})
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("http://%s/%s/mtu", c.dummyHost, c.version)
+	req, err := http.NewRequest("PUT", u, bytes.NewReader(m))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ctxhttp.Do(ctx, c.HTTPClient(), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return successful(resp)
+}
+
+func (c *client) Start(ctx context.Context) error {
+	u := fmt.Sprintf("http://%s/%s/start", c.dummyHost, c.version)
+	req, err := http.NewRequest("POST", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ctxhttp.Do(ctx, c.HTTPClient(), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return successful(resp)
+}
+
+func (c *client) Drivers(ctx context.Context) (*api.Drivers, error) {
+	u := fmt.Sprintf("http://%s/%s/drivers", c.dummyHost, c.version)
+	resp, err := ctxhttp.Get(ctx, c.HTTPClient(), u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := successful(resp); err != nil {
+		return nil, err
+	}
+	var drivers api.Drivers
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&drivers); err != nil {
+		return nil, err
+	}
+	return &drivers, nil
+}
+
+func (c *client) NetNSFD(ctx context.Context) (*os.File, error) {
+	if c.unixSocket == "" {
+		return nil, errors.New("the file descriptor can be received only over a UNIX socket")
+	}
+	var fc *fdConn
+	var rt http.RoundTripper = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "unix", c.unixSocket)
+			if err != nil {
+				return nil, err
+			}
+			fc = &fdConn{UnixConn: conn.(*net.UnixConn)}
+			return fc, nil
+		},
+		DisableKeepAlives: true,
+	}
+	if c.token != "" {
+		rt = &tokenRoundTripper{
+			RoundTripper: rt,
+			token:        c.token,
+		}
+	}
+	u := fmt.Sprintf("http://%s/%s/netns-fd", c.dummyHost, c.version)
+	resp, err := ctxhttp.Get(ctx, &http.Client{Transport: rt}, u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := successful(resp); err != nil {
+		return nil, err
+	}
+	// the file descriptor is sent along with the body
+	if _, err := readAtMost(resp.Body, 64); err != nil {
+		return nil, err
+	}
+	fds := fc.takeFDs()
+	if len(fds) != 1 {
+		for _, fd := range fds {
+			unix.Close(fd)
+		}
+		return nil, errors.Errorf("expected 1 file descriptor, got %d", len(fds))
+	}
+	unix.CloseOnExec(fds[0])
+	return os.NewFile(uintptr(fds[0]), "netns"), nil
+}
+
+// fdConn is a UNIX socket connection that receives the file descriptors sent via SCM_RIGHTS.
+type fdConn struct {
+	*net.UnixConn
+	mu  sync.Mutex
+	fds []int
+}
+
+func (c *fdConn) Read(b []byte) (int, error) {
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := c.ReadMsgUnix(b, oob)
+	if oobn > 0 {
+		msgs, pErr := unix.ParseSocketControlMessage(oob[:oobn])
+		if pErr != nil {
+			return n, pErr
+		}
+		for _, msg := range msgs {
+			fds, pErr := unix.ParseUnixRights(&msg)
+			if pErr != nil {
+				continue
+			}
+			c.mu.Lock()
+			c.fds = append(c.fds, fds...)
+			c.mu.Unlock()
+		}
+	}
+	return n, err
+}
+
+func (c *fdConn) takeFDs() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fds := c.fds
+	c.fds = nil
+	return fds
+}
+
+func (c *client) Events(ctx context.Context, since *uint64, f func(api.Event) error) error {
+	u := fmt.Sprintf("http://%s/%s/events", c.dummyHost, c.version)
+	if since != nil {
+		u += "?since=" + strconv.FormatUint(*since, 10)
+	}
+	resp, err := ctxhttp.Get(ctx, c.HTTPClient(), u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := successful(resp); err != nil {
+		return err
+	}
+	return readEvents(resp.Body, f)
+}
+
+// readEvents reads the server-sent events from r, and calls f with the data of each event.
+// The "id" and "event" fields are ignored, as the data contains them.
+func readEvents(r io.Reader, f func(api.Event) error) error {
+	scanner := bufio.NewScanner(r)
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data:") {
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			continue
+		}
+		if line != "" || len(data) == 0 {
+			continue
+		}
+		var ev api.Event
+		if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &ev); err != nil {
+			return errors.Wrap(err, "failed to parse the event")
+		}
+		data = nil
+		if err := f(ev); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
 func readAtMost(r io.Reader, maxBytes int) ([]byte, error) {
 	lr := &io.LimitedReader{
 		R: r,
@@ -149,3 +436,29 @@ func (pm *portManager) RemovePort(ctx context.Context, id int) error {
 	}
 	return nil
 }
+
+type copyUpManager struct {
+	*client
+}
+
+func (cm *copyUpManager) CopyUp(ctx context.Context, dirs []string) ([]string, error) {
+	m, err := json.Marshal(&api.CopyUpRequest{Dirs: dirs})
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("http://%s/%s/copy-up", cm.client.dummyHost, cm.client.version)
+	resp, err := ctxhttp.Post(ctx, cm.client.HTTPClient(), u, "application/json", bytes.NewReader(m))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := successful(resp); err != nil {
+		return nil, err
+	}
+	var res api.CopyUpResponse
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&res); err != nil {
+		return nil, err
+	}
+	return res.Copied, nil
+}
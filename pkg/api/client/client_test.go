@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/api"
+	"github.com/rootless-containers/rootlesskit/pkg/api/router"
+	"github.com/rootless-containers/rootlesskit/pkg/common"
+	"github.com/rootless-containers/rootlesskit/pkg/event"
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+type fakeNetworkDriver struct{}
+
+func (d *fakeNetworkDriver) MTU() int {
+	return 1500
+}
+
+func (d *fakeNetworkDriver) ConfigureNetwork(childPID int, stateDir string) (*common.NetworkMessage, func() error, error) {
+	return nil, nil, nil
+}
+
+func (d *fakeNetworkDriver) Info(ctx context.Context) (*api.NetworkDriverInfo, error) {
+	return &api.NetworkDriverInfo{Driver: "fake"}, nil
+}
+
+func serve(t *testing.T, socketPath, token string, events *event.Bus) func() {
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	router.AddRoutes(r, &router.Backend{
+		ChildPID:      os.Getpid(),
+		NetworkDriver: &fakeNetworkDriver{},
+		NetworkDev:    "tap0",
+		Events:        events,
+	})
+	if token != "" {
+		r.Use(router.NewTokenAuthMiddleware(token))
+	}
+	srv := &http.Server{Handler: r}
+	go srv.Serve(l)
+	return func() { srv.Close() }
+}
+
+func TestNetNSFD(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-netns-fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	var expected unix.Stat_t
+	if err := unix.Stat("/proc/self/ns/net", &expected); err != nil {
+		t.Skip(err)
+	}
+	for _, token := range []string{"", "secret"} {
+		socketPath := filepath.Join(tmp, "api.sock")
+		stop := serve(t, socketPath, token, nil)
+		c, err := NewWithToken(socketPath, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := c.NetNSFD(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var st unix.Stat_t
+		if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if st.Ino != expected.Ino || st.Dev != expected.Dev {
+			t.Fatalf("expected the netns %d:%d, got %d:%d", expected.Dev, expected.Ino, st.Dev, st.Ino)
+		}
+		// the regular API is still available
+		if _, err := c.Info(context.TODO()); err != nil {
+			t.Fatal(err)
+		}
+		stop()
+		os.Remove(socketPath)
+	}
+
+	// wrong token
+	socketPath := filepath.Join(tmp, "api.sock")
+	stop := serve(t, socketPath, "secret", nil)
+	defer stop()
+	c, err := NewWithToken(socketPath, "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := c.NetNSFD(context.TODO()); err == nil {
+		f.Close()
+		t.Fatal("expected an error")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	socketPath := filepath.Join(tmp, "api.sock")
+	bus := event.NewBus(event.DefaultBacklog)
+	// published before subscribing
+	bus.Publish(api.Event{Type: api.EventNetworkReady})
+	stop := serve(t, socketPath, "", bus)
+	defer stop()
+	c, err := New(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []api.Event
+	errCh := make(chan error)
+	var since uint64
+	go func() {
+		errCh <- c.Events(context.TODO(), &since, func(ev api.Event) error {
+			got = append(got, ev)
+			if ev.Type == api.EventNetworkReady {
+				bus.Publish(api.Event{Type: api.EventPortAdded, Port: &port.Status{ID: 1}})
+			}
+			if ev.Type == api.EventPortAdded {
+				exitCode := 42
+				bus.Publish(api.Event{Type: api.EventChildExited, ExitCode: &exitCode})
+				// closes the stream
+				bus.Close(time.Second)
+			}
+			return nil
+		})
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out")
+	}
+	expected := []string{api.EventNetworkReady, api.EventPortAdded, api.EventChildExited}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d events, got %+v", len(expected), got)
+	}
+	for i, ev := range got {
+		if ev.Type != expected[i] || ev.ID != uint64(i+1) {
+			t.Errorf("expected %q with ID %d, got %+v", expected[i], i+1, ev)
+		}
+	}
+	if got[1].Port == nil || got[1].Port.ID != 1 {
+		t.Errorf("unexpected port: %+v", got[1].Port)
+	}
+	if got[2].ExitCode == nil || *got[2].ExitCode != 42 {
+		t.Errorf("unexpected exit code: %+v", got[2].ExitCode)
+	}
+}
+
+func TestSetMTUAboveDriver(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-set-mtu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	socketPath := filepath.Join(tmp, "api.sock")
+	stop := serve(t, socketPath, "", nil)
+	defer stop()
+	c, err := New(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fakeNetworkDriver is started with MTU 1500
+	err = c.SetMTU(context.TODO(), 9000)
+	if err == nil || !strings.Contains(err.Error(), "the MTU of the network driver") {
+		t.Fatalf("expected an error about the MTU of the network driver, got %v", err)
+	}
+}
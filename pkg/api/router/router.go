@@ -2,20 +2,51 @@ package router
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 
+	"github.com/rootless-containers/rootlesskit/pkg/api"
+	"github.com/rootless-containers/rootlesskit/pkg/copyup"
+	"github.com/rootless-containers/rootlesskit/pkg/event"
+	"github.com/rootless-containers/rootlesskit/pkg/network"
+	"github.com/rootless-containers/rootlesskit/pkg/network/parentutils"
 	"github.com/rootless-containers/rootlesskit/pkg/port"
+	"github.com/rootless-containers/rootlesskit/pkg/procutil"
+	"github.com/rootless-containers/rootlesskit/pkg/version"
 )
 
 type Backend struct {
+	StateDir string
+	// Name is empty for an unnamed instance
+	Name     string
+	ChildPID int
+	// NetworkDriver can be nil
+	NetworkDriver network.ParentDriver
+	// NetworkDev is the network interface in the child, e.g. "tap0".
+	// Empty if NetworkDriver is nil.
+	NetworkDev string
 	// PortDriver MUST be thread-safe.
 	// PortDriver can be nil
 	PortDriver port.ParentDriver
+	// CopyUpManager can be nil
+	CopyUpManager copyup.Manager
+	// Start lets the child execute the command, when the child is waiting for the start.
+	// Start can be nil.
+	Start func()
+	// Drivers can be nil
+	Drivers *api.Drivers
+	// Events can be nil
+	Events *event.Bus
 }
 
 func (b *Backend) onError(w http.ResponseWriter, r *http.Request, err error, ec int) {
@@ -106,9 +137,286 @@ func (b *Backend) DeletePort(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// PostCopyUp is the handler for POST /v{N}/copy-up
+func (b *Backend) PostCopyUp(w http.ResponseWriter, r *http.Request) {
+	if b.CopyUpManager == nil {
+		b.onError(w, r, errors.New("no CopyUpManager is available"), http.StatusBadRequest)
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	var req api.CopyUpRequest
+	if err := decoder.Decode(&req); err != nil {
+		b.onError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	copied, err := b.CopyUpManager.CopyUp(context.TODO(), req.Dirs)
+	if err != nil {
+		b.onError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	m, err := json.Marshal(&api.CopyUpResponse{Copied: copied})
+	if err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(m)
+}
+
+// GetInfo is the handler for GET /v{N}/info
+func (b *Backend) GetInfo(w http.ResponseWriter, r *http.Request) {
+	info := &api.Info{
+		APIVersion: api.Version,
+		Info:       *version.GetInfo(),
+		StateDir:   b.StateDir,
+		Name:       b.Name,
+		ChildPID:   b.ChildPID,
+	}
+	if b.NetworkDriver != nil {
+		ndInfo, err := b.NetworkDriver.Info(context.TODO())
+		if err != nil {
+			b.onError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		info.NetworkDriver = ndInfo
+		if b.NetworkDev != "" {
+			if stats, err := parentutils.DevStats(b.ChildPID, b.NetworkDev); err != nil {
+				logrus.WithError(err).Debugf("failed to read the statistics of %s", b.NetworkDev)
+			} else {
+				ndInfo.DevStats = stats
+			}
+		}
+		if ndInfo.HelperVersion != "" {
+			info.Helpers = map[string]string{ndInfo.Driver: ndInfo.HelperVersion}
+		}
+	}
+	if usage, err := procutil.TreeUsage(b.ChildPID); err != nil {
+		logrus.WithError(err).Debugf("failed to read the resource usage of the child %d", b.ChildPID)
+	} else {
+		info.ChildUsage = usage
+	}
+	m, err := json.Marshal(info)
+	if err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(m)
+}
+
+func (b *Backend) onNetworkDevEmpty(w http.ResponseWriter, r *http.Request) {
+	b.onError(w, r, errors.New("no network interface is available (--net=host?)"), http.StatusBadRequest)
+}
+
+// GetMTU is the handler for GET /v{N}/mtu
+func (b *Backend) GetMTU(w http.ResponseWriter, r *http.Request) {
+	if b.NetworkDev == "" {
+		b.onNetworkDevEmpty(w, r)
+		return
+	}
+	mtu, err := parentutils.GetMTU(b.ChildPID, b.NetworkDev)
+	if err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	m, err := json.Marshal(&api.MTU{Dev: b.NetworkDev, MTU: mtu})
+	if err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(m)
+}
+
+// PutMTU is the handler for PUT /v{N}/mtu
+func (b *Backend) PutMTU(w http.ResponseWriter, r *http.Request) {
+	if b.NetworkDev == "" {
+		b.onNetworkDevEmpty(w, r)
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	var req api.MTU
+	if err := decoder.Decode(&req); err != nil {
+		b.onError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if err := network.ValidateMTU(req.MTU); err != nil {
+		b.onError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	// the MTU of the network driver (e.g. slirp4netns) cannot be changed at runtime,
+	// so raising the MTU above it would silently drop the large frames
+	if b.NetworkDriver != nil {
+		if driverMTU := b.NetworkDriver.MTU(); req.MTU > driverMTU {
+			b.onError(w, r, errors.Errorf("mtu must be <= %d (the MTU of the network driver), got %d", driverMTU, req.MTU), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := parentutils.SetMTU(b.ChildPID, b.NetworkDev, req.MTU); err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// PostStart is the handler for POST /v{N}/start
+func (b *Backend) PostStart(w http.ResponseWriter, r *http.Request) {
+	if b.Start == nil {
+		b.onError(w, r, errors.New("the child is not waiting for the start (--wait-start is not specified)"), http.StatusBadRequest)
+		return
+	}
+	b.Start()
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetDrivers is the handler for GET /v{N}/drivers
+func (b *Backend) GetDrivers(w http.ResponseWriter, r *http.Request) {
+	if b.Drivers == nil {
+		b.onError(w, r, errors.New("no driver information is available"), http.StatusInternalServerError)
+		return
+	}
+	m, err := json.Marshal(b.Drivers)
+	if err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(m)
+}
+
+// GetNetNSFD is the handler for GET /v{N}/netns-fd.
+// The file descriptor of the network namespace of the child is sent via SCM_RIGHTS along with the 1-byte body.
+// Only available on UNIX sockets.
+func (b *Backend) GetNetNSFD(w http.ResponseWriter, r *http.Request) {
+	if b.NetworkDriver == nil {
+		b.onError(w, r, errors.New("the child has no dedicated network namespace (--net=host?)"), http.StatusBadRequest)
+		return
+	}
+	if laddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); !ok || laddr.Network() != "unix" {
+		b.onError(w, r, errors.New("the file descriptor can be passed only over a UNIX socket"), http.StatusBadRequest)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		b.onError(w, r, errors.New("the connection cannot be hijacked"), http.StatusInternalServerError)
+		return
+	}
+	// the child PID is not recycled while RootlessKit is running, as the child is not reaped
+	f, err := os.Open(fmt.Sprintf("/proc/%d/ns/net", b.ChildPID))
+	if err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		b.onError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		// NOTREACHED
+		return
+	}
+	const header = "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: 1\r\nConnection: close\r\n\r\n"
+	if _, err := uc.Write([]byte(header)); err != nil {
+		logrus.WithError(err).Warn("failed to write the response header of netns-fd")
+		return
+	}
+	if _, _, err := uc.WriteMsgUnix([]byte{0}, unix.UnixRights(int(f.Fd())), nil); err != nil {
+		logrus.WithError(err).Warn("failed to send the file descriptor of the network namespace")
+	}
+}
+
+// GetEvents is the handler for GET /v{N}/events.
+// The events are streamed as the server-sent events, until the client disconnects or the child exits.
+// The retained events whose IDs are greater than the "since" query parameter (or the "Last-Event-ID" header) are replayed first.
+func (b *Backend) GetEvents(w http.ResponseWriter, r *http.Request) {
+	if b.Events == nil {
+		b.onError(w, r, errors.New("no event bus is available"), http.StatusInternalServerError)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		b.onError(w, r, errors.New("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+	since := r.URL.Query().Get("since")
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		since = s
+	}
+	var (
+		ch     <-chan api.Event
+		cancel func()
+	)
+	if since != "" {
+		id, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			b.onError(w, r, errors.Wrapf(err, "invalid event ID %q", since), http.StatusBadRequest)
+			return
+		}
+		ch, cancel = b.Events.SubscribeSince(id)
+	} else {
+		ch, cancel = b.Events.Subscribe()
+	}
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			m, err := json.Marshal(ev)
+			if err != nil {
+				logrus.WithError(err).Warnf("failed to marshal event %+v", ev)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, m); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// NewTokenAuthMiddleware returns a middleware that requires "Authorization: Bearer <token>" header.
+func NewTokenAuthMiddleware(token string) mux.MiddlewareFunc {
+	expected := []byte("Bearer " + token)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(got, expected) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func AddRoutes(r *mux.Router, b *Backend) {
 	v1 := r.PathPrefix("/v1").Subrouter()
+	v1.Path("/info").Methods("GET").HandlerFunc(b.GetInfo)
 	v1.Path("/ports").Methods("GET").HandlerFunc(b.GetPorts)
 	v1.Path("/ports").Methods("POST").HandlerFunc(b.PostPort)
 	v1.Path("/ports/{id}").Methods("DELETE").HandlerFunc(b.DeletePort)
+	v1.Path("/copy-up").Methods("POST").HandlerFunc(b.PostCopyUp)
+	v1.Path("/mtu").Methods("GET").HandlerFunc(b.GetMTU)
+	v1.Path("/mtu").Methods("PUT").HandlerFunc(b.PutMTU)
+	v1.Path("/start").Methods("POST").HandlerFunc(b.PostStart)
+	v1.Path("/drivers").Methods("GET").HandlerFunc(b.GetDrivers)
+	v1.Path("/netns-fd").Methods("GET").HandlerFunc(b.GetNetNSFD)
+	v1.Path("/events").Methods("GET").HandlerFunc(b.GetEvents)
 }
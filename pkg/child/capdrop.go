@@ -0,0 +1,167 @@
+package child
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// capabilities lists the capability names in the order of the numbers, as in <linux/capability.h>.
+var capabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN",
+	"CAP_NET_RAW",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_MKNOD",
+	"CAP_LEASE",
+	"CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MAC_ADMIN",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ",
+	"CAP_PERFMON",
+	"CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// ParseCapDrop parses the capability names such as "CAP_NET_RAW" or "net_raw" (case-insensitive, "CAP_" prefix is optional),
+// and returns the sorted capability numbers. "ALL" stands for all the capabilities.
+func ParseCapDrop(names []string) ([]int, error) {
+	m := make(map[int]struct{})
+	for _, name := range names {
+		s := strings.ToUpper(name)
+		if s == "ALL" {
+			for i := range capabilities {
+				m[i] = struct{}{}
+			}
+			continue
+		}
+		if !strings.HasPrefix(s, "CAP_") {
+			s = "CAP_" + s
+		}
+		found := false
+		for i, c := range capabilities {
+			if c == s {
+				m[i] = struct{}{}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("unknown capability %q", name)
+		}
+	}
+	var caps []int
+	for c := range m {
+		caps = append(caps, c)
+	}
+	sort.Ints(caps)
+	return caps, nil
+}
+
+// CapDropShimEnvKey is set when RootlessKit is re-executed as the shim for dropping the capabilities.
+// The capabilities need to be dropped in the thread that executes the command, as the capability sets are per-thread.
+const CapDropShimEnvKey = "_ROOTLESSKIT_CAPDROP_SHIM_UNDOCUMENTED"
+
+// CapDropShim drops the capabilities listed in CapDropShimEnvKey from the bounding set and the inheritable set,
+// and executes args.
+// CapDropShim does not return on success.
+func CapDropShim(args []string) error {
+	if len(args) == 0 {
+		return errors.New("no command specified")
+	}
+	var caps []int
+	for _, s := range strings.Split(os.Getenv(CapDropShimEnvKey), ",") {
+		c, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s", CapDropShimEnvKey)
+		}
+		caps = append(caps, c)
+	}
+	os.Unsetenv(CapDropShimEnvKey)
+	path := "/proc/self/exe"
+	if os.Getenv(ListenPIDShimEnvKey) == "" {
+		var err error
+		path, err = exec.LookPath(args[0])
+		if err != nil {
+			return err
+		}
+	}
+	// otherwise chained to ListenPIDShim
+	runtime.LockOSThread()
+	if err := dropCaps(caps); err != nil {
+		return err
+	}
+	return syscall.Exec(path, args, os.Environ())
+}
+
+// dropCaps drops caps from the bounding set and the inheritable set of the current thread.
+// The ambient set is cleared.
+// The capabilities unknown to the kernel are ignored.
+func dropCaps(caps []int) error {
+	for _, c := range caps {
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil && err != unix.EINVAL {
+			return errors.Wrapf(err, "failed to drop %s from the bounding set", capabilities[c])
+		}
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil && err != unix.EINVAL {
+		return errors.Wrap(err, "failed to clear the ambient set")
+	}
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return errors.Wrap(err, "capget failed")
+	}
+	for _, c := range caps {
+		data[c/32].Inheritable &^= 1 << uint(c%32)
+	}
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return errors.Wrap(err, "capset failed")
+	}
+	return nil
+}
+
+// setCapDrop wraps cmd with CapDropShim.
+func setCapDrop(cmd *exec.Cmd, caps []int) {
+	var ss []string
+	for _, c := range caps {
+		ss = append(ss, strconv.Itoa(c))
+	}
+	cmd.Env = append(cmd.Env, CapDropShimEnvKey+"="+strings.Join(ss, ","))
+	// cmd.Args is kept, and resolved again in the shim
+	cmd.Path = "/proc/self/exe"
+}
@@ -0,0 +1,45 @@
+package child
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseCapDrop(t *testing.T) {
+	testCases := []struct {
+		names    []string
+		expected []int
+		ok       bool
+	}{
+		{nil, nil, true},
+		{[]string{"CAP_NET_RAW"}, []int{unix.CAP_NET_RAW}, true},
+		{[]string{"net_raw", "CAP_SYS_ADMIN", "CAP_NET_RAW"}, []int{unix.CAP_NET_RAW, unix.CAP_SYS_ADMIN}, true},
+		{[]string{"CAP_FOO"}, nil, false},
+		{[]string{""}, nil, false},
+	}
+	for _, tc := range testCases {
+		got, err := ParseCapDrop(tc.names)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%v: expected an error", tc.names)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tc.names, err)
+			continue
+		}
+		if !reflect.DeepEqual(tc.expected, got) {
+			t.Errorf("%v: expected %v, got %v", tc.names, tc.expected, got)
+		}
+	}
+	all, err := ParseCapDrop([]string{"all", "CAP_NET_RAW"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(capabilities) || all[unix.CAP_AUDIT_READ] != unix.CAP_AUDIT_READ {
+		t.Errorf("unexpected result for ALL: %v", all)
+	}
+}
@@ -0,0 +1,17 @@
+package child
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// mountCgroup2 mounts cgroup2 on /sys/fs/cgroup.
+// The child needs to be in a cgroup namespace owned by its user namespace,
+// so that the mount is scoped to the cgroup of the child.
+func mountCgroup2() error {
+	const cgroupDir = "/sys/fs/cgroup"
+	if err := unix.Mount("none", cgroupDir, "cgroup2", uintptr(unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC), ""); err != nil {
+		return errors.Wrapf(err, "failed to mount cgroup2 on %s (missing --cgroupns?)", cgroupDir)
+	}
+	return nil
+}
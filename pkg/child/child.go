@@ -6,8 +6,11 @@ import (
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -15,8 +18,12 @@ import (
 
 	"github.com/rootless-containers/rootlesskit/pkg/common"
 	"github.com/rootless-containers/rootlesskit/pkg/copyup"
+	"github.com/rootless-containers/rootlesskit/pkg/copyup/remote"
+	"github.com/rootless-containers/rootlesskit/pkg/firewall"
+	"github.com/rootless-containers/rootlesskit/pkg/mountinfo"
 	"github.com/rootless-containers/rootlesskit/pkg/msgutil"
 	"github.com/rootless-containers/rootlesskit/pkg/network"
+	"github.com/rootless-containers/rootlesskit/pkg/network/hostloopback"
 	"github.com/rootless-containers/rootlesskit/pkg/port"
 )
 
@@ -36,6 +43,29 @@ func createCmd(targetCmd []string) (*exec.Cmd, error) {
 	return cmd, nil
 }
 
+// setControllingTerminal makes the pty slave the controlling terminal of cmd,
+// in a new session.
+func setControllingTerminal(cmd *exec.Cmd, ttyFile *os.File) {
+	cmd.Stdin = ttyFile
+	cmd.Stdout = ttyFile
+	cmd.Stderr = ttyFile
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	// Ctty is the FD number in the command, i.e. stdin
+	cmd.SysProcAttr.Ctty = 0
+}
+
+// setSession runs cmd in a new session. The pty slave becomes the controlling terminal unless ttyFile is nil.
+// Without ttyFile, cmd runs in a new session only when setsid is true, without any controlling terminal.
+func setSession(cmd *exec.Cmd, ttyFile *os.File, setsid bool) {
+	if ttyFile != nil {
+		// always in a new session
+		setControllingTerminal(cmd, ttyFile)
+		return
+	}
+	cmd.SysProcAttr.Setsid = setsid
+}
+
 // mountSysfs is needed for mounting /sys/class/net
 // when netns is unshared.
 func mountSysfs() error {
@@ -66,6 +96,57 @@ func mountSysfs() error {
 	return nil
 }
 
+// stashHostSysfs bind-mounts the host /sys on a temporary directory,
+// so that it can be restored with bindHostSysfs after mountSysfs.
+func stashHostSysfs() (string, error) {
+	tmp, err := ioutil.TempDir("/tmp", "rkhostsys")
+	if err != nil {
+		return "", errors.Wrap(err, "creating a directory under /tmp")
+	}
+	if err := unix.Mount("/sys", tmp, "", uintptr(unix.MS_BIND|unix.MS_REC), ""); err != nil {
+		os.RemoveAll(tmp)
+		return "", errors.Wrapf(err, "failed to create bind mount on %s", tmp)
+	}
+	return tmp, nil
+}
+
+// bindHostSysfs bind-mounts the host /sys stashed by stashHostSysfs on /sys as read-only.
+// The submounts such as /sys/fs/cgroup are remounted as read-only too, as the recursive bind mount keeps their flags.
+func bindHostSysfs(stashed string) error {
+	defer os.RemoveAll(stashed)
+	if err := unix.Mount(stashed, "/sys", "", uintptr(unix.MS_MOVE), ""); err != nil {
+		return errors.Wrapf(err, "failed to move mount point from %s to /sys", stashed)
+	}
+	mountPoints, err := mountinfo.SelfMountPoints()
+	if err != nil {
+		return err
+	}
+	for _, mp := range mountPointsUnder(mountPoints, "/sys") {
+		// fails rather than leaving a writable submount
+		if err := remountReadOnly(mp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mountPointsUnder returns the sorted unique mount points that are equal to or under dir.
+func mountPointsUnder(mountPoints []string, dir string) []string {
+	seen := make(map[string]struct{})
+	var res []string
+	for _, mp := range mountPoints {
+		if _, ok := seen[mp]; ok {
+			continue
+		}
+		seen[mp] = struct{}{}
+		if mp == dir || strings.HasPrefix(mp, dir+"/") {
+			res = append(res, mp)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
 func mountProcfs() error {
 	if err := unix.Mount("none", "/proc", "proc", 0, ""); err != nil {
 		logrus.Warnf("failed to mount procfs, falling back to read-only mount: %v", err)
@@ -86,12 +167,24 @@ func activateLoopback() error {
 	return nil
 }
 
-func activateDev(dev, ip string, netmask int, gateway string, mtu int) error {
+func activateDev(dev string, netmsg *common.NetworkMessage) error {
 	cmds := [][]string{
 		{"ip", "link", "set", dev, "up"},
-		{"ip", "link", "set", "dev", dev, "mtu", strconv.Itoa(mtu)},
-		{"ip", "addr", "add", ip + "/" + strconv.Itoa(netmask), "dev", dev},
-		{"ip", "route", "add", "default", "via", gateway, "dev", dev},
+		{"ip", "link", "set", "dev", dev, "mtu", strconv.Itoa(netmsg.MTU)},
+	}
+	// IP is empty in IPv6-only mode
+	if netmsg.IP != "" {
+		cmds = append(cmds, []string{"ip", "addr", "add", netmsg.IP + "/" + strconv.Itoa(netmsg.Netmask), "dev", dev})
+		// Gateway can be empty for the bridge driver
+		if netmsg.Gateway != "" {
+			cmds = append(cmds, []string{"ip", "route", "add", "default", "via", netmsg.Gateway, "dev", dev})
+		}
+	}
+	if netmsg.IPv6 != "" {
+		cmds = append(cmds, [][]string{
+			{"ip", "-6", "addr", "add", netmsg.IPv6 + "/" + strconv.Itoa(netmsg.IPv6Netmask), "dev", dev, "nodad"},
+			{"ip", "-6", "route", "add", "default", "via", netmsg.IPv6Gateway, "dev", dev},
+		}...)
 	}
 	if err := common.Execs(os.Stderr, os.Environ(), cmds); err != nil {
 		return errors.Wrapf(err, "executing %v", cmds)
@@ -99,25 +192,22 @@ func activateDev(dev, ip string, netmask int, gateway string, mtu int) error {
 	return nil
 }
 
-func setupCopyDir(driver copyup.ChildDriver, dirs []string) (bool, error) {
+// setupCopyDir returns the directories that have been copied up.
+func setupCopyDir(driver copyup.ChildDriver, dirs []string, missing string) ([]string, error) {
 	if driver != nil {
-		etcWasCopied := false
-		copied, err := driver.CopyUp(dirs)
-		for _, d := range copied {
-			if d == "/etc" {
-				etcWasCopied = true
-				break
-			}
-		}
-		return etcWasCopied, err
+		return copyUpDirs(driver, dirs, missing)
 	}
 	if len(dirs) != 0 {
-		return false, errors.New("copy-up driver is not specified")
+		return nil, errors.New("copy-up driver is not specified")
 	}
-	return false, nil
+	return nil, nil
 }
 
-func setupNet(msg common.Message, etcWasCopied bool, driver network.ChildDriver) error {
+// dnsSearch and dnsOptions are applied only when /etc was copied up, for ResolvConfRewrite.
+// hostResolvConf is the host /etc/resolv.conf opened by openHostResolvConf for ResolvConfBindHost.
+// The loopback interface is not configured if noLoopbackSetup is true.
+func setupNet(msg common.Message, etcWasCopied bool, driver network.ChildDriver, resolvConf ResolvConfMode, hostResolvConf *os.File,
+	dnsSearch, dnsOptions []string, noLoopbackSetup bool) error {
 	// HostNetwork
 	if driver == nil {
 		return nil
@@ -126,36 +216,57 @@ func setupNet(msg common.Message, etcWasCopied bool, driver network.ChildDriver)
 	if err := mountSysfs(); err != nil {
 		return err
 	}
-	if err := activateLoopback(); err != nil {
-		return err
+	if !noLoopbackSetup {
+		if err := activateLoopback(); err != nil {
+			return err
+		}
 	}
 	dev, err := driver.ConfigureNetworkChild(&msg.Network)
 	if err != nil {
 		return err
 	}
-	if err := activateDev(dev, msg.Network.IP, msg.Network.Netmask, msg.Network.Gateway, msg.Network.MTU); err != nil {
+	if err := activateDev(dev, &msg.Network); err != nil {
 		return err
 	}
-	if etcWasCopied {
-		if err := writeResolvConf(msg.Network.DNS); err != nil {
-			return err
+	if msg.Network.DNS == "" {
+		// the bridge driver does not provide DNS; /etc/resolv.conf is kept as-is
+		if len(dnsSearch) != 0 || len(dnsOptions) != 0 {
+			logrus.Warn("DNS search domains and options are ignored, as the network driver does not provide DNS")
 		}
-		if err := writeEtcHosts(); err != nil {
-			return err
+		return nil
+	}
+	switch resolvConf {
+	case ResolvConfNone, ResolvConfBindHost:
+		if len(dnsSearch) != 0 || len(dnsOptions) != 0 {
+			logrus.Warnf("DNS search domains and options are ignored for resolv.conf mode %q", resolvConf)
 		}
-	} else {
-		logrus.Warn("Mounting /etc/resolv.conf without copying-up /etc. " +
-			"Note that /etc/resolv.conf in the namespace will be unmounted when it is recreated on the host. " +
-			"Unless /etc/resolv.conf is statically configured, copying-up /etc is highly recommended. " +
-			"Please refer to RootlessKit documentation for further information.")
-		if err := mountResolvConf(msg.StateDir, msg.Network.DNS); err != nil {
-			return err
+		if resolvConf == ResolvConfBindHost {
+			if err := bindHostResolvConf(hostResolvConf, etcWasCopied); err != nil {
+				return err
+			}
 		}
-		if err := mountEtcHosts(msg.StateDir); err != nil {
-			return err
+	default:
+		if etcWasCopied {
+			if err := writeResolvConf(msg.Network.DNS, dnsSearch, dnsOptions); err != nil {
+				return err
+			}
+		} else {
+			if len(dnsSearch) != 0 || len(dnsOptions) != 0 {
+				logrus.Warn("DNS search domains and options are ignored without copying-up /etc")
+			}
+			logrus.Warn("Mounting /etc/resolv.conf without copying-up /etc. " +
+				"Note that /etc/resolv.conf in the namespace will be unmounted when it is recreated on the host. " +
+				"Unless /etc/resolv.conf is statically configured, copying-up /etc is highly recommended. " +
+				"Please refer to RootlessKit documentation for further information.")
+			if err := mountResolvConf(msg.StateDir, msg.Network.DNS); err != nil {
+				return err
+			}
 		}
 	}
-	return nil
+	if etcWasCopied {
+		return writeEtcHosts()
+	}
+	return mountEtcHosts(msg.StateDir)
 }
 
 type Opt struct {
@@ -164,9 +275,105 @@ type Opt struct {
 	NetworkDriver network.ChildDriver // nil for HostNetwork
 	CopyUpDriver  copyup.ChildDriver  // cannot be nil if len(CopyUpDirs) != 0
 	CopyUpDirs    []string
+	// CopyUpMissing is either CopyUpMissingError, CopyUpMissingSkip, or CopyUpMissingCreate.
+	CopyUpMissing string
 	PortDriver    port.ChildDriver
 	MountProcfs   bool // needs to be set if (and only if) parent.Opt.CreatePIDNS is set
 	Reaper        bool
+	// ExitOnChildDeath terminates the PID namespace as soon as a direct child of the target command
+	// exits with a non-zero status. Requires Reaper.
+	ExitOnChildDeath bool
+	// Rootfs is the root filesystem for the target command. Empty for the host root.
+	// Copy-up and network are configured in the host view before pivoting.
+	Rootfs string
+	// ReadOnly remounts Rootfs as read-only, with tmpfs on /tmp and /run.
+	// Requires Rootfs.
+	ReadOnly bool
+	// NoPivot falls back to chroot(2) when pivot_root(2) fails, e.g. when the rootfs is on the initramfs.
+	// Requires Rootfs.
+	NoPivot bool
+	// DevMode is the mode of setting up /dev in Rootfs. Empty for DevModeBind.
+	// Requires Rootfs.
+	DevMode DevMode
+	// JoinNS are the existing namespaces joined by the setup commands and the target command.
+	// The paths are opened before pivoting to Rootfs. The types must not overlap with the namespaces created by RootlessKit.
+	JoinNS []JoinNS
+	// ROHost remounts the mounts of the host root as read-only for the target command, except /dev, /proc, /sys,
+	// the copied-up directories, and ROHostWritable. Cannot be combined with Rootfs.
+	ROHost bool
+	// ROHostWritable are the absolute paths kept writable on ROHost.
+	ROHostWritable []string
+	// Setsid runs TargetCmd as the leader of a new session, detached from the controlling terminal.
+	// TargetCmd always runs in a new session with the parent TTY, as the controlling terminal of the session.
+	// The setup commands are not affected.
+	Setsid bool
+	// Cwd is the absolute path of the working directory of the target command, in the view of the target command.
+	// Empty for the current working directory (or "/" for Rootfs).
+	Cwd string
+	// SetupCmds are executed with "/bin/sh -c" one by one before TargetCmd.
+	// TargetCmd is not executed if any of SetupCmds fails.
+	SetupCmds []string
+	// RestartPolicy restarts TargetCmd in the same namespaces on exit.
+	// The zero value does not restart TargetCmd.
+	RestartPolicy RestartPolicy
+	// ResolvConf is the mode of configuring /etc/resolv.conf for non-host network. Empty for ResolvConfRewrite.
+	ResolvConf ResolvConfMode
+	// DNSSearch and DNSOptions are written to /etc/resolv.conf, when /etc is copied up for non-host network.
+	// Ignored unless ResolvConf is ResolvConfRewrite.
+	DNSSearch  []string
+	DNSOptions []string
+	// NoLoopbackSetup skips bringing up the loopback interface in the child network namespace.
+	// Ignored for HostNetwork.
+	NoLoopbackSetup bool
+	// Umask is applied to the setup commands and the target command. nil to inherit the umask.
+	Umask *int
+	// Rlimits are applied to the setup commands and the target command.
+	Rlimits []Rlimit
+	// CPUAffinity is the list of the CPUs that the target command runs on. Empty to inherit.
+	// The setup commands and RootlessKit itself run on the inherited CPUs.
+	CPUAffinity []int
+	// SchedPolicy is the scheduling policy of the target command. nil to inherit.
+	SchedPolicy *SchedPolicy
+	// CapDrop is the list of the capability numbers dropped from the target command. See ParseCapDrop.
+	// The setup commands are executed with the full capabilities.
+	CapDrop []int
+	// BindSys bind-mounts the host /sys as read-only (including the submounts), instead of mounting a new sysfs for the child netns.
+	BindSys bool
+	// MountCgroup2 mounts cgroup2 on /sys/fs/cgroup. Requires the child to be in a new cgroup namespace.
+	MountCgroup2 bool
+	// ProcessName is set as the comm of the child process. Empty to keep "rootlesskit" (or "exe").
+	// The comm of the target command is not affected.
+	ProcessName string
+	// ExportEnv writes the environment variables of the command to StateFileEnv in the state directory.
+	ExportEnv bool
+	// MaskEnv is the list of the environment variable names whose values are masked in StateFileEnv.
+	MaskEnv []string
+	// Stdio redirects the stdio of the setup commands and the target command to files.
+	// The paths are resolved before pivoting to Rootfs. Not supported with the parent TTY.
+	Stdio Stdio
+	// Sysctls are applied in the child network namespace after the network is configured. Requires NetworkDriver.
+	Sysctls []Sysctl
+	// IPTablesRules is the path of the file in the iptables-restore format, applied in the child network namespace
+	// after the network is configured. Requires NetworkDriver.
+	IPTablesRules string
+	// FirewallBackend is the backend for applying IPTablesRules.
+	FirewallBackend firewall.Backend
+	// Script is executed with TargetCmd as the arguments, instead of TargetCmd itself, unless the zero value.
+	// The script is read in the host view, and written to a temporary file in the view of the command.
+	Script Script
+	// RuntimeDir is the runtime directory of the host view, e.g. "/run/user/1001".
+	// RuntimeDir is bind-mounted on the same path in Rootfs (kept writable on ROHost),
+	// and set to RuntimeDirEnvKey for the setup commands and the target command.
+	RuntimeDir string
+	// WriteNSSwitch writes the minimal /etc/nsswitch.conf ("hosts: files dns") when /etc is copied up.
+	// An existing non-trivial file is kept unless WriteNSSwitchForce is set.
+	WriteNSSwitch      bool
+	WriteNSSwitchForce bool
+	// CopyLocaltime writes the host /etc/localtime as a real file when /etc is copied up. No-op otherwise.
+	CopyLocaltime bool
+	// ExportTZ sets the TZ environment variable to the zone of the host /etc/localtime, unless TZ is already set.
+	// Requires CopyLocaltime.
+	ExportTZ bool
 }
 
 func Child(opt Opt) error {
@@ -207,6 +414,11 @@ func Child(opt Opt) error {
 	if err != nil {
 		return err
 	}
+	if opt.ProcessName != "" {
+		if err := setProcessName(opt.ProcessName); err != nil {
+			return err
+		}
+	}
 	os.Unsetenv(opt.PipeFDEnvKey)
 	if err := pipeR.Close(); err != nil {
 		return errors.Wrapf(err, "failed to close fd %d", pipeFD)
@@ -214,18 +426,136 @@ func Child(opt Opt) error {
 	if msg.StateDir == "" {
 		return errors.New("got empty StateDir")
 	}
-	etcWasCopied, err := setupCopyDir(opt.CopyUpDriver, opt.CopyUpDirs)
+	if opt.RuntimeDir != "" {
+		os.Setenv(RuntimeDirEnvKey, opt.RuntimeDir)
+	}
+	var script []byte
+	if opt.Script != (Script{}) {
+		// read in the host view, before pivoting
+		if script, err = readScript(opt.Script); err != nil {
+			return err
+		}
+	}
+	var envFile *os.File
+	if opt.ExportEnv {
+		// created in the host view, before pivoting, but written after all the changes of the environment
+		if envFile, err = createEnvFile(msg.StateDir); err != nil {
+			return err
+		}
+		defer envFile.Close()
+	}
+	var hostLT *hostLocaltime
+	if opt.CopyLocaltime {
+		// read in the host view, before copying up /etc
+		if hostLT, err = readHostLocaltime(localtime); err != nil {
+			return err
+		}
+	}
+	var hostResolvConf *os.File
+	if opt.ResolvConf == ResolvConfBindHost && opt.NetworkDriver != nil {
+		// open in the host view, before copying up /etc
+		if hostResolvConf, err = openHostResolvConf(); err != nil {
+			return err
+		}
+		defer hostResolvConf.Close()
+	}
+	copied, err := setupCopyDir(opt.CopyUpDriver, opt.CopyUpDirs, opt.CopyUpMissing)
 	if err != nil {
 		return err
 	}
-	if err := setupNet(msg, etcWasCopied, opt.NetworkDriver); err != nil {
+	if opt.RuntimeDir != "" {
+		// e.g. hidden by the copy-up excludes
+		if _, err := os.Stat(opt.RuntimeDir); err != nil {
+			return errors.Wrap(err, "runtime directory is not visible after copy-up")
+		}
+	}
+	etcWasCopied := false
+	for _, d := range copied {
+		if d == "/etc" {
+			etcWasCopied = true
+			break
+		}
+	}
+	if opt.WriteNSSwitch {
+		if etcWasCopied {
+			if err := writeNSSwitchConf(nsswitchConf, opt.WriteNSSwitchForce); err != nil {
+				return err
+			}
+		} else {
+			logrus.Warnf("%s is not written, as /etc is not copied up", nsswitchConf)
+		}
+	}
+	if opt.CopyLocaltime {
+		switch {
+		case !etcWasCopied:
+			logrus.Debugf("%s is not written, as /etc is not copied up", localtime)
+		case hostLT == nil:
+			logrus.Debugf("%s is not written, as the host does not have %s", localtime, localtime)
+		default:
+			if err := writeLocaltime(localtime, hostLT); err != nil {
+				return err
+			}
+			if opt.ExportTZ {
+				exportTZ(hostLT)
+			}
+		}
+	}
+	// the target command in the pivoted rootfs cannot see the directories copied up at runtime
+	if opt.CopyUpDriver != nil && opt.Rootfs == "" {
+		closer, err := remote.Serve(remote.SocketPath(msg.StateDir), opt.CopyUpDriver, copied)
+		if err != nil {
+			return errors.Wrap(err, "failed to serve copy-up requests")
+		}
+		defer closer.Close()
+	}
+	var stashedSysfs string
+	if opt.BindSys {
+		if stashedSysfs, err = stashHostSysfs(); err != nil {
+			return err
+		}
+	}
+	if err := setupNet(msg, etcWasCopied, opt.NetworkDriver, opt.ResolvConf, hostResolvConf, opt.DNSSearch, opt.DNSOptions, opt.NoLoopbackSetup); err != nil {
 		return err
 	}
+	if len(opt.Sysctls) != 0 {
+		if opt.NetworkDriver == nil {
+			return errors.New("sysctls require a network driver")
+		}
+		if err := setSysctls(procSys, opt.Sysctls); err != nil {
+			return err
+		}
+	}
+	if opt.IPTablesRules != "" {
+		if opt.NetworkDriver == nil {
+			return errors.New("iptables rules require a network driver")
+		}
+		if err := applyIPTablesRules(opt.IPTablesRules, opt.FirewallBackend); err != nil {
+			return err
+		}
+	}
+	if stashedSysfs != "" {
+		// the network entries such as /sys/class/net reflect the host netns, not the child netns
+		if err := bindHostSysfs(stashedSysfs); err != nil {
+			return err
+		}
+	}
+	for _, hl := range msg.HostLoopback {
+		closer, err := hostloopback.ListenChild(hl.Addr, hl.SocketPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to relay host loopback %s", hl.Addr)
+		}
+		defer closer.Close()
+	}
 	if opt.MountProcfs {
 		if err := mountProcfs(); err != nil {
 			return err
 		}
 	}
+	if opt.MountCgroup2 {
+		if err := mountCgroup2(); err != nil {
+			return err
+		}
+	}
 	portQuitCh := make(chan struct{})
 	portErrCh := make(chan error)
 	if opt.PortDriver != nil {
@@ -234,19 +564,147 @@ func Child(opt Opt) error {
 		}()
 	}
 
-	cmd, err := createCmd(opt.TargetCmd)
+	// opened in the host view, before pivoting
+	stdio, err := openStdio(opt.Stdio)
 	if err != nil {
 		return err
 	}
-	if opt.Reaper {
-		if err := runAndReap(cmd); err != nil {
-			return errors.Wrapf(err, "command %v exited", opt.TargetCmd)
+	defer stdio.Close()
+	// opened in the host view, before pivoting
+	nsFiles, err := openJoinNS(opt.JoinNS)
+	if err != nil {
+		return err
+	}
+	defer closeFiles(nsFiles)
+	if opt.Rootfs != "" {
+		var etcFiles []string
+		if opt.NetworkDriver != nil {
+			// configured by setupNet
+			etcFiles = append(etcFiles, "/etc/hosts")
+			if opt.ResolvConf != ResolvConfNone {
+				etcFiles = append(etcFiles, "/etc/resolv.conf")
+			}
 		}
-	} else {
-		if err := cmd.Run(); err != nil {
-			return errors.Wrapf(err, "command %v exited", opt.TargetCmd)
+		if err := setupRootfs(opt.Rootfs, opt.ReadOnly, opt.NoPivot, etcFiles, opt.RuntimeDir, opt.DevMode); err != nil {
+			return err
+		}
+	} else if opt.ROHost {
+		writable := append(copied, opt.ROHostWritable...)
+		if opt.RuntimeDir != "" {
+			writable = append(writable, opt.RuntimeDir)
+		}
+		if err := setupROHost(writable); err != nil {
+			return err
 		}
 	}
+	if len(opt.JoinNS) != 0 {
+		if err := joinNS(opt.JoinNS, nsFiles); err != nil {
+			return err
+		}
+	}
+	if opt.Cwd != "" {
+		// resolved in the pivoted rootfs, as the goroutine is locked to the thread with the new root
+		if st, err := os.Stat(opt.Cwd); err != nil {
+			return errors.Wrap(err, "invalid working directory")
+		} else if !st.IsDir() {
+			return errors.Errorf("working directory %q is not a directory", opt.Cwd)
+		}
+	}
+	targetCmd := opt.TargetCmd
+	if script != nil {
+		var removeScript func()
+		targetCmd, removeScript, err = installScript(script, opt.TargetCmd)
+		if err != nil {
+			return err
+		}
+		defer removeScript()
+	}
+	var ttyFile *os.File
+	if msg.TTYFD != 0 {
+		ttyFile = os.NewFile(uintptr(msg.TTYFD), "tty")
+		defer ttyFile.Close()
+		// not to be inherited to the command as an extra FD
+		syscall.CloseOnExec(msg.TTYFD)
+	}
+	if msg.StartFD != 0 {
+		// not to be inherited to the setup commands
+		syscall.CloseOnExec(msg.StartFD)
+	}
+	if opt.Umask != nil {
+		unix.Umask(*opt.Umask)
+	}
+	if err := setRlimits(opt.Rlimits); err != nil {
+		return err
+	}
+	// setup commands run to completion sequentially, in the same namespaces as the target command
+	for _, s := range opt.SetupCmds {
+		setupCmd, err := createCmd([]string{"/bin/sh", "-c", s})
+		if err != nil {
+			return err
+		}
+		setupCmd.Dir = opt.Cwd
+		stdio.apply(setupCmd)
+		if ttyFile != nil {
+			setControllingTerminal(setupCmd, ttyFile)
+		}
+		if err := setupCmd.Run(); err != nil {
+			return errors.Wrapf(err, "setup command %q failed", s)
+		}
+	}
+	if msg.StartFD != 0 {
+		if err := waitStart(msg.StartFD); err != nil {
+			return err
+		}
+	}
+	if envFile != nil {
+		// just before executing the command, so that the environment is same as the command, e.g. TZ for ExportTZ
+		if err := writeEnvFile(envFile, os.Environ(), opt.MaskEnv); err != nil {
+			return err
+		}
+		if err := envFile.Close(); err != nil {
+			return err
+		}
+	}
+	preservedFiles := openPreservedFDs(msg.PreservedFDs)
+	// the command is restarted here rather than in the parent, as this process holds the namespaces
+	// (and is the init of the PID namespace), which would be lost if this process exited.
+	var restartDelay time.Duration
+	for restarts := 0; ; restarts++ {
+		cmd, err := createCmd(targetCmd)
+		if err != nil {
+			return err
+		}
+		cmd.Dir = opt.Cwd
+		stdio.apply(cmd)
+		setSession(cmd, ttyFile, opt.Setsid)
+		if len(preservedFiles) != 0 {
+			setPreservedFDs(cmd, preservedFiles)
+		}
+		if len(opt.CapDrop) != 0 {
+			setCapDrop(cmd, opt.CapDrop)
+		}
+		// the shim is executed after setRlimits, as RLIMIT_RTPRIO allows the real-time policies
+		setSchedShim(cmd, opt.CPUAffinity, opt.SchedPolicy)
+		began := time.Now()
+		if opt.Reaper {
+			err = runAndReap(cmd, opt.ExitOnChildDeath)
+		} else {
+			err = cmd.Run()
+		}
+		if !opt.RestartPolicy.shouldRestart(err, restarts) {
+			if err != nil {
+				if restarts > 0 {
+					logrus.Warnf("giving up restarting command %v after %d restarts: %v", targetCmd, restarts, err)
+				}
+				return errors.Wrapf(err, "command %v exited", targetCmd)
+			}
+			break
+		}
+		// the namespaces, the network, and the port driver are preserved across restarts
+		restartDelay = nextRestartDelay(restartDelay, time.Since(began))
+		logrus.Infof("restarting command %v in %v (restart policy %q, %d restarts so far), exited: %v", targetCmd, restartDelay, opt.RestartPolicy.Name, restarts, err)
+		time.Sleep(restartDelay)
+	}
 	if opt.PortDriver != nil {
 		portQuitCh <- struct{}{}
 		return <-portErrCh
@@ -254,9 +712,19 @@ func Child(opt Opt) error {
 	return nil
 }
 
-func runAndReap(cmd *exec.Cmd) error {
+// runAndReap runs cmd and reaps zombies until cmd exits.
+// Must be called in the init process of the PID namespace.
+// The processes that have been reparented to the init are just reaped.
+//
+// When exitOnChildDeath is true, the direct children of cmd are watched with runAndWatchChildren.
+func runAndReap(cmd *exec.Cmd, exitOnChildDeath bool) error {
+	if exitOnChildDeath {
+		return runAndWatchChildren(cmd)
+	}
 	c := make(chan os.Signal, 32)
 	signal.Notify(c, syscall.SIGCHLD)
+	// runAndReap may be called multiple times for restarting cmd
+	defer signal.Stop(c)
 	if err := cmd.Start(); err != nil {
 		return err
 	}
@@ -265,13 +733,23 @@ func runAndReap(cmd *exec.Cmd) error {
 		defer close(result)
 		for range c {
 			for {
-				if pid, err := syscall.Wait4(-1, nil, syscall.WNOHANG, nil); err != nil || pid <= 0 {
+				var status syscall.WaitStatus
+				pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+				if err != nil || pid <= 0 {
 					break
+				}
+				if pid != cmd.Process.Pid {
+					continue
+				}
+				// cmd has been already reaped above, so the error of cmd.Wait (ECHILD) is ignored.
+				// cmd.Wait is still needed for releasing the resources of cmd.
+				cmd.Wait()
+				if status.Exited() && status.ExitStatus() == 0 {
+					result <- nil
 				} else {
-					if pid == cmd.Process.Pid {
-						result <- cmd.Wait()
-					}
+					result <- &common.ExitStatusError{PID: pid, Status: status}
 				}
+				return
 			}
 		}
 	}()
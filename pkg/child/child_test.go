@@ -0,0 +1,52 @@
+package child
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSetSession(t *testing.T) {
+	mySID, err := unix.Getsid(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, setsid := range []bool{false, true} {
+		// prints the PID and the session ID of the shell
+		cmd, err := createCmd([]string{"/bin/sh", "-c", `echo $$ $(cut -d " " -f 6 /proc/$$/stat)`})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd.Stdout = nil
+		setSession(cmd, nil, setsid)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := strings.Fields(string(out))
+		if len(fields) != 2 {
+			t.Fatalf("unexpected output %q", string(out))
+		}
+		pid, _ := strconv.Atoi(fields[0])
+		sid, _ := strconv.Atoi(fields[1])
+		expected := mySID
+		if setsid {
+			expected = pid
+		}
+		if sid != expected {
+			t.Errorf("setsid=%v: expected session %d, got %d", setsid, expected, sid)
+		}
+	}
+}
+
+func TestMountPointsUnder(t *testing.T) {
+	mountPoints := []string{"/", "/sys", "/sys/fs/cgroup", "/sysroot", "/sys/kernel/security", "/sys/fs/cgroup", "/proc"}
+	got := mountPointsUnder(mountPoints, "/sys")
+	expected := []string{"/sys", "/sys/fs/cgroup", "/sys/kernel/security"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
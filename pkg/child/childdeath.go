@@ -0,0 +1,143 @@
+package child
+
+import (
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/common"
+)
+
+// runAndWatchChildren is runAndReap for exitOnChildDeath.
+//
+// The direct children of cmd are reaped by cmd, not by the init, so their exit status
+// can be observed only by tracing them. cmd is attached with PTRACE_SEIZE and PTRACE_O_TRACEFORK,
+// so that its new children are auto-attached. The options of the children are cleared
+// on their first stop, so the grandchildren are not traced.
+// When a direct child exits with a non-zero status, all the processes in the PID namespace are killed,
+// and the status of the child is returned after cmd has been reaped.
+//
+// While being traced, the processes cannot be traced by a debugger such as strace or gdb.
+func runAndWatchChildren(cmd *exec.Cmd) error {
+	// ptrace(2) requests have to be issued from the thread that has attached the tracees,
+	// including the PTRACE_TRACEME of cmd.Start.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	cmd.SysProcAttr.Ptrace = true
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	if err := seizeCmd(pid); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	seen := map[int]bool{pid: true}
+	var firstErr error
+	for {
+		var status unix.WaitStatus
+		p, err := unix.Wait4(-1, &status, unix.WALL, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to wait for the processes")
+		}
+		if status.Exited() || status.Signaled() {
+			if p == pid {
+				// cmd has been already reaped above, so the error of cmd.Wait (ECHILD) is ignored.
+				// cmd.Wait is still needed for releasing the resources of cmd.
+				cmd.Wait()
+				if firstErr != nil {
+					return firstErr
+				}
+				if status.Exited() && status.ExitStatus() == 0 {
+					return nil
+				}
+				return &common.ExitStatusError{PID: p, Status: syscall.WaitStatus(status)}
+			}
+			if !seen[p] {
+				// reparented to the init
+				continue
+			}
+			delete(seen, p)
+			if firstErr != nil || (status.Exited() && status.ExitStatus() == 0) {
+				continue
+			}
+			logrus.Debugf("child %d of command %d died (status=%v), terminating the PID namespace", p, pid, status)
+			firstErr = &common.ExitStatusError{PID: p, Status: syscall.WaitStatus(status)}
+			// kill(-1) sends the signal to all the processes in the namespace except the init itself
+			if err := unix.Kill(-1, unix.SIGKILL); err != nil && err != unix.ESRCH {
+				logrus.WithError(err).Warn("failed to terminate the PID namespace")
+			}
+			continue
+		}
+		if !status.Stopped() {
+			continue
+		}
+		if err := resumeTracee(p, status, seen); err != nil && err != unix.ESRCH {
+			logrus.WithError(err).Debugf("failed to resume process %d", p)
+		}
+	}
+}
+
+// seizeCmd replaces the PTRACE_TRACEME attachment of cmd with PTRACE_SEIZE,
+// as only PTRACE_SEIZE supports the job control stops (PTRACE_LISTEN).
+// cmd is stopped at execve(2) on entry, and is kept stopped with SIGSTOP until it is seized.
+func seizeCmd(pid int) error {
+	var status unix.WaitStatus
+	if _, err := unix.Wait4(pid, &status, unix.WALL, nil); err != nil {
+		return errors.Wrapf(err, "failed to wait for process %d to execute", pid)
+	}
+	if !status.Stopped() {
+		return errors.Errorf("process %d exited before being traced (status=%v)", pid, status)
+	}
+	if err := ptrace(unix.PTRACE_DETACH, pid, uintptr(unix.SIGSTOP)); err != nil {
+		return errors.Wrapf(err, "failed to detach process %d", pid)
+	}
+	if err := ptrace(unix.PTRACE_SEIZE, pid, unix.PTRACE_O_TRACEFORK|unix.PTRACE_O_TRACEVFORK); err != nil {
+		return errors.Wrapf(err, "failed to trace process %d", pid)
+	}
+	return unix.Kill(pid, unix.SIGCONT)
+}
+
+// resumeTracee resumes the tracee p in the ptrace-stop with status.
+func resumeTracee(p int, status unix.WaitStatus, seen map[int]bool) error {
+	if !seen[p] {
+		// the initial stop of a child auto-attached by PTRACE_O_TRACEFORK
+		seen[p] = true
+		if err := unix.PtraceSetOptions(p, 0); err != nil {
+			return err
+		}
+		return unix.PtraceCont(p, 0)
+	}
+	sig := status.StopSignal()
+	switch event := int(status) >> 16; event {
+	case 0:
+		// signal-delivery-stop: deliver the signal
+		return unix.PtraceCont(p, int(sig))
+	case unix.PTRACE_EVENT_STOP:
+		switch sig {
+		case unix.SIGSTOP, unix.SIGTSTP, unix.SIGTTIN, unix.SIGTTOU:
+			// group-stop: keep the tracee stopped until SIGCONT
+			return ptrace(unix.PTRACE_LISTEN, p, 0)
+		}
+		return unix.PtraceCont(p, 0)
+	default:
+		// PTRACE_EVENT_FORK, PTRACE_EVENT_VFORK, ...
+		return unix.PtraceCont(p, 0)
+	}
+}
+
+// ptrace calls ptrace(2) with the requests not wrapped in golang.org/x/sys/unix.
+func ptrace(request int, pid int, data uintptr) error {
+	if _, _, errno := unix.Syscall6(unix.SYS_PTRACE, uintptr(request), uintptr(pid), 0, data, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package child
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// TaskCommLen is TASK_COMM_LEN of the kernel, including the trailing NUL.
+const TaskCommLen = 16
+
+// ValidateProcessName validates the name for setProcessName.
+func ValidateProcessName(name string) error {
+	if name == "" {
+		return errors.New("got empty process name")
+	}
+	if len(name) >= TaskCommLen {
+		return errors.Errorf("process name %q is too long (max %d bytes)", name, TaskCommLen-1)
+	}
+	return nil
+}
+
+// setProcessName sets the comm of the current process, which is shown in `ps` and `top`.
+// prctl(PR_SET_NAME) cannot be used here, as it only affects the calling thread, which is not
+// necessarily the main thread in Go, so /proc/self/comm (the comm of the main thread) is written instead.
+// argv (/proc/self/cmdline) is not changed.
+func setProcessName(name string) error {
+	if err := ioutil.WriteFile("/proc/self/comm", []byte(name), 0644); err != nil {
+		return errors.Wrapf(err, "failed to set the process name to %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package child
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestValidateProcessName(t *testing.T) {
+	for _, s := range []string{"rk-foo", "012345678901234"} {
+		if err := ValidateProcessName(s); err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "0123456789012345"} {
+		if err := ValidateProcessName(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestSetProcessName(t *testing.T) {
+	b, err := ioutil.ReadFile("/proc/self/comm")
+	if err != nil {
+		t.Skip(err)
+	}
+	orig := strings.TrimSpace(string(b))
+	defer setProcessName(orig)
+	if err := setProcessName("rk-test"); err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadFile("/proc/self/comm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(b)); got != "rk-test" {
+		t.Fatalf("expected \"rk-test\", got %q", got)
+	}
+}
@@ -0,0 +1,53 @@
+package child
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rootless-containers/rootlesskit/pkg/copyup"
+)
+
+// CopyUpMissing values specify how to handle the copy-up directories that do not exist.
+const (
+	// CopyUpMissingError fails the startup (default)
+	CopyUpMissingError = ""
+	// CopyUpMissingSkip skips the directory with a warning
+	CopyUpMissingSkip = "skip"
+	// CopyUpMissingCreate creates the directory as an empty directory before copying it up
+	CopyUpMissingCreate = "create"
+)
+
+// copyUpDirs copies up dirs one by one in the order, so that a missing directory can be created
+// in the directory that has been copied up just before, e.g. "/etc/cni" after "/etc".
+// copyUpDirs returns the directories that have been copied up.
+func copyUpDirs(driver copyup.ChildDriver, dirs []string, missing string) ([]string, error) {
+	if missing == CopyUpMissingError {
+		return driver.CopyUp(dirs)
+	}
+	var copied []string
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			switch missing {
+			case CopyUpMissingSkip:
+				logrus.Warnf("skipping copy-up of %s, as it does not exist", dir)
+				continue
+			case CopyUpMissingCreate:
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					return copied, errors.Wrapf(err, "failed to create the copy-up directory %s (copy-up the parent directory first?)", dir)
+				}
+				logrus.Debugf("created the copy-up directory %s", dir)
+			default:
+				return copied, errors.Errorf("unknown copy-up missing policy %q", missing)
+			}
+		}
+		c, err := driver.CopyUp([]string{filepath.Clean(dir)})
+		copied = append(copied, c...)
+		if err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
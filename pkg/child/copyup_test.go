@@ -0,0 +1,68 @@
+package child
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type fakeCopyUpDriver struct {
+	calls [][]string
+}
+
+func (d *fakeCopyUpDriver) CopyUp(dirs []string) ([]string, error) {
+	d.calls = append(d.calls, dirs)
+	return dirs, nil
+}
+
+func TestCopyUpDirs(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-copyup-dirs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	existing := filepath.Join(tmp, "existing")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(tmp, "missing")
+
+	d := &fakeCopyUpDriver{}
+	copied, err := copyUpDirs(d, []string{existing, missing}, CopyUpMissingError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the error is up to the driver
+	if expected := [][]string{{existing, missing}}; !reflect.DeepEqual(d.calls, expected) {
+		t.Fatalf("expected %v, got %v", expected, d.calls)
+	}
+
+	d = &fakeCopyUpDriver{}
+	copied, err = copyUpDirs(d, []string{existing, missing}, CopyUpMissingSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{existing}; !reflect.DeepEqual(copied, expected) {
+		t.Fatalf("expected %v, got %v", expected, copied)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Fatalf("expected %s not to be created, got %v", missing, err)
+	}
+
+	d = &fakeCopyUpDriver{}
+	copied, err = copyUpDirs(d, []string{existing, missing}, CopyUpMissingCreate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{existing, missing}; !reflect.DeepEqual(copied, expected) {
+		t.Fatalf("expected %v, got %v", expected, copied)
+	}
+	if expected := [][]string{{existing}, {missing}}; !reflect.DeepEqual(d.calls, expected) {
+		t.Fatalf("expected %v, got %v", expected, d.calls)
+	}
+	if st, err := os.Stat(missing); err != nil || !st.IsDir() {
+		t.Fatalf("expected %s to be created, got %v", missing, err)
+	}
+}
@@ -0,0 +1,118 @@
+package child
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// DevMode is the mode of setting up /dev in Rootfs.
+type DevMode string
+
+const (
+	// DevModeBind bind-mounts the whole /dev of the host view recursively. The default.
+	DevModeBind = DevMode("bind")
+	// DevModeMinimal mounts a tmpfs on /dev, and bind-mounts minimalDevNodes from the host view.
+	DevModeMinimal = DevMode("minimal")
+	// DevModeHost leaves /dev of Rootfs as it is.
+	DevModeHost = DevMode("host")
+)
+
+// ParseDevMode parses "bind", "minimal", or "host".
+func ParseDevMode(s string) (DevMode, error) {
+	switch m := DevMode(s); m {
+	case DevModeBind, DevModeMinimal, DevModeHost:
+		return m, nil
+	default:
+		return "", errors.Errorf("unknown dev mode %q, expected \"bind\", \"minimal\", or \"host\"", s)
+	}
+}
+
+// minimalDevNodes are the device nodes bind-mounted for DevModeMinimal.
+// "ptmx" is replaced with the symlink to "pts/ptmx" when a new devpts instance can be mounted on /dev/pts,
+// as the bind mount of /dev/ptmx cannot find the devpts instance under the tmpfs.
+var minimalDevNodes = []string{"null", "zero", "full", "random", "urandom", "tty", "ptmx"}
+
+// minimalDevSymlinks are the symlinks created for DevModeMinimal.
+var minimalDevSymlinks = map[string]string{
+	"fd":     "/proc/self/fd",
+	"stdin":  "/proc/self/fd/0",
+	"stdout": "/proc/self/fd/1",
+	"stderr": "/proc/self/fd/2",
+}
+
+// validateDevNodes returns an error if any of the nodes is not a character device in the dev directory.
+func validateDevNodes(dev string, nodes []string) error {
+	for _, n := range nodes {
+		p := filepath.Join(dev, n)
+		st, err := os.Stat(p)
+		if err != nil {
+			return errors.Wrapf(err, "device node %s is not available", p)
+		}
+		if st.Mode()&os.ModeCharDevice == 0 {
+			return errors.Errorf("%s is not a character device", p)
+		}
+	}
+	return nil
+}
+
+// setupMinimalDev mounts a tmpfs on /dev of rootfs, and populates it with the bind mounts of minimalDevNodes,
+// a devpts on /dev/pts, minimalDevSymlinks, and a tmpfs on /dev/shm.
+// Creating the device nodes with mknod(2) is not permitted in the user namespace.
+func setupMinimalDev(rootfs string) error {
+	if err := validateDevNodes("/dev", minimalDevNodes); err != nil {
+		return err
+	}
+	target := filepath.Join(rootfs, "/dev")
+	if st, err := os.Stat(target); err != nil || !st.IsDir() {
+		return errors.New("rootfs lacks /dev directory")
+	}
+	if err := unix.Mount("tmpfs", target, "tmpfs", unix.MS_NOSUID|unix.MS_NOEXEC, "mode=755"); err != nil {
+		return errors.Wrapf(err, "failed to mount tmpfs on %s", target)
+	}
+	for _, d := range []string{"pts", "shm"} {
+		if err := os.Mkdir(filepath.Join(target, d), 0755); err != nil {
+			return err
+		}
+	}
+	pts := filepath.Join(target, "pts")
+	newPTS := true
+	// gid=5 (tty) is not specified, as it may not be mapped
+	if err := unix.Mount("devpts", pts, "devpts", unix.MS_NOSUID|unix.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+		logrus.WithError(err).Warn("failed to mount devpts on /dev/pts, falling back to bind-mounting /dev/pts and /dev/ptmx (the pseudo terminals may not work)")
+		if err := unix.Mount("/dev/pts", pts, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return errors.Wrap(err, "failed to bind-mount /dev/pts")
+		}
+		newPTS = false
+	}
+	for _, n := range minimalDevNodes {
+		p := filepath.Join(target, n)
+		if n == "ptmx" && newPTS {
+			if err := os.Symlink("pts/ptmx", p); err != nil {
+				return err
+			}
+			continue
+		}
+		// the mount point of a bind mount needs to be a file
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		f.Close()
+		if err := unix.Mount(filepath.Join("/dev", n), p, "", unix.MS_BIND, ""); err != nil {
+			return errors.Wrapf(err, "failed to bind-mount /dev/%s on %s", n, p)
+		}
+	}
+	if err := unix.Mount("shm", filepath.Join(target, "shm"), "tmpfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "mode=1777"); err != nil {
+		return errors.Wrap(err, "failed to mount tmpfs on /dev/shm")
+	}
+	for n, dest := range minimalDevSymlinks {
+		if err := os.Symlink(dest, filepath.Join(target, n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
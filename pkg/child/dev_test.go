@@ -0,0 +1,45 @@
+package child
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDevMode(t *testing.T) {
+	for _, s := range []string{"bind", "minimal", "host"} {
+		m, err := ParseDevMode(s)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		}
+		if string(m) != s {
+			t.Errorf("expected %q, got %q", s, m)
+		}
+	}
+	for _, s := range []string{"", "devtmpfs", "Minimal"} {
+		if _, err := ParseDevMode(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestValidateDevNodes(t *testing.T) {
+	if err := validateDevNodes("/dev", []string{"null"}); err != nil {
+		t.Fatal(err)
+	}
+	tmpDir, err := ioutil.TempDir("", "test-validate-dev-nodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := validateDevNodes(tmpDir, []string{"null"}); err == nil {
+		t.Fatal("expected an error for the missing node")
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "null"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateDevNodes(tmpDir, []string{"null"}); err == nil {
+		t.Fatal("expected an error for the regular file")
+	}
+}
@@ -0,0 +1,80 @@
+package child
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// StateFileEnv is the file in the state directory that contains the environment variables
+// of the command, separated by NUL as in /proc/PID/environ.
+// Written only when Opt.ExportEnv is set.
+const StateFileEnv = "child_env"
+
+// MaskedEnvValue replaces the values of the masked environment variables in StateFileEnv.
+const MaskedEnvValue = "********"
+
+// maskEnv returns a copy of env with the values of the variables listed in mask replaced.
+func maskEnv(env, mask []string) []string {
+	masked := make(map[string]struct{}, len(mask))
+	for _, k := range mask {
+		masked[k] = struct{}{}
+	}
+	res := make([]string, 0, len(env))
+	for _, kv := range env {
+		k := kv
+		if i := strings.Index(kv, "="); i >= 0 {
+			k = kv[:i]
+		}
+		if _, ok := masked[k]; ok {
+			kv = k + "=" + MaskedEnvValue
+		}
+		res = append(res, kv)
+	}
+	return res
+}
+
+// createEnvFile creates StateFileEnv under stateDir.
+// The file is created before pivoting to Opt.Rootfs, and written by writeEnvFile later.
+func createEnvFile(stateDir string) (*os.File, error) {
+	p := filepath.Join(stateDir, StateFileEnv)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s", p)
+	}
+	return f, nil
+}
+
+// writeEnvFile writes env to f created by createEnvFile.
+func writeEnvFile(f *os.File, env, mask []string) error {
+	var b bytes.Buffer
+	for _, kv := range maskEnv(env, mask) {
+		b.WriteString(kv)
+		b.WriteByte(0)
+	}
+	if _, err := f.Write(b.Bytes()); err != nil {
+		return errors.Wrapf(err, "failed to write %s", f.Name())
+	}
+	return nil
+}
+
+// ReadEnvFile reads the environment variables written to StateFileEnv under stateDir,
+// for executing another process in the namespaces with the same environment.
+// The masked variables are returned with MaskedEnvValue.
+func ReadEnvFile(stateDir string) ([]string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(stateDir, StateFileEnv))
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	for _, kv := range bytes.Split(b, []byte{0}) {
+		if len(kv) != 0 {
+			env = append(env, string(kv))
+		}
+	}
+	return env, nil
+}
@@ -0,0 +1,77 @@
+package child
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestEnvFile(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "envfile-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	env := []string{"FOO=foo", "TOKEN=secret", "EMPTY=", "MULTI=a=b\nc"}
+	f, err := createEnvFile(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := writeEnvFile(f, env, []string{"TOKEN", "NONEXISTENT"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadEnvFile(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"FOO=foo", "TOKEN=" + MaskedEnvValue, "EMPTY=", "MULTI=a=b\nc"}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+// TestEnvFileExportTZ tests ExportEnv with ExportTZ: TZ exported after creating the file is written to the file.
+func TestEnvFileExportTZ(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "envfile-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	if tz, ok := os.LookupEnv("TZ"); ok {
+		defer os.Setenv("TZ", tz)
+	} else {
+		defer os.Unsetenv("TZ")
+	}
+	os.Unsetenv("TZ")
+	// same order as Child
+	f, err := createEnvFile(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	exportTZ(&hostLocaltime{zone: "Asia/Tokyo"})
+	if err := writeEnvFile(f, os.Environ(), nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadEnvFile(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, kv := range got {
+		if kv == "TZ=Asia/Tokyo" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected TZ=Asia/Tokyo in %q", got)
+	}
+
+	// TZ that is already set is kept
+	exportTZ(&hostLocaltime{zone: "Europe/Paris"})
+	if tz := os.Getenv("TZ"); tz != "Asia/Tokyo" {
+		t.Errorf("expected TZ to be kept, got %q", tz)
+	}
+}
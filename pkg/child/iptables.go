@@ -0,0 +1,57 @@
+package child
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rootless-containers/rootlesskit/pkg/firewall"
+)
+
+// applyIPTablesRules applies the rules in the iptables-restore format to the current network namespace.
+// The rules are validated with `iptables-restore --test` before applying.
+// When applying the rules fails, the rules saved before applying are restored.
+func applyIPTablesRules(path string, backend firewall.Backend) error {
+	rules, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read iptables rules")
+	}
+	cmds, err := firewall.Detect(backend)
+	if err != nil {
+		return err
+	}
+	restore := cmds.Restore
+	backup, err := runIPTables(cmds.Save, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to save the current iptables rules")
+	}
+	if _, err := runIPTables(restore, rules, "--test"); err != nil {
+		return errors.Wrapf(err, "invalid iptables rules in %s", path)
+	}
+	if _, err := runIPTables(restore, rules); err != nil {
+		if _, rbErr := runIPTables(restore, backup); rbErr != nil {
+			logrus.WithError(rbErr).Warn("failed to roll back the iptables rules")
+		}
+		return errors.Wrapf(err, "failed to apply iptables rules in %s", path)
+	}
+	logrus.Debugf("applied iptables rules in %s with %s", path, restore)
+	return nil
+}
+
+// runIPTables runs the command with stdin, and returns the stdout.
+func runIPTables(path string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Env = os.Environ()
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "executing %v: %q", cmd.Args, stderr.String())
+	}
+	return out, nil
+}
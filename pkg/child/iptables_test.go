@@ -0,0 +1,80 @@
+package child
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rootless-containers/rootlesskit/pkg/firewall"
+)
+
+// writeFakeIPTables writes fake iptables-restore and iptables-save to dir.
+// The fake iptables-restore records the applied rules to dir/applied, and fails for the rules containing "BAD",
+// or for the rules containing "FAIL" without --test.
+func writeFakeIPTables(t *testing.T, dir string) {
+	applied := filepath.Join(dir, "applied")
+	restore := `#!/bin/sh
+set -e
+if [ "$1" = "--version" ]; then echo "iptables-restore v1.8.7 (nf_tables)"; exit 0; fi
+rules=$(cat)
+case "$rules" in *BAD*) exit 1;; esac
+if [ "$1" = "--test" ]; then exit 0; fi
+case "$rules" in *FAIL*) exit 1;; esac
+echo "$rules" >` + applied + `
+`
+	save := `#!/bin/sh
+cat ` + applied + ` 2>/dev/null || true
+`
+	for name, s := range map[string]string{"iptables-restore": restore, "iptables-save": save} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(s), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestApplyIPTablesRules(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-iptables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	writeFakeIPTables(t, tmp)
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", tmp+":"+oldPath)
+
+	applied := func() string {
+		b, _ := ioutil.ReadFile(filepath.Join(tmp, "applied"))
+		return strings.TrimSpace(string(b))
+	}
+	apply := func(rules string) error {
+		p := filepath.Join(tmp, "rules")
+		if err := ioutil.WriteFile(p, []byte(rules), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return applyIPTablesRules(p, firewall.BackendAuto)
+	}
+
+	if err := apply("*filter\nGOOD\nCOMMIT"); err != nil {
+		t.Fatal(err)
+	}
+	if s := applied(); s != "*filter\nGOOD\nCOMMIT" {
+		t.Fatalf("unexpected applied rules %q", s)
+	}
+	// rejected by --test
+	if err := apply("*filter\nBAD\nCOMMIT"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if s := applied(); s != "*filter\nGOOD\nCOMMIT" {
+		t.Fatalf("unexpected applied rules %q", s)
+	}
+	// passes --test but fails to apply; rolled back to the previous rules
+	if err := apply("*filter\nFAIL\nCOMMIT"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if s := applied(); s != "*filter\nGOOD\nCOMMIT" {
+		t.Fatalf("unexpected applied rules %q", s)
+	}
+}
@@ -0,0 +1,85 @@
+package child
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// JoinNS is an existing namespace joined by the setup commands and the target command.
+type JoinNS struct {
+	// Type is the namespace type, e.g. "ipc". See JoinNSTypes.
+	Type string
+	// Path is the path of the namespace file, e.g. "/proc/42/ns/ipc".
+	Path string
+}
+
+// JoinNSTypes are the namespace types that can be joined.
+// "mnt" and "user" are not supported, as setns(2) does not allow a multi-threaded process to join them.
+var JoinNSTypes = map[string]int{
+	"ipc":    unix.CLONE_NEWIPC,
+	"uts":    unix.CLONE_NEWUTS,
+	"net":    unix.CLONE_NEWNET,
+	"pid":    unix.CLONE_NEWPID,
+	"cgroup": unix.CLONE_NEWCGROUP,
+}
+
+// ParseJoinNS parses "TYPE:PATH", e.g. "ipc:/proc/42/ns/ipc".
+func ParseJoinNS(s string) (JoinNS, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return JoinNS{}, errors.Errorf("invalid namespace %q, must be \"TYPE:PATH\"", s)
+	}
+	ns := JoinNS{Type: s[:i], Path: s[i+1:]}
+	if _, ok := JoinNSTypes[ns.Type]; !ok {
+		var types []string
+		for t := range JoinNSTypes {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return JoinNS{}, errors.Errorf("unsupported namespace type %q in %q, must be one of %v", ns.Type, s, types)
+	}
+	if !filepath.IsAbs(ns.Path) {
+		return JoinNS{}, errors.Errorf("namespace path must be absolute, got %q", s)
+	}
+	return ns, nil
+}
+
+// openJoinNS opens the namespace files, in the host view before pivoting.
+func openJoinNS(nss []JoinNS) ([]*os.File, error) {
+	var files []*os.File
+	for _, ns := range nss {
+		f, err := os.Open(ns.Path)
+		if err != nil {
+			closeFiles(files)
+			return nil, errors.Wrapf(err, "failed to open %s namespace", ns.Type)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// joinNS joins the current thread to the namespaces.
+// The OS thread is locked and never unlocked, so that the setup commands and the target command
+// started from the same goroutine are executed in the namespaces.
+func joinNS(nss []JoinNS, files []*os.File) error {
+	runtime.LockOSThread()
+	for i, ns := range nss {
+		// setns(2) fails with EINVAL if the file is not a namespace of the type
+		if err := unix.Setns(int(files[i].Fd()), JoinNSTypes[ns.Type]); err != nil {
+			return errors.Wrapf(err, "failed to join %s namespace %s", ns.Type, ns.Path)
+		}
+	}
+	return nil
+}
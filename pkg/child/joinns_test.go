@@ -0,0 +1,37 @@
+package child
+
+import (
+	"testing"
+)
+
+func TestParseJoinNS(t *testing.T) {
+	testCases := []struct {
+		s        string
+		expected JoinNS // zero for an error
+	}{
+		{s: "ipc:/proc/42/ns/ipc", expected: JoinNS{Type: "ipc", Path: "/proc/42/ns/ipc"}},
+		{s: "net:/var/run/netns/foo", expected: JoinNS{Type: "net", Path: "/var/run/netns/foo"}},
+		{s: "cgroup:/proc/42/ns/cgroup", expected: JoinNS{Type: "cgroup", Path: "/proc/42/ns/cgroup"}},
+		{s: "mnt:/proc/42/ns/mnt"},
+		{s: "user:/proc/42/ns/user"},
+		{s: "ipc:proc/42/ns/ipc"},
+		{s: "ipc"},
+		{s: "/proc/42/ns/ipc"},
+	}
+	for _, tc := range testCases {
+		got, err := ParseJoinNS(tc.s)
+		if tc.expected == (JoinNS{}) {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", tc.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.s, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("%q: expected %+v, got %+v", tc.s, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package child
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ListenPIDShimEnvKey is set when RootlessKit is re-executed as the shim for setting LISTEN_PID.
+// LISTEN_PID needs to be the PID of the target command, which cannot be known before executing it.
+const ListenPIDShimEnvKey = "_ROOTLESSKIT_LISTEN_PID_SHIM_UNDOCUMENTED"
+
+// ListenPIDShim sets LISTEN_PID to the current PID, and executes args.
+// ListenPIDShim does not return on success.
+func ListenPIDShim(args []string) error {
+	if len(args) == 0 {
+		return errors.New("no command specified")
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	os.Unsetenv(ListenPIDShimEnvKey)
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	return syscall.Exec(path, args, os.Environ())
+}
+
+// openPreservedFDs returns the FDs inherited from the parent for --preserve-fd.
+// The FDs are marked as close-on-exec, so that they are passed only to the target command via setPreservedFDs.
+func openPreservedFDs(fds []int) []*os.File {
+	var files []*os.File
+	for _, fd := range fds {
+		syscall.CloseOnExec(fd)
+		files = append(files, os.NewFile(uintptr(fd), "preserved-fd-"+strconv.Itoa(fd)))
+	}
+	return files
+}
+
+// setPreservedFDs passes files to cmd as FD 3, 4, ..., with the socket activation environment variables
+// compatible with sd_listen_fds(3).
+// The LISTEN_* variables inherited from the parent are removed, as they are for RootlessKit itself.
+func setPreservedFDs(cmd *exec.Cmd, files []*os.File) {
+	cmd.ExtraFiles = files
+	var env []string
+	for _, e := range cmd.Env {
+		if strings.HasPrefix(e, "LISTEN_PID=") || strings.HasPrefix(e, "LISTEN_FDS=") || strings.HasPrefix(e, "LISTEN_FDNAMES=") {
+			continue
+		}
+		env = append(env, e)
+	}
+	cmd.Env = append(env, "LISTEN_FDS="+strconv.Itoa(len(files)), ListenPIDShimEnvKey+"=1")
+	// cmd.Args is kept, and resolved again in the shim
+	cmd.Path = "/proc/self/exe"
+}
@@ -0,0 +1,25 @@
+package child
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestSetPreservedFDs(t *testing.T) {
+	cmd := exec.Command("true")
+	cmd.Env = []string{"FOO=foo", "LISTEN_PID=42", "LISTEN_FDS=1", "LISTEN_FDNAMES=rootlesskit"}
+	files := []*os.File{os.Stdin, os.Stdout}
+	setPreservedFDs(cmd, files)
+	expectedEnv := []string{"FOO=foo", "LISTEN_FDS=2", ListenPIDShimEnvKey + "=1"}
+	if !reflect.DeepEqual(cmd.Env, expectedEnv) {
+		t.Fatalf("expected %v, got %v", expectedEnv, cmd.Env)
+	}
+	if len(cmd.ExtraFiles) != 2 {
+		t.Fatalf("expected 2 extra files, got %d", len(cmd.ExtraFiles))
+	}
+	if cmd.Path != "/proc/self/exe" {
+		t.Fatalf("expected the shim, got %q", cmd.Path)
+	}
+}
@@ -0,0 +1,78 @@
+package child
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const localtime = "/etc/localtime"
+
+// hostLocaltime is the snapshot of the host /etc/localtime, taken before copying up /etc.
+type hostLocaltime struct {
+	data []byte
+	// zone is the zone name (e.g. "Asia/Tokyo") derived from the symlink target, or empty if unknown.
+	zone string
+}
+
+// readHostLocaltime reads p (typically /etc/localtime) following symlinks.
+// Returns nil without an error if p does not exist.
+func readHostLocaltime(p string) (*hostLocaltime, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "reading %s", p)
+	}
+	lt := &hostLocaltime{data: b}
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		lt.zone = zoneFromPath(resolved)
+	}
+	return lt, nil
+}
+
+// zoneFromPath returns the zone name from the path in the zoneinfo directory,
+// e.g. "Asia/Tokyo" for "/usr/share/zoneinfo/Asia/Tokyo".
+func zoneFromPath(p string) string {
+	const zoneinfo = "/zoneinfo/"
+	i := strings.LastIndex(p, zoneinfo)
+	if i < 0 {
+		return ""
+	}
+	zone := p[i+len(zoneinfo):]
+	// e.g. "/usr/share/zoneinfo/posix/Asia/Tokyo"
+	for _, prefix := range []string{"posix/", "right/"} {
+		zone = strings.TrimPrefix(zone, prefix)
+	}
+	return zone
+}
+
+// tz returns the value for the TZ environment variable.
+func (lt *hostLocaltime) tz() string {
+	if lt.zone != "" {
+		return lt.zone
+	}
+	// glibc and musl read the file specified with ":"
+	return ":" + localtime
+}
+
+// exportTZ sets TZ to lt.tz(), unless TZ is already set.
+func exportTZ(lt *hostLocaltime) {
+	if _, ok := os.LookupEnv("TZ"); !ok {
+		os.Setenv("TZ", lt.tz())
+	}
+}
+
+// writeLocaltime writes the snapshot to p as a real file, replacing the copied-up symlink.
+func writeLocaltime(p string, lt *hostLocaltime) error {
+	// remove copied-up link
+	_ = os.Remove(p)
+	if err := ioutil.WriteFile(p, lt.data, 0644); err != nil {
+		return errors.Wrapf(err, "writing %s", p)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package child
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestZoneFromPath(t *testing.T) {
+	testCases := map[string]string{
+		"/usr/share/zoneinfo/Asia/Tokyo":       "Asia/Tokyo",
+		"/usr/share/zoneinfo/UTC":              "UTC",
+		"/usr/share/zoneinfo/posix/Asia/Tokyo": "Asia/Tokyo",
+		"/usr/lib/zoneinfo/right/Europe/Paris": "Europe/Paris",
+		"/etc/localtime":                       "",
+	}
+	for p, expected := range testCases {
+		if got := zoneFromPath(p); got != expected {
+			t.Errorf("%q: expected %q, got %q", p, expected, got)
+		}
+	}
+}
+
+func TestLocaltime(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-localtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	lt, err := readHostLocaltime(filepath.Join(tmp, "nonexistent"))
+	if err != nil || lt != nil {
+		t.Fatalf("expected nil for nonexistent file, got %+v, %v", lt, err)
+	}
+
+	zoneFile := filepath.Join(tmp, "zoneinfo", "Asia", "Tokyo")
+	if err := os.MkdirAll(filepath.Dir(zoneFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("TZif-dummy")
+	if err := ioutil.WriteFile(zoneFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	hostLink := filepath.Join(tmp, "host-localtime")
+	if err := os.Symlink(zoneFile, hostLink); err != nil {
+		t.Fatal(err)
+	}
+	lt, err = readHostLocaltime(hostLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lt.zone != "Asia/Tokyo" || lt.tz() != "Asia/Tokyo" {
+		t.Fatalf("unexpected zone %q", lt.zone)
+	}
+
+	// the copied-up symlink is replaced with the real file
+	p := filepath.Join(tmp, "localtime")
+	if err := os.Symlink(filepath.Join(tmp, "broken"), p); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeLocaltime(p, lt); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Lstat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Mode().IsRegular() {
+		t.Fatalf("expected a regular file, got %v", st.Mode())
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Fatalf("expected %q, got %q", data, b)
+	}
+
+	if tz := (&hostLocaltime{data: data}).tz(); tz != ":/etc/localtime" {
+		t.Fatalf("unexpected TZ %q", tz)
+	}
+}
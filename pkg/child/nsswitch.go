@@ -0,0 +1,52 @@
+package child
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const nsswitchConf = "/etc/nsswitch.conf"
+
+// generateNSSwitchConf generates the minimal nsswitch.conf for resolving hostnames with /etc/hosts and DNS.
+func generateNSSwitchConf() []byte {
+	return []byte("# generated by RootlessKit\nhosts: files dns\n")
+}
+
+// trivialNSSwitchConf returns true if b has no database entry, i.e. b consists of blank lines and comments.
+func trivialNSSwitchConf(b []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return true
+}
+
+// writeNSSwitchConf writes the minimal nsswitch.conf to p.
+// An existing non-trivial file is kept unless force is true.
+func writeNSSwitchConf(p string, force bool) error {
+	if !force {
+		b, err := ioutil.ReadFile(p)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "reading %s", p)
+		}
+		if err == nil && !trivialNSSwitchConf(b) {
+			logrus.Infof("Keeping the existing %s", p)
+			return nil
+		}
+	}
+	// remove copied-up link
+	_ = os.Remove(p)
+	if err := ioutil.WriteFile(p, generateNSSwitchConf(), 0644); err != nil {
+		return errors.Wrapf(err, "writing %s", p)
+	}
+	return nil
+}
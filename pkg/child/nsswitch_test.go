@@ -0,0 +1,50 @@
+package child
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteNSSwitchConf(t *testing.T) {
+	testCases := []struct {
+		existing *string
+		force    bool
+		written  bool
+	}{
+		{existing: nil, written: true},
+		{existing: strPtr(""), written: true},
+		{existing: strPtr("# comment\n\n  # indented comment\n"), written: true},
+		{existing: strPtr("hosts: files\n"), written: false},
+		{existing: strPtr("# comment\npasswd: files\n"), written: false},
+		{existing: strPtr("hosts: files\n"), force: true, written: true},
+	}
+	for i, tc := range testCases {
+		tmp, err := ioutil.TempDir("", "test-nsswitch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := filepath.Join(tmp, "nsswitch.conf")
+		if tc.existing != nil {
+			if err := ioutil.WriteFile(p, []byte(*tc.existing), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writeNSSwitchConf(p, tc.force); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if written := string(b) == string(generateNSSwitchConf()); written != tc.written {
+			t.Errorf("#%d: expected written=%v, got %q", i, tc.written, string(b))
+		}
+		os.RemoveAll(tmp)
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
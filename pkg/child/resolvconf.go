@@ -1,22 +1,79 @@
 package child
 
 import (
+	"fmt"
 	"golang.org/x/sys/unix"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
-func generateResolvConf(dns string) []byte {
-	return []byte("nameserver " + dns + "\n")
+// ResolvConfMode is the mode of configuring /etc/resolv.conf for non-host network.
+type ResolvConfMode string
+
+const (
+	// ResolvConfRewrite writes the nameserver of the network driver. The default.
+	ResolvConfRewrite = ResolvConfMode("rewrite")
+	// ResolvConfBindHost bind-mounts the host /etc/resolv.conf as read-only.
+	ResolvConfBindHost = ResolvConfMode("bind-host")
+	// ResolvConfNone leaves /etc/resolv.conf as it is.
+	ResolvConfNone = ResolvConfMode("none")
+)
+
+// ParseResolvConfMode parses "rewrite", "bind-host", or "none".
+func ParseResolvConfMode(s string) (ResolvConfMode, error) {
+	switch m := ResolvConfMode(s); m {
+	case ResolvConfRewrite, ResolvConfBindHost, ResolvConfNone:
+		return m, nil
+	default:
+		return "", errors.Errorf("unknown resolv.conf mode %q, expected \"rewrite\", \"bind-host\", or \"none\"", s)
+	}
+}
+
+// ValidateDNSSearchDomain validates the domain for the "search" line of resolv.conf.
+func ValidateDNSSearchDomain(domain string) error {
+	d := strings.TrimSuffix(domain, ".")
+	if d == "" || len(d) > 253 {
+		return errors.Errorf("invalid DNS search domain %q", domain)
+	}
+	label := regexp.MustCompile("^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$")
+	for _, l := range strings.Split(d, ".") {
+		if !label.MatchString(l) {
+			return errors.Errorf("invalid DNS search domain %q: invalid label %q", domain, l)
+		}
+	}
+	return nil
+}
+
+// ValidateDNSOption validates the option for the "options" line of resolv.conf, e.g. "ndots:2".
+func ValidateDNSOption(option string) error {
+	if option == "" || strings.ContainsAny(option, " \t\n") {
+		return errors.Errorf("invalid DNS option %q", option)
+	}
+	return nil
+}
+
+// generateResolvConf generates resolv.conf. search and options can be nil.
+// The order of search and options is preserved.
+func generateResolvConf(dns string, search, options []string) []byte {
+	s := "nameserver " + dns + "\n"
+	if len(search) != 0 {
+		s += "search " + strings.Join(search, " ") + "\n"
+	}
+	if len(options) != 0 {
+		s += "options " + strings.Join(options, " ") + "\n"
+	}
+	return []byte(s)
 }
 
-func writeResolvConf(dns string) error {
+func writeResolvConf(dns string, search, options []string) error {
 	// remove copied-up link
 	_ = os.Remove("/etc/resolv.conf")
-	if err := ioutil.WriteFile("/etc/resolv.conf", generateResolvConf(dns), 0644); err != nil {
+	if err := ioutil.WriteFile("/etc/resolv.conf", generateResolvConf(dns, search, options), 0644); err != nil {
 		return errors.Wrapf(err, "writing %s", "/etc/resolv.conf")
 	}
 	return nil
@@ -32,7 +89,7 @@ func writeResolvConf(dns string) error {
 // Use writeResolvConf with copying-up /etc for most cases.
 func mountResolvConf(tempDir, dns string) error {
 	myResolvConf := filepath.Join(tempDir, "resolv.conf")
-	if err := ioutil.WriteFile(myResolvConf, generateResolvConf(dns), 0644); err != nil {
+	if err := ioutil.WriteFile(myResolvConf, generateResolvConf(dns, nil, nil), 0644); err != nil {
 		return errors.Wrapf(err, "writing %s", myResolvConf)
 	}
 
@@ -41,3 +98,32 @@ func mountResolvConf(tempDir, dns string) error {
 	}
 	return nil
 }
+
+// openHostResolvConf opens the host /etc/resolv.conf for bindHostResolvConf.
+// Needs to be called before copying-up /etc, as the symlink may point to a file that is not reachable after copying-up.
+func openHostResolvConf() (*os.File, error) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the host /etc/resolv.conf")
+	}
+	return f, nil
+}
+
+// bindHostResolvConf bind-mounts the host file opened by openHostResolvConf on /etc/resolv.conf as read-only.
+// When /etc is copied up, the copied-up link is replaced with an empty file for the mount point,
+// so that the original file is not shadowed via the link.
+func bindHostResolvConf(host *os.File, etcWasCopied bool) error {
+	const p = "/etc/resolv.conf"
+	if etcWasCopied {
+		// remove copied-up link
+		_ = os.Remove(p)
+		if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+			return errors.Wrapf(err, "creating %s", p)
+		}
+	}
+	src := fmt.Sprintf("/proc/self/fd/%d", host.Fd())
+	if err := unix.Mount(src, p, "", uintptr(unix.MS_BIND), ""); err != nil {
+		return errors.Wrapf(err, "failed to create bind mount %s for the host %s", p, host.Name())
+	}
+	return remountReadOnly(p)
+}
@@ -0,0 +1,57 @@
+package child
+
+import (
+	"testing"
+)
+
+func TestGenerateResolvConf(t *testing.T) {
+	got := string(generateResolvConf("10.0.2.3", []string{"example.com", "example.org"}, []string{"ndots:2", "edns0"}))
+	expected := "nameserver 10.0.2.3\nsearch example.com example.org\noptions ndots:2 edns0\n"
+	if got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+	got = string(generateResolvConf("10.0.2.3", nil, nil))
+	expected = "nameserver 10.0.2.3\n"
+	if got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestValidateDNSSearchDomain(t *testing.T) {
+	testCases := map[string]bool{
+		"example.com":   true,
+		"example.com.":  true,
+		"svc.cluster-1": true,
+		"":              false,
+		"-example.com":  false,
+		"example..com":  false,
+		"exa mple.com":  false,
+		"example.com/":  false,
+	}
+	for domain, valid := range testCases {
+		err := ValidateDNSSearchDomain(domain)
+		if valid && err != nil {
+			t.Errorf("expected %q to be valid, got %v", domain, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("expected %q to be invalid", domain)
+		}
+	}
+}
+
+func TestParseResolvConfMode(t *testing.T) {
+	for _, s := range []string{"rewrite", "bind-host", "none"} {
+		m, err := ParseResolvConfMode(s)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+		}
+		if string(m) != s {
+			t.Errorf("expected %q, got %q", s, m)
+		}
+	}
+	for _, s := range []string{"", "bind", "Rewrite"} {
+		if _, err := ParseResolvConfMode(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
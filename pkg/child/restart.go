@@ -0,0 +1,83 @@
+package child
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RestartPolicy is the policy for restarting the target command in the same namespaces.
+type RestartPolicy struct {
+	// Name is either "no", "on-failure", or "always".
+	Name string
+	// MaxRetries is the maximum number of restarts for "on-failure". 0 means unlimited.
+	MaxRetries int
+}
+
+// ParseRestartPolicy parses "no", "on-failure[:max]", or "always".
+// Empty string is parsed as "no".
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	name, maxStr := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		name, maxStr = s[:i], s[i+1:]
+	}
+	switch name {
+	case "", "no", "always":
+		if maxStr != "" {
+			return RestartPolicy{}, errors.Errorf("restart policy %q does not accept the maximum retry count", name)
+		}
+		if name == "" {
+			name = "no"
+		}
+		return RestartPolicy{Name: name}, nil
+	case "on-failure":
+		p := RestartPolicy{Name: name}
+		if maxStr != "" {
+			max, err := strconv.Atoi(maxStr)
+			if err != nil || max <= 0 {
+				return RestartPolicy{}, errors.Errorf("invalid maximum retry count %q in restart policy %q", maxStr, s)
+			}
+			p.MaxRetries = max
+		}
+		return p, nil
+	default:
+		return RestartPolicy{}, errors.Errorf("unknown restart policy %q, expected \"no\", \"on-failure[:max]\", or \"always\"", s)
+	}
+}
+
+// shouldRestart returns true if the command that exited with exitErr should be restarted,
+// after being restarted for the restarts times.
+func (p RestartPolicy) shouldRestart(exitErr error, restarts int) bool {
+	switch p.Name {
+	case "always":
+		return true
+	case "on-failure":
+		return exitErr != nil && (p.MaxRetries == 0 || restarts < p.MaxRetries)
+	default:
+		return false
+	}
+}
+
+// The delay before restarting the command is doubled on each consecutive restart, from restartDelayMin up to restartDelayMax,
+// so that a command that fails immediately does not restart in a busy loop.
+// The delay is reset to restartDelayMin when the command ran for restartDelayReset or longer.
+const (
+	restartDelayMin   = 100 * time.Millisecond
+	restartDelayMax   = 30 * time.Second
+	restartDelayReset = 10 * time.Second
+)
+
+// nextRestartDelay returns the delay before the next restart, from the previous delay (0 for the first restart),
+// and the duration of the last execution of the command.
+func nextRestartDelay(prev, ran time.Duration) time.Duration {
+	if prev == 0 || ran >= restartDelayReset {
+		return restartDelayMin
+	}
+	d := prev * 2
+	if d > restartDelayMax {
+		d = restartDelayMax
+	}
+	return d
+}
@@ -0,0 +1,79 @@
+package child
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	testCases := []struct {
+		s string
+		// nil for invalid string
+		expected *RestartPolicy
+	}{
+		{"", &RestartPolicy{Name: "no"}},
+		{"no", &RestartPolicy{Name: "no"}},
+		{"always", &RestartPolicy{Name: "always"}},
+		{"on-failure", &RestartPolicy{Name: "on-failure"}},
+		{"on-failure:3", &RestartPolicy{Name: "on-failure", MaxRetries: 3}},
+		{"on-failure:0", nil},
+		{"on-failure:x", nil},
+		{"always:3", nil},
+		{"unless-stopped", nil},
+	}
+	for _, tc := range testCases {
+		got, err := ParseRestartPolicy(tc.s)
+		if tc.expected == nil {
+			if err == nil {
+				t.Fatalf("error is expected for %q", tc.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("got error for %q: %v", tc.s, err)
+		}
+		if !reflect.DeepEqual(got, *tc.expected) {
+			t.Fatalf("expected %+v, got %+v", *tc.expected, got)
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	failure := errors.New("exit status 1")
+	p := RestartPolicy{Name: "on-failure", MaxRetries: 2}
+	if p.shouldRestart(nil, 0) {
+		t.Fatal("on-failure should not restart on success")
+	}
+	if !p.shouldRestart(failure, 1) {
+		t.Fatal("on-failure:2 should restart for the second time")
+	}
+	if p.shouldRestart(failure, 2) {
+		t.Fatal("on-failure:2 should give up after 2 restarts")
+	}
+	if !(RestartPolicy{Name: "always"}).shouldRestart(nil, 100) {
+		t.Fatal("always should restart")
+	}
+	if (RestartPolicy{Name: "no"}).shouldRestart(failure, 0) {
+		t.Fatal("no should not restart")
+	}
+}
+
+func TestNextRestartDelay(t *testing.T) {
+	var d time.Duration
+	var got []time.Duration
+	for i := 0; i < 12; i++ {
+		d = nextRestartDelay(d, time.Millisecond)
+		got = append(got, d)
+	}
+	if got[0] != restartDelayMin || got[1] != 2*restartDelayMin || got[2] != 4*restartDelayMin {
+		t.Fatalf("expected the delay to be doubled, got %v", got)
+	}
+	if last := got[len(got)-1]; last != restartDelayMax {
+		t.Fatalf("expected the delay to be capped at %v, got %v", restartDelayMax, last)
+	}
+	if d := nextRestartDelay(restartDelayMax, restartDelayReset); d != restartDelayMin {
+		t.Fatalf("expected the delay to be reset, got %v", d)
+	}
+}
@@ -0,0 +1,116 @@
+package child
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// rlimitResources maps the names used in ParseRlimit to the resources.
+var rlimitResources = map[string]int{
+	"as":         unix.RLIMIT_AS,
+	"core":       unix.RLIMIT_CORE,
+	"cpu":        unix.RLIMIT_CPU,
+	"data":       unix.RLIMIT_DATA,
+	"fsize":      unix.RLIMIT_FSIZE,
+	"locks":      unix.RLIMIT_LOCKS,
+	"memlock":    unix.RLIMIT_MEMLOCK,
+	"msgqueue":   unix.RLIMIT_MSGQUEUE,
+	"nice":       unix.RLIMIT_NICE,
+	"nofile":     unix.RLIMIT_NOFILE,
+	"nproc":      unix.RLIMIT_NPROC,
+	"rss":        unix.RLIMIT_RSS,
+	"rtprio":     unix.RLIMIT_RTPRIO,
+	"rttime":     unix.RLIMIT_RTTIME,
+	"sigpending": unix.RLIMIT_SIGPENDING,
+	"stack":      unix.RLIMIT_STACK,
+}
+
+// Rlimit is a resource limit applied to the setup commands and the target command.
+type Rlimit struct {
+	// Name is the name of the resource without "RLIMIT_" prefix, in lower case, e.g. "nofile".
+	Name     string
+	Resource int
+	Soft     uint64
+	Hard     uint64
+}
+
+// ParseRlimit parses "NAME=SOFT:HARD" or "NAME=VALUE" (for both the soft and the hard limits), e.g. "nofile=1024:65536".
+// The limits can be "unlimited".
+func ParseRlimit(s string) (Rlimit, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return Rlimit{}, errors.Errorf("invalid rlimit %q, expected NAME=SOFT:HARD", s)
+	}
+	name, value := s[:i], s[i+1:]
+	resource, ok := rlimitResources[name]
+	if !ok {
+		var names []string
+		for k := range rlimitResources {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return Rlimit{}, errors.Errorf("unknown rlimit %q, expected one of %v", name, names)
+	}
+	softStr, hardStr := value, value
+	if j := strings.Index(value, ":"); j >= 0 {
+		softStr, hardStr = value[:j], value[j+1:]
+	}
+	soft, err := parseRlimitValue(softStr)
+	if err != nil {
+		return Rlimit{}, errors.Wrapf(err, "invalid rlimit %q", s)
+	}
+	hard, err := parseRlimitValue(hardStr)
+	if err != nil {
+		return Rlimit{}, errors.Wrapf(err, "invalid rlimit %q", s)
+	}
+	if soft > hard {
+		return Rlimit{}, errors.Errorf("invalid rlimit %q: the soft limit exceeds the hard limit", s)
+	}
+	return Rlimit{Name: name, Resource: resource, Soft: soft, Hard: hard}, nil
+}
+
+func parseRlimitValue(s string) (uint64, error) {
+	if s == "unlimited" {
+		return math.MaxUint64, nil // RLIM_INFINITY
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// Validate returns an error if the hard limit exceeds the current hard limit,
+// as raising the hard limit requires CAP_SYS_RESOURCE in the initial user namespace.
+func (r Rlimit) Validate() error {
+	var cur syscall.Rlimit
+	if err := syscall.Getrlimit(r.Resource, &cur); err != nil {
+		return errors.Wrapf(err, "failed to get rlimit %q", r.Name)
+	}
+	if r.Hard > cur.Max {
+		return errors.Errorf("rlimit %q: the hard limit %s exceeds the current hard limit %s (raising the hard limit requires CAP_SYS_RESOURCE)",
+			r.Name, formatRlimitValue(r.Hard), formatRlimitValue(cur.Max))
+	}
+	return nil
+}
+
+func formatRlimitValue(v uint64) string {
+	if v == math.MaxUint64 {
+		return "unlimited"
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// setRlimits applies rlimits to the current process, so that they are inherited by the commands.
+// syscall.Setrlimit is used rather than unix.Setrlimit, so that recent Go runtime does not
+// restore RLIMIT_NOFILE on executing the commands.
+func setRlimits(rlimits []Rlimit) error {
+	for _, r := range rlimits {
+		if err := syscall.Setrlimit(r.Resource, &syscall.Rlimit{Cur: r.Soft, Max: r.Hard}); err != nil {
+			return errors.Wrapf(err, "failed to set rlimit %q", r.Name)
+		}
+	}
+	return nil
+}
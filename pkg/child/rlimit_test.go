@@ -0,0 +1,62 @@
+package child
+
+import (
+	"math"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseRlimit(t *testing.T) {
+	testCases := []struct {
+		s        string
+		expected Rlimit
+		ok       bool
+	}{
+		{"nofile=1024:65536", Rlimit{Name: "nofile", Resource: unix.RLIMIT_NOFILE, Soft: 1024, Hard: 65536}, true},
+		{"nofile=4096", Rlimit{Name: "nofile", Resource: unix.RLIMIT_NOFILE, Soft: 4096, Hard: 4096}, true},
+		{"core=0:unlimited", Rlimit{Name: "core", Resource: unix.RLIMIT_CORE, Soft: 0, Hard: math.MaxUint64}, true},
+		{"memlock=unlimited", Rlimit{Name: "memlock", Resource: unix.RLIMIT_MEMLOCK, Soft: math.MaxUint64, Hard: math.MaxUint64}, true},
+		{"nofile=65536:1024", Rlimit{}, false},
+		{"nofile=unlimited:1024", Rlimit{}, false},
+		{"nofile=-1", Rlimit{}, false},
+		{"nofile=", Rlimit{}, false},
+		{"nofile", Rlimit{}, false},
+		{"NOFILE=1024", Rlimit{}, false},
+		{"foo=1024", Rlimit{}, false},
+	}
+	for _, tc := range testCases {
+		got, err := ParseRlimit(tc.s)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", tc.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.s, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("%q: expected %+v, got %+v", tc.s, tc.expected, got)
+		}
+	}
+}
+
+func TestRlimitValidate(t *testing.T) {
+	ok, err := ParseRlimit("nofile=0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ok.Validate(); err != nil {
+		t.Error(err)
+	}
+	ng, err := ParseRlimit("nofile=unlimited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RLIMIT_NOFILE cannot be unlimited (capped by fs.nr_open)
+	if err := ng.Validate(); err == nil {
+		t.Error("expected an error")
+	}
+}
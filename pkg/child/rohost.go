@@ -0,0 +1,81 @@
+package child
+
+import (
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/mountinfo"
+)
+
+// roHostKeptDirs are kept writable (as they are) on --ro-host, so that the pseudo filesystems remain usable.
+var roHostKeptDirs = []string{"/dev", "/proc", "/sys"}
+
+// setupROHost unshares the mount namespace of the current thread, and remounts all the mounts as read-only,
+// except roHostKeptDirs and writable.
+// writable are bind-mounted on themselves before remounting, so that they remain writable.
+// As in setupRootfs, the OS thread is locked and never unlocked, and the target command needs to be started from the same goroutine.
+//
+// The init process and the port driver are kept in the original mount namespace,
+// so that they can still write the state directory on the host.
+func setupROHost(writable []string) error {
+	runtime.LockOSThread()
+	if err := unix.Unshare(unix.CLONE_NEWNS); err != nil {
+		return errors.Wrap(err, "failed to unshare mount namespace")
+	}
+	if err := unix.Mount("", "/", "", uintptr(unix.MS_SLAVE|unix.MS_REC), ""); err != nil {
+		return errors.Wrap(err, "failed to make / rslave")
+	}
+	for _, p := range writable {
+		if err := unix.Mount(p, p, "", uintptr(unix.MS_BIND|unix.MS_REC), ""); err != nil {
+			return errors.Wrapf(err, "failed to create bind mount on %s", p)
+		}
+	}
+	mountPoints, err := mountinfo.SelfMountPoints()
+	if err != nil {
+		return err
+	}
+	for _, mp := range roHostTargets(mountPoints, append(roHostKeptDirs, writable...)) {
+		if err := remountReadOnly(mp); err != nil {
+			if mp == "/" {
+				return err
+			}
+			// e.g. the mount point is not accessible
+			logrus.WithError(err).Warnf("failed to remount %s as read-only", mp)
+		}
+	}
+	return nil
+}
+
+// roHostTargets returns the sorted unique mount points that are not equal to or under any of excluded.
+func roHostTargets(mountPoints, excluded []string) []string {
+	seen := make(map[string]struct{})
+	var res []string
+	for _, mp := range mountPoints {
+		if _, ok := seen[mp]; ok {
+			continue
+		}
+		seen[mp] = struct{}{}
+		if isUnderAny(mp, excluded) {
+			continue
+		}
+		res = append(res, mp)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func isUnderAny(p string, dirs []string) bool {
+	for _, d := range dirs {
+		d = filepath.Clean(d)
+		if p == d || d == "/" || strings.HasPrefix(p, d+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+package child
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestROHostTargets(t *testing.T) {
+	mountPoints := []string{"/", "/dev", "/dev/pts", "/proc", "/home", "/home/user/work", "/home/user/workspace", "/sys/fs/cgroup", "/home"}
+	excluded := []string{"/dev", "/proc", "/sys", "/home/user/work/"}
+	got := roHostTargets(mountPoints, excluded)
+	expected := []string{"/", "/home", "/home/user/workspace"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
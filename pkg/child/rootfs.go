@@ -0,0 +1,158 @@
+package child
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// rootfsWritableDirs are the directories that are mounted as tmpfs on --read-only
+var rootfsWritableDirs = map[string]string{
+	"/tmp": "mode=1777",
+	"/run": "mode=755",
+}
+
+// setupRootfs unshares the mount namespace of the current thread, and pivots the root of the thread into rootfs.
+// The runtime directory of the host view is bind-mounted into rootfs unless runtimeDir is empty.
+// When noPivot is true, chroot(2) is used when pivot_root(2) fails.
+// devMode is the mode of setting up /dev in rootfs.
+// etcFiles are the files in /etc configured by setupNet in the original mount namespace, bind-mounted into rootfs.
+// The OS thread is locked and never unlocked, so that the thread is discarded when the goroutine exits.
+// The target command needs to be started from the same goroutine so as to inherit the new root.
+//
+// The init process and the port driver are kept in the original mount namespace,
+// so that they can still access the state directory on the host.
+func setupRootfs(rootfs string, readOnly, noPivot bool, etcFiles []string, runtimeDir string, devMode DevMode) error {
+	runtime.LockOSThread()
+	if err := unix.Unshare(unix.CLONE_NEWNS); err != nil {
+		return errors.Wrap(err, "failed to unshare mount namespace")
+	}
+	if err := unix.Mount("", "/", "", uintptr(unix.MS_SLAVE|unix.MS_REC), ""); err != nil {
+		return errors.Wrap(err, "failed to make / rslave")
+	}
+	// pivot_root(2) requires rootfs to be a mount point
+	if err := unix.Mount(rootfs, rootfs, "", uintptr(unix.MS_BIND|unix.MS_REC), ""); err != nil {
+		return errors.Wrapf(err, "failed to create bind mount on %s", rootfs)
+	}
+	for _, d := range []string{"/proc", "/sys"} {
+		if err := bindIntoRootfs(rootfs, d, true); err != nil {
+			return err
+		}
+	}
+	switch devMode {
+	case DevModeMinimal:
+		if err := setupMinimalDev(rootfs); err != nil {
+			return err
+		}
+	case DevModeHost:
+		// NOP
+	default:
+		if err := bindIntoRootfs(rootfs, "/dev", true); err != nil {
+			return err
+		}
+	}
+	for _, f := range etcFiles {
+		if _, err := os.Stat(filepath.Join(rootfs, f)); err != nil {
+			logrus.Warnf("%s does not exist in the rootfs, not bind-mounting", f)
+			continue
+		}
+		if err := bindIntoRootfs(rootfs, f, false); err != nil {
+			return err
+		}
+	}
+	if readOnly {
+		for d, data := range rootfsWritableDirs {
+			target := filepath.Join(rootfs, d)
+			if st, err := os.Stat(target); err != nil || !st.IsDir() {
+				return errors.Errorf("read-only rootfs requires %s to be a directory (needed for a writable tmpfs)", d)
+			}
+			if err := unix.Mount("tmpfs", target, "tmpfs", 0, data); err != nil {
+				return errors.Wrapf(err, "failed to mount tmpfs on %s", target)
+			}
+		}
+		if err := remountReadOnly(rootfs); err != nil {
+			return err
+		}
+	}
+	if runtimeDir != "" {
+		// mounted after remounting rootfs as read-only, so as to keep the runtime directory writable
+		if err := bindRuntimeDir(rootfs, runtimeDir); err != nil {
+			return err
+		}
+	}
+	if err := unix.Chdir(rootfs); err != nil {
+		return errors.Wrapf(err, "failed to chdir to %s", rootfs)
+	}
+	// pivot_root(".", ".") stacks the old root on the new root, so no temporary directory is needed.
+	// https://github.com/opencontainers/runc/blob/v1.0.0-rc9/libcontainer/rootfs_linux.go#L767-L771
+	if err := unix.PivotRoot(".", "."); err != nil {
+		if !noPivot {
+			return errors.Wrapf(err, "failed to pivot_root to %s", rootfs)
+		}
+		logrus.WithError(err).Warnf("failed to pivot_root to %s, falling back to chroot", rootfs)
+		return moveRoot(rootfs)
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return errors.Wrap(err, "failed to unmount the old root")
+	}
+	return unix.Chdir("/")
+}
+
+// moveRoot moves the mount of rootfs on / and chroots into it, as in runc --no-pivot.
+// Unlike pivot_root, the old root is still mounted under the new root, so the command
+// with CAP_SYS_CHROOT in the user namespace can escape to the host view.
+// https://github.com/opencontainers/runc/blob/v1.0.0-rc9/libcontainer/rootfs_linux.go#L797-L848
+func moveRoot(rootfs string) error {
+	if err := unix.Mount(rootfs, "/", "", unix.MS_MOVE, ""); err != nil {
+		return errors.Wrapf(err, "failed to move %s to /", rootfs)
+	}
+	if err := unix.Chroot("."); err != nil {
+		return errors.Wrapf(err, "failed to chroot to %s", rootfs)
+	}
+	return unix.Chdir("/")
+}
+
+func bindIntoRootfs(rootfs, p string, recursive bool) error {
+	target := filepath.Join(rootfs, p)
+	if _, err := os.Stat(target); err != nil {
+		return errors.Wrapf(err, "rootfs lacks %s", p)
+	}
+	flags := unix.MS_BIND
+	if recursive {
+		flags |= unix.MS_REC
+	}
+	if err := unix.Mount(p, target, "", uintptr(flags), ""); err != nil {
+		return errors.Wrapf(err, "failed to bind-mount %s on %s", p, target)
+	}
+	return nil
+}
+
+// remountReadOnly remounts the bind mount p as read-only.
+// The existing "locked" flags such as nosuid need to be preserved, otherwise remounting fails with EPERM in userns.
+func remountReadOnly(p string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(p, &st); err != nil {
+		return errors.Wrapf(err, "failed to statfs %s", p)
+	}
+	flags := unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY
+	for stFlag, msFlag := range map[int64]int{
+		unix.ST_NOSUID:     unix.MS_NOSUID,
+		unix.ST_NODEV:      unix.MS_NODEV,
+		unix.ST_NOEXEC:     unix.MS_NOEXEC,
+		unix.ST_NOATIME:    unix.MS_NOATIME,
+		unix.ST_NODIRATIME: unix.MS_NODIRATIME,
+		unix.ST_RELATIME:   unix.MS_RELATIME,
+	} {
+		if int64(st.Flags)&stFlag == stFlag {
+			flags |= msFlag
+		}
+	}
+	if err := unix.Mount("", p, "", uintptr(flags), ""); err != nil {
+		return errors.Wrapf(err, "failed to remount %s as read-only", p)
+	}
+	return nil
+}
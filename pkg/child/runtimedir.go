@@ -0,0 +1,47 @@
+package child
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// RuntimeDirEnvKey is set to the runtime directory for the setup commands and the target command.
+const RuntimeDirEnvKey = "XDG_RUNTIME_DIR"
+
+// RuntimeDir returns the runtime directory of the user, i.e. "/run/user/<uid>".
+func RuntimeDir(uid int) string {
+	return fmt.Sprintf("/run/user/%d", uid)
+}
+
+// ValidateRuntimeDir verifies that p is a directory owned by uid.
+func ValidateRuntimeDir(p string, uid int) error {
+	st, err := os.Stat(p)
+	if err != nil {
+		return errors.Wrap(err, "invalid runtime directory")
+	}
+	if !st.IsDir() {
+		return errors.Errorf("runtime directory %q is not a directory", p)
+	}
+	if sys, ok := st.Sys().(*syscall.Stat_t); !ok || int(sys.Uid) != uid {
+		return errors.Errorf("runtime directory %q is not owned by UID %d", p, uid)
+	}
+	return nil
+}
+
+// bindRuntimeDir bind-mounts the runtime directory p of the host view on the same path in rootfs.
+// The mount point is created in rootfs if missing.
+func bindRuntimeDir(rootfs, p string) error {
+	target := filepath.Join(rootfs, p)
+	if err := os.MkdirAll(target, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create the mount point %s", target)
+	}
+	if err := unix.Mount(p, target, "", uintptr(unix.MS_BIND|unix.MS_REC), ""); err != nil {
+		return errors.Wrapf(err, "failed to bind-mount %s on %s", p, target)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package child
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateRuntimeDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-runtimedir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	f := filepath.Join(tmp, "file")
+	if err := ioutil.WriteFile(f, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	uid := os.Geteuid()
+	if err := ValidateRuntimeDir(tmp, uid); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		p   string
+		uid int
+	}{
+		{tmp, uid + 1},
+		{f, uid},
+		{filepath.Join(tmp, "nonexistent"), uid},
+	} {
+		if err := ValidateRuntimeDir(tc.p, tc.uid); err == nil {
+			t.Errorf("expected an error for %q (UID %d)", tc.p, tc.uid)
+		}
+	}
+}
+
+func TestRuntimeDir(t *testing.T) {
+	if s := RuntimeDir(1001); s != "/run/user/1001" {
+		t.Fatalf("unexpected runtime dir %q", s)
+	}
+}
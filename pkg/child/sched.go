@@ -0,0 +1,261 @@
+package child
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// The scheduling policies in sched(7). Not defined in golang.org/x/sys/unix.
+const (
+	schedOther = 0
+	schedFIFO  = 1
+	schedRR    = 2
+	schedBatch = 3
+	schedIdle  = 5
+)
+
+// schedPolicies maps the names used in ParseSchedPolicy to the policies.
+var schedPolicies = map[string]int{
+	"other": schedOther,
+	"fifo":  schedFIFO,
+	"rr":    schedRR,
+	"batch": schedBatch,
+	"idle":  schedIdle,
+}
+
+// SchedPolicy is the scheduling policy applied to the target command.
+type SchedPolicy struct {
+	// Name is the name of the policy without "SCHED_" prefix, in lower case, e.g. "batch".
+	Name   string
+	Policy int
+	// Priority is the static priority [1..99] for the real-time policies ("fifo" and "rr"), 0 otherwise.
+	Priority int
+}
+
+// realtime returns true for SCHED_FIFO and SCHED_RR.
+func (p SchedPolicy) realtime() bool {
+	return p.Policy == schedFIFO || p.Policy == schedRR
+}
+
+// ParseSchedPolicy parses "other", "batch", "idle", "fifo:PRIORITY", or "rr:PRIORITY", e.g. "fifo:10".
+func ParseSchedPolicy(s string) (SchedPolicy, error) {
+	name, prioStr := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		name, prioStr = s[:i], s[i+1:]
+	}
+	policy, ok := schedPolicies[name]
+	if !ok {
+		var names []string
+		for k := range schedPolicies {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return SchedPolicy{}, errors.Errorf("unknown scheduling policy %q, expected one of %v", name, names)
+	}
+	p := SchedPolicy{Name: name, Policy: policy}
+	if !p.realtime() {
+		if prioStr != "" {
+			return SchedPolicy{}, errors.Errorf("invalid scheduling policy %q: priority is supported only for \"fifo\" and \"rr\"", s)
+		}
+		return p, nil
+	}
+	prio, err := strconv.Atoi(prioStr)
+	if err != nil || prio < 1 || prio > 99 {
+		return SchedPolicy{}, errors.Errorf("invalid scheduling policy %q, expected %s:PRIORITY with PRIORITY in [1..99]", s, name)
+	}
+	p.Priority = prio
+	return p, nil
+}
+
+// maxCPUs is CPU_SETSIZE, i.e. the size of unix.CPUSet in bits.
+const maxCPUs = int(unsafe.Sizeof(unix.CPUSet{})) * 8
+
+// ParseCPUList parses the CPU list format of cpuset(7), e.g. "0-3,8".
+// The returned CPUs are sorted and deduplicated.
+func ParseCPUList(s string) ([]int, error) {
+	seen := make(map[int]struct{})
+	for _, f := range strings.Split(s, ",") {
+		startS, endS := f, f
+		if i := strings.Index(f, "-"); i >= 0 {
+			startS, endS = f[:i], f[i+1:]
+		}
+		start, err := strconv.Atoi(startS)
+		if err != nil {
+			return nil, errors.Errorf("invalid CPU list %q", s)
+		}
+		end, err := strconv.Atoi(endS)
+		if err != nil {
+			return nil, errors.Errorf("invalid CPU list %q", s)
+		}
+		if start < 0 || end >= maxCPUs || start > end {
+			return nil, errors.Errorf("invalid CPU list %q", s)
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			seen[cpu] = struct{}{}
+		}
+	}
+	var cpus []int
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// ValidateCPUAffinity returns an error if cpus contains a CPU that is not online.
+func ValidateCPUAffinity(cpus []int) error {
+	b, err := ioutil.ReadFile("/sys/devices/system/cpu/online")
+	if err != nil {
+		return errors.Wrap(err, "failed to read the online CPUs")
+	}
+	online, err := ParseCPUList(strings.TrimSpace(string(b)))
+	if err != nil {
+		return err
+	}
+	return validateCPUs(cpus, online)
+}
+
+func validateCPUs(cpus, online []int) error {
+	onlineMap := make(map[int]struct{}, len(online))
+	for _, cpu := range online {
+		onlineMap[cpu] = struct{}{}
+	}
+	for _, cpu := range cpus {
+		if _, ok := onlineMap[cpu]; !ok {
+			return errors.Errorf("CPU %d is not online (online CPUs: %v)", cpu, online)
+		}
+	}
+	return nil
+}
+
+// SchedShimEnvKey is set when RootlessKit is re-executed as the shim for applying the CPU affinity and the scheduling policy.
+// They are applied in the shim rather than in the child, so that the threads of the child (e.g. the port driver) are not affected.
+const SchedShimEnvKey = "_ROOTLESSKIT_SCHED_SHIM_UNDOCUMENTED"
+
+// SchedShim applies the CPU affinity and the scheduling policy encoded in SchedShimEnvKey to the current thread,
+// and executes args. The command inherits them from the thread.
+// SchedShim does not return on success.
+func SchedShim(args []string) error {
+	if len(args) == 0 {
+		return errors.New("no command specified")
+	}
+	cpus, policy, err := decodeSched(os.Getenv(SchedShimEnvKey))
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s", SchedShimEnvKey)
+	}
+	os.Unsetenv(SchedShimEnvKey)
+	path := "/proc/self/exe"
+	if os.Getenv(CapDropShimEnvKey) == "" && os.Getenv(ListenPIDShimEnvKey) == "" {
+		path, err = exec.LookPath(args[0])
+		if err != nil {
+			return err
+		}
+	}
+	// otherwise chained to CapDropShim or ListenPIDShim
+	runtime.LockOSThread()
+	if err := setSched(cpus, policy); err != nil {
+		return err
+	}
+	return syscall.Exec(path, args, os.Environ())
+}
+
+// setSchedShim wraps cmd with SchedShim.
+// Empty cpus and nil policy are ignored.
+func setSchedShim(cmd *exec.Cmd, cpus []int, policy *SchedPolicy) {
+	if len(cpus) == 0 && policy == nil {
+		return
+	}
+	cmd.Env = append(cmd.Env, SchedShimEnvKey+"="+encodeSched(cpus, policy))
+	// cmd.Args is kept, and resolved again in the shim
+	cmd.Path = "/proc/self/exe"
+}
+
+// encodeSched encodes cpus and policy as "CPUS;POLICY", e.g. "0,1;fifo:10". Either of them can be empty.
+func encodeSched(cpus []int, policy *SchedPolicy) string {
+	var ss []string
+	for _, cpu := range cpus {
+		ss = append(ss, strconv.Itoa(cpu))
+	}
+	s := strings.Join(ss, ",") + ";"
+	if policy != nil {
+		s += policy.Name
+		if policy.realtime() {
+			s += ":" + strconv.Itoa(policy.Priority)
+		}
+	}
+	return s
+}
+
+func decodeSched(s string) ([]int, *SchedPolicy, error) {
+	i := strings.Index(s, ";")
+	if i < 0 {
+		return nil, nil, errors.Errorf("unexpected string %q", s)
+	}
+	var (
+		cpus   []int
+		policy *SchedPolicy
+	)
+	if cpusS := s[:i]; cpusS != "" {
+		var err error
+		cpus, err = ParseCPUList(cpusS)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if policyS := s[i+1:]; policyS != "" {
+		p, err := ParseSchedPolicy(policyS)
+		if err != nil {
+			return nil, nil, err
+		}
+		policy = &p
+	}
+	return cpus, policy, nil
+}
+
+// setSched applies the CPU affinity and the scheduling policy to the current thread.
+// The caller needs to lock the OS thread.
+// Empty cpus and nil policy are ignored.
+func setSched(cpus []int, policy *SchedPolicy) error {
+	if len(cpus) != 0 {
+		var set unix.CPUSet
+		for _, cpu := range cpus {
+			set.Set(cpu)
+		}
+		// 0 for the current thread
+		if err := unix.SchedSetaffinity(0, &set); err != nil {
+			return errors.Wrapf(err, "failed to set CPU affinity %v (the CPUs may not be allowed by the cpuset cgroup)", cpus)
+		}
+	}
+	if policy != nil {
+		if err := schedSetscheduler(0, *policy); err != nil {
+			if err == unix.EPERM && policy.realtime() {
+				return errors.Wrapf(err, "failed to set scheduling policy %q with priority %d (real-time policies require RLIMIT_RTPRIO, e.g. --rlimit=rtprio=%d, or CAP_SYS_NICE on the host)",
+					policy.Name, policy.Priority, policy.Priority)
+			}
+			return errors.Wrapf(err, "failed to set scheduling policy %q", policy.Name)
+		}
+	}
+	return nil
+}
+
+// schedSetscheduler calls sched_setscheduler(2), which is not implemented in golang.org/x/sys/unix.
+func schedSetscheduler(tid int, policy SchedPolicy) error {
+	param := struct {
+		priority int32
+	}{priority: int32(policy.Priority)}
+	_, _, errno := unix.Syscall(unix.SYS_SCHED_SETSCHEDULER, uintptr(tid), uintptr(policy.Policy), uintptr(unsafe.Pointer(&param)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
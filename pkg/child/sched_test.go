@@ -0,0 +1,120 @@
+package child
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSchedPolicy(t *testing.T) {
+	testCases := []struct {
+		s        string
+		expected SchedPolicy
+		ok       bool
+	}{
+		{"other", SchedPolicy{Name: "other", Policy: schedOther}, true},
+		{"batch", SchedPolicy{Name: "batch", Policy: schedBatch}, true},
+		{"idle", SchedPolicy{Name: "idle", Policy: schedIdle}, true},
+		{"fifo:10", SchedPolicy{Name: "fifo", Policy: schedFIFO, Priority: 10}, true},
+		{"rr:99", SchedPolicy{Name: "rr", Policy: schedRR, Priority: 99}, true},
+		{"fifo", SchedPolicy{}, false},
+		{"fifo:0", SchedPolicy{}, false},
+		{"rr:100", SchedPolicy{}, false},
+		{"batch:1", SchedPolicy{}, false},
+		{"BATCH", SchedPolicy{}, false},
+		{"deadline", SchedPolicy{}, false},
+		{"", SchedPolicy{}, false},
+	}
+	for _, tc := range testCases {
+		got, err := ParseSchedPolicy(tc.s)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", tc.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.s, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("%q: expected %+v, got %+v", tc.s, tc.expected, got)
+		}
+	}
+}
+
+func TestParseCPUList(t *testing.T) {
+	testCases := []struct {
+		s        string
+		expected []int
+		ok       bool
+	}{
+		{"0", []int{0}, true},
+		{"0-3,8", []int{0, 1, 2, 3, 8}, true},
+		{"8,0-1,1", []int{0, 1, 8}, true},
+		{"1023", []int{1023}, true},
+		{"1024", nil, false},
+		{"3-0", nil, false},
+		{"-1", nil, false},
+		{"0,", nil, false},
+		{"a", nil, false},
+		{"", nil, false},
+	}
+	for _, tc := range testCases {
+		got, err := ParseCPUList(tc.s)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", tc.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%q: expected %v, got %v", tc.s, tc.expected, got)
+		}
+	}
+}
+
+func TestValidateCPUs(t *testing.T) {
+	online := []int{0, 1, 2, 3}
+	if err := validateCPUs([]int{0, 3}, online); err != nil {
+		t.Error(err)
+	}
+	if err := validateCPUs([]int{0, 4}, online); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestEncodeSched(t *testing.T) {
+	fifo := SchedPolicy{Name: "fifo", Policy: schedFIFO, Priority: 10}
+	batch := SchedPolicy{Name: "batch", Policy: schedBatch}
+	testCases := []struct {
+		cpus    []int
+		policy  *SchedPolicy
+		encoded string
+	}{
+		{[]int{0, 1, 8}, &fifo, "0,1,8;fifo:10"},
+		{nil, &batch, ";batch"},
+		{[]int{3}, nil, "3;"},
+	}
+	for _, tc := range testCases {
+		s := encodeSched(tc.cpus, tc.policy)
+		if s != tc.encoded {
+			t.Errorf("expected %q, got %q", tc.encoded, s)
+			continue
+		}
+		cpus, policy, err := decodeSched(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(cpus, tc.cpus) || !reflect.DeepEqual(policy, tc.policy) {
+			t.Errorf("%q: expected %v %+v, got %v %+v", s, tc.cpus, tc.policy, cpus, policy)
+		}
+	}
+	if _, _, err := decodeSched("0,1"); err == nil {
+		t.Error("expected an error for a string without \";\"")
+	}
+}
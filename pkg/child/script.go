@@ -0,0 +1,95 @@
+package child
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultScriptInterpreter is used for the script without the shebang line.
+const DefaultScriptInterpreter = "/bin/sh"
+
+// Script is the script executed instead of the target command.
+// TargetCmd is passed to the script as the arguments.
+type Script struct {
+	// Path is the absolute path of the script file in the host view. Exclusive with Stdin.
+	Path string
+	// Stdin reads the script from the stdin of RootlessKit. Exclusive with Path.
+	Stdin bool
+}
+
+// ScriptInterpreter returns the interpreter and the optional argument in the shebang line of the script,
+// e.g. ["/bin/bash", "-e"] for "#!/bin/bash -e".
+// As in the kernel, the optional argument is not split by whitespaces.
+// DefaultScriptInterpreter is returned when the script has no shebang line.
+func ScriptInterpreter(script []byte) []string {
+	if !bytes.HasPrefix(script, []byte("#!")) {
+		return []string{DefaultScriptInterpreter}
+	}
+	line := string(script[2:])
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return []string{DefaultScriptInterpreter}
+	}
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		return []string{line[:i], strings.TrimSpace(line[i+1:])}
+	}
+	return []string{line}
+}
+
+// ValidateScriptInterpreter returns an error if the interpreter of the script is not executable.
+func ValidateScriptInterpreter(script []byte) error {
+	interp := ScriptInterpreter(script)[0]
+	if _, err := exec.LookPath(interp); err != nil {
+		return errors.Wrapf(err, "interpreter %q of the script is not available", interp)
+	}
+	return nil
+}
+
+func readScript(s Script) ([]byte, error) {
+	if s.Stdin {
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the script from stdin")
+		}
+		return b, nil
+	}
+	b, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the script")
+	}
+	return b, nil
+}
+
+// installScript writes the script to a temporary file in the current view, and returns the command line
+// that executes the script with the interpreter, followed by args.
+// The script is executed via the interpreter, so that the temporary directory can be mounted with noexec.
+// The returned function removes the temporary file.
+func installScript(script []byte, args []string) ([]string, func(), error) {
+	if err := ValidateScriptInterpreter(script); err != nil {
+		return nil, nil, err
+	}
+	f, err := ioutil.TempFile("", "rootlesskit-script")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create a temporary file for the script")
+	}
+	remove := func() { os.Remove(f.Name()) }
+	if _, err := f.Write(script); err != nil {
+		f.Close()
+		remove()
+		return nil, nil, errors.Wrapf(err, "failed to write the script to %s", f.Name())
+	}
+	if err := f.Close(); err != nil {
+		remove()
+		return nil, nil, err
+	}
+	cmd := append(ScriptInterpreter(script), f.Name())
+	return append(cmd, args...), remove, nil
+}
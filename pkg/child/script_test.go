@@ -0,0 +1,51 @@
+package child
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestScriptInterpreter(t *testing.T) {
+	testCases := map[string][]string{
+		"#!/bin/bash\necho foo\n":     {"/bin/bash"},
+		"#! /bin/bash -e\necho foo\n": {"/bin/bash", "-e"},
+		"#!/usr/bin/env python3 -u\n": {"/usr/bin/env", "python3 -u"},
+		"#!/bin/sh":                   {"/bin/sh"},
+		"echo foo\n":                  {DefaultScriptInterpreter},
+		"#!\necho foo\n":              {DefaultScriptInterpreter},
+		"":                            {DefaultScriptInterpreter},
+	}
+	for s, expected := range testCases {
+		if got := ScriptInterpreter([]byte(s)); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q: expected %v, got %v", s, expected, got)
+		}
+	}
+}
+
+func TestInstallScript(t *testing.T) {
+	script := []byte("#!/bin/sh -e\necho foo\n")
+	cmd, remove, err := installScript(script, []string{"bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmd) != 4 || cmd[0] != "/bin/sh" || cmd[1] != "-e" || cmd[3] != "bar" {
+		t.Fatalf("unexpected command %v", cmd)
+	}
+	b, err := ioutil.ReadFile(cmd[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(script) {
+		t.Fatalf("unexpected script %q", string(b))
+	}
+	remove()
+	if _, err := os.Stat(cmd[2]); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed, got %v", cmd[2], err)
+	}
+
+	if _, _, err := installScript([]byte("#!/nonexistent/interpreter\n"), nil); err == nil {
+		t.Fatal("expected an error for a nonexistent interpreter")
+	}
+}
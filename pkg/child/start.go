@@ -0,0 +1,22 @@
+package child
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// waitStart blocks until the parent writes a byte to the start pipe fd.
+// EOF without the byte means the parent exited without starting the command.
+func waitStart(fd int) error {
+	f := os.NewFile(uintptr(fd), "start")
+	defer f.Close()
+	logrus.Debug("waiting for the parent to start the command")
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return errors.Wrap(err, "the parent exited without starting the command")
+	}
+	return nil
+}
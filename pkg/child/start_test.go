@@ -0,0 +1,40 @@
+package child
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// dupReader returns a duplicate of the FD of r, and closes r.
+func dupReader(t *testing.T, r *os.File) int {
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	return fd
+}
+
+func TestWaitStart(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := waitStart(dupReader(t, r)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := waitStart(dupReader(t, r)); err == nil {
+		t.Fatal("expected an error on EOF")
+	}
+}
@@ -0,0 +1,82 @@
+package child
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// Stdio specifies the files to be used as the stdio of the setup commands and the target command,
+// instead of the stdio of RootlessKit. Empty paths are not redirected.
+type Stdio struct {
+	Stdin  string
+	Stdout string
+	Stderr string
+	// Append appends to Stdout and Stderr instead of truncating them.
+	Append bool
+}
+
+// stdioFiles is the opened Stdio. nil fields are not redirected.
+type stdioFiles struct {
+	stdin  *os.File
+	stdout *os.File
+	stderr *os.File
+}
+
+// openStdio opens the files in stdio.
+// The files are opened once, and shared across the restarts of the target command.
+// When Stdout and Stderr are the same path, the file is opened only once, so that the outputs do not overwrite each other.
+func openStdio(stdio Stdio) (*stdioFiles, error) {
+	var (
+		f   stdioFiles
+		err error
+	)
+	if stdio.Stdin != "" {
+		if f.stdin, err = os.Open(stdio.Stdin); err != nil {
+			return nil, errors.Wrap(err, "failed to open stdin")
+		}
+	}
+	outFlags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if stdio.Append {
+		outFlags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	if stdio.Stdout != "" {
+		if f.stdout, err = os.OpenFile(stdio.Stdout, outFlags, 0644); err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "failed to open stdout")
+		}
+	}
+	if stdio.Stderr != "" {
+		if stdio.Stderr == stdio.Stdout {
+			f.stderr = f.stdout
+		} else if f.stderr, err = os.OpenFile(stdio.Stderr, outFlags, 0644); err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "failed to open stderr")
+		}
+	}
+	return &f, nil
+}
+
+// apply sets the files to cmd.
+func (f *stdioFiles) apply(cmd *exec.Cmd) {
+	if f.stdin != nil {
+		cmd.Stdin = f.stdin
+	}
+	if f.stdout != nil {
+		cmd.Stdout = f.stdout
+	}
+	if f.stderr != nil {
+		cmd.Stderr = f.stderr
+	}
+}
+
+func (f *stdioFiles) Close() error {
+	for _, x := range []*os.File{f.stdin, f.stdout, f.stderr} {
+		if x != nil {
+			// stderr may be same as stdout, and the error of closing twice is ignored
+			x.Close()
+		}
+	}
+	return nil
+}
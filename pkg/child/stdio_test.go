@@ -0,0 +1,65 @@
+package child
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestStdio(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-stdio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	in := filepath.Join(tmp, "in")
+	if err := ioutil.WriteFile(in, []byte("input\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(tmp, "out")
+	if err := ioutil.WriteFile(out, []byte("stale\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(stdio Stdio) {
+		f, err := openStdio(stdio)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		cmd := exec.Command("/bin/sh", "-c", "cat; echo err >&2")
+		f.apply(cmd)
+		if err := cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testCases := []struct {
+		stdio    Stdio
+		expected string
+	}{
+		{
+			stdio:    Stdio{Stdin: in, Stdout: out, Stderr: out},
+			expected: "input\nerr\n",
+		},
+		{
+			stdio:    Stdio{Stdin: in, Stdout: out, Stderr: out, Append: true},
+			expected: "input\nerr\ninput\nerr\n",
+		},
+	}
+	for _, tc := range testCases {
+		run(tc.stdio)
+		b, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != tc.expected {
+			t.Errorf("%+v: expected %q, got %q", tc.stdio, tc.expected, got)
+		}
+	}
+
+	if _, err := openStdio(Stdio{Stdin: filepath.Join(tmp, "nonexistent")}); err == nil {
+		t.Error("expected an error for nonexistent stdin")
+	}
+}
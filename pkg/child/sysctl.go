@@ -0,0 +1,78 @@
+package child
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// procSys is the mount point of the sysctls.
+const procSys = "/proc/sys"
+
+// Sysctl is a sysctl applied in the child network namespace.
+type Sysctl struct {
+	// Key is the name of the sysctl in the dotted notation, e.g. "net.ipv4.ip_local_port_range".
+	// The slash notation (e.g. "net/ipv4/conf/eth0.100/forwarding") is kept as it is,
+	// for the names that contain dots, as in sysctl(8).
+	Key   string
+	Value string
+}
+
+// path returns the path of the sysctl relative to /proc/sys.
+func (s Sysctl) path() string {
+	if strings.Contains(s.Key, "/") {
+		return s.Key
+	}
+	return strings.Replace(s.Key, ".", "/", -1)
+}
+
+// ParseSysctl parses "KEY=VALUE", e.g. "net.ipv4.ip_local_port_range=1024 65535".
+// Only the sysctls under "net." are accepted, as the others are not scoped to the network namespace.
+// Note that some of the sysctls under "net." (e.g. "net.core.rmem_max") are still global,
+// and fail on applying, as they are missing or read-only in the child network namespace.
+func ParseSysctl(s string) (Sysctl, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return Sysctl{}, errors.Errorf("invalid sysctl %q, expected KEY=VALUE", s)
+	}
+	sc := Sysctl{Key: s[:i], Value: s[i+1:]}
+	p := sc.path()
+	if !strings.HasPrefix(p, "net/") {
+		return Sysctl{}, errors.Errorf("sysctl %q is not scoped to the network namespace, only \"net.*\" is supported", sc.Key)
+	}
+	for _, c := range strings.Split(p, "/") {
+		if c == "" || c == "." || c == ".." {
+			return Sysctl{}, errors.Errorf("invalid sysctl key %q", sc.Key)
+		}
+	}
+	return sc, nil
+}
+
+// setSysctls writes the sysctls under root (typically procSys) one by one.
+func setSysctls(root string, sysctls []Sysctl) error {
+	for _, sc := range sysctls {
+		if err := writeSysctl(filepath.Join(root, sc.path()), sc.Value); err != nil {
+			// the global sysctls are missing (or read-only, depending on the kernel) in the non-initial network namespaces
+			if os.IsNotExist(err) || os.IsPermission(err) {
+				return errors.Wrapf(err, "sysctl %q cannot be set in the network namespace (global, or unsupported by the kernel)", sc.Key)
+			}
+			return errors.Wrapf(err, "failed to set sysctl %s=%q", sc.Key, sc.Value)
+		}
+	}
+	return nil
+}
+
+// writeSysctl writes the value to the existing file p. The error of the value is returned on Write or Close.
+func writeSysctl(p, value string) error {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write([]byte(value)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
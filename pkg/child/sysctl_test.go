@@ -0,0 +1,78 @@
+package child
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSysctl(t *testing.T) {
+	testCases := []struct {
+		s    string
+		key  string
+		path string
+		err  bool
+	}{
+		{s: "net.core.somaxconn=4096", key: "net.core.somaxconn", path: "net/core/somaxconn"},
+		{s: "net.ipv4.ip_local_port_range=1024 65535", key: "net.ipv4.ip_local_port_range", path: "net/ipv4/ip_local_port_range"},
+		{s: "net/ipv4/conf/eth0.100/forwarding=1", key: "net/ipv4/conf/eth0.100/forwarding", path: "net/ipv4/conf/eth0.100/forwarding"},
+		{s: "net.ipv4.ping_group_range=", key: "net.ipv4.ping_group_range", path: "net/ipv4/ping_group_range"},
+		{s: "net.core.somaxconn", err: true},
+		{s: "kernel.pid_max=4096", err: true},
+		{s: "vm.overcommit_memory=1", err: true},
+		{s: "network.foo=1", err: true},
+		{s: "net=1", err: true},
+		{s: "net..core=1", err: true},
+		{s: "net/../kernel/pid_max=1", err: true},
+		{s: "/net/core/somaxconn=1", err: true},
+	}
+	for _, tc := range testCases {
+		sc, err := ParseSysctl(tc.s)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", tc.s, sc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.s, err)
+			continue
+		}
+		if sc.Key != tc.key || sc.path() != tc.path {
+			t.Errorf("%q: expected key %q and path %q, got %q and %q", tc.s, tc.key, tc.path, sc.Key, sc.path())
+		}
+	}
+}
+
+func TestSetSysctls(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-set-sysctls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	p := filepath.Join(tmpDir, "net", "core", "somaxconn")
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, []byte("128\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setSysctls(tmpDir, []Sysctl{{Key: "net.core.somaxconn", Value: "4096"}}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "4096" {
+		t.Fatalf("unexpected content %q", string(b))
+	}
+	// not created
+	if err := setSysctls(tmpDir, []Sysctl{{Key: "net.core.rmem_max", Value: "1"}}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "net", "core", "rmem_max")); !os.IsNotExist(err) {
+		t.Fatalf("expected the file not to be created, got %v", err)
+	}
+}
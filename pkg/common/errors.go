@@ -0,0 +1,54 @@
+package common
+
+import (
+	"github.com/pkg/errors"
+)
+
+// The typed errors below are returned for the common failure modes, so that embedders can produce tailored
+// remediation messages. The messages are same as the wrapped errors.
+//
+// The typed errors are kept as the outermost errors (see Wrapf), as github.com/pkg/errors v0.8 does not
+// support Unwrap. They can be detected with errors.As (Go 1.13+), or with a type assertion.
+
+// SubIDNotConfiguredError is returned when the subordinate IDs of User are not configured in /etc/subuid and /etc/subgid.
+type SubIDNotConfiguredError struct {
+	User string
+	Err  error
+}
+
+func (e *SubIDNotConfiguredError) Error() string { return e.Err.Error() }
+
+func (e *SubIDNotConfiguredError) Unwrap() error { return e.Err }
+
+// HelperNotFoundError is returned when the helper binary (e.g. "newuidmap" or "slirp4netns") is not installed.
+type HelperNotFoundError struct {
+	Helper string
+	Err    error
+}
+
+func (e *HelperNotFoundError) Error() string { return e.Err.Error() }
+
+func (e *HelperNotFoundError) Unwrap() error { return e.Err }
+
+// NetNotReadyError is returned when the network driver failed to set up the network, e.g. the helper did not become ready.
+type NetNotReadyError struct {
+	Err error
+}
+
+func (e *NetNotReadyError) Error() string { return e.Err.Error() }
+
+func (e *NetNotReadyError) Unwrap() error { return e.Err }
+
+// Wrapf is like errors.Wrapf of github.com/pkg/errors, but keeps the typed errors in this package as the outermost errors.
+func Wrapf(err error, format string, args ...interface{}) error {
+	switch e := err.(type) {
+	case *SubIDNotConfiguredError:
+		return &SubIDNotConfiguredError{User: e.User, Err: errors.Wrapf(e.Err, format, args...)}
+	case *HelperNotFoundError:
+		return &HelperNotFoundError{Helper: e.Helper, Err: errors.Wrapf(e.Err, format, args...)}
+	case *NetNotReadyError:
+		return &NetNotReadyError{Err: errors.Wrapf(e.Err, format, args...)}
+	default:
+		return errors.Wrapf(err, format, args...)
+	}
+}
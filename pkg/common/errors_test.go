@@ -0,0 +1,35 @@
+package common
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestWrapf(t *testing.T) {
+	var err error = &HelperNotFoundError{Helper: "newuidmap", Err: exec.ErrNotFound}
+	err = Wrapf(err, "failed to setup %s", "UID/GID map")
+	e, ok := err.(*HelperNotFoundError)
+	if !ok {
+		t.Fatalf("expected *HelperNotFoundError, got %T", err)
+	}
+	if e.Helper != "newuidmap" {
+		t.Fatalf("unexpected helper %q", e.Helper)
+	}
+	expected := "failed to setup UID/GID map: " + exec.ErrNotFound.Error()
+	if s := err.Error(); s != expected {
+		t.Fatalf("expected %q, got %q", expected, s)
+	}
+	if cause := errors.Cause(e.Unwrap()); cause != exec.ErrNotFound {
+		t.Fatalf("unexpected cause %v", cause)
+	}
+
+	err = Wrapf(errors.New("foo"), "bar")
+	if _, ok := err.(*HelperNotFoundError); ok {
+		t.Fatal("unexpected *HelperNotFoundError")
+	}
+	if s := err.Error(); s != "bar: foo" {
+		t.Fatalf("expected \"bar: foo\", got %q", s)
+	}
+}
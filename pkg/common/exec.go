@@ -1,19 +1,39 @@
 package common
 
 import (
+	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"strings"
 	"syscall"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// ExitStatusError is an error that contains the wait status of a process
+// that is not represented as *exec.ExitError, e.g. a process reaped by the init.
+type ExitStatusError struct {
+	PID    int
+	Status syscall.WaitStatus
+}
+
+func (e *ExitStatusError) Error() string {
+	if e.Status.Signaled() {
+		return fmt.Sprintf("process %d was killed by signal %v", e.PID, e.Status.Signal())
+	}
+	return fmt.Sprintf("process %d exited with status %d", e.PID, e.Status.ExitStatus())
+}
+
 func GetExecExitStatus(err error) (int, bool) {
 	err = errors.Cause(err)
 	if err == nil {
 		return 0, false
 	}
+	if statusErr, ok := err.(*ExitStatusError); ok {
+		return statusErr.Status.ExitStatus(), true
+	}
 	exitErr, ok := err.(*exec.ExitError)
 	if !ok {
 		return 0, false
@@ -46,3 +66,37 @@ func Execs(o io.Writer, env []string, cmds [][]string) error {
 	}
 	return nil
 }
+
+// LogHelperCmd logs the argv and the environment of the helper command such as slirp4netns at debug level.
+// The values of the secret-looking environment variables are redacted with RedactEnv.
+func LogHelperCmd(cmd *exec.Cmd) {
+	if !logrus.IsLevelEnabled(logrus.DebugLevel) {
+		return
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	logrus.Debugf("executing helper %s: argv=%q, env=%q", cmd.Path, cmd.Args, RedactEnv(env))
+}
+
+// secretEnvKeywords are the substrings of the names of the environment variables that are redacted by RedactEnv.
+var secretEnvKeywords = []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "CREDENTIAL", "API_KEY", "PRIVATE_KEY"}
+
+// RedactEnv returns a copy of env with the values of the secret-looking variables, e.g. ROOTLESSKIT_API_TOKEN, replaced with "********".
+func RedactEnv(env []string) []string {
+	res := make([]string, 0, len(env))
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i >= 0 {
+			k := strings.ToUpper(kv[:i])
+			for _, w := range secretEnvKeywords {
+				if strings.Contains(k, w) {
+					kv = kv[:i] + "=********"
+					break
+				}
+			}
+		}
+		res = append(res, kv)
+	}
+	return res
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"os/exec"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRedactEnv(t *testing.T) {
+	env := []string{
+		"PATH=/usr/bin:/bin",
+		"ROOTLESSKIT_API_TOKEN=foo",
+		"aws_secret_access_key=bar",
+		"DB_PASSWORD=baz",
+		"GITHUB_API_KEY=qux",
+		"HOME=/home/user",
+		"NOVALUE",
+	}
+	expected := []string{
+		"PATH=/usr/bin:/bin",
+		"ROOTLESSKIT_API_TOKEN=********",
+		"aws_secret_access_key=********",
+		"DB_PASSWORD=********",
+		"GITHUB_API_KEY=********",
+		"HOME=/home/user",
+		"NOVALUE",
+	}
+	if got := RedactEnv(env); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestGetExecExitStatus(t *testing.T) {
+	err := exec.Command("/bin/sh", "-c", "exit 3").Run()
+	if code, ok := GetExecExitStatus(errors.Wrap(err, "wrapped")); !ok || code != 3 {
+		t.Errorf("expected 3, got %d (%v)", code, err)
+	}
+	status := syscall.WaitStatus(4 << 8)
+	if code, ok := GetExecExitStatus(&ExitStatusError{PID: 42, Status: status}); !ok || code != 4 {
+		t.Errorf("expected 4, got %d", code)
+	}
+}
@@ -18,6 +18,15 @@ type Message1 struct {
 	StateDir string
 	Network  NetworkMessage
 	Port     PortMessage
+	// HostLoopback is empty unless --allow-host-loopback is specified
+	HostLoopback []HostLoopbackMessage
+	// TTYFD is the FD of the pty slave in the child. 0 unless --tty is specified.
+	TTYFD int
+	// PreservedFDs are the FDs in the child that are passed to the target command as FD 3, 4, ...
+	PreservedFDs []int
+	// StartFD is the FD of the pipe in the child, from which a byte is read before executing the command.
+	// 0 unless --wait-start is specified.
+	StartFD int
 }
 
 // NetworkMessage is empty for HostNetwork.
@@ -28,6 +37,11 @@ type NetworkMessage struct {
 	Gateway string
 	DNS     string
 	MTU     int
+	// IPv6 stuff is set only when IPv6 is enabled.
+	// IP is empty when IPv6 is enabled in IPv6-only mode.
+	IPv6        string
+	IPv6Netmask int
+	IPv6Gateway string
 	// Opaque strings are specific to driver
 	Opaque map[string]string
 }
@@ -35,3 +49,10 @@ type NetworkMessage struct {
 type PortMessage struct {
 	Opaque map[string]string
 }
+
+// HostLoopbackMessage is an allowed endpoint on the host loopback.
+// The child listens on Addr and relays the connections to the parent via SocketPath.
+type HostLoopbackMessage struct {
+	Addr       string
+	SocketPath string
+}
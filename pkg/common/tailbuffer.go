@@ -0,0 +1,34 @@
+package common
+
+import (
+	"sync"
+)
+
+// TailBuffer is an io.Writer that retains the last max bytes.
+// TailBuffer is used for capturing the stderr of the helper processes for diagnosing startup failures.
+type TailBuffer struct {
+	mu  sync.Mutex
+	max int
+	b   []byte
+}
+
+// NewTailBuffer creates TailBuffer that retains the last max bytes.
+func NewTailBuffer(max int) *TailBuffer {
+	return &TailBuffer{max: max}
+}
+
+func (t *TailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.b = append(t.b, p...)
+	if len(t.b) > t.max {
+		t.b = t.b[len(t.b)-t.max:]
+	}
+	return len(p), nil
+}
+
+func (t *TailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.b)
+}
@@ -0,0 +1,14 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestTailBuffer(t *testing.T) {
+	b := NewTailBuffer(4)
+	b.Write([]byte("foo"))
+	b.Write([]byte("bar"))
+	if s := b.String(); s != "obar" {
+		t.Fatalf("expected \"obar\", got %q", s)
+	}
+}
@@ -1,5 +1,15 @@
 package copyup
 
+import (
+	"context"
+)
+
 type ChildDriver interface {
 	CopyUp([]string) ([]string, error)
 }
+
+// Manager copies up additional directories in a running instance.
+type Manager interface {
+	// CopyUp returns the directories that have been copied up.
+	CopyUp(ctx context.Context, dirs []string) ([]string, error)
+}
@@ -0,0 +1,90 @@
+package copyup
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Options is passed to Factory.
+type Options struct {
+	// Excludes are absolute path globs of the entries that are not copied up, e.g. "/etc/ssl/certs".
+	Excludes []string
+	// RealFiles are absolute path globs of the files that are copied as real files rather than symlinks,
+	// e.g. "/etc/resolv.conf".
+	RealFiles []string
+	// Strict requires every entry (except excluded ones) to be reachable in the copied-up directory.
+	Strict bool
+	// PersistDir is the absolute path of the directory on the host that stores the copied-up directories across restarts,
+	// instead of an ephemeral tmpfs. Empty for tmpfs.
+	PersistDir string
+}
+
+// DefaultRealFiles are the files that are always copied as real files, as they are rewritten by RootlessKit.
+// Programs that open them with O_NOFOLLOW (e.g. musl-based tools) fail on symlinks.
+var DefaultRealFiles = []string{"/etc/resolv.conf", "/etc/hosts"}
+
+// ValidatePatterns validates the absolute path globs used for Options.
+// kind is used in the error message, e.g. "exclude".
+func ValidatePatterns(kind string, patterns []string) error {
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			return errors.Errorf("copy-up %s pattern must be absolute, got %q", kind, pattern)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid copy-up %s pattern %q", kind, pattern)
+		}
+	}
+	return nil
+}
+
+// Factory instantiates a ChildDriver for a copy-up mode.
+type Factory func(opts Options) (ChildDriver, error)
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]Factory)
+)
+
+// Register registers the factory of the copy-up mode such as "tmpfs+symlink".
+// Register is typically called from the init function of the package that implements the mode.
+// Register panics if the mode is already registered.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if name == "" {
+		panic("copyup: empty mode name")
+	}
+	if factory == nil {
+		panic("copyup: nil factory for mode " + name)
+	}
+	if _, ok := registry[name]; ok {
+		panic("copyup: mode " + name + " is already registered")
+	}
+	registry[name] = factory
+}
+
+// New instantiates the ChildDriver of the registered copy-up mode.
+func New(name string, opts Options) (ChildDriver, error) {
+	registryMu.Lock()
+	factory, ok := registry[name]
+	registryMu.Unlock()
+	if !ok {
+		return nil, errors.Errorf("unknown copy-up mode: %s", name)
+	}
+	return factory(opts)
+}
+
+// Modes returns the sorted names of the registered copy-up modes.
+func Modes() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,63 @@
+package copyup
+
+import (
+	"reflect"
+	"testing"
+)
+
+type fakeChildDriver struct {
+	opts Options
+}
+
+func (d *fakeChildDriver) CopyUp(dirs []string) ([]string, error) {
+	return dirs, nil
+}
+
+func TestRegistry(t *testing.T) {
+	Register("test-fake", func(opts Options) (ChildDriver, error) {
+		return &fakeChildDriver{opts: opts}, nil
+	})
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "test-fake")
+		registryMu.Unlock()
+	}()
+	found := false
+	for _, name := range Modes() {
+		if name == "test-fake" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("test-fake is not listed in %v", Modes())
+	}
+	opts := Options{Excludes: []string{"/etc/ssl/certs"}, Strict: true}
+	d, err := New("test-fake", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.(*fakeChildDriver).opts; !reflect.DeepEqual(got, opts) {
+		t.Fatalf("expected %+v, got %+v", opts, got)
+	}
+	if _, err := New("test-unknown", opts); err == nil {
+		t.Fatal("expected error for unknown mode")
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	factory := func(opts Options) (ChildDriver, error) {
+		return &fakeChildDriver{opts: opts}, nil
+	}
+	Register("test-dup", factory)
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "test-dup")
+		registryMu.Unlock()
+	}()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for duplicate mode")
+		}
+	}()
+	Register("test-dup", factory)
+}
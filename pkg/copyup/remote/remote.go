@@ -0,0 +1,149 @@
+// Package remote provides copyup.Manager that requests the child to copy up directories at runtime.
+//
+// The child serves the requests on a UNIX socket in the state directory,
+// because the parent is not in the mount namespace of the child.
+package remote
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rootless-containers/rootlesskit/pkg/copyup"
+	"github.com/rootless-containers/rootlesskit/pkg/msgutil"
+)
+
+// SocketPath returns the path of the socket in the state directory.
+func SocketPath(stateDir string) string {
+	return filepath.Join(stateDir, ".copyup.sock")
+}
+
+type request struct {
+	Dirs []string
+}
+
+type reply struct {
+	Copied []string
+	Error  string
+}
+
+// NewManager instantiates copyup.Manager that connects to the child via socketPath.
+func NewManager(socketPath string) copyup.Manager {
+	return &manager{
+		socketPath: socketPath,
+	}
+}
+
+type manager struct {
+	socketPath string
+}
+
+func (m *manager) CopyUp(ctx context.Context, dirs []string) ([]string, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "unix", m.socketPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "copy-up is not available for this instance")
+	}
+	defer c.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+	if _, err := msgutil.MarshalToWriter(c, &request{Dirs: dirs}); err != nil {
+		return nil, err
+	}
+	var rep reply
+	if _, err := msgutil.UnmarshalFromReader(c, &rep); err != nil {
+		return nil, err
+	}
+	if rep.Error != "" {
+		return rep.Copied, errors.New(rep.Error)
+	}
+	return rep.Copied, nil
+}
+
+// Serve serves the copy-up requests on socketPath.
+// copied is the list of the directories that have been already copied up.
+// Serve must be called in the mount namespace of the child.
+func Serve(socketPath string, driver copyup.ChildDriver, copied []string) (io.Closer, error) {
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	s := &server{
+		driver: driver,
+	}
+	for _, d := range copied {
+		s.copied = append(s.copied, filepath.Clean(d))
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				// the listener is closed
+				return
+			}
+			go s.handle(c)
+		}
+	}()
+	return ln, nil
+}
+
+type server struct {
+	driver copyup.ChildDriver
+	mu     sync.Mutex
+	copied []string
+}
+
+func (s *server) handle(c net.Conn) {
+	defer c.Close()
+	var req request
+	if _, err := msgutil.UnmarshalFromReader(c, &req); err != nil {
+		logrus.WithError(err).Warn("failed to read copy-up request")
+		return
+	}
+	var rep reply
+	copied, err := s.copyUp(req.Dirs)
+	rep.Copied = copied
+	if err != nil {
+		rep.Error = err.Error()
+	}
+	if _, err := msgutil.MarshalToWriter(c, &rep); err != nil {
+		logrus.WithError(err).Warn("failed to write copy-up reply")
+	}
+}
+
+func (s *server) copyUp(dirs []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var cleaned []string
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			return nil, errors.Errorf("copy-up directory must be absolute, got %q", dir)
+		}
+		dir = filepath.Clean(dir)
+		if dir == "/etc" {
+			// /etc/resolv.conf and /etc/hosts may have been already mounted
+			return nil, errors.New("/etc cannot be copied up at runtime")
+		}
+		for _, c := range append(s.copied, cleaned...) {
+			if overlaps(dir, c) {
+				return nil, errors.Errorf("%s overlaps with the copied-up directory %s", dir, c)
+			}
+		}
+		cleaned = append(cleaned, dir)
+	}
+	copied, err := s.driver.CopyUp(cleaned)
+	s.copied = append(s.copied, copied...)
+	return copied, err
+}
+
+// overlaps returns true if a and b are the same directory, or one is a descendant of the other.
+func overlaps(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/") || a == "/" || b == "/"
+}
@@ -0,0 +1,43 @@
+package remote
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+type fakeDriver struct{}
+
+func (d *fakeDriver) CopyUp(dirs []string) ([]string, error) {
+	return dirs, nil
+}
+
+func TestCopyUp(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "test-copyup-remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	closer, err := Serve(SocketPath(stateDir), &fakeDriver{}, []string{"/run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+	m := NewManager(SocketPath(stateDir))
+	ctx := context.Background()
+
+	copied, err := m.CopyUp(ctx, []string{"/var/lib/foo/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"/var/lib/foo"}; !reflect.DeepEqual(expected, copied) {
+		t.Fatalf("expected %v, got %v", expected, copied)
+	}
+	for _, dir := range []string{"/run", "/run/foo", "/var/lib/foo", "/var", "/etc", "relative"} {
+		if _, err := m.CopyUp(ctx, []string{dir}); err == nil {
+			t.Errorf("expected an error for %q", dir)
+		}
+	}
+}
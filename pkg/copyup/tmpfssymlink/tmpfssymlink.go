@@ -2,24 +2,124 @@ package tmpfssymlink
 
 import (
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"golang.org/x/sys/unix"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/theckman/go-flock"
 
 	"github.com/rootless-containers/rootlesskit/pkg/copyup"
 )
 
-func NewChildDriver() copyup.ChildDriver {
-	return &childDriver{}
+// Mode is the name of the copy-up mode registered to copyup.Register.
+const Mode = "tmpfs+symlink"
+
+func init() {
+	copyup.Register(Mode, func(opts copyup.Options) (copyup.ChildDriver, error) {
+		if opts.PersistDir != "" && opts.Strict {
+			return nil, errors.New("strict mode is not supported with a persist directory, as the persisted entries may be modified")
+		}
+		return NewChildDriver(opts.Excludes, opts.RealFiles, opts.Strict, opts.PersistDir), nil
+	})
+}
+
+// persistRoDir is the name of the mount point of the original directory in a persisted directory.
+// Unlike the random name used on tmpfs, the name is fixed so that the persisted symlinks remain valid across restarts.
+const persistRoDir = ".ro"
+
+// PersistLockFile is the name of the lock file in the persist directory.
+const PersistLockFile = "lock"
+
+// NewChildDriver instantiates new child driver.
+// excludes are absolute path globs of the entries that are not copied up, e.g. "/etc/ssl/certs".
+// realFiles are absolute path globs of the files that are copied as real files rather than symlinks,
+// e.g. "/etc/resolv.conf", so that they can be opened with O_NOFOLLOW.
+//
+// When strict is true, CopyUp verifies that every entry (except excluded ones) is reachable
+// in the copied-up directory, and fails otherwise.
+//
+// When persistDir is not empty, the copied-up directories are stored under persistDir instead of tmpfs,
+// and reused on the next run. The entries that exist in the persisted directories are kept as they are.
+func NewChildDriver(excludes, realFiles []string, strict bool, persistDir string) copyup.ChildDriver {
+	return &childDriver{
+		excludes:   excludes,
+		realFiles:  realFiles,
+		strict:     strict,
+		persistDir: persistDir,
+	}
 }
 
 type childDriver struct {
+	excludes   []string
+	realFiles  []string
+	strict     bool
+	persistDir string
+	// persistLock is held until the process exits
+	persistLock *flock.Flock
+}
+
+// lockPersistDir locks d.persistDir so as to prevent other instances from using it.
+// The lock is released by the kernel when the process exits, so a lock file left by a crashed instance does not block.
+func (d *childDriver) lockPersistDir() error {
+	if d.persistLock != nil {
+		return nil
+	}
+	lockPath := filepath.Join(d.persistDir, PersistLockFile)
+	lock := flock.NewFlock(lockPath)
+	locked, err := lock.TryLock()
+	if err != nil {
+		return errors.Wrapf(err, "failed to lock %s", lockPath)
+	}
+	if !locked {
+		return errors.Errorf("failed to lock %s, another RootlessKit is running with the same copy-up persist directory?", lockPath)
+	}
+	d.persistLock = lock
+	return nil
+}
+
+// persistPath returns the path under d.persistDir for storing dir, e.g. "etc" for "/etc", and "var%2Flib%2Ffoo" for "/var/lib/foo".
+func (d *childDriver) persistPath(dir string) (string, error) {
+	rel := strings.TrimPrefix(filepath.Clean(dir), "/")
+	if rel == "" {
+		return "", errors.New("/ cannot be copied up with a persist directory")
+	}
+	if d.persistDir == dir || strings.HasPrefix(d.persistDir, dir+"/") {
+		return "", errors.Errorf("%s cannot be copied up, as it contains the persist directory %s", dir, d.persistDir)
+	}
+	return filepath.Join(d.persistDir, url.PathEscape(rel)), nil
+}
+
+// mountPersistDir bind-mounts the persisted directory for dir on dir, creating it with the permission of dir on the first run.
+func (d *childDriver) mountPersistDir(dir string) error {
+	upper, err := d.persistPath(dir)
+	if err != nil {
+		return err
+	}
+	st, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(upper, st.Mode().Perm()); err != nil && !os.IsExist(err) {
+		return errors.Wrapf(err, "failed to create %s", upper)
+	}
+	if err := unix.Mount(upper, dir, "", uintptr(unix.MS_BIND), ""); err != nil {
+		return errors.Wrapf(err, "failed to bind-mount %s on %s", upper, dir)
+	}
+	return nil
 }
 
 func (d *childDriver) CopyUp(dirs []string) ([]string, error) {
+	if err := copyup.ValidatePatterns("exclude", d.excludes); err != nil {
+		return nil, err
+	}
+	if err := copyup.ValidatePatterns("real-file", d.realFiles); err != nil {
+		return nil, err
+	}
 	// we create bind0 outside of StateDir so as to allow
 	// copying up /run with stateDir=/run/user/1001/rootlesskit/default.
 	bind0, err := ioutil.TempDir("/tmp", "rootlesskit-b")
@@ -27,56 +127,225 @@ func (d *childDriver) CopyUp(dirs []string) ([]string, error) {
 		return nil, errors.Wrap(err, "creating bind0 directory under /tmp")
 	}
 	defer os.RemoveAll(bind0)
+	if d.persistDir != "" {
+		if err := d.lockPersistDir(); err != nil {
+			return nil, err
+		}
+	}
 	var copied []string
-	for _, d := range dirs {
-		d := filepath.Clean(d)
-		if d == "/tmp" {
+	for _, dir := range dirs {
+		dir := filepath.Clean(dir)
+		if dir == "/tmp" {
 			// TODO: we can support copy-up /tmp by changing bind0TempDir
 			return copied, errors.New("/tmp cannot be copied up")
 		}
-
-		if err := unix.Mount(d, bind0, "", uintptr(unix.MS_BIND|unix.MS_REC), ""); err != nil {
-			return copied, errors.Wrapf(err, "failed to create bind mount on %s", d)
+		if d.persistDir != "" {
+			// fail before mounting anything on dir
+			if _, err := d.persistPath(dir); err != nil {
+				return copied, err
+			}
 		}
 
-		if err := unix.Mount("none", d, "tmpfs", 0, ""); err != nil {
-			return copied, errors.Wrapf(err, "failed to mount tmpfs on %s", d)
+		if err := unix.Mount(dir, bind0, "", uintptr(unix.MS_BIND|unix.MS_REC), ""); err != nil {
+			return copied, errors.Wrapf(err, "failed to create bind mount on %s", dir)
 		}
 
-		bind1, err := ioutil.TempDir(d, ".ro")
-		if err != nil {
-			return copied, errors.Wrapf(err, "creating a directory under %s", d)
+		var bind1 string
+		if d.persistDir == "" {
+			if err := unix.Mount("none", dir, "tmpfs", 0, ""); err != nil {
+				return copied, errors.Wrapf(err, "failed to mount tmpfs on %s", dir)
+			}
+			bind1, err = ioutil.TempDir(dir, ".ro")
+			if err != nil {
+				return copied, errors.Wrapf(err, "creating a directory under %s", dir)
+			}
+		} else {
+			if err := d.mountPersistDir(dir); err != nil {
+				return copied, err
+			}
+			bind1 = filepath.Join(dir, persistRoDir)
+			if err := os.Mkdir(bind1, 0755); err != nil && !os.IsExist(err) {
+				return copied, errors.Wrapf(err, "creating a directory under %s", dir)
+			}
 		}
 		if err := unix.Mount(bind0, bind1, "", uintptr(unix.MS_MOVE), ""); err != nil {
 			return copied, errors.Wrapf(err, "failed to move mount point from %s to %s", bind0, bind1)
 		}
 
-		files, err := ioutil.ReadDir(bind1)
-		if err != nil {
-			return copied, errors.Wrapf(err, "reading dir %s", bind1)
+		if err := d.symlinkEntries(bind1, dir, filepath.Base(bind1)); err != nil {
+			return copied, err
+		}
+		if d.strict {
+			if err := d.verifyEntries(bind1, dir); err != nil {
+				return copied, err
+			}
 		}
-		for _, f := range files {
-			fFull := filepath.Join(bind1, f.Name())
-			var symlinkSrc string
-			if f.Mode()&os.ModeSymlink != 0 {
-				symlinkSrc, err = os.Readlink(fFull)
-				if err != nil {
-					return copied, errors.Wrapf(err, "reading dir %s", fFull)
+		copied = append(copied, dir)
+	}
+	return copied, nil
+}
+
+// symlinkEntries creates symlinks in dst for the entries in ro.
+// relRo is the relative path from dst to ro.
+//
+// Entries that match d.excludes are skipped.
+// Files that match d.realFiles are copied rather than symlinked.
+// Directories that contain excluded entries or real files are created as real directories,
+// and the entries in them are symlinked recursively.
+func (d *childDriver) symlinkEntries(ro, dst, relRo string) error {
+	files, err := ioutil.ReadDir(ro)
+	if err != nil {
+		return errors.Wrapf(err, "reading dir %s", ro)
+	}
+	for _, f := range files {
+		fFull := filepath.Join(ro, f.Name())
+		symlinkDst := filepath.Join(dst, f.Name())
+		if d.excluded(symlinkDst) {
+			continue
+		}
+		if d.persistDir != "" {
+			if dstSt, err := os.Lstat(symlinkDst); err == nil {
+				// persisted by the previous run, possibly modified by the user.
+				// real directories are traversed for the entries added on the host since the previous run.
+				if f.IsDir() && dstSt.IsDir() && d.realDirRequired(symlinkDst) {
+					if err := d.symlinkEntries(fFull, symlinkDst, filepath.Join("..", relRo, f.Name())); err != nil {
+						return err
+					}
 				}
-			} else {
-				symlinkSrc = filepath.Join(filepath.Base(bind1), f.Name())
+				continue
 			}
-			symlinkDst := filepath.Join(d, f.Name())
-			// `mount` may create extra `/etc/mtab` after mounting empty tmpfs on /etc
-			// https://github.com/rootless-containers/rootlesskit/issues/45
-			if err = os.RemoveAll(symlinkDst); err != nil {
-				return copied, errors.Wrapf(err, "removing %s", symlinkDst)
+		}
+		// `mount` may create extra `/etc/mtab` after mounting empty tmpfs on /etc
+		// https://github.com/rootless-containers/rootlesskit/issues/45
+		if err = os.RemoveAll(symlinkDst); err != nil {
+			return errors.Wrapf(err, "removing %s", symlinkDst)
+		}
+		if f.IsDir() && d.realDirRequired(symlinkDst) {
+			if err := os.Mkdir(symlinkDst, f.Mode().Perm()); err != nil {
+				return errors.Wrapf(err, "creating dir %s", symlinkDst)
 			}
-			if err := os.Symlink(symlinkSrc, symlinkDst); err != nil {
-				return copied, errors.Wrapf(err, "symlinking %s to %s", symlinkSrc, symlinkDst)
+			if err := d.symlinkEntries(fFull, symlinkDst, filepath.Join("..", relRo, f.Name())); err != nil {
+				return err
 			}
+			continue
+		}
+		if !f.IsDir() && matchAny(d.realFiles, symlinkDst) {
+			copied, err := copyRealFile(fFull, symlinkDst)
+			if err != nil {
+				return err
+			}
+			if copied {
+				continue
+			}
+			logrus.Warnf("copy-up: %s is not a regular file, falling back to a symlink", symlinkDst)
+		}
+		var symlinkSrc string
+		if f.Mode()&os.ModeSymlink != 0 {
+			symlinkSrc, err = os.Readlink(fFull)
+			if err != nil {
+				return errors.Wrapf(err, "reading dir %s", fFull)
+			}
+		} else {
+			symlinkSrc = filepath.Join(relRo, f.Name())
+		}
+		if err := os.Symlink(symlinkSrc, symlinkDst); err != nil {
+			return errors.Wrapf(err, "symlinking %s to %s", symlinkSrc, symlinkDst)
 		}
-		copied = append(copied, d)
 	}
-	return copied, nil
+	return nil
+}
+
+// verifyEntries verifies that the entries in ro are reachable via dst as the same files.
+// Dangling symlinks in ro only need to exist in dst.
+func (d *childDriver) verifyEntries(ro, dst string) error {
+	files, err := ioutil.ReadDir(ro)
+	if err != nil {
+		return errors.Wrapf(err, "reading dir %s", ro)
+	}
+	for _, f := range files {
+		fFull := filepath.Join(ro, f.Name())
+		dstFull := filepath.Join(dst, f.Name())
+		if d.excluded(dstFull) {
+			continue
+		}
+		if f.IsDir() && d.realDirRequired(dstFull) {
+			if err := d.verifyEntries(fFull, dstFull); err != nil {
+				return err
+			}
+			continue
+		}
+		var verifyErr error
+		if dstSt, err := os.Lstat(dstFull); err == nil && dstSt.Mode().IsRegular() && matchAny(d.realFiles, dstFull) {
+			// copied as a real file
+			continue
+		} else if roSt, err := os.Stat(fFull); err == nil {
+			dstSt, err := os.Stat(dstFull)
+			if err != nil {
+				verifyErr = err
+			} else if !os.SameFile(roSt, dstSt) {
+				verifyErr = errors.Errorf("%s does not point to the original file", dstFull)
+			}
+		} else if _, err := os.Lstat(dstFull); err != nil {
+			verifyErr = err
+		}
+		if verifyErr != nil {
+			logrus.WithError(verifyErr).Errorf("copy-up: failed to represent %s", dstFull)
+			return errors.Wrapf(verifyErr, "copy-up: failed to represent %s (strict mode)", dstFull)
+		}
+	}
+	return nil
+}
+
+// excluded returns true if p matches any of d.excludes.
+func (d *childDriver) excluded(p string) bool {
+	return matchAny(d.excludes, p)
+}
+
+// realDirRequired returns true if any of d.excludes or d.realFiles may match a descendant of dir.
+func (d *childDriver) realDirRequired(dir string) bool {
+	return matchDescendant(d.excludes, dir) || matchDescendant(d.realFiles, dir)
+}
+
+// matchAny returns true if p matches any of patterns.
+func matchAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDescendant returns true if any of patterns may match a descendant of dir.
+func matchDescendant(patterns []string, dir string) bool {
+	dirComponents := strings.Split(dir, "/")
+	for _, pattern := range patterns {
+		patternComponents := strings.Split(pattern, "/")
+		if len(patternComponents) <= len(dirComponents) {
+			continue
+		}
+		prefix := strings.Join(patternComponents[:len(dirComponents)], "/")
+		if ok, _ := filepath.Match(prefix, dir); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// copyRealFile copies the content of src to dst as a real file.
+// Symlinks in src are followed.
+// copyRealFile returns false without an error when src is not a regular file, e.g. a dangling symlink.
+func copyRealFile(src, dst string) (bool, error) {
+	st, err := os.Stat(src)
+	if err != nil || !st.Mode().IsRegular() {
+		return false, nil
+	}
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return false, errors.Wrapf(err, "reading %s", src)
+	}
+	if err := ioutil.WriteFile(dst, b, st.Mode().Perm()); err != nil {
+		return false, errors.Wrapf(err, "writing %s", dst)
+	}
+	return true, nil
 }
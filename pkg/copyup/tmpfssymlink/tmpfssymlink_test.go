@@ -0,0 +1,300 @@
+package tmpfssymlink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyEntries(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-tmpfssymlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dst := filepath.Join(tmp, "dst")
+	ro := filepath.Join(dst, ".ro")
+	if err := os.MkdirAll(filepath.Join(ro, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"foo", "dir/bar"} {
+		if err := ioutil.WriteFile(filepath.Join(ro, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/nonexistent", filepath.Join(ro, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	d := &childDriver{strict: true}
+	if err := d.symlinkEntries(ro, dst, ".ro"); err != nil {
+		t.Fatal(err)
+	}
+	// ".ro" itself is listed in dst, but not in ro
+	if err := d.verifyEntries(ro, dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dst, "foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.verifyEntries(ro, dst); err == nil {
+		t.Fatal("expected an error for the missing entry")
+	}
+}
+
+func TestSymlinkEntriesRealFiles(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-tmpfssymlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dst := filepath.Join(tmp, "dst")
+	ro := filepath.Join(dst, ".ro")
+	if err := os.MkdirAll(filepath.Join(ro, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"foo", "bar", "dir/baz"} {
+		if err := ioutil.WriteFile(filepath.Join(ro, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("foo", filepath.Join(ro, "link")); err != nil {
+		t.Fatal(err)
+	}
+	d := &childDriver{
+		realFiles: []string{filepath.Join(dst, "foo"), filepath.Join(dst, "dir/baz"), filepath.Join(dst, "link")},
+		strict:    true,
+	}
+	if err := d.symlinkEntries(ro, dst, ".ro"); err != nil {
+		t.Fatal(err)
+	}
+	testCases := map[string]bool{
+		"foo":     true,
+		"bar":     false,
+		"dir":     true,
+		"dir/baz": true,
+		"link":    true,
+	}
+	for f, real := range testCases {
+		st, err := os.Lstat(filepath.Join(dst, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isSymlink := st.Mode()&os.ModeSymlink != 0; isSymlink == real {
+			t.Errorf("%s: expected real=%v, got mode %v", f, real, st.Mode())
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dst, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "foo" {
+		t.Errorf("expected the content of the symlink target, got %q", string(b))
+	}
+	if err := d.verifyEntries(ro, dst); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSymlinkEntriesExcludes(t *testing.T) {
+	const (
+		missing = "missing"
+		symlink = "symlink"
+		realDir = "realDir"
+	)
+	testCases := []struct {
+		name     string
+		excludes []string // relative to dst
+		expected map[string]string
+	}{
+		{
+			name:     "exact path",
+			excludes: []string{"foo"},
+			expected: map[string]string{"foo": missing, "bar.conf": symlink, "dir": symlink, "real": symlink},
+		},
+		{
+			name:     "glob in the last element",
+			excludes: []string{"*.conf"},
+			expected: map[string]string{"foo": symlink, "bar.conf": missing, "baz.conf": missing, "dir": symlink},
+		},
+		{
+			name:     "glob on a parent directory",
+			excludes: []string{"*/a"},
+			expected: map[string]string{
+				"foo": symlink, "dir": realDir, "dir/a": missing, "dir/b": symlink,
+				// may contain "a", so created as a real directory too
+				"real": realDir, "real/sub": symlink,
+			},
+		},
+		{
+			name:     "excluded entry in a real subdirectory",
+			excludes: []string{"real/sub/secret"},
+			expected: map[string]string{
+				"foo": symlink, "dir": symlink, "real": realDir, "real/sub": realDir,
+				"real/sub/secret": missing, "real/sub/other": symlink,
+			},
+		},
+		{
+			name:     "pattern that matches nothing",
+			excludes: []string{"nonexistent*", "nonexistent/x"},
+			expected: map[string]string{"foo": symlink, "bar.conf": symlink, "dir": symlink, "real": symlink},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "test-tmpfssymlink")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+			dst := filepath.Join(tmp, "dst")
+			ro := filepath.Join(dst, ".ro")
+			for _, dir := range []string{"dir", "real/sub"} {
+				if err := os.MkdirAll(filepath.Join(ro, dir), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, f := range []string{"foo", "bar.conf", "baz.conf", "dir/a", "dir/b", "real/sub/secret", "real/sub/other"} {
+				if err := ioutil.WriteFile(filepath.Join(ro, f), []byte(f), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d := &childDriver{strict: true}
+			for _, e := range tc.excludes {
+				d.excludes = append(d.excludes, filepath.Join(dst, e))
+			}
+			if err := d.symlinkEntries(ro, dst, ".ro"); err != nil {
+				t.Fatal(err)
+			}
+			for f, expected := range tc.expected {
+				got := missing
+				if st, err := os.Lstat(filepath.Join(dst, f)); err == nil {
+					switch {
+					case st.Mode()&os.ModeSymlink != 0:
+						got = symlink
+					case st.IsDir():
+						got = realDir
+					default:
+						got = st.Mode().String()
+					}
+				} else if !os.IsNotExist(err) {
+					t.Fatal(err)
+				}
+				if got != expected {
+					t.Errorf("%s: expected %s, got %s", f, expected, got)
+				}
+			}
+			// the excluded entries are not verified
+			if err := d.verifyEntries(ro, dst); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSymlinkEntriesPersist(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-tmpfssymlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dst := filepath.Join(tmp, "dst")
+	ro := filepath.Join(dst, ".ro")
+	if err := os.MkdirAll(filepath.Join(ro, "ssl", "certs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"foo", "bar", "ssl/openssl.cnf"} {
+		if err := ioutil.WriteFile(filepath.Join(ro, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := &childDriver{excludes: []string{filepath.Join(dst, "ssl", "certs")}, persistDir: tmp}
+	if err := d.symlinkEntries(ro, dst, ".ro"); err != nil {
+		t.Fatal(err)
+	}
+	// modified by the user
+	if err := os.Remove(filepath.Join(dst, "foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dst, "foo"), []byte("modified"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// added on the host
+	for _, f := range []string{"baz", "ssl/ct_log_list.cnf"} {
+		if err := ioutil.WriteFile(filepath.Join(ro, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the next run
+	if err := d.symlinkEntries(ro, dst, ".ro"); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"foo":                 "modified",
+		"bar":                 "bar",
+		"baz":                 "baz",
+		"ssl/openssl.cnf":     "ssl/openssl.cnf",
+		"ssl/ct_log_list.cnf": "ssl/ct_log_list.cnf",
+	}
+	for f, s := range expected {
+		b, err := ioutil.ReadFile(filepath.Join(dst, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != s {
+			t.Errorf("%s: expected %q, got %q", f, s, string(b))
+		}
+	}
+}
+
+func TestPersistPath(t *testing.T) {
+	d := &childDriver{persistDir: "/home/foo/persist"}
+	testCases := map[string]string{
+		"/etc":         "/home/foo/persist/etc",
+		"/var/lib/foo": "/home/foo/persist/var%2Flib%2Ffoo",
+		"/":            "",
+		"/home":        "",
+		"/home/foo":    "",
+	}
+	for dir, expected := range testCases {
+		got, err := d.persistPath(dir)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", dir, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", dir, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("%s: expected %q, got %q", dir, expected, got)
+		}
+	}
+}
+
+func TestLockPersistDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-tmpfssymlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// a lock file left by a crashed instance
+	if err := ioutil.WriteFile(filepath.Join(tmp, PersistLockFile), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	d1 := &childDriver{persistDir: tmp}
+	if err := d1.lockPersistDir(); err != nil {
+		t.Fatal(err)
+	}
+	defer d1.persistLock.Unlock()
+	// locking twice is a no-op
+	if err := d1.lockPersistDir(); err != nil {
+		t.Fatal(err)
+	}
+	d2 := &childDriver{persistDir: tmp}
+	if err := d2.lockPersistDir(); err == nil {
+		t.Fatal("expected an error for the directory locked by another instance")
+	}
+}
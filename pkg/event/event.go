@@ -0,0 +1,139 @@
+// Package event provides the event bus that the parent and the drivers publish the events to,
+// and that the API streams to the subscribers (`GET /v1/events`).
+package event
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rootless-containers/rootlesskit/pkg/api"
+)
+
+// DefaultBacklog is the default number of the recent events retained for SubscribeSince.
+const DefaultBacklog = 128
+
+// subscriberBuffer is the number of the events buffered for each subscriber, in addition to the replayed events.
+// A subscriber that does not receive the events in time is dropped, i.e. its channel is closed.
+const subscriberBuffer = 64
+
+// Bus is the event bus. Bus is thread-safe.
+type Bus struct {
+	mu      sync.Mutex
+	backlog int
+	recent  []api.Event
+	lastID  uint64
+	subs    map[chan api.Event]struct{}
+	closed  bool
+	// wg is done when the subscriptions are cancelled
+	wg sync.WaitGroup
+}
+
+// NewBus instantiates a Bus that retains backlog recent events.
+func NewBus(backlog int) *Bus {
+	return &Bus{
+		backlog: backlog,
+		subs:    make(map[chan api.Event]struct{}),
+	}
+}
+
+// Publish assigns the ID to ev, sets the time if not set, and sends ev to the subscribers.
+// Publish never blocks. Publish is no-op after Close.
+func (b *Bus) Publish(ev api.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.lastID++
+	ev.ID = b.lastID
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b.recent = append(b.recent, ev)
+	if len(b.recent) > b.backlog {
+		b.recent = b.recent[len(b.recent)-b.backlog:]
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			// too slow, the subscriber can resubscribe with the ID of the last received event
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe subscribes to the events published after the call.
+// The channel is closed on Close, or when the subscriber is too slow.
+// The returned function cancels the subscription, and needs to be called always.
+func (b *Bus) Subscribe() (<-chan api.Event, func()) {
+	return b.subscribe(false, 0)
+}
+
+// SubscribeSince is like Subscribe, but also replays the retained events whose IDs are greater than since.
+// since is typically the ID of the last received event, or 0 for replaying all the retained events.
+func (b *Bus) SubscribeSince(since uint64) (<-chan api.Event, func()) {
+	return b.subscribe(true, since)
+}
+
+func (b *Bus) subscribe(replay bool, since uint64) (<-chan api.Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var replayed []api.Event
+	if replay {
+		for _, ev := range b.recent {
+			if ev.ID > since {
+				replayed = append(replayed, ev)
+			}
+		}
+	}
+	ch := make(chan api.Event, len(replayed)+subscriberBuffer)
+	for _, ev := range replayed {
+		ch <- ev
+	}
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[ch] = struct{}{}
+	b.wg.Add(1)
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			if _, ok := b.subs[ch]; ok {
+				delete(b.subs, ch)
+				close(ch)
+			}
+			b.mu.Unlock()
+			b.wg.Done()
+		})
+	}
+	return ch, cancel
+}
+
+// Close closes the channels of the subscribers, and waits for the subscriptions to be cancelled
+// up to timeout, so that the subscribers can receive the last events before the API server is closed.
+func (b *Bus) Close(timeout time.Duration) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+	b.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
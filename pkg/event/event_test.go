@@ -0,0 +1,87 @@
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rootless-containers/rootlesskit/pkg/api"
+)
+
+func receiveIDs(ch <-chan api.Event, n int) []uint64 {
+	var ids []uint64
+	for i := 0; i < n; i++ {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return ids
+			}
+			ids = append(ids, ev.ID)
+		case <-time.After(time.Second):
+			return ids
+		}
+	}
+	return ids
+}
+
+func TestSubscribeSince(t *testing.T) {
+	bus := NewBus(2)
+	for i := 0; i < 3; i++ {
+		bus.Publish(api.Event{Type: api.EventPortAdded})
+	}
+	// only the last 2 events are retained
+	ch, cancel := bus.SubscribeSince(0)
+	defer cancel()
+	newCh, newCancel := bus.Subscribe()
+	defer newCancel()
+	bus.Publish(api.Event{Type: api.EventPortRemoved})
+	if ids := receiveIDs(ch, 3); len(ids) != 3 || ids[0] != 2 || ids[1] != 3 || ids[2] != 4 {
+		t.Errorf("expected [2 3 4], got %v", ids)
+	}
+	if ids := receiveIDs(newCh, 1); len(ids) != 1 || ids[0] != 4 {
+		t.Errorf("expected [4], got %v", ids)
+	}
+	sinceCh, sinceCancel := bus.SubscribeSince(3)
+	defer sinceCancel()
+	if ids := receiveIDs(sinceCh, 1); len(ids) != 1 || ids[0] != 4 {
+		t.Errorf("expected [4], got %v", ids)
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	bus := NewBus(DefaultBacklog)
+	ch, cancel := bus.Subscribe()
+	defer cancel()
+	for i := 0; i < subscriberBuffer+1; i++ {
+		bus.Publish(api.Event{Type: api.EventPortAdded})
+	}
+	// the buffered events are still received, and then the channel is closed
+	if ids := receiveIDs(ch, subscriberBuffer+1); len(ids) != subscriberBuffer {
+		t.Errorf("expected %d events, got %d", subscriberBuffer, len(ids))
+	}
+}
+
+func TestClose(t *testing.T) {
+	bus := NewBus(DefaultBacklog)
+	ch, cancel := bus.Subscribe()
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+		cancel()
+	}()
+	bus.Publish(api.Event{Type: api.EventChildExited})
+	bus.Close(10 * time.Second)
+	select {
+	case <-done:
+	default:
+		t.Fatal("Close returned before the subscription was cancelled")
+	}
+	// no-op after Close
+	bus.Publish(api.Event{Type: api.EventPortAdded})
+	closedCh, closedCancel := bus.Subscribe()
+	defer closedCancel()
+	if _, ok := <-closedCh; ok {
+		t.Fatal("expected a closed channel")
+	}
+}
@@ -0,0 +1,114 @@
+// Package firewall detects the iptables backend (nf_tables or legacy) available on the system.
+package firewall
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rootless-containers/rootlesskit/pkg/common"
+)
+
+// Backend is the firewall backend.
+type Backend string
+
+const (
+	// BackendAuto selects the first available backend, preferring the default iptables of the system.
+	BackendAuto = Backend("auto")
+	// BackendNft selects the nf_tables backend of iptables (iptables-nft).
+	BackendNft = Backend("nft")
+	// BackendIPTables selects the legacy backend of iptables (iptables-legacy).
+	BackendIPTables = Backend("iptables")
+)
+
+// Backends are the supported backends.
+var Backends = []Backend{BackendAuto, BackendNft, BackendIPTables}
+
+// ParseBackend parses the backend name. Empty string is parsed as BackendAuto.
+func ParseBackend(s string) (Backend, error) {
+	if s == "" {
+		return BackendAuto, nil
+	}
+	for _, b := range Backends {
+		if string(b) == s {
+			return b, nil
+		}
+	}
+	return "", errors.Errorf("unknown firewall backend %q (must be one of %v)", s, Backends)
+}
+
+// Commands are the absolute paths of the iptables-restore and iptables-save commands of the backend.
+type Commands struct {
+	Restore string
+	Save    string
+}
+
+type candidate struct {
+	restore string
+	save    string
+	// backend is empty for the default iptables of the system, which can be either nft or legacy.
+	backend Backend
+}
+
+var candidates = []candidate{
+	{"iptables-restore", "iptables-save", ""},
+	{"iptables-nft-restore", "iptables-nft-save", BackendNft},
+	{"iptables-legacy-restore", "iptables-legacy-save", BackendIPTables},
+}
+
+// Detect returns the commands of the backend.
+// *common.HelperNotFoundError is returned when the backend is not available.
+func Detect(b Backend) (*Commands, error) {
+	for _, c := range candidates {
+		restore, err := exec.LookPath(c.restore)
+		if err != nil {
+			continue
+		}
+		save, err := exec.LookPath(c.save)
+		if err != nil {
+			continue
+		}
+		if b != BackendAuto {
+			backend := c.backend
+			if backend == "" {
+				if backend, err = detectDefaultBackend(restore); err != nil {
+					continue
+				}
+			}
+			if backend != b {
+				continue
+			}
+		}
+		return &Commands{Restore: restore, Save: save}, nil
+	}
+	var names []string
+	for _, c := range candidates {
+		if b == BackendAuto || c.backend == "" || c.backend == b {
+			names = append(names, c.restore)
+		}
+	}
+	return nil, &common.HelperNotFoundError{
+		Helper: names[0],
+		Err:    errors.Errorf("firewall backend %q is not available (none of %v supports it)", b, names),
+	}
+}
+
+// detectDefaultBackend detects the backend of the default iptables-restore from the version string,
+// e.g. "iptables-restore v1.8.7 (nf_tables)" or "iptables-restore v1.8.7 (legacy)".
+// The versions prior to v1.8 are always legacy, and do not print the backend.
+func detectDefaultBackend(restore string) (Backend, error) {
+	cmd := exec.Command(restore, "--version")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to detect the backend of %s", restore)
+	}
+	return parseVersion(string(out)), nil
+}
+
+func parseVersion(s string) Backend {
+	if strings.Contains(s, "(nf_tables)") {
+		return BackendNft
+	}
+	return BackendIPTables
+}
@@ -0,0 +1,78 @@
+package firewall
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rootless-containers/rootlesskit/pkg/common"
+)
+
+func TestParseVersion(t *testing.T) {
+	testCases := map[string]Backend{
+		"iptables-restore v1.8.7 (nf_tables)\n": BackendNft,
+		"iptables-restore v1.8.7 (legacy)\n":    BackendIPTables,
+		"iptables-restore v1.6.1\n":             BackendIPTables,
+	}
+	for s, expected := range testCases {
+		if got := parseVersion(s); got != expected {
+			t.Errorf("%q: expected %q, got %q", s, expected, got)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-firewall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", tmp)
+	writeScript := func(name, s string) {
+		if err := ioutil.WriteFile(filepath.Join(tmp, name), []byte("#!/bin/sh\n"+s+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only the default iptables with the nf_tables backend
+	writeScript("iptables-restore", "echo 'iptables-restore v1.8.7 (nf_tables)'")
+	writeScript("iptables-save", "true")
+	for _, b := range []Backend{BackendAuto, BackendNft} {
+		c, err := Detect(b)
+		if err != nil {
+			t.Fatalf("%q: %v", b, err)
+		}
+		if expected := filepath.Join(tmp, "iptables-restore"); c.Restore != expected {
+			t.Fatalf("%q: expected %q, got %q", b, expected, c.Restore)
+		}
+	}
+	_, err = Detect(BackendIPTables)
+	if _, ok := err.(*common.HelperNotFoundError); !ok {
+		t.Fatalf("expected *common.HelperNotFoundError, got %v", err)
+	}
+
+	// iptables-legacy is installed as well
+	writeScript("iptables-legacy-restore", "true")
+	writeScript("iptables-legacy-save", "true")
+	c, err := Detect(BackendIPTables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(tmp, "iptables-legacy-restore"); c.Restore != expected {
+		t.Fatalf("expected %q, got %q", expected, c.Restore)
+	}
+}
+
+func TestParseBackend(t *testing.T) {
+	if b, err := ParseBackend(""); err != nil || b != BackendAuto {
+		t.Fatalf("expected auto, got %q, %v", b, err)
+	}
+	if b, err := ParseBackend("nft"); err != nil || b != BackendNft {
+		t.Fatalf("expected nft, got %q, %v", b, err)
+	}
+	if _, err := ParseBackend("ebtables"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,48 @@
+// Package instance resolves the state directory of a named RootlessKit instance.
+package instance
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// nameRegexp is same as the container name of Docker, so that the name can be used as a directory name.
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// maxNameLen is the maximum length of the name, so that the path of api.sock does not exceed the limit of sun_path.
+const maxNameLen = 64
+
+// ValidateName validates the instance name, e.g. "foo".
+func ValidateName(name string) error {
+	if len(name) > maxNameLen {
+		return errors.Errorf("instance name %q is too long (max: %d)", name, maxNameLen)
+	}
+	if !nameRegexp.MatchString(name) {
+		return errors.Errorf("invalid instance name %q, must match %s", name, nameRegexp.String())
+	}
+	return nil
+}
+
+// BaseDir returns "$XDG_RUNTIME_DIR/rootlesskit".
+func BaseDir() (string, error) {
+	xdr := os.Getenv("XDG_RUNTIME_DIR")
+	if xdr == "" {
+		return "", errors.New("named instances require $XDG_RUNTIME_DIR to be set")
+	}
+	return filepath.Join(xdr, "rootlesskit"), nil
+}
+
+// StateDir returns "$XDG_RUNTIME_DIR/rootlesskit/<name>".
+func StateDir(name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	base, err := BaseDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, name), nil
+}
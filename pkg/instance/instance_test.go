@@ -0,0 +1,53 @@
+package instance
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	testCases := map[string]bool{
+		"foo":                   true,
+		"foo-bar_1.2":           true,
+		"0":                     true,
+		"":                      false,
+		".foo":                  false,
+		"-foo":                  false,
+		"foo/bar":               false,
+		"..":                    false,
+		strings.Repeat("a", 64): true,
+		strings.Repeat("a", 65): false,
+		"foo bar":               false,
+	}
+	for name, valid := range testCases {
+		err := ValidateName(name)
+		if valid && err != nil {
+			t.Errorf("expected %q to be valid, got %v", name, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
+
+func TestStateDir(t *testing.T) {
+	old, ok := os.LookupEnv("XDG_RUNTIME_DIR")
+	if ok {
+		defer os.Setenv("XDG_RUNTIME_DIR", old)
+	} else {
+		defer os.Unsetenv("XDG_RUNTIME_DIR")
+	}
+	os.Setenv("XDG_RUNTIME_DIR", "/run/user/1001")
+	d, err := StateDir("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != "/run/user/1001/rootlesskit/foo" {
+		t.Fatalf("unexpected state dir %q", d)
+	}
+	os.Unsetenv("XDG_RUNTIME_DIR")
+	if _, err := StateDir("foo"); err == nil {
+		t.Fatal("expected an error without XDG_RUNTIME_DIR")
+	}
+}
@@ -0,0 +1,55 @@
+// Package mountinfo parses /proc/self/mountinfo.
+package mountinfo
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MountPoints parses the mount points (the fifth fields) of mountinfo read from r, in the order of the lines.
+func MountPoints(r io.Reader) ([]string, error) {
+	var res []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			return nil, errors.Errorf("unexpected mountinfo line %q", scanner.Text())
+		}
+		res = append(res, Unescape(fields[4]))
+	}
+	return res, scanner.Err()
+}
+
+// SelfMountPoints returns the mount points in /proc/self/mountinfo.
+func SelfMountPoints() ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return MountPoints(f)
+}
+
+// Unescape unescapes the octal escapes such as "\040" (space) in mountinfo.
+func Unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if x, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(x))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
@@ -0,0 +1,40 @@
+package mountinfo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMountPoints(t *testing.T) {
+	s := `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+23 22 0:5 / /dev rw,nosuid shared:2 - devtmpfs udev rw
+24 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+25 22 8:2 / /mnt/foo\040bar rw,relatime shared:30 - ext4 /dev/sda2 rw
+`
+	got, err := MountPoints(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/", "/dev", "/proc", "/mnt/foo bar"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if _, err := MountPoints(strings.NewReader("22 1 8:1 /\n")); err == nil {
+		t.Fatal("expected an error for a truncated line")
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	testCases := map[string]string{
+		`/tmp/foo\040bar`: "/tmp/foo bar",
+		"/tmp/foo":        "/tmp/foo",
+		`/tmp/foo\134bar`: `/tmp/foo\bar`,
+		`/tmp/foo\04`:     `/tmp/foo\04`,
+	}
+	for s, expected := range testCases {
+		if got := Unescape(s); got != expected {
+			t.Errorf("%q: expected %q, got %q", s, expected, got)
+		}
+	}
+}
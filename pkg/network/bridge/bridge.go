@@ -0,0 +1,183 @@
+// Package bridge provides the network driver that connects the child to a bridge in the parent network namespace
+// via a veth pair.
+//
+// The bridge is shared across RootlessKit instances, so that the instances can communicate with each other over L2.
+// The parent needs CAP_NET_ADMIN in its network namespace, e.g. RootlessKit needs to be executed in another
+// RootlessKit instance (typically with --net=slirp4netns) that owns the bridge.
+package bridge
+
+import (
+	"context"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rootless-containers/rootlesskit/pkg/api"
+	"github.com/rootless-containers/rootlesskit/pkg/common"
+	"github.com/rootless-containers/rootlesskit/pkg/network"
+)
+
+// DefaultBridge is the default bridge name.
+const DefaultBridge = "rootlesskit0"
+
+// NewParentDriver instantiates the parent driver.
+// ip is the static address of the child in CIDR notation, e.g. "10.0.100.2/24".
+// gateway is optional. When gateway is set, the gateway is used as the default route of the child,
+// and assigned to the bridge on creating the bridge.
+// mac is the MAC address of the veth in the child. nil for a random address.
+func NewParentDriver(mtu int, bridge, ip, gateway string, mac net.HardwareAddr) (network.ParentDriver, error) {
+	if mtu < 0 {
+		return nil, errors.New("got negative mtu")
+	}
+	if mtu == 0 {
+		mtu = 1500
+	}
+	if bridge == "" {
+		return nil, errors.New("got empty bridge")
+	}
+	if len(bridge) > 15 {
+		return nil, errors.Errorf("bridge name %q is too long", bridge)
+	}
+	if ip == "" {
+		return nil, errors.New("the static IP of the child (e.g. \"10.0.100.2/24\") needs to be specified for the bridge driver")
+	}
+	childIP, ipnet, err := net.ParseCIDR(ip)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid IP %q", ip)
+	}
+	if childIP.To4() == nil {
+		return nil, errors.Errorf("IP %q is not an IPv4 address", ip)
+	}
+	d := &parentDriver{
+		mtu:    mtu,
+		bridge: bridge,
+		ip:     childIP.To4(),
+		ipnet:  ipnet,
+		mac:    mac,
+	}
+	if gateway != "" {
+		d.gateway = net.ParseIP(gateway).To4()
+		if d.gateway == nil {
+			return nil, errors.Errorf("invalid gateway %q", gateway)
+		}
+		if !ipnet.Contains(d.gateway) {
+			return nil, errors.Errorf("gateway %s is not in %s", d.gateway, ipnet)
+		}
+		if d.gateway.Equal(d.ip) {
+			return nil, errors.Errorf("gateway %s conflicts with the IP of the child", d.gateway)
+		}
+	}
+	return d, nil
+}
+
+type parentDriver struct {
+	mtu     int
+	bridge  string
+	ip      net.IP
+	ipnet   *net.IPNet
+	gateway net.IP           // can be nil
+	mac     net.HardwareAddr // can be nil
+}
+
+func (d *parentDriver) MTU() int {
+	return d.mtu
+}
+
+func (d *parentDriver) Info(ctx context.Context) (*api.NetworkDriverInfo, error) {
+	return &api.NetworkDriverInfo{
+		Driver: "bridge",
+	}, nil
+}
+
+// ensureBridge creates the bridge unless it already exists.
+func (d *parentDriver) ensureBridge() error {
+	if _, err := net.InterfaceByName(d.bridge); err == nil {
+		return nil
+	}
+	if err := ip("link", "add", "name", d.bridge, "type", "bridge"); err != nil {
+		// another instance may have created the bridge concurrently
+		if _, err2 := net.InterfaceByName(d.bridge); err2 == nil {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to create bridge %s (the bridge driver requires CAP_NET_ADMIN in the parent network namespace)", d.bridge)
+	}
+	logrus.Debugf("created bridge %s", d.bridge)
+	if d.gateway != nil {
+		prefix, _ := d.ipnet.Mask.Size()
+		if err := ip("addr", "add", d.gateway.String()+"/"+strconv.Itoa(prefix), "dev", d.bridge); err != nil {
+			return err
+		}
+	}
+	return ip("link", "set", d.bridge, "up")
+}
+
+func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.NetworkMessage, func() error, error) {
+	var cleanups []func() error
+	if err := d.ensureBridge(); err != nil {
+		return nil, common.Seq(cleanups), err
+	}
+	// the bridge is left on exit, as it may be shared with other instances
+	dev := "eth0"
+	veth := "rkveth" + strconv.Itoa(childPID)
+	peerArgs := []string{"name", dev, "mtu", strconv.Itoa(d.mtu)}
+	if d.mac != nil {
+		peerArgs = append(peerArgs, "address", d.mac.String())
+	}
+	peerArgs = append(peerArgs, "netns", strconv.Itoa(childPID))
+	if err := ip(append([]string{"link", "add", veth, "mtu", strconv.Itoa(d.mtu), "type", "veth", "peer"}, peerArgs...)...); err != nil {
+		return nil, common.Seq(cleanups), err
+	}
+	cleanups = append(cleanups, func() error {
+		// the veth pair is also removed automatically when the child netns is destroyed
+		if _, err := net.InterfaceByName(veth); err != nil {
+			return nil
+		}
+		return ip("link", "del", veth)
+	})
+	if err := ip("link", "set", veth, "master", d.bridge); err != nil {
+		return nil, common.Seq(cleanups), err
+	}
+	if err := ip("link", "set", veth, "up"); err != nil {
+		return nil, common.Seq(cleanups), err
+	}
+	netmsg := common.NetworkMessage{
+		Dev: dev,
+		IP:  d.ip.String(),
+		MTU: d.mtu,
+		// DNS is empty: /etc/resolv.conf of the parent is kept
+	}
+	netmsg.Netmask, _ = d.ipnet.Mask.Size()
+	if d.gateway != nil {
+		netmsg.Gateway = d.gateway.String()
+	}
+	return &netmsg, common.Seq(cleanups), nil
+}
+
+func ip(args ...string) error {
+	cmd := exec.Command("ip", args...)
+	logrus.Debugf("executing %v", cmd.Args)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "failed to execute %v: %s", cmd.Args, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+func NewChildDriver() network.ChildDriver {
+	return &childDriver{}
+}
+
+type childDriver struct {
+}
+
+func (d *childDriver) ConfigureNetworkChild(netmsg *common.NetworkMessage) (string, error) {
+	// the veth has been already moved into the child netns by the parent.
+	// the address is configured by pkg/child.
+	if netmsg.Dev == "" {
+		return "", errors.New("could not determine the dev")
+	}
+	return netmsg.Dev, nil
+}
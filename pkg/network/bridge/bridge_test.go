@@ -0,0 +1,34 @@
+package bridge
+
+import (
+	"testing"
+)
+
+func TestNewParentDriver(t *testing.T) {
+	testCases := []struct {
+		bridge  string
+		ip      string
+		gateway string
+		ok      bool
+	}{
+		{DefaultBridge, "10.0.100.2/24", "", true},
+		{DefaultBridge, "10.0.100.2/24", "10.0.100.1", true},
+		{DefaultBridge, "", "", false},
+		{DefaultBridge, "10.0.100.2", "", false},
+		{DefaultBridge, "fd00::2/64", "", false},
+		{DefaultBridge, "10.0.100.2/24", "10.0.200.1", false},
+		{DefaultBridge, "10.0.100.2/24", "10.0.100.2", false},
+		{DefaultBridge, "10.0.100.2/24", "foo", false},
+		{"", "10.0.100.2/24", "", false},
+		{"rootlesskit-too-long", "10.0.100.2/24", "", false},
+	}
+	for _, tc := range testCases {
+		_, err := NewParentDriver(0, tc.bridge, tc.ip, tc.gateway, nil)
+		if tc.ok && err != nil {
+			t.Errorf("%+v: unexpected error: %v", tc, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%+v: expected an error", tc)
+		}
+	}
+}
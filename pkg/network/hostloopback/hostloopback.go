@@ -0,0 +1,231 @@
+// Package hostloopback relays connections from the child network namespace
+// to specific endpoints on the host loopback interface.
+//
+// The parent listens on a UNIX socket for each of the endpoints, and connects to the endpoint on the host.
+// The child listens on the same TCP address in the child network namespace, and connects to the UNIX socket.
+// Thus only the allowed endpoints are reachable even when the host loopback is disabled for the network driver.
+//
+// UDP endpoints ("ip:port/udp") are relayed via SOCK_SEQPACKET UNIX sockets, one connection per UDP client.
+package hostloopback
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port/portutil"
+)
+
+// SplitProto splits "ip:port/proto" into "ip:port" and "proto".
+// proto defaults to "tcp".
+func SplitProto(s string) (addr, proto string) {
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, "tcp"
+}
+
+// ValidateAddr validates "ip:port" address, with an optional "/tcp" or "/udp" suffix.
+// The IP needs to be a loopback address.
+func ValidateAddr(s string) error {
+	addr, proto := SplitProto(s)
+	if proto != "tcp" && proto != "udp" {
+		return errors.Errorf("invalid proto %q in %q, expected \"tcp\" or \"udp\"", proto, s)
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.Wrapf(err, "invalid address %q, expected \"ip:port\"", addr)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errors.Errorf("invalid IP %q in %q", host, addr)
+	}
+	if !ip.IsLoopback() {
+		return errors.Errorf("%q is not a loopback address", host)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return errors.Wrapf(err, "invalid port in %q", addr)
+	}
+	if port <= 0 || port > 65535 {
+		return errors.Errorf("invalid port number %d in %q", port, addr)
+	}
+	return nil
+}
+
+// ListenParent listens on socketPath, and relays the connections to addr ("ip:port" or "ip:port/proto") on the host.
+func ListenParent(socketPath, s string) (io.Closer, error) {
+	addr, proto := SplitProto(s)
+	if proto == "udp" {
+		ln, err := net.Listen("unixpacket", socketPath)
+		if err != nil {
+			return nil, err
+		}
+		go serveUDPParent(ln, addr)
+		return ln, nil
+	}
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	return serve(ln, "tcp", addr), nil
+}
+
+// ListenChild listens on addr ("ip:port" or "ip:port/proto") in the child network namespace,
+// and relays the connections to socketPath.
+func ListenChild(s, socketPath string) (io.Closer, error) {
+	addr, proto := SplitProto(s)
+	if proto == "udp" {
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		go serveUDPChild(pc, socketPath)
+		return pc, nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return serve(ln, "unix", socketPath), nil
+}
+
+// udpIdleTimeout is the timeout for closing the idle UDP relay for a client
+const udpIdleTimeout = 60 * time.Second
+
+// serveUDPParent relays the packets from each of the child connections to addr, via a dedicated UDP socket.
+func serveUDPParent(ln net.Listener, addr string) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			// the listener is closed
+			return
+		}
+		go func() {
+			defer c.Close()
+			d, err := net.Dial("udp", addr)
+			if err != nil {
+				logrus.WithError(err).Warnf("failed to connect to %s", addr)
+				return
+			}
+			defer d.Close()
+			go func() {
+				b := make([]byte, 65536)
+				for {
+					d.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+					n, err := d.Read(b)
+					if err != nil {
+						// closing c terminates the loop below
+						c.Close()
+						return
+					}
+					if _, err := c.Write(b[:n]); err != nil {
+						return
+					}
+				}
+			}()
+			b := make([]byte, 65536)
+			for {
+				n, err := c.Read(b)
+				if err != nil {
+					return
+				}
+				if _, err := d.Write(b[:n]); err != nil {
+					logrus.WithError(err).Debugf("failed to write to %s", addr)
+				}
+			}
+		}()
+	}
+}
+
+// serveUDPChild relays the packets from each of the UDP clients to socketPath, via a dedicated connection.
+func serveUDPChild(pc net.PacketConn, socketPath string) {
+	var mu sync.Mutex
+	sessions := make(map[string]net.Conn)
+	b := make([]byte, 65536)
+	for {
+		n, from, err := pc.ReadFrom(b)
+		if err != nil {
+			// the packet conn is closed
+			return
+		}
+		key := from.String()
+		mu.Lock()
+		c, ok := sessions[key]
+		if !ok {
+			c, err = net.Dial("unixpacket", socketPath)
+			if err != nil {
+				mu.Unlock()
+				logrus.WithError(err).Warnf("failed to connect to %s", socketPath)
+				continue
+			}
+			sessions[key] = c
+			go func() {
+				defer func() {
+					mu.Lock()
+					delete(sessions, key)
+					mu.Unlock()
+					c.Close()
+				}()
+				rb := make([]byte, 65536)
+				for {
+					// returns EOF when the parent closes the idle relay
+					rn, err := c.Read(rb)
+					if err != nil {
+						return
+					}
+					if _, err := pc.WriteTo(rb[:rn], from); err != nil {
+						return
+					}
+				}
+			}()
+		}
+		mu.Unlock()
+		if _, err := c.Write(b[:n]); err != nil {
+			logrus.WithError(err).Debugf("failed to write to %s", socketPath)
+		}
+	}
+}
+
+// relayListener closes the relayed connections on closing the listener.
+type relayListener struct {
+	net.Listener
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+func (l *relayListener) Close() error {
+	l.quitOnce.Do(func() { close(l.quit) })
+	return l.Listener.Close()
+}
+
+// serve relays the connections accepted on ln to addr, until the returned closer is closed.
+func serve(ln net.Listener, network, addr string) io.Closer {
+	l := &relayListener{Listener: ln, quit: make(chan struct{})}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				// the listener is closed
+				return
+			}
+			go func() {
+				defer c.Close()
+				d, err := net.Dial(network, addr)
+				if err != nil {
+					logrus.WithError(err).Warnf("failed to connect to %s", addr)
+					return
+				}
+				defer d.Close()
+				portutil.BiCopy(c, d, l.quit, 0)
+			}()
+		}
+	}()
+	return l
+}
@@ -0,0 +1,137 @@
+package hostloopback
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateAddr(t *testing.T) {
+	testCases := []struct {
+		addr  string
+		valid bool
+	}{
+		{"127.0.0.1:5000", true},
+		{"127.0.0.2:5000", true},
+		{"[::1]:5000", true},
+		{"10.0.2.2:5000", false},
+		{"localhost:5000", false},
+		{"127.0.0.1", false},
+		{"127.0.0.1:0", false},
+		{"127.0.0.1:65536", false},
+		{"127.0.0.1:53/udp", true},
+		{"127.0.0.1:5000/tcp", true},
+		{"127.0.0.1:5000/sctp", false},
+	}
+	for _, tc := range testCases {
+		err := ValidateAddr(tc.addr)
+		if tc.valid && err != nil {
+			t.Errorf("expected %q to be valid, got %v", tc.addr, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected %q to be invalid", tc.addr)
+		}
+	}
+}
+
+func TestRelay(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-hostloopback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		c, err := target.Accept()
+		if err != nil {
+			return
+		}
+		c.Write([]byte("hello"))
+		c.Close()
+	}()
+	socketPath := filepath.Join(tmpDir, "hostloopback.sock")
+	p, err := ListenParent(socketPath, target.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	// in the real world, the child listens on the same address as the target, in the child network namespace
+	c, err := ListenChild("127.0.0.1:0", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn, err := net.Dial("tcp", c.(net.Listener).Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("expected \"hello\", got %q", string(b))
+	}
+}
+
+func TestRelayUDP(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-hostloopback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	target, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		// echo
+		b := make([]byte, 64)
+		for {
+			n, from, err := target.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			target.WriteTo(b[:n], from)
+		}
+	}()
+	socketPath := filepath.Join(tmpDir, "hostloopback.sock")
+	p, err := ListenParent(socketPath, target.LocalAddr().String()+"/udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	c, err := ListenChild("127.0.0.1:0/udp", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn, err := net.Dial("udp", c.(net.PacketConn).LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(3 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 64)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "hello" {
+		t.Fatalf("expected \"hello\", got %q", string(b[:n]))
+	}
+}
@@ -1,6 +1,7 @@
 package lxcusernic
 
 import (
+	"context"
 	"net"
 	"os"
 	"os/exec"
@@ -12,11 +13,14 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
+	"github.com/rootless-containers/rootlesskit/pkg/api"
 	"github.com/rootless-containers/rootlesskit/pkg/common"
 	"github.com/rootless-containers/rootlesskit/pkg/network"
+	"github.com/rootless-containers/rootlesskit/pkg/network/parentutils"
 )
 
-func NewParentDriver(binary string, mtu int, bridge string) (network.ParentDriver, error) {
+// mac is the MAC address of the veth in the child. nil for the address assigned by lxc-user-nic.
+func NewParentDriver(binary string, mtu int, bridge string, mac net.HardwareAddr) (network.ParentDriver, error) {
 	if binary == "" {
 		return nil, errors.New("got empty binary")
 	}
@@ -33,6 +37,7 @@ func NewParentDriver(binary string, mtu int, bridge string) (network.ParentDrive
 		binary: binary,
 		mtu:    mtu,
 		bridge: bridge,
+		mac:    mac,
 	}, nil
 }
 
@@ -40,22 +45,37 @@ type parentDriver struct {
 	binary string
 	mtu    int
 	bridge string
+	mac    net.HardwareAddr // can be nil
 }
 
 func (d *parentDriver) MTU() int {
 	return d.mtu
 }
 
+func (d *parentDriver) Info(ctx context.Context) (*api.NetworkDriverInfo, error) {
+	// lxc-user-nic does not support --version
+	return &api.NetworkDriverInfo{
+		Driver: "lxc-user-nic",
+	}, nil
+}
+
 func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.NetworkMessage, func() error, error) {
 	var cleanups []func() error
 	dummyLXCPath := "/dev/null"
 	dummyLXCName := "dummy"
 	dev := "eth0"
 	cmd := exec.Command(d.binary, "create", dummyLXCPath, dummyLXCName, strconv.Itoa(childPID), "veth", d.bridge, dev)
+	common.LogHelperCmd(cmd)
 	b, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, common.Seq(cleanups), errors.Wrapf(err, "%s failed: %s", d.binary, string(b))
 	}
+	if d.mac != nil {
+		// set before the child brings up dev for DHCP
+		if err := parentutils.SetMAC(childPID, dev, d.mac); err != nil {
+			return nil, common.Seq(cleanups), err
+		}
+	}
 	netmsg := common.NetworkMessage{
 		Dev: dev,
 		// IP, Netmask, Gateway, and DNS are configured in Child (via DHCP)
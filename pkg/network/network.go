@@ -1,6 +1,11 @@
 package network
 
 import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/rootless-containers/rootlesskit/pkg/api"
 	"github.com/rootless-containers/rootlesskit/pkg/common"
 )
 
@@ -10,6 +15,9 @@ type ParentDriver interface {
 	MTU() int
 	// ConfigureNetwork sets up Slirp, updates msg, and returns destructor function.
 	ConfigureNetwork(childPID int, stateDir string) (netmsg *common.NetworkMessage, cleanup func() error, err error)
+	// Info returns the information of the driver.
+	// Info may take time for detecting the version of the helper binary.
+	Info(ctx context.Context) (*api.NetworkDriverInfo, error)
 }
 
 // ChildDriver is called from the child namespace
@@ -18,3 +26,14 @@ type ChildDriver interface {
 	// devName is like "tap" or "eth0"
 	ConfigureNetworkChild(netmsg *common.NetworkMessage) (devName string, err error)
 }
+
+// MaxMTU is the maximum MTU
+const MaxMTU = 65521
+
+// ValidateMTU validates the MTU. 0 is not accepted.
+func ValidateMTU(mtu int) error {
+	if mtu <= 0 || mtu > MaxMTU {
+		return errors.Errorf("mtu must be > 0 and <= %d, got %d", MaxMTU, mtu)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package parentutils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rootless-containers/rootlesskit/pkg/api"
+)
+
+// DevStats reads the statistics of the network interface dev in the network namespace of pid, from /proc/PID/net/dev.
+func DevStats(pid int, dev string) (*api.NetworkDevStats, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/net/dev", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseProcNetDev(f, dev)
+}
+
+// parseProcNetDev parses the line of dev in /proc/PID/net/dev.
+func parseProcNetDev(r io.Reader, dev string) (*api.NetworkDevStats, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, ":")
+		// the header lines contain "|" instead of ":"
+		if i < 0 || strings.TrimSpace(line[:i]) != dev {
+			continue
+		}
+		// bytes packets errs drop fifo frame compressed multicast (receive),
+		// bytes packets errs drop fifo colls carrier compressed (transmit)
+		fields := strings.Fields(line[i+1:])
+		if len(fields) < 16 {
+			return nil, errors.Errorf("unexpected line %q", line)
+		}
+		var (
+			v   [16]uint64
+			err error
+		)
+		for j := range v {
+			if v[j], err = strconv.ParseUint(fields[j], 10, 64); err != nil {
+				return nil, errors.Wrapf(err, "unexpected line %q", line)
+			}
+		}
+		return &api.NetworkDevStats{
+			Dev:       dev,
+			RxBytes:   v[0],
+			RxPackets: v[1],
+			RxErrors:  v[2],
+			RxDropped: v[3],
+			TxBytes:   v[8],
+			TxPackets: v[9],
+			TxErrors:  v[10],
+			TxDropped: v[11],
+		}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.Errorf("network interface %q not found", dev)
+}
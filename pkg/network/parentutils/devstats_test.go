@@ -0,0 +1,54 @@
+package parentutils
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rootless-containers/rootlesskit/pkg/api"
+)
+
+const testProcNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:     480       6    0    0    0     0          0         0      480       6    0    0    0     0       0          0
+  tap0: 1234567    890    1    2    0     0          0         0   765432     321    3   42    0     0       0          0
+`
+
+func TestParseProcNetDev(t *testing.T) {
+	stats, err := parseProcNetDev(strings.NewReader(testProcNetDev), "tap0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := api.NetworkDevStats{
+		Dev:       "tap0",
+		RxBytes:   1234567,
+		RxPackets: 890,
+		RxErrors:  1,
+		RxDropped: 2,
+		TxBytes:   765432,
+		TxPackets: 321,
+		TxErrors:  3,
+		TxDropped: 42,
+	}
+	if *stats != expected {
+		t.Fatalf("expected %+v, got %+v", expected, *stats)
+	}
+	for _, dev := range []string{"tap1", "face", ""} {
+		if _, err := parseProcNetDev(strings.NewReader(testProcNetDev), dev); err == nil {
+			t.Errorf("%q: expected an error", dev)
+		}
+	}
+	if _, err := parseProcNetDev(strings.NewReader("  tap0: 1 2 3\n"), "tap0"); err == nil {
+		t.Fatal("expected an error for the truncated line")
+	}
+}
+
+func TestDevStats(t *testing.T) {
+	stats, err := DevStats(os.Getpid(), "lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Dev != "lo" {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,30 @@
+package parentutils
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// IFNAMSIZ includes the trailing NUL
+const IFNAMSIZ = 16
+
+// ValidateIfName validates the network interface name, with the same rules as dev_valid_name() of the kernel.
+func ValidateIfName(name string) error {
+	if name == "" {
+		return errors.New("got empty interface name")
+	}
+	if len(name) >= IFNAMSIZ {
+		return errors.Errorf("interface name %q is too long (max %d characters)", name, IFNAMSIZ-1)
+	}
+	if name == "." || name == ".." {
+		return errors.Errorf("invalid interface name %q", name)
+	}
+	if strings.IndexFunc(name, func(r rune) bool {
+		return r == '/' || r == ':' || unicode.IsSpace(r) || r > unicode.MaxASCII || !unicode.IsPrint(r)
+	}) >= 0 {
+		return errors.Errorf("interface name %q contains an invalid character", name)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package parentutils
+
+import (
+	"testing"
+)
+
+func TestValidateIfName(t *testing.T) {
+	testCases := []struct {
+		name string
+		ok   bool
+	}{
+		{"tap0", true},
+		{"rk-tap.1_a", true},
+		{"012345678901234", true},
+		{"0123456789012345", false},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"tap/0", false},
+		{"tap:0", false},
+		{"tap 0", false},
+		{"tap\t0", false},
+		{"täp0", false},
+	}
+	for _, tc := range testCases {
+		err := ValidateIfName(tc.name)
+		if tc.ok && err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.name, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%q: expected error", tc.name)
+		}
+	}
+}
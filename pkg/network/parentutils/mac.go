@@ -0,0 +1,40 @@
+package parentutils
+
+import (
+	"net"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// ParseMAC parses the MAC address of the child interface.
+// The address needs to be a 48-bit, unicast, and locally administered address (e.g. "02:42:c0:a8:00:02"),
+// so that it does not conflict with the addresses assigned to the hardware vendors.
+func ParseMAC(s string) (net.HardwareAddr, error) {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(mac) != 6 {
+		return nil, errors.Errorf("MAC address %q is not a 48-bit address", s)
+	}
+	if mac[0]&0x01 != 0 {
+		return nil, errors.Errorf("MAC address %q is not a unicast address", s)
+	}
+	if mac[0]&0x02 == 0 {
+		return nil, errors.Errorf("MAC address %q is not a locally administered address (the second-least-significant bit of the first octet needs to be set, e.g. \"02:...\")", s)
+	}
+	return mac, nil
+}
+
+// SetMAC sets the MAC address of dev in the network namespace of pid.
+func SetMAC(pid int, dev string, mac net.HardwareAddr) error {
+	args := nsenter(pid, []string{"ip", "link", "set", "dev", dev, "address", mac.String()})
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = os.Environ()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "executing %v: %q", args, string(out))
+	}
+	return nil
+}
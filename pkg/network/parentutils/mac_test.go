@@ -0,0 +1,31 @@
+package parentutils
+
+import (
+	"testing"
+)
+
+func TestParseMAC(t *testing.T) {
+	testCases := []struct {
+		s  string
+		ok bool
+	}{
+		{"02:42:c0:a8:00:02", true},
+		{"0E-00-00-00-00-01", true},
+		{"02:42:c0:a8:00", false},          // too short
+		{"00:00:5e:00:53:01", false},       // globally administered
+		{"03:00:00:00:00:01", false},       // multicast
+		{"ff:ff:ff:ff:ff:ff", false},       // broadcast
+		{"02:00:00:00:fe:80:00:00", false}, // EUI-64
+		{"foo", false},
+		{"", false},
+	}
+	for _, tc := range testCases {
+		mac, err := ParseMAC(tc.s)
+		if tc.ok && err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.s, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%q: expected an error, got %v", tc.s, mac)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package parentutils
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rootless-containers/rootlesskit/pkg/network"
+)
+
+// GetMTU returns the MTU of dev in the network namespace of pid.
+func GetMTU(pid int, dev string) (int, error) {
+	args := nsenter(pid, []string{"ip", "-o", "link", "show", "dev", dev})
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = os.Environ()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, errors.Wrapf(err, "executing %v: %q", args, stderr.String())
+	}
+	return parseIPLinkMTU(string(out))
+}
+
+// SetMTU sets the MTU of dev in the network namespace of pid.
+func SetMTU(pid int, dev string, mtu int) error {
+	if err := network.ValidateMTU(mtu); err != nil {
+		return err
+	}
+	args := nsenter(pid, []string{"ip", "link", "set", "dev", dev, "mtu", strconv.Itoa(mtu)})
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = os.Environ()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "executing %v: %q", args, string(out))
+	}
+	return nil
+}
+
+// parseIPLinkMTU parses the output of `ip -o link show dev DEV`, e.g.
+// "2: tap0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 65520 qdisc fq_codel state UP ..."
+func parseIPLinkMTU(s string) (int, error) {
+	fields := strings.Fields(s)
+	for i := 0; i < len(fields)-1; i++ {
+		if fields[i] == "mtu" {
+			mtu, err := strconv.Atoi(fields[i+1])
+			if err != nil {
+				return 0, errors.Wrapf(err, "failed to parse mtu %q", fields[i+1])
+			}
+			return mtu, nil
+		}
+	}
+	return 0, errors.Errorf("mtu not found in %q", s)
+}
@@ -0,0 +1,21 @@
+package parentutils
+
+import (
+	"testing"
+)
+
+func TestParseIPLinkMTU(t *testing.T) {
+	s := "2: tap0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 65520 qdisc fq_codel state UP mode DEFAULT group default qlen 1000\\    link/ether 46:dc:8d:09:fd:f2 brd ff:ff:ff:ff:ff:ff\n"
+	mtu, err := parseIPLinkMTU(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtu != 65520 {
+		t.Fatalf("expected 65520, got %d", mtu)
+	}
+	for _, s := range []string{"", "2: tap0: <UP> qdisc noop", "2: tap0: <UP> mtu foo qdisc noop", "2: tap0: <UP> mtu"} {
+		if _, err := parseIPLinkMTU(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package parentutils
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// OpenTapEnvKey is set when RootlessKit is re-executed as the helper process for OpenTap.
+// The value is the name of the tap device.
+const OpenTapEnvKey = "_ROOTLESSKIT_OPENTAP_UNDOCUMENTED"
+
+// OpenTap opens the tap device that has been created with PrepareTap, in the network namespace of pid.
+// The FD is opened by re-executing RootlessKit as a helper process in the namespaces of pid (see OpenTapHelper),
+// and is received via SCM_RIGHTS.
+func OpenTap(pid int, tap string) (*os.File, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	local := os.NewFile(uintptr(fds[0]), "opentap-local")
+	defer local.Close()
+	remote := os.NewFile(uintptr(fds[1]), "opentap-remote")
+	defer remote.Close()
+	// "-m" is not needed, as the helper only opens /dev/net/tun
+	args := []string{"nsenter", "-t", strconv.Itoa(pid), "-n", "-U", "--preserve-credentials", exe}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), OpenTapEnvKey+"="+tap)
+	// FD 3 in the helper
+	cmd.ExtraFiles = []*os.File{remote}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Pdeathsig: syscall.SIGKILL,
+	}
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "executing %v", args)
+	}
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobN, _, _, err := unix.Recvmsg(fds[0], nil, oob, unix.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to receive the tap fd")
+	}
+	scms, err := unix.ParseSocketControlMessage(oob[:oobN])
+	if err != nil {
+		return nil, err
+	}
+	if len(scms) != 1 {
+		return nil, errors.Errorf("unexpected scms: %v", scms)
+	}
+	tapFDs, err := unix.ParseUnixRights(&scms[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(tapFDs) != 1 {
+		return nil, errors.Errorf("unexpected fds: %v", tapFDs)
+	}
+	return os.NewFile(uintptr(tapFDs[0]), tap), nil
+}
+
+// OpenTapHelper is executed in the namespaces of the child, when OpenTapEnvKey is set.
+// OpenTapHelper opens the tap, and sends the FD to FD 3 via SCM_RIGHTS.
+func OpenTapHelper(tap string) error {
+	f, err := os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var ifr struct {
+		name  [unix.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	if len(tap) >= unix.IFNAMSIZ {
+		return errors.Errorf("too long tap name %q", tap)
+	}
+	copy(ifr.name[:], tap)
+	ifr.flags = unix.IFF_TAP | unix.IFF_NO_PI
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.TUNSETIFF, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return errors.Wrapf(errno, "failed to attach to tap %s", tap)
+	}
+	return unix.Sendmsg(3, nil, unix.UnixRights(int(f.Fd())), nil, 0)
+}
@@ -7,6 +7,8 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -14,10 +16,14 @@ import (
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 
+	"github.com/rootless-containers/rootlesskit/pkg/api"
 	"github.com/rootless-containers/rootlesskit/pkg/common"
 	"github.com/rootless-containers/rootlesskit/pkg/network"
 	"github.com/rootless-containers/rootlesskit/pkg/network/iputils"
 	"github.com/rootless-containers/rootlesskit/pkg/network/parentutils"
+	"github.com/rootless-containers/rootlesskit/pkg/procutil"
+	"github.com/rootless-containers/rootlesskit/pkg/seccomp"
+	"github.com/rootless-containers/rootlesskit/pkg/version"
 )
 
 type Features struct {
@@ -31,6 +37,12 @@ type Features struct {
 	SupportsEnableSandbox bool
 	// SupportsEnableSeccomp --enable-seccomp (v0.4.0)
 	SupportsEnableSeccomp bool
+	// SupportsEnableIPv6 --enable-ipv6 (v0.2.0, experimental)
+	SupportsEnableIPv6 bool
+	// SupportsNetnsType --netns-type (v0.4.0)
+	SupportsNetnsType bool
+	// SupportsMACAddress --macaddress (v1.1.0)
+	SupportsMACAddress bool
 	// KernelSupportsSeccomp whether the kernel supports slirp4netns --enable-seccomp
 	KernelSupportsEnableSeccomp bool
 }
@@ -41,7 +53,7 @@ func DetectFeatures(binary string) (*Features, error) {
 	}
 	realBinary, err := exec.LookPath(binary)
 	if err != nil {
-		return nil, errors.Wrapf(err, "slirp4netns binary %q is not installed", binary)
+		return nil, &common.HelperNotFoundError{Helper: binary, Err: errors.Wrapf(err, "slirp4netns binary %q is not installed", binary)}
 	}
 	cmd := exec.Command(realBinary, "--help")
 	cmd.Env = os.Environ()
@@ -62,6 +74,9 @@ func DetectFeatures(binary string) (*Features, error) {
 		SupportsAPISocket:           strings.Contains(s, "--api-socket"),
 		SupportsEnableSandbox:       strings.Contains(s, "--enable-sandbox"),
 		SupportsEnableSeccomp:       strings.Contains(s, "--enable-seccomp"),
+		SupportsEnableIPv6:          strings.Contains(s, "--enable-ipv6"),
+		SupportsNetnsType:           strings.Contains(s, "--netns-type"),
+		SupportsMACAddress:          strings.Contains(s, "--macaddress"),
 		KernelSupportsEnableSeccomp: kernelSupportsEnableSeccomp,
 	}
 	return &f, nil
@@ -75,7 +90,15 @@ func DetectFeatures(binary string) (*Features, error) {
 // apiSocketPath is supported only for slirp4netns v0.3.0+
 // enableSandbox is supported only for slirp4netns v0.4.0+
 // enableSeccomp is supported only for slirp4netns v0.4.0+
-func NewParentDriver(binary string, mtu int, ipnet *net.IPNet, disableHostLoopback bool, apiSocketPath string, enableSandbox, enableSeccomp bool) network.ParentDriver {
+// enableIPv6 requires slirp4netns to support --enable-ipv6.
+// ipv6Only requires enableIPv6, and ipnet MUST be nil for ipv6Only.
+// readyTimeout bounds the wait for the ready FD of slirp4netns. 0 means no timeout.
+// netnsPath is the path of the netns joined by the child, e.g. "/var/run/netns/foo". Empty for the netns created for the child.
+// netnsPath requires slirp4netns to support --netns-type.
+// ifname is the name of the tap device in the child. Empty for DefaultIfName.
+// mac is the MAC address of the tap device. nil for the default of slirp4netns.
+// mac requires slirp4netns to support --macaddress.
+func NewParentDriver(binary string, mtu int, ipnet *net.IPNet, disableHostLoopback bool, apiSocketPath string, enableSandbox, enableSeccomp, enableIPv6, ipv6Only bool, readyTimeout time.Duration, netnsPath, ifname string, mac net.HardwareAddr, helperSeccomp string) network.ParentDriver {
 	if binary == "" {
 		panic("got empty slirp4netns binary")
 	}
@@ -85,6 +108,18 @@ func NewParentDriver(binary string, mtu int, ipnet *net.IPNet, disableHostLoopba
 	if mtu == 0 {
 		mtu = 65520
 	}
+	if ipv6Only && !enableIPv6 {
+		panic("ipv6Only requires enableIPv6")
+	}
+	if ipv6Only && ipnet != nil {
+		panic("ipv6Only is incompatible with ipnet")
+	}
+	if ifname == "" {
+		ifname = DefaultIfName
+	}
+	if err := parentutils.ValidateIfName(ifname); err != nil {
+		panic(err)
+	}
 	return &parentDriver{
 		binary:              binary,
 		mtu:                 mtu,
@@ -93,9 +128,19 @@ func NewParentDriver(binary string, mtu int, ipnet *net.IPNet, disableHostLoopba
 		apiSocketPath:       apiSocketPath,
 		enableSandbox:       enableSandbox,
 		enableSeccomp:       enableSeccomp,
+		enableIPv6:          enableIPv6,
+		ipv6Only:            ipv6Only,
+		readyTimeout:        readyTimeout,
+		netnsPath:           netnsPath,
+		ifname:              ifname,
+		mac:                 mac,
+		helperSeccomp:       helperSeccomp,
 	}
 }
 
+// DefaultIfName is the default name of the tap device in the child.
+const DefaultIfName = "tap0"
+
 type parentDriver struct {
 	binary              string
 	mtu                 int
@@ -104,25 +149,58 @@ type parentDriver struct {
 	apiSocketPath       string
 	enableSandbox       bool
 	enableSeccomp       bool
+	enableIPv6          bool
+	ipv6Only            bool
+	readyTimeout        time.Duration
+	netnsPath           string
+	ifname              string
+	mac                 net.HardwareAddr // can be nil
+	helperSeccomp       string           // the path of the seccomp profile, can be empty
+	helperPID           int32            // accessed atomically, 0 if not running
+	helperVersionOnce   sync.Once
+	helperVersion       string
 }
 
 func (d *parentDriver) MTU() int {
 	return d.mtu
 }
 
+func (d *parentDriver) Info(ctx context.Context) (*api.NetworkDriverInfo, error) {
+	d.helperVersionOnce.Do(func() {
+		v, err := version.DetectHelperVersion(d.binary)
+		if err != nil {
+			logrus.WithError(err).Debug("failed to detect slirp4netns version")
+		}
+		d.helperVersion = v
+	})
+	info := &api.NetworkDriverInfo{
+		Driver:        "slirp4netns",
+		HelperVersion: d.helperVersion,
+	}
+	if pid := atomic.LoadInt32(&d.helperPID); pid != 0 {
+		helper, err := procutil.HelperUsage(int(pid))
+		if err != nil {
+			logrus.WithError(err).Debug("failed to read the resource usage of slirp4netns")
+		} else {
+			info.Helper = helper
+		}
+	}
+	return info, nil
+}
+
 func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.NetworkMessage, func() error, error) {
-	tap := "tap0"
+	tap := d.ifname
 	var cleanups []func() error
 	if err := parentutils.PrepareTap(childPID, tap); err != nil {
 		return nil, common.Seq(cleanups), errors.Wrapf(err, "setting up tap %s", tap)
 	}
-	ctx, cancel := context.WithCancel(context.Background())
 	readyR, readyW, err := os.Pipe()
 	if err != nil {
 		return nil, common.Seq(cleanups), err
 	}
 	defer readyR.Close()
 	defer readyW.Close()
+	ctx, cancel := context.WithCancel(context.Background())
 	// -r: readyFD
 	opts := []string{"--mtu", strconv.Itoa(d.mtu), "-r", "3"}
 	if d.disableHostLoopback {
@@ -140,30 +218,55 @@ func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.
 	if d.enableSeccomp {
 		opts = append(opts, "--enable-seccomp")
 	}
-	cmd := exec.CommandContext(ctx, d.binary, append(opts, []string{strconv.Itoa(childPID), tap}...)...)
+	if d.enableIPv6 {
+		opts = append(opts, "--enable-ipv6")
+	}
+	if d.mac != nil {
+		opts = append(opts, "--macaddress", d.mac.String())
+	}
+	target := strconv.Itoa(childPID)
+	if d.netnsPath != "" {
+		// the netns is owned by the user namespace of RootlessKit, so --userns-path is not needed
+		opts = append(opts, "--netns-type=path")
+		target = d.netnsPath
+	}
+	cmd := exec.CommandContext(ctx, d.binary, append(opts, []string{target, tap}...)...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Pdeathsig: syscall.SIGKILL,
 	}
+	// captured for diagnosing startup failures
+	stderr := common.NewTailBuffer(4096)
+	cmd.Stderr = stderr
 	cmd.ExtraFiles = append(cmd.ExtraFiles, readyW)
+	if d.helperSeccomp != "" {
+		seccomp.Wrap(cmd, d.helperSeccomp)
+	}
 	cleanups = append(cleanups, func() error {
 		logrus.Debugf("killing slirp4netns")
+		atomic.StoreInt32(&d.helperPID, 0)
 		cancel()
 		wErr := cmd.Wait()
 		logrus.Debugf("killed slirp4netns: %v", wErr)
 		return nil
 	})
+	common.LogHelperCmd(cmd)
 	if err := cmd.Start(); err != nil {
 		return nil, common.Seq(cleanups), errors.Wrapf(err, "executing %v", cmd)
 	}
+	atomic.StoreInt32(&d.helperPID, int32(cmd.Process.Pid))
 
-	if err := waitForReadyFD(cmd.Process.Pid, readyR); err != nil {
-		return nil, common.Seq(cleanups), errors.Wrapf(err, "waiting for ready fd (%v)", cmd)
+	if err := waitForReadyFD(cmd.Process.Pid, readyR, d.readyTimeout); err != nil {
+		return nil, common.Seq(cleanups), errors.Wrapf(err, "waiting for ready fd (%v), stderr: %q", cmd, stderr.String())
 	}
 	netmsg := common.NetworkMessage{
 		Dev: tap,
 		MTU: d.mtu,
 	}
-	if d.ipnet != nil {
+	switch {
+	case d.ipv6Only:
+		// the IPv4 stack of slirp4netns is still running,
+		// but the child does not configure the IPv4 address and the route.
+	case d.ipnet != nil:
 		// TODO: get the actual configuration via slirp4netns API?
 		x, err := iputils.AddIPInt(d.ipnet.IP, 100)
 		if err != nil {
@@ -181,21 +284,43 @@ func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.
 			return nil, common.Seq(cleanups), err
 		}
 		netmsg.DNS = x.String()
-	} else {
+	default:
 		netmsg.IP = "10.0.2.100"
 		netmsg.Netmask = 24
 		netmsg.Gateway = "10.0.2.2"
 		netmsg.DNS = "10.0.2.3"
 	}
+	if d.enableIPv6 {
+		// slirp4netns always uses fd00::/64 for IPv6
+		netmsg.IPv6 = "fd00::100"
+		netmsg.IPv6Netmask = 64
+		netmsg.IPv6Gateway = "fd00::2"
+		if d.ipv6Only {
+			netmsg.DNS = "fd00::3"
+		}
+	}
 	return &netmsg, common.Seq(cleanups), nil
 }
 
 // waitForReady is from libpod
 // https://github.com/containers/libpod/blob/e6b843312b93ddaf99d0ef94a7e60ff66bc0eac8/libpod/networking_linux.go#L272-L308
-func waitForReadyFD(cmdPid int, r *os.File) error {
+//
+// timeout is ignored when it is 0.
+func waitForReadyFD(cmdPid int, r *os.File, timeout time.Duration) error {
 	b := make([]byte, 16)
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
 	for {
-		if err := r.SetDeadline(time.Now().Add(1 * time.Second)); err != nil {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return errors.Errorf("timed out after %v", timeout)
+		}
+		readDeadline := time.Now().Add(1 * time.Second)
+		if !deadline.IsZero() && deadline.Before(readDeadline) {
+			readDeadline = deadline
+		}
+		if err := r.SetDeadline(readDeadline); err != nil {
 			return errors.Wrapf(err, "error setting slirp4netns pipe timeout")
 		}
 		if _, err := r.Read(b); err == nil {
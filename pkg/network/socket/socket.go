@@ -0,0 +1,279 @@
+// Package socket provides the network driver that relays the raw Ethernet frames between the tap in the child
+// and an externally supplied socket, so that embedders can implement arbitrary transports (e.g. tunnels)
+// without a dedicated driver.
+//
+// The framing depends on the type of the socket:
+//   - SOCK_DGRAM and SOCK_SEQPACKET: each message is an Ethernet frame, without any header.
+//   - SOCK_STREAM: each Ethernet frame is prefixed with its length in 4 bytes in big endian,
+//     i.e. the same framing as the "stream" (formerly "socket") netdev of QEMU.
+//
+// The frames do not contain the preamble, the FCS, nor the tun_pi header.
+// The peer is responsible for the addressing (ARP, DHCP is not used), the routing, and the DNS.
+package socket
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/api"
+	"github.com/rootless-containers/rootlesskit/pkg/common"
+	"github.com/rootless-containers/rootlesskit/pkg/network"
+	"github.com/rootless-containers/rootlesskit/pkg/network/parentutils"
+)
+
+// MaxFrameSize is the maximum size of a frame, i.e. network.MaxMTU plus the Ethernet header with a VLAN tag.
+const MaxFrameSize = network.MaxMTU + 18
+
+// frameHeaderLen is the length of the header of each frame on SOCK_STREAM.
+const frameHeaderLen = 4
+
+// NewParentDriver instantiates the parent driver.
+// f is the socket (SOCK_STREAM, SOCK_DGRAM, or SOCK_SEQPACKET) over which the frames are exchanged.
+// f is not inherited to the child.
+// ip is the static address of the child in CIDR notation, e.g. "10.0.100.2/24".
+// gateway is optional. mac is the MAC address of the tap in the child. nil for a random address.
+func NewParentDriver(f *os.File, mtu int, ip, gateway string, mac net.HardwareAddr) (network.ParentDriver, error) {
+	if mtu < 0 {
+		return nil, errors.New("got negative mtu")
+	}
+	if mtu == 0 {
+		mtu = 1500
+	}
+	sockType, err := unix.GetsockoptInt(int(f.Fd()), unix.SOL_SOCKET, unix.SO_TYPE)
+	if err != nil {
+		// EBADF if not inherited
+		return nil, errors.Wrapf(err, "FD %d is not an open socket", f.Fd())
+	}
+	switch sockType {
+	case unix.SOCK_STREAM, unix.SOCK_DGRAM, unix.SOCK_SEQPACKET:
+	default:
+		return nil, errors.Errorf("unsupported socket type %d of FD %d, expected SOCK_STREAM, SOCK_DGRAM, or SOCK_SEQPACKET", sockType, f.Fd())
+	}
+	unix.CloseOnExec(int(f.Fd()))
+	if ip == "" {
+		return nil, errors.New("the static IP of the child (e.g. \"10.0.100.2/24\") needs to be specified for the socket driver")
+	}
+	childIP, ipnet, err := net.ParseCIDR(ip)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid IP %q", ip)
+	}
+	if childIP.To4() == nil {
+		return nil, errors.Errorf("IP %q is not an IPv4 address", ip)
+	}
+	d := &parentDriver{
+		f:      f,
+		stream: sockType == unix.SOCK_STREAM,
+		mtu:    mtu,
+		ip:     childIP.To4(),
+		ipnet:  ipnet,
+		mac:    mac,
+	}
+	if gateway != "" {
+		d.gateway = net.ParseIP(gateway).To4()
+		if d.gateway == nil {
+			return nil, errors.Errorf("invalid gateway %q", gateway)
+		}
+		if !ipnet.Contains(d.gateway) {
+			return nil, errors.Errorf("gateway %s is not in %s", d.gateway, ipnet)
+		}
+		if d.gateway.Equal(d.ip) {
+			return nil, errors.Errorf("gateway %s conflicts with the IP of the child", d.gateway)
+		}
+	}
+	return d, nil
+}
+
+type parentDriver struct {
+	f       *os.File
+	stream  bool
+	mtu     int
+	ip      net.IP
+	ipnet   *net.IPNet
+	gateway net.IP           // can be nil
+	mac     net.HardwareAddr // can be nil
+}
+
+func (d *parentDriver) MTU() int {
+	return d.mtu
+}
+
+func (d *parentDriver) Info(ctx context.Context) (*api.NetworkDriverInfo, error) {
+	return &api.NetworkDriverInfo{
+		Driver: "socket",
+	}, nil
+}
+
+func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.NetworkMessage, func() error, error) {
+	var cleanups []func() error
+	tap := "tap0"
+	if err := parentutils.PrepareTap(childPID, tap); err != nil {
+		return nil, common.Seq(cleanups), errors.Wrapf(err, "setting up tap %s", tap)
+	}
+	if d.mac != nil {
+		args := []string{"nsenter", "-t", strconv.Itoa(childPID), "-n", "-U", "--preserve-credentials",
+			"ip", "link", "set", tap, "address", d.mac.String()}
+		if err := common.Execs(os.Stderr, os.Environ(), [][]string{args}); err != nil {
+			return nil, common.Seq(cleanups), errors.Wrapf(err, "executing %v", args)
+		}
+	}
+	tapFile, err := parentutils.OpenTap(childPID, tap)
+	if err != nil {
+		return nil, common.Seq(cleanups), errors.Wrapf(err, "opening tap %s", tap)
+	}
+	tapFile, err = pollable(tapFile)
+	if err != nil {
+		return nil, common.Seq(cleanups), err
+	}
+	sockFile, err := pollable(d.f)
+	if err != nil {
+		tapFile.Close()
+		return nil, common.Seq(cleanups), err
+	}
+	var sock frameReadWriter = &packetConn{sockFile}
+	if d.stream {
+		sock = &streamConn{sockFile}
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := relay(sock, &packetConn{tapFile}); err != nil {
+			logrus.WithError(err).Debugf("stopped relaying frames from %s", tap)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := relay(&packetConn{tapFile}, sock); err != nil {
+			logrus.WithError(err).Warnf("stopped relaying frames to %s", tap)
+		}
+	}()
+	cleanups = append(cleanups, func() error {
+		// closing the pollable files interrupts the reads
+		tapFile.Close()
+		sockFile.Close()
+		wg.Wait()
+		return nil
+	})
+	netmsg := common.NetworkMessage{
+		Dev: tap,
+		IP:  d.ip.String(),
+		MTU: d.mtu,
+		// DNS is empty: /etc/resolv.conf of the parent is kept
+	}
+	netmsg.Netmask, _ = d.ipnet.Mask.Size()
+	if d.gateway != nil {
+		netmsg.Gateway = d.gateway.String()
+	}
+	return &netmsg, common.Seq(cleanups), nil
+}
+
+// pollable returns the non-blocking duplicate of f, so that Close interrupts the pending Read. f is closed.
+func pollable(f *os.File) (*os.File, error) {
+	defer f.Close()
+	fd, err := unix.Dup(int(f.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	unix.CloseOnExec(fd)
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
+
+// frameReadWriter reads and writes Ethernet frames.
+type frameReadWriter interface {
+	// ReadFrame reads a frame into b, and returns the length of the frame.
+	ReadFrame(b []byte) (int, error)
+	WriteFrame(b []byte) error
+}
+
+// packetConn is a frameReadWriter for the tap, SOCK_DGRAM, and SOCK_SEQPACKET.
+type packetConn struct {
+	io.ReadWriter
+}
+
+func (c *packetConn) ReadFrame(b []byte) (int, error) {
+	return c.Read(b)
+}
+
+func (c *packetConn) WriteFrame(b []byte) error {
+	_, err := c.Write(b)
+	return err
+}
+
+// streamConn is a frameReadWriter for SOCK_STREAM, with the 4-byte big endian length header.
+type streamConn struct {
+	io.ReadWriter
+}
+
+func (c *streamConn) ReadFrame(b []byte) (int, error) {
+	var hdr [frameHeaderLen]byte
+	if _, err := io.ReadFull(c, hdr[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint32(hdr[:]))
+	if n > len(b) {
+		return 0, errors.Errorf("too large frame: %d bytes", n)
+	}
+	return io.ReadFull(c, b[:n])
+}
+
+func (c *streamConn) WriteFrame(b []byte) error {
+	buf := make([]byte, frameHeaderLen+len(b))
+	binary.BigEndian.PutUint32(buf, uint32(len(b)))
+	copy(buf[frameHeaderLen:], b)
+	_, err := c.Write(buf)
+	return err
+}
+
+// relay copies the frames from src to dst until src fails.
+// The frames that cannot be written to a packet dst are dropped, as with a lossy link.
+// A failure of writing to a stream dst stops the relay, as a partially written frame
+// breaks the framing of the rest of the stream.
+func relay(dst, src frameReadWriter) error {
+	buf := make([]byte, MaxFrameSize)
+	for {
+		n, err := src.ReadFrame(buf)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		if err := dst.WriteFrame(buf[:n]); err != nil {
+			if _, ok := dst.(*streamConn); ok {
+				logrus.WithError(err).Warn("failed to write a frame to the stream socket, stopping the relay")
+				return err
+			}
+			logrus.WithError(err).Debug("dropped a frame")
+		}
+	}
+}
+
+// NewChildDriver instantiates the child driver.
+func NewChildDriver() network.ChildDriver {
+	return &childDriver{}
+}
+
+type childDriver struct {
+}
+
+func (d *childDriver) ConfigureNetworkChild(netmsg *common.NetworkMessage) (string, error) {
+	// the tap has been already created by the parent.
+	// the address is configured by pkg/child.
+	if netmsg.Dev == "" {
+		return "", errors.New("could not determine the dev")
+	}
+	return netmsg.Dev, nil
+}
@@ -0,0 +1,157 @@
+package socket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func socketpair(t *testing.T, typ int) (*os.File, *os.File) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, typ|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return os.NewFile(uintptr(fds[0]), "a"), os.NewFile(uintptr(fds[1]), "b")
+}
+
+func TestNewParentDriver(t *testing.T) {
+	testCases := []struct {
+		typ     int
+		ip      string
+		gateway string
+		ok      bool
+	}{
+		{unix.SOCK_STREAM, "10.0.100.2/24", "", true},
+		{unix.SOCK_DGRAM, "10.0.100.2/24", "10.0.100.1", true},
+		{unix.SOCK_SEQPACKET, "10.0.100.2/24", "10.0.100.1", true},
+		{unix.SOCK_STREAM, "", "", false},
+		{unix.SOCK_STREAM, "10.0.100.2", "", false},
+		{unix.SOCK_STREAM, "fd00::2/64", "", false},
+		{unix.SOCK_STREAM, "10.0.100.2/24", "10.0.200.1", false},
+		{unix.SOCK_STREAM, "10.0.100.2/24", "10.0.100.2", false},
+		{unix.SOCK_STREAM, "10.0.100.2/24", "foo", false},
+	}
+	for _, tc := range testCases {
+		a, b := socketpair(t, tc.typ)
+		_, err := NewParentDriver(a, 0, tc.ip, tc.gateway, nil)
+		if tc.ok && err != nil {
+			t.Errorf("%+v: unexpected error: %v", tc, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%+v: expected an error", tc)
+		}
+		a.Close()
+		b.Close()
+	}
+
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := NewParentDriver(f, 0, "10.0.100.2/24", "", nil); err == nil {
+		t.Error("expected an error for a non-socket FD")
+	}
+}
+
+func TestStreamConn(t *testing.T) {
+	var buf bytes.Buffer
+	c := &streamConn{&buf}
+	frames := [][]byte{[]byte("foo"), []byte("barbaz")}
+	for _, f := range frames {
+		if err := c.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []byte{0, 0, 0, 3, 'f', 'o', 'o', 0, 0, 0, 6, 'b', 'a', 'r', 'b', 'a', 'z'}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("expected %v, got %v", expected, buf.Bytes())
+	}
+	b := make([]byte, MaxFrameSize)
+	for _, f := range frames {
+		n, err := c.ReadFrame(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b[:n], f) {
+			t.Fatalf("expected %q, got %q", f, b[:n])
+		}
+	}
+	if _, err := c.ReadFrame(b); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	var hdr [frameHeaderLen]byte
+	binary.BigEndian.PutUint32(hdr[:], MaxFrameSize+1)
+	buf.Write(hdr[:])
+	if _, err := c.ReadFrame(b); err == nil {
+		t.Fatal("expected an error for a too large frame")
+	}
+}
+
+func TestRelay(t *testing.T) {
+	// tapA-tapB emulates the tap, sockA-sockB emulates the socket supplied with --net-fd
+	tapA, tapB := socketpair(t, unix.SOCK_SEQPACKET)
+	defer tapA.Close()
+	defer tapB.Close()
+	sockA, sockB := socketpair(t, unix.SOCK_STREAM)
+	defer sockA.Close()
+	defer sockB.Close()
+	go relay(&streamConn{sockA}, &packetConn{tapA})
+	go relay(&packetConn{tapA}, &streamConn{sockA})
+
+	frame := bytes.Repeat([]byte{0x42}, 1500)
+	if _, err := tapB.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	peer := &streamConn{sockB}
+	b := make([]byte, MaxFrameSize)
+	n, err := peer.ReadFrame(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[:n], frame) {
+		t.Fatalf("unexpected frame from the tap (%d bytes)", n)
+	}
+
+	frame = []byte("hello")
+	if err := peer.WriteFrame(frame); err != nil {
+		t.Fatal(err)
+	}
+	n, err = tapB.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[:n], frame) {
+		t.Fatalf("expected %q, got %q", frame, b[:n])
+	}
+}
+
+func TestRelayStreamClosed(t *testing.T) {
+	tapA, tapB := socketpair(t, unix.SOCK_SEQPACKET)
+	defer tapA.Close()
+	defer tapB.Close()
+	sockA, sockB := socketpair(t, unix.SOCK_STREAM)
+	defer sockA.Close()
+	sockB.Close()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- relay(&streamConn{sockA}, &packetConn{tapA})
+	}()
+	if _, err := tapB.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("relay did not stop after the peer of the stream was closed")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
+	"github.com/rootless-containers/rootlesskit/pkg/api"
 	"github.com/rootless-containers/rootlesskit/pkg/common"
 	"github.com/rootless-containers/rootlesskit/pkg/network"
 	"github.com/rootless-containers/rootlesskit/pkg/network/parentutils"
@@ -40,6 +41,12 @@ func (d *parentDriver) MTU() int {
 	return d.mtu
 }
 
+func (d *parentDriver) Info(ctx context.Context) (*api.NetworkDriverInfo, error) {
+	return &api.NetworkDriverInfo{
+		Driver: "vdeplug_slirp",
+	}, nil
+}
+
 func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.NetworkMessage, func() error, error) {
 	tap := "tap0"
 	var cleanups []func() error
@@ -60,6 +67,7 @@ func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.
 		logrus.Debugf("killed vde_plug(slirp): %v", wErr)
 		return nil
 	})
+	common.LogHelperCmd(slirpCmd)
 	if err := slirpCmd.Start(); err != nil {
 		return nil, common.Seq(cleanups), errors.Wrapf(err, "executing %v", slirpCmd)
 	}
@@ -78,6 +86,7 @@ func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.
 		logrus.Debugf("killed vde_plug(tap): %v", wErr)
 		return nil
 	})
+	common.LogHelperCmd(tapCmd)
 	if err := tapCmd.Start(); err != nil {
 		return nil, common.Seq(cleanups), errors.Wrapf(err, "executing %v", tapCmd)
 	}
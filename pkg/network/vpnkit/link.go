@@ -0,0 +1,152 @@
+package vpnkit
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// vifConn is implemented by *vmnet.Vif
+type vifConn interface {
+	Read() ([]byte, error)
+	Write(packet []byte) error
+}
+
+// vifConnector connects to VPNKit, and returns the vif along with the closer of the underlying connection.
+type vifConnector func() (vifConn, io.Closer, error)
+
+// link relays the ethernet frames between the tap and the VPNKit vif.
+// When the connection to VPNKit is lost, link reconnects to VPNKit up to maxRetries times.
+type link struct {
+	tap        io.ReadWriter
+	connect    vifConnector
+	maxRetries int
+	// retryInterval is multiplied by the number of the retries
+	retryInterval time.Duration
+
+	mu     sync.Mutex
+	vif    vifConn
+	closer io.Closer
+	lost   chan struct{}
+}
+
+func newLink(tap io.ReadWriter, vif vifConn, closer io.Closer, connect vifConnector, maxRetries int) *link {
+	return &link{
+		tap:           tap,
+		connect:       connect,
+		maxRetries:    maxRetries,
+		retryInterval: 100 * time.Millisecond,
+		vif:           vif,
+		closer:        closer,
+		lost:          make(chan struct{}, 1),
+	}
+}
+
+// run blocks until the tap is closed, or reconnecting to VPNKit fails.
+func (l *link) run() error {
+	tapErr := make(chan error, 1)
+	go func() {
+		tapErr <- l.tap2vif()
+	}()
+	for {
+		l.mu.Lock()
+		vif := l.vif
+		l.mu.Unlock()
+		go l.vif2tap(vif)
+		select {
+		case err := <-tapErr:
+			return err
+		case <-l.lost:
+		}
+		if err := l.reconnect(); err != nil {
+			return err
+		}
+	}
+}
+
+// current returns the current vif
+func (l *link) current() vifConn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.vif
+}
+
+// markLost signals that the connection is lost, unless vif has been already replaced
+func (l *link) markLost(vif vifConn, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.vif != vif {
+		return
+	}
+	logrus.WithError(err).Warn("lost the connection to VPNKit")
+	select {
+	case l.lost <- struct{}{}:
+	default:
+	}
+}
+
+// reconnect does not hold l.mu while sleeping and connecting, so that tap2vif and markLost are not blocked
+// during the outage. The packets written to the old vif in the meantime are dropped.
+func (l *link) reconnect() error {
+	l.mu.Lock()
+	closer := l.closer
+	l.closer = nil
+	l.mu.Unlock()
+	if closer != nil {
+		closer.Close()
+	}
+	var lastErr error
+	for i := 0; i < l.maxRetries; i++ {
+		time.Sleep(time.Duration(i+1) * l.retryInterval)
+		vif, closer, err := l.connect()
+		if err != nil {
+			logrus.WithError(err).Warnf("failed to reconnect to VPNKit (%d/%d)", i+1, l.maxRetries)
+			lastErr = err
+			continue
+		}
+		logrus.Infof("reconnected to VPNKit (%d/%d)", i+1, l.maxRetries)
+		l.mu.Lock()
+		l.vif, l.closer = vif, closer
+		// drain the stale signal from the old vif.
+		// markLost does not signal for the old vif any more, as l.vif has been replaced.
+		select {
+		case <-l.lost:
+		default:
+		}
+		l.mu.Unlock()
+		return nil
+	}
+	return errors.Errorf("failed to reconnect to VPNKit after %d retries, last error: %v", l.maxRetries, lastErr)
+}
+
+func (l *link) tap2vif() error {
+	b := make([]byte, 65536)
+	for {
+		n, err := l.tap.Read(b)
+		if err != nil {
+			return errors.Wrap(err, "tap2vif: read")
+		}
+		vif := l.current()
+		if err := vif.Write(b[:n]); err != nil {
+			// the packet is dropped
+			l.markLost(vif, errors.Wrap(err, "tap2vif: write"))
+		}
+	}
+}
+
+func (l *link) vif2tap(vif vifConn) {
+	for {
+		b, err := vif.Read()
+		if err != nil {
+			l.markLost(vif, errors.Wrap(err, "vif2tap: read"))
+			return
+		}
+		if _, err := l.tap.Write(b); err != nil {
+			// tap errors are fatal, as in tap2vif
+			panic(errors.Wrap(err, "vif2tap: write"))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package vpnkit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fakeTap is an io.ReadWriter backed by channels
+type fakeTap struct {
+	in  chan []byte // read by the link
+	out chan []byte // written by the link
+}
+
+func (t *fakeTap) Read(b []byte) (int, error) {
+	p, ok := <-t.in
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(b, p), nil
+}
+
+func (t *fakeTap) Write(b []byte) (int, error) {
+	t.out <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+// fakeVif simulates a connection to VPNKit. Closing in simulates a connection drop.
+type fakeVif struct {
+	in  chan []byte // read by the link
+	out chan []byte // written by the link
+}
+
+func newFakeVif() *fakeVif {
+	return &fakeVif{
+		in:  make(chan []byte),
+		out: make(chan []byte, 16),
+	}
+}
+
+func (v *fakeVif) Read() ([]byte, error) {
+	p, ok := <-v.in
+	if !ok {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return p, nil
+}
+
+func (v *fakeVif) Write(p []byte) error {
+	v.out <- append([]byte(nil), p...)
+	return nil
+}
+
+func (v *fakeVif) Close() error {
+	return nil
+}
+
+func expectPacket(t *testing.T, ch <-chan []byte, expected string) {
+	t.Helper()
+	select {
+	case p := <-ch:
+		if !bytes.Equal(p, []byte(expected)) {
+			t.Fatalf("expected %q, got %q", expected, string(p))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %q", expected)
+	}
+}
+
+func TestLinkReconnect(t *testing.T) {
+	tap := &fakeTap{in: make(chan []byte), out: make(chan []byte, 16)}
+	vif1, vif2 := newFakeVif(), newFakeVif()
+	connects := 0
+	connect := func() (vifConn, io.Closer, error) {
+		connects++
+		if connects == 1 {
+			// the first retry fails, as VPNKit may not be ready yet
+			return nil, nil, errors.New("transient error")
+		}
+		return vif2, vif2, nil
+	}
+	l := newLink(tap, vif1, vif1, connect, 3)
+	l.retryInterval = time.Millisecond
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- l.run()
+	}()
+
+	vif1.in <- []byte("vif1->tap")
+	expectPacket(t, tap.out, "vif1->tap")
+	tap.in <- []byte("tap->vif1")
+	expectPacket(t, vif1.out, "tap->vif1")
+
+	// simulate a transient drop
+	close(vif1.in)
+	vif2.in <- []byte("vif2->tap")
+	expectPacket(t, tap.out, "vif2->tap")
+	tap.in <- []byte("tap->vif2")
+	expectPacket(t, vif2.out, "tap->vif2")
+	if connects != 2 {
+		t.Fatalf("expected 2 connection attempts, got %d", connects)
+	}
+
+	close(tap.in)
+	if err := <-errCh; err == nil {
+		t.Fatal("expected an error after closing the tap")
+	}
+}
+
+func TestLinkReconnectGiveUp(t *testing.T) {
+	tap := &fakeTap{in: make(chan []byte), out: make(chan []byte, 16)}
+	vif1 := newFakeVif()
+	connects := 0
+	connect := func() (vifConn, io.Closer, error) {
+		connects++
+		return nil, nil, errors.New("VPNKit is gone")
+	}
+	l := newLink(tap, vif1, vif1, connect, 3)
+	l.retryInterval = time.Millisecond
+	close(vif1.in)
+	if err := l.run(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if connects != 3 {
+		t.Fatalf("expected 3 connection attempts, got %d", connects)
+	}
+}
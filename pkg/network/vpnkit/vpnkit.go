@@ -3,10 +3,13 @@ package vpnkit
 import (
 	"context"
 	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,11 +19,19 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
+	"github.com/rootless-containers/rootlesskit/pkg/api"
 	"github.com/rootless-containers/rootlesskit/pkg/common"
 	"github.com/rootless-containers/rootlesskit/pkg/network"
+	"github.com/rootless-containers/rootlesskit/pkg/procutil"
+	"github.com/rootless-containers/rootlesskit/pkg/seccomp"
+	"github.com/rootless-containers/rootlesskit/pkg/version"
 )
 
-func NewParentDriver(binary string, mtu int, disableHostLoopback bool) network.ParentDriver {
+// DefaultReadyTimeout is the default timeout for waiting for the ethernet socket of VPNKit to be ready.
+const DefaultReadyTimeout = 10 * time.Second
+
+// readyTimeout bounds the wait for the ethernet socket of VPNKit. 0 means DefaultReadyTimeout.
+func NewParentDriver(binary string, mtu int, disableHostLoopback bool, readyTimeout time.Duration, helperSeccomp string) network.ParentDriver {
 	if binary == "" {
 		panic("got empty vpnkit binary")
 	}
@@ -34,10 +45,18 @@ func NewParentDriver(binary string, mtu int, disableHostLoopback bool) network.P
 		logrus.Warnf("vpnkit is known to have issues with non-1500 MTU (current: %d), see https://github.com/rootless-containers/rootlesskit/issues/6#issuecomment-403531453", mtu)
 		// NOTE: iperf3 stops working with MTU >= 16425
 	}
+	if readyTimeout < 0 {
+		panic("got negative readyTimeout")
+	}
+	if readyTimeout == 0 {
+		readyTimeout = DefaultReadyTimeout
+	}
 	return &parentDriver{
 		binary:              binary,
 		mtu:                 mtu,
 		disableHostLoopback: disableHostLoopback,
+		readyTimeout:        readyTimeout,
+		helperSeccomp:       helperSeccomp,
 	}
 }
 
@@ -51,12 +70,40 @@ type parentDriver struct {
 	binary              string
 	mtu                 int
 	disableHostLoopback bool
+	readyTimeout        time.Duration
+	helperSeccomp       string // the path of the seccomp profile, can be empty
+	helperPID           int32  // accessed atomically, 0 if not running
+	helperVersionOnce   sync.Once
+	helperVersion       string
 }
 
 func (d *parentDriver) MTU() int {
 	return d.mtu
 }
 
+func (d *parentDriver) Info(ctx context.Context) (*api.NetworkDriverInfo, error) {
+	d.helperVersionOnce.Do(func() {
+		v, err := version.DetectHelperVersion(d.binary)
+		if err != nil {
+			logrus.WithError(err).Debug("failed to detect vpnkit version")
+		}
+		d.helperVersion = v
+	})
+	info := &api.NetworkDriverInfo{
+		Driver:        "vpnkit",
+		HelperVersion: d.helperVersion,
+	}
+	if pid := atomic.LoadInt32(&d.helperPID); pid != 0 {
+		helper, err := procutil.HelperUsage(int(pid))
+		if err != nil {
+			logrus.WithError(err).Debug("failed to read the resource usage of vpnkit")
+		} else {
+			info.Helper = helper
+		}
+	}
+	return info, nil
+}
+
 func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.NetworkMessage, func() error, error) {
 	var cleanups []func() error
 	vpnkitSocket := filepath.Join(stateDir, "vpnkit-ethernet.sock")
@@ -68,21 +115,33 @@ func (d *parentDriver) ConfigureNetwork(childPID int, stateDir string) (*common.
 	vpnkitCmd.SysProcAttr = &syscall.SysProcAttr{
 		Pdeathsig: syscall.SIGKILL,
 	}
+	// captured for diagnosing startup failures
+	stderr := common.NewTailBuffer(4096)
+	vpnkitCmd.Stderr = stderr
+	if d.helperSeccomp != "" {
+		seccomp.Wrap(vpnkitCmd, d.helperSeccomp)
+	}
 	cleanups = append(cleanups, func() error {
 		logrus.Debugf("killing vpnkit")
+		atomic.StoreInt32(&d.helperPID, 0)
 		vpnkitCancel()
 		wErr := vpnkitCmd.Wait()
 		logrus.Debugf("killed vpnkit: %v", wErr)
 		return nil
 	})
+	common.LogHelperCmd(vpnkitCmd)
 	if err := vpnkitCmd.Start(); err != nil {
 		return nil, common.Seq(cleanups), errors.Wrapf(err, "executing %v", vpnkitCmd)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	atomic.StoreInt32(&d.helperPID, int32(vpnkitCmd.Process.Pid))
+	ctx, cancel := context.WithTimeout(context.Background(), d.readyTimeout)
 	cleanups = append(cleanups, func() error { cancel(); return nil })
 	vmnet, err := waitForVPNKit(ctx, vpnkitSocket)
 	if err != nil {
-		return nil, common.Seq(cleanups), errors.Wrapf(err, "connecting to %s", vpnkitSocket)
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, common.Seq(cleanups), errors.Wrapf(err, "vpnkit did not become ready within %v (%v), stderr: %q", d.readyTimeout, vpnkitCmd, stderr.String())
+		}
+		return nil, common.Seq(cleanups), errors.Wrapf(err, "connecting to %s, stderr: %q", vpnkitSocket, stderr.String())
 	}
 	cleanups = append(cleanups, func() error { return vmnet.Close() })
 	vifUUID := uuid.New()
@@ -127,11 +186,17 @@ func waitForVPNKit(ctx context.Context, socket string) (*vmnet.Vmnet, error) {
 	}
 }
 
-func NewChildDriver() network.ChildDriver {
-	return &childDriver{}
+// NewChildDriver instantiates new child driver.
+// reconnectRetries is the number of the retries for reconnecting to VPNKit when the connection is lost.
+// 0 disables reconnection.
+func NewChildDriver(reconnectRetries int) network.ChildDriver {
+	return &childDriver{
+		reconnectRetries: reconnectRetries,
+	}
 }
 
 type childDriver struct {
+	reconnectRetries int
 }
 
 func (d *childDriver) ConfigureNetworkChild(netmsg *common.NetworkMessage) (tap string, err error) {
@@ -151,10 +216,10 @@ func (d *childDriver) ConfigureNetworkChild(netmsg *common.NetworkMessage) (tap
 	if uuidStr == "" {
 		return "", errors.New("no VPNKit UUID is set")
 	}
-	return startVPNKitRoutines(context.TODO(), tapName, macStr, socket, uuidStr)
+	return d.startVPNKitRoutines(context.TODO(), tapName, macStr, socket, uuidStr, net.ParseIP(netmsg.IP))
 }
 
-func startVPNKitRoutines(ctx context.Context, tapName, macStr, socket, uuidStr string) (string, error) {
+func (d *childDriver) startVPNKitRoutines(ctx context.Context, tapName, macStr, socket, uuidStr string, ip net.IP) (string, error) {
 	cmds := [][]string{
 		{"ip", "tuntap", "add", "name", tapName, "mode", "tap"},
 		{"ip", "link", "set", tapName, "address", macStr},
@@ -170,44 +235,46 @@ func startVPNKitRoutines(ctx context.Context, tapName, macStr, socket, uuidStr s
 	if tap.Name() != tapName {
 		return "", errors.Wrapf(err, "expected %q, got %q", tapName, tap.Name())
 	}
-	vmnet, err := vmnet.New(ctx, socket)
-	if err != nil {
-		return "", err
-	}
 	vifUUID, err := uuid.Parse(uuidStr)
 	if err != nil {
 		return "", err
 	}
-	vif, err := vmnet.ConnectVif(vifUUID)
-	if err != nil {
-		return "", err
-	}
-	go tap2vif(vif, tap)
-	go vif2tap(tap, vif)
-	return tapName, nil
-}
-
-func tap2vif(vif *vmnet.Vif, r io.Reader) {
-	b := make([]byte, 65536)
-	for {
-		n, err := r.Read(b)
+	connect := func() (vifConn, io.Closer, error) {
+		vm, err := vmnet.New(ctx, socket)
 		if err != nil {
-			panic(errors.Wrap(err, "tap2vif: read"))
+			return nil, nil, err
 		}
-		if err := vif.Write(b[:n]); err != nil {
-			panic(errors.Wrap(err, "tap2vif: write"))
+		var vif *vmnet.Vif
+		if ip != nil {
+			// request the same IP so that the IP of the tap does not need to be reconfigured on reconnection
+			vif, err = vm.ConnectVifIP(vifUUID, ip)
+		} else {
+			vif, err = vm.ConnectVif(vifUUID)
 		}
-	}
-}
-
-func vif2tap(w io.Writer, vif *vmnet.Vif) {
-	for {
-		b, err := vif.Read()
 		if err != nil {
-			panic(errors.Wrap(err, "vif2tap: read"))
+			vm.Close()
+			return nil, nil, err
 		}
-		if _, err := w.Write(b); err != nil {
-			panic(errors.Wrap(err, "vif2tap: write"))
+		if mac := vif.ClientMAC.String(); mac != macStr {
+			logrus.Warnf("VPNKit MAC address changed from %s to %s, reconfiguring %s", macStr, mac, tapName)
+			cmds := [][]string{{"ip", "link", "set", tapName, "address", mac}}
+			if err := common.Execs(os.Stderr, os.Environ(), cmds); err != nil {
+				vm.Close()
+				return nil, nil, errors.Wrapf(err, "executing %v", cmds)
+			}
+			macStr = mac
 		}
+		return vif, vm, nil
+	}
+	vif, closer, err := connect()
+	if err != nil {
+		return "", err
 	}
+	l := newLink(tap, vif, closer, connect, d.reconnectRetries)
+	go func() {
+		if err := l.run(); err != nil {
+			panic(err)
+		}
+	}()
+	return tapName, nil
 }
@@ -0,0 +1,300 @@
+package parent
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+// DefaultAutoPublishInterval is the default interval of polling the listening sockets in the child.
+const DefaultAutoPublishInterval = time.Second
+
+// AutoPublishOpt is the option for publishing the listening TCP ports in the child automatically.
+type AutoPublishOpt struct {
+	// Interval is the interval of polling the listening sockets in the child.
+	Interval time.Duration
+	// Ports are the ranges of the ports to be published. Empty for all the ports.
+	Ports []PortRange
+}
+
+// PortRange is a range of ports. Start and End are inclusive.
+type PortRange struct {
+	Start int
+	End   int
+}
+
+func (r PortRange) contains(p int) bool {
+	return r.Start <= p && p <= r.End
+}
+
+// ParsePortRanges parses the comma-separated port ranges, e.g. "80,8000-8999".
+func ParsePortRanges(s string) ([]PortRange, error) {
+	var ranges []PortRange
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		startS, endS := f, f
+		if i := strings.Index(f, "-"); i >= 0 {
+			startS, endS = f[:i], f[i+1:]
+		}
+		start, err := strconv.Atoi(startS)
+		if err != nil {
+			return nil, errors.Errorf("invalid port range %q", f)
+		}
+		end, err := strconv.Atoi(endS)
+		if err != nil {
+			return nil, errors.Errorf("invalid port range %q", f)
+		}
+		if start < 1 || end > 65535 || start > end {
+			return nil, errors.Errorf("invalid port range %q", f)
+		}
+		ranges = append(ranges, PortRange{Start: start, End: end})
+	}
+	return ranges, nil
+}
+
+// tcpListener is a listening TCP socket read from /proc/net/tcp{,6}.
+type tcpListener struct {
+	IP   net.IP
+	Port int
+}
+
+// tcpStateListen is TCP_LISTEN in /proc/net/tcp{,6}
+const tcpStateListen = "0A"
+
+// parseProcNetTCP parses /proc/net/tcp or /proc/net/tcp6, and returns the listening sockets.
+func parseProcNetTCP(r io.Reader) ([]tcpListener, error) {
+	var listeners []tcpListener
+	scanner := bufio.NewScanner(r)
+	for i := 0; scanner.Scan(); i++ {
+		fields := strings.Fields(scanner.Text())
+		if i == 0 || len(fields) < 4 {
+			// header
+			continue
+		}
+		if fields[3] != tcpStateListen {
+			continue
+		}
+		colon := strings.LastIndex(fields[1], ":")
+		if colon < 0 {
+			return nil, errors.Errorf("unexpected local address %q", fields[1])
+		}
+		ip, err := decodeProcNetIP(fields[1][:colon])
+		if err != nil {
+			return nil, err
+		}
+		p, err := strconv.ParseUint(fields[1][colon+1:], 16, 16)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unexpected local address %q", fields[1])
+		}
+		listeners = append(listeners, tcpListener{IP: ip, Port: int(p)})
+	}
+	return listeners, scanner.Err()
+}
+
+// decodeProcNetIP decodes the address in /proc/net/tcp{,6}, printed as 32-bit words in the host byte order.
+func decodeProcNetIP(s string) (net.IP, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, errors.Errorf("unexpected address %q", s)
+	}
+	ip := make(net.IP, len(b))
+	for i := 0; i < len(b); i += 4 {
+		binary.NativeEndian.PutUint32(ip[i:i+4], binary.BigEndian.Uint32(b[i:i+4]))
+	}
+	return ip, nil
+}
+
+// autoPublisher publishes the listening TCP ports of the child on the same ports of the parent.
+// The ports published via other ways (PublishPorts, PublishFile, and the API) are not affected.
+type autoPublisher struct {
+	opt    AutoPublishOpt
+	driver port.Manager
+	// procNetTCP are the paths of /proc/net/tcp{,6} in the child netns
+	procNetTCP []string
+	// ids are the port IDs of the published ports, keyed by the port number
+	ids   map[int]int
+	specs map[int]port.Spec
+	// failed are the specs that could not be published, so as to avoid retrying them on every poll
+	failed map[int]port.Spec
+}
+
+func (a *autoPublisher) allowed(p int) bool {
+	if len(a.opt.Ports) == 0 {
+		return true
+	}
+	for _, r := range a.opt.Ports {
+		if r.contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// desired returns the specs for the listening sockets in the child, keyed by the port number.
+func (a *autoPublisher) desired() (map[int]port.Spec, error) {
+	var listeners []tcpListener
+	for _, path := range a.procNetTCP {
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// e.g. tcp6 without IPv6
+				continue
+			}
+			return nil, err
+		}
+		l, err := parseProcNetTCP(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", path)
+		}
+		listeners = append(listeners, l...)
+	}
+	specs := make(map[int]port.Spec)
+	for _, l := range listeners {
+		if !a.allowed(l.Port) {
+			continue
+		}
+		sp := port.Spec{
+			Proto:      "tcp",
+			ParentPort: l.Port,
+			ChildPort:  l.Port,
+		}
+		switch {
+		case l.IP.IsUnspecified():
+			// the default child IP (127.0.0.1) is reachable
+		case l.IP.To4() == nil:
+			// IPv6-only addresses other than "::" are not reachable via the child IP
+			continue
+		case l.IP.IsLoopback():
+			// loopback-only in the child, so loopback-only in the parent as well
+			sp.ParentIP = "127.0.0.1"
+			if !l.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+				sp.ChildIP = l.IP.String()
+			}
+		default:
+			sp.ChildIP = l.IP.String()
+		}
+		if prev, ok := specs[l.Port]; ok && specRank(prev) <= specRank(sp) {
+			continue
+		}
+		specs[l.Port] = sp
+	}
+	return specs, nil
+}
+
+// specRank ranks the specs for the listeners on the same port, the lower the more preferred:
+// the wildcard address, the specific addresses, and then the loopback addresses.
+func specRank(sp port.Spec) int {
+	switch {
+	case sp.ParentIP != "":
+		return 2
+	case sp.ChildIP != "":
+		return 1
+	default:
+		return 0
+	}
+}
+
+// reconcile adds and removes the ports to match the listening sockets in the child.
+func (a *autoPublisher) reconcile(ctx context.Context) error {
+	desired, err := a.desired()
+	if err != nil {
+		return err
+	}
+	for p, id := range a.ids {
+		if sp, ok := desired[p]; ok && sp == a.specs[p] {
+			continue
+		}
+		if err := a.driver.RemovePort(ctx, id); err != nil {
+			// e.g. already removed via the API
+			logrus.WithError(err).Debugf("auto-publish: failed to remove port %+v", a.specs[p])
+		} else {
+			logrus.Infof("auto-publish: removed port %+v", a.specs[p])
+		}
+		delete(a.ids, p)
+		delete(a.specs, p)
+	}
+	for p, sp := range a.failed {
+		if d, ok := desired[p]; !ok || d != sp {
+			delete(a.failed, p)
+		}
+	}
+	var ports []int
+	for p := range desired {
+		ports = append(ports, p)
+	}
+	sort.Ints(ports)
+	for _, p := range ports {
+		sp := desired[p]
+		if _, ok := a.ids[p]; ok {
+			continue
+		}
+		if _, ok := a.failed[p]; ok {
+			continue
+		}
+		st, err := a.driver.AddPort(ctx, sp)
+		if err != nil {
+			logrus.WithError(err).Warnf("auto-publish: failed to add port %+v", sp)
+			a.failed[p] = sp
+			continue
+		}
+		a.ids[p] = st.ID
+		a.specs[p] = sp
+		logrus.Infof("auto-publish: added port %+v (ID %d)", sp, st.ID)
+	}
+	return nil
+}
+
+// startAutoPublish polls the listening TCP sockets in the netns of childPID, and publishes them.
+// The returned function stops polling. The published ports are kept, as they are removed on shutting down the port driver.
+func startAutoPublish(opt AutoPublishOpt, driver port.Manager, childPID int) func() {
+	if opt.Interval <= 0 {
+		opt.Interval = DefaultAutoPublishInterval
+	}
+	a := &autoPublisher{
+		opt:    opt,
+		driver: driver,
+		procNetTCP: []string{
+			fmt.Sprintf("/proc/%d/net/tcp", childPID),
+			fmt.Sprintf("/proc/%d/net/tcp6", childPID),
+		},
+		ids:    make(map[int]int),
+		specs:  make(map[int]port.Spec),
+		failed: make(map[int]port.Spec),
+	}
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(opt.Interval)
+		defer ticker.Stop()
+		for {
+			if err := a.reconcile(context.TODO()); err != nil {
+				logrus.WithError(err).Warn("auto-publish: failed to read the listening sockets")
+			}
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(stopCh)
+		<-doneCh
+	}
+}
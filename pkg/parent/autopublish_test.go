@@ -0,0 +1,189 @@
+package parent
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+func TestParsePortRanges(t *testing.T) {
+	testCases := map[string][]PortRange{
+		"80":             {{80, 80}},
+		"80,8000-8999":   {{80, 80}, {8000, 8999}},
+		" 1-65535 ":      {{1, 65535}},
+		"":               nil,
+		"0":              nil,
+		"65536":          nil,
+		"8999-8000":      nil,
+		"80-":            nil,
+		"http":           nil,
+		"80,,81":         nil,
+		"1024-65535,443": {{1024, 65535}, {443, 443}},
+	}
+	for s, expected := range testCases {
+		got, err := ParsePortRanges(s)
+		if expected == nil {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("%q: expected %+v, got %+v", s, expected, got)
+		}
+	}
+}
+
+// procNetTCP is an excerpt of /proc/net/tcp on a little-endian host
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 2 1 0000000000000000 100 0 0 10 0
+   2: 6402000A:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 3 1 0000000000000000 100 0 0 10 0
+   3: 0100007F:0CEA 0100007F:D2F0 01 00000000:00000000 00:00000000 00000000  1000        0 4 1 0000000000000000 100 0 0 10 0
+`
+
+// procNetTCP6 is an excerpt of /proc/net/tcp6 on a little-endian host
+const procNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:1F91 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 5 1 0000000000000000 100 0 0 10 0
+   1: 00000000000000000000000001000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 6 1 0000000000000000 100 0 0 10 0
+`
+
+func TestParseProcNetTCP(t *testing.T) {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("the test data is for little-endian hosts")
+	}
+	testCases := map[string][]string{
+		procNetTCP:  {"0.0.0.0:8080", "127.0.0.1:3306", "10.0.2.100:22"},
+		procNetTCP6: {"[::]:8081", "[::1]:80"},
+	}
+	for data, expected := range testCases {
+		listeners, err := parseProcNetTCP(strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, l := range listeners {
+			got = append(got, net.JoinHostPort(l.IP.String(), strconv.Itoa(l.Port)))
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestAutoPublisherReconcile(t *testing.T) {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("the test data is for little-endian hosts")
+	}
+	tmp, err := ioutil.TempDir("", "test-autopublish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tcp, tcp6 := filepath.Join(tmp, "tcp"), filepath.Join(tmp, "tcp6")
+	if err := ioutil.WriteFile(tcp, []byte(procNetTCP), 0644); err != nil {
+		t.Fatal(err)
+	}
+	driver := newFakePortDriver()
+	// published in other ways
+	if _, err := driver.AddPort(context.TODO(), port.Spec{Proto: "tcp", ParentPort: 10000, ChildPort: 10000}); err != nil {
+		t.Fatal(err)
+	}
+	a := &autoPublisher{
+		opt:        AutoPublishOpt{Ports: []PortRange{{22, 22}, {3000, 9999}}},
+		driver:     driver,
+		procNetTCP: []string{tcp, tcp6}, // tcp6 does not exist yet
+		ids:        make(map[int]int),
+		specs:      make(map[int]port.Spec),
+		failed:     make(map[int]port.Spec),
+	}
+	published := func() []port.Spec {
+		ports, err := driver.ListPorts(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var specs []port.Spec
+		for _, st := range ports {
+			specs = append(specs, st.Spec)
+		}
+		return specs
+	}
+	if err := a.reconcile(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []port.Spec{
+		{Proto: "tcp", ParentPort: 10000, ChildPort: 10000},
+		{Proto: "tcp", ParentPort: 22, ChildPort: 22, ChildIP: "10.0.2.100"},
+		{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 3306, ChildPort: 3306},
+		{Proto: "tcp", ParentPort: 8080, ChildPort: 8080},
+	}
+	if got := published(); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected %+v, got %+v", expected, got)
+	}
+	// 8080 and 3306 are closed, 8081 is opened on "::", 80 on "::1" is not reachable (and not in the ranges)
+	if err := ioutil.WriteFile(tcp, []byte(strings.Join(strings.Split(procNetTCP, "\n")[:1], "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tcp6, []byte(procNetTCP6), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.reconcile(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	expected = []port.Spec{
+		{Proto: "tcp", ParentPort: 10000, ChildPort: 10000},
+		{Proto: "tcp", ParentPort: 8081, ChildPort: 8081},
+	}
+	if got := published(); !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestAutoPublisherDesiredPreference(t *testing.T) {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("the test data is for little-endian hosts")
+	}
+	tmp, err := ioutil.TempDir("", "test-autopublish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// 80 on 127.0.0.1 and 0.0.0.0, 81 on 127.0.0.1 and 10.0.2.100, 82 on 127.0.0.53
+	data := `  sl  local_address rem_address   st
+   0: 0100007F:0050 00000000:0000 0A
+   1: 00000000:0050 00000000:0000 0A
+   2: 0100007F:0051 00000000:0000 0A
+   3: 6402000A:0051 00000000:0000 0A
+   4: 3500007F:0052 00000000:0000 0A
+`
+	tcp := filepath.Join(tmp, "tcp")
+	if err := ioutil.WriteFile(tcp, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := &autoPublisher{procNetTCP: []string{tcp}}
+	got, err := a.desired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]port.Spec{
+		80: {Proto: "tcp", ParentPort: 80, ChildPort: 80},
+		81: {Proto: "tcp", ParentPort: 81, ChildPort: 81, ChildIP: "10.0.2.100"},
+		82: {Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 82, ChildPort: 82, ChildIP: "127.0.0.53"},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected %+v, got %+v", expected, got)
+	}
+}
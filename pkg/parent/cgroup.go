@@ -0,0 +1,202 @@
+package parent
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const cgroup2Mountpoint = "/sys/fs/cgroup"
+
+// ValidateCgroup2Delegation returns an error unless the cgroup v2 (unified) hierarchy is mounted on /sys/fs/cgroup,
+// and the current cgroup is delegated to the current user.
+func ValidateCgroup2Delegation() error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(cgroup2Mountpoint, &st); err != nil {
+		return errors.Wrapf(err, "failed to stat %s", cgroup2Mountpoint)
+	}
+	if st.Type != unix.CGROUP2_SUPER_MAGIC {
+		return errors.Errorf("%s is not cgroup v2 (unified hierarchy), try booting the host with systemd.unified_cgroup_hierarchy=1", cgroup2Mountpoint)
+	}
+	p, err := currentCgroup2Path()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(cgroup2Mountpoint, p)
+	for _, f := range []string{dir, filepath.Join(dir, "cgroup.procs"), filepath.Join(dir, "cgroup.subtree_control")} {
+		if err := unix.Access(f, unix.W_OK); err != nil {
+			return errors.Wrapf(err, "cgroup %s is not delegated to the current user (hint: `systemd-run --user -p Delegate=yes --scope rootlesskit ...`)", p)
+		}
+	}
+	return nil
+}
+
+// ValidateCgroup2Controller returns an error unless the controller (e.g. "memory") is available in the current cgroup.
+// ValidateCgroup2Delegation should be called beforehand.
+func ValidateCgroup2Controller(controller string) error {
+	p, err := currentCgroup2Path()
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(filepath.Join(cgroup2Mountpoint, p, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	for _, c := range strings.Fields(string(b)) {
+		if c == controller {
+			return nil
+		}
+	}
+	return errors.Errorf("cgroup v2 controller %q is not delegated to cgroup %s (hint: `systemd-run --user -p Delegate=yes --scope rootlesskit ...`, and see /etc/systemd/system/user@.service.d)", controller, p)
+}
+
+func currentCgroup2Path() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return parseCgroup2Path(f)
+}
+
+// Cgroup names created by setupChildCgroup under the current cgroup.
+// RootlessKit itself is moved into cgroupLeafParent, due to the "no internal process" rule.
+// cgroupLeafParent is shared across the instances of RootlessKit, and is left on exit,
+// as a process cannot remove the cgroup it belongs to.
+// The child is moved into cgroupLeafChildPrefix + the PID of the child, which is removed on exit.
+const (
+	cgroupLeafParent      = "rootlesskit"
+	cgroupLeafChildPrefix = "child-"
+)
+
+// setupChildCgroup moves the child process into a new cgroup under the current cgroup, and sets memory.max.
+// memoryMax is ignored if zero.
+// Fails if the current cgroup contains processes other than RootlessKit and the child (e.g. the shell of the user),
+// as they would have to be moved out of the current cgroup for enabling the memory controller.
+// The returned path of the new cgroup is non-empty even on error, if the cgroup was created.
+func setupChildCgroup(childPID int, memoryMax int64) (string, error) {
+	p, err := currentCgroup2Path()
+	if err != nil {
+		return "", err
+	}
+	return setupChildCgroupInDir(filepath.Join(cgroup2Mountpoint, p), os.Getpid(), childPID, memoryMax)
+}
+
+func setupChildCgroupInDir(dir string, parentPID, childPID int, memoryMax int64) (string, error) {
+	procs, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return "", err
+	}
+	parentPIDStr, childPIDStr := strconv.Itoa(parentPID), strconv.Itoa(childPID)
+	for _, pid := range strings.Fields(string(procs)) {
+		if pid != parentPIDStr && pid != childPIDStr {
+			return "", errors.Errorf("cgroup %s contains process %s, which is not RootlessKit "+
+				"(hint: `systemd-run --user -p Delegate=yes --scope rootlesskit ...` to run RootlessKit in a dedicated cgroup)", dir, pid)
+		}
+	}
+	parentLeaf := filepath.Join(dir, cgroupLeafParent)
+	if err := os.MkdirAll(parentLeaf, 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create cgroup %s", parentLeaf)
+	}
+	if err := writeCgroupFile(parentLeaf, "cgroup.procs", parentPIDStr); err != nil {
+		return "", err
+	}
+	// the PID is unique across the concurrent instances
+	childLeaf := filepath.Join(dir, cgroupLeafChildPrefix+childPIDStr)
+	if err := os.MkdirAll(childLeaf, 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create cgroup %s", childLeaf)
+	}
+	if err := writeCgroupFile(childLeaf, "cgroup.procs", childPIDStr); err != nil {
+		return childLeaf, err
+	}
+	if memoryMax != 0 {
+		// can be enabled only after moving out all the processes of dir
+		if err := writeCgroupFile(dir, "cgroup.subtree_control", "+memory"); err != nil {
+			return childLeaf, err
+		}
+		if err := writeCgroupFile(childLeaf, "memory.max", strconv.FormatInt(memoryMax, 10)); err != nil {
+			return childLeaf, err
+		}
+	}
+	return childLeaf, nil
+}
+
+// removeCgroup removes the cgroup created by setupChildCgroup.
+// Fails with EBUSY if the cgroup still has processes, e.g. the orphaned descendants of the child without --pidns.
+func removeCgroup(dir string) error {
+	if err := unix.Rmdir(dir); err != nil && err != unix.ENOENT {
+		return errors.Wrapf(err, "failed to remove cgroup %s", dir)
+	}
+	return nil
+}
+
+func writeCgroupFile(dir, file, s string) error {
+	p := filepath.Join(dir, file)
+	f, err := os.OpenFile(p, os.O_WRONLY, 0)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", p)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		return errors.Wrapf(err, "failed to write %q to %s", s, p)
+	}
+	return nil
+}
+
+// MinMemoryMax is the minimum value of ParseMemoryMax, so as to avoid the immediate OOM of the child.
+const MinMemoryMax = 6 * 1024 * 1024
+
+// ParseMemoryMax parses the memory limit in bytes, with an optional binary suffix ("k", "m", "g", case-insensitive),
+// e.g. "536870912", "512m", "4G".
+func ParseMemoryMax(s string) (int64, error) {
+	multiplier := int64(1)
+	num := s
+	if len(s) > 0 {
+		switch strings.ToLower(s[len(s)-1:]) {
+		case "k":
+			multiplier = 1024
+		case "m":
+			multiplier = 1024 * 1024
+		case "g":
+			multiplier = 1024 * 1024 * 1024
+		}
+		if multiplier != 1 {
+			num = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid memory limit %q", s)
+	}
+	if n <= 0 || n > math.MaxInt64/multiplier {
+		return 0, errors.Errorf("invalid memory limit %q", s)
+	}
+	n *= multiplier
+	if n < MinMemoryMax {
+		return 0, errors.Errorf("memory limit %q is too small (min: %d bytes)", s, MinMemoryMax)
+	}
+	return n, nil
+}
+
+// parseCgroup2Path parses /proc/self/cgroup and returns the path of the cgroup v2 entry ("0::/path").
+func parseCgroup2Path(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("cgroup v2 entry not found in /proc/self/cgroup")
+}
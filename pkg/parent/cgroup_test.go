@@ -0,0 +1,128 @@
+package parent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseCgroup2Path(t *testing.T) {
+	testCases := []struct {
+		s        string
+		expected string
+	}{
+		{"0::/user.slice/user-1001.slice/user@1001.service/app.slice/foo.scope\n", "/user.slice/user-1001.slice/user@1001.service/app.slice/foo.scope"},
+		{"12:pids:/user.slice/user-1001.slice\n1:name=systemd:/user.slice/user-1001.slice/session-1.scope\n0::/user.slice/user-1001.slice/session-1.scope\n", "/user.slice/user-1001.slice/session-1.scope"},
+		{"0::/\n", "/"},
+		// cgroup v1 only
+		{"12:pids:/user.slice/user-1001.slice\n1:name=systemd:/user.slice/user-1001.slice/session-1.scope\n", ""},
+	}
+	for _, tc := range testCases {
+		got, err := parseCgroup2Path(strings.NewReader(tc.s))
+		if tc.expected == "" {
+			if err == nil {
+				t.Errorf("%q: expected error", tc.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.s, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.s, tc.expected, got)
+		}
+	}
+}
+
+func TestSetupChildCgroupInDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// cgroupfs creates the interface files on mkdir
+	childLeaf := cgroupLeafChildPrefix + "43"
+	for _, d := range []string{cgroupLeafParent, childLeaf} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range []string{"cgroup.procs", "memory.max"} {
+			if err := ioutil.WriteFile(filepath.Join(dir, d, f), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 44 is not RootlessKit
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte("42\n43\n44\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := setupChildCgroupInDir(dir, 42, 43, 64*1024*1024); err == nil || !strings.Contains(err.Error(), "systemd-run") {
+		t.Fatalf("expected an error with the hint, got %v", err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, cgroupLeafParent, "cgroup.procs")); err != nil || len(b) != 0 {
+		t.Fatalf("expected no process to be moved, got %q (%v)", string(b), err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte("42\n43\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	created, err := setupChildCgroupInDir(dir, 42, 43, 64*1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(dir, childLeaf); created != expected {
+		t.Errorf("expected %q, got %q", expected, created)
+	}
+	for f, expected := range map[string]string{
+		filepath.Join(cgroupLeafParent, "cgroup.procs"): "42",
+		filepath.Join(childLeaf, "cgroup.procs"):        "43",
+		filepath.Join(childLeaf, "memory.max"):          "67108864",
+		"cgroup.subtree_control":                        "+memory",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("%s: expected %q, got %q", f, expected, string(b))
+		}
+	}
+}
+
+func TestParseMemoryMax(t *testing.T) {
+	testCases := map[string]int64{
+		"536870912":          536870912,
+		"512m":               512 * 1024 * 1024,
+		"512M":               512 * 1024 * 1024,
+		"4G":                 4 * 1024 * 1024 * 1024,
+		"8192k":              8192 * 1024,
+		"":                   -1,
+		"0":                  -1,
+		"-1":                 -1,
+		"1024":               -1, // too small
+		"1T":                 -1,
+		"m":                  -1,
+		"99999999999999999g": -1,
+	}
+	for s, expected := range testCases {
+		got, err := ParseMemoryMax(s)
+		if expected < 0 {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %d", s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("%q: expected %d, got %d", s, expected, got)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package parent
+
+import (
+	"context"
+	"net"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rootless-containers/rootlesskit/pkg/common"
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+// childIPPortDriver wraps port.ParentDriver, and validates ChildIP of the ports against the subnets of the child,
+// so that a typo in ChildIP is caught on AddPort, rather than silently failing to forward the connections.
+// ChildIP is canonicalized, e.g. "::ffff:10.0.2.100" is converted to "10.0.2.100", and "fd00:0::100" to "fd00::100".
+type childIPPortDriver struct {
+	port.ParentDriver
+	subnets []*net.IPNet
+	// warnOnly logs the warning instead of failing AddPort, e.g. for the advanced routing in the child
+	warnOnly bool
+}
+
+func newChildIPPortDriver(d port.ParentDriver, subnets []*net.IPNet, warnOnly bool) *childIPPortDriver {
+	return &childIPPortDriver{
+		ParentDriver: d,
+		subnets:      subnets,
+		warnOnly:     warnOnly,
+	}
+}
+
+func (d *childIPPortDriver) AddPort(ctx context.Context, spec port.Spec) (*port.Status, error) {
+	if err := canonicalizeChildIP(&spec); err != nil {
+		return nil, err
+	}
+	if err := d.validateChildIP(&spec); err != nil {
+		if !d.warnOnly {
+			return nil, err
+		}
+		logrus.Warn(err)
+	}
+	return d.ParentDriver.AddPort(ctx, spec)
+}
+
+// canonicalizeChildIP canonicalizes spec.ChildIP, and returns an error if it is unparsable.
+func canonicalizeChildIP(spec *port.Spec) error {
+	if spec.ChildIP == "" {
+		return nil
+	}
+	ip := net.ParseIP(spec.ChildIP)
+	if ip == nil {
+		return errors.Errorf("invalid ChildIP %q", spec.ChildIP)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	spec.ChildIP = ip.String()
+	return nil
+}
+
+// validateChildIP returns an error if the canonicalized spec.ChildIP is outside the subnets.
+func (d *childIPPortDriver) validateChildIP(spec *port.Spec) error {
+	if spec.ChildIP == "" {
+		return nil
+	}
+	ip := net.ParseIP(spec.ChildIP)
+	for _, subnet := range d.subnets {
+		if subnet.Contains(ip) {
+			return nil
+		}
+	}
+	return errors.Errorf("ChildIP %s is not within the subnets of the child %v", ip, d.subnets)
+}
+
+// childSubnets returns the subnets of the child: the loopbacks, and the IPv4 and IPv6 networks configured by the network driver
+// (e.g. 10.0.2.0/24 and fd00::/64 for slirp4netns).
+// Returns nil when the network driver did not configure the address, e.g. lxc-user-nic (DHCP).
+func childSubnets(netmsg common.NetworkMessage) []*net.IPNet {
+	var subnets []*net.IPNet
+	if ip := net.ParseIP(netmsg.IP).To4(); ip != nil {
+		mask := net.CIDRMask(netmsg.Netmask, 32)
+		subnets = append(subnets, &net.IPNet{IP: ip.Mask(mask), Mask: mask})
+	}
+	if ip := net.ParseIP(netmsg.IPv6); ip != nil && ip.To4() == nil {
+		mask := net.CIDRMask(netmsg.IPv6Netmask, 128)
+		subnets = append(subnets, &net.IPNet{IP: ip.Mask(mask), Mask: mask})
+	}
+	if subnets == nil {
+		return nil
+	}
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, loopback6, _ := net.ParseCIDR("::1/128")
+	return append([]*net.IPNet{loopback, loopback6}, subnets...)
+}
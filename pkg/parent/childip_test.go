@@ -0,0 +1,102 @@
+package parent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rootless-containers/rootlesskit/pkg/common"
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+func TestChildSubnets(t *testing.T) {
+	if subnets := childSubnets(common.NetworkMessage{}); subnets != nil {
+		t.Fatalf("expected nil for the unconfigured network, got %v", subnets)
+	}
+	subnets := childSubnets(common.NetworkMessage{IP: "10.0.2.100", Netmask: 24})
+	if len(subnets) != 3 || subnets[0].String() != "127.0.0.0/8" || subnets[1].String() != "::1/128" || subnets[2].String() != "10.0.2.0/24" {
+		t.Fatalf("unexpected subnets: %v", subnets)
+	}
+	// slirp4netns --enable-ipv6
+	subnets = childSubnets(common.NetworkMessage{IP: "10.0.2.100", Netmask: 24, IPv6: "fd00::100", IPv6Netmask: 64})
+	if len(subnets) != 4 || subnets[3].String() != "fd00::/64" {
+		t.Fatalf("unexpected subnets: %v", subnets)
+	}
+	// IPv6-only
+	subnets = childSubnets(common.NetworkMessage{IPv6: "fd00::100", IPv6Netmask: 64})
+	if len(subnets) != 3 || subnets[2].String() != "fd00::/64" {
+		t.Fatalf("unexpected subnets: %v", subnets)
+	}
+}
+
+func TestValidateChildIP(t *testing.T) {
+	d := newChildIPPortDriver(nil, childSubnets(common.NetworkMessage{IP: "10.0.2.100", Netmask: 24, IPv6: "fd00::100", IPv6Netmask: 64}), false)
+	testCases := []struct {
+		childIP  string
+		expected string
+		ok       bool
+	}{
+		{"", "", true},
+		{"127.0.0.1", "127.0.0.1", true},
+		{"127.0.1.1", "127.0.1.1", true},
+		{"10.0.2.100", "10.0.2.100", true},
+		{"10.0.2.200", "10.0.2.200", true},
+		{"::ffff:10.0.2.100", "10.0.2.100", true},
+		{"10.0.3.100", "10.0.3.100", false},
+		{"192.168.0.1", "192.168.0.1", false},
+		{"::1", "::1", true},
+		{"fd00::100", "fd00::100", true},
+		{"fd00:0:0:0::200", "fd00::200", true},
+		{"FD00::100", "fd00::100", true},
+		{"fd01::100", "fd01::100", false},
+		{"foo", "foo", false},
+	}
+	for _, tc := range testCases {
+		spec := port.Spec{Proto: "tcp", ChildIP: tc.childIP, ChildPort: 80}
+		err := canonicalizeChildIP(&spec)
+		if err == nil {
+			err = d.validateChildIP(&spec)
+		}
+		if tc.ok && err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.childIP, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%q: expected an error", tc.childIP)
+		}
+		if spec.ChildIP != tc.expected {
+			t.Errorf("%q: expected ChildIP %q, got %q", tc.childIP, tc.expected, spec.ChildIP)
+		}
+	}
+}
+
+func TestChildIPPortDriverWarnOnly(t *testing.T) {
+	subnets := childSubnets(common.NetworkMessage{IP: "10.0.2.100", Netmask: 24})
+	spec := port.Spec{Proto: "tcp", ParentPort: 8080, ChildIP: "10.0.3.100", ChildPort: 80}
+	for _, warnOnly := range []bool{false, true} {
+		fake := newFakePortDriver()
+		d := newChildIPPortDriver(fake, subnets, warnOnly)
+		_, err := d.AddPort(context.TODO(), spec)
+		if warnOnly && err != nil {
+			t.Errorf("warnOnly: unexpected error: %v", err)
+		}
+		if !warnOnly && err == nil {
+			t.Error("expected an error")
+		}
+		if added := len(fake.ports) == 1; added != warnOnly {
+			t.Errorf("warnOnly=%v: added=%v", warnOnly, added)
+		}
+	}
+}
+
+func TestChildIPPortDriverInvalid(t *testing.T) {
+	subnets := childSubnets(common.NetworkMessage{IP: "10.0.2.100", Netmask: 24})
+	spec := port.Spec{Proto: "tcp", ParentPort: 8080, ChildIP: "10.0.2.300", ChildPort: 80}
+	fake := newFakePortDriver()
+	// an unparsable ChildIP is rejected even with warnOnly
+	d := newChildIPPortDriver(fake, subnets, true)
+	if _, err := d.AddPort(context.TODO(), spec); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(fake.ports) != 0 {
+		t.Fatalf("unexpected ports: %v", fake.ports)
+	}
+}
@@ -0,0 +1,56 @@
+package parent
+
+import (
+	"context"
+	"time"
+
+	"github.com/rootless-containers/rootlesskit/pkg/api"
+	"github.com/rootless-containers/rootlesskit/pkg/event"
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+// eventsCloseTimeout is the timeout for the subscribers to receive EventChildExited before the API socket is closed.
+const eventsCloseTimeout = time.Second
+
+// eventPortDriver wraps port.ParentDriver, and publishes EventPortAdded and EventPortRemoved to bus,
+// regardless of the way the ports are added and removed (PublishPorts, PublishFile, AutoPublish, and the API).
+type eventPortDriver struct {
+	port.ParentDriver
+	bus *event.Bus
+}
+
+func newEventPortDriver(d port.ParentDriver, bus *event.Bus) *eventPortDriver {
+	return &eventPortDriver{
+		ParentDriver: d,
+		bus:          bus,
+	}
+}
+
+func (d *eventPortDriver) AddPort(ctx context.Context, spec port.Spec) (*port.Status, error) {
+	st, err := d.ParentDriver.AddPort(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	stCopy := *st
+	d.bus.Publish(api.Event{Type: api.EventPortAdded, Port: &stCopy})
+	return st, nil
+}
+
+func (d *eventPortDriver) RemovePort(ctx context.Context, id int) error {
+	// the status is no longer available after removing the port
+	removed := &port.Status{ID: id}
+	if ports, err := d.ParentDriver.ListPorts(ctx); err == nil {
+		for _, st := range ports {
+			if st.ID == id {
+				stCopy := st
+				removed = &stCopy
+				break
+			}
+		}
+	}
+	if err := d.ParentDriver.RemovePort(ctx, id); err != nil {
+		return err
+	}
+	d.bus.Publish(api.Event{Type: api.EventPortRemoved, Port: removed})
+	return nil
+}
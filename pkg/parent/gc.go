@@ -0,0 +1,152 @@
+package parent
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/theckman/go-flock"
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/mountinfo"
+)
+
+// GCOpt is the option for GC.
+type GCOpt struct {
+	// TempDir is scanned for "rootlesskit*" state directories created without --state-dir.
+	// Empty for os.TempDir().
+	TempDir string
+	// BaseDirs are scanned for state directories of any name, e.g. "/run/user/1001/rootlesskit".
+	BaseDirs []string
+	// DryRun only prints the stale state directories.
+	DryRun bool
+}
+
+// GC removes the state directories left by crashed RootlessKit instances, and prints the removed directories to w.
+//
+// A state directory is stale only when its lock file exists and is not locked, and the PID in child_pid (if any) is not alive.
+// Directories without the lock file are never touched.
+// Mounts under the stale state directories are unmounted before removal.
+func GC(opt GCOpt, w io.Writer) error {
+	tempDir := opt.TempDir
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	candidates, err := listStateDirs(tempDir, "rootlesskit")
+	if err != nil {
+		return err
+	}
+	for _, base := range opt.BaseDirs {
+		dirs, err := listStateDirs(base, "")
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, dirs...)
+	}
+	for _, dir := range candidates {
+		if err := gcStateDir(dir, opt.DryRun, w); err != nil {
+			logrus.WithError(err).Warnf("failed to remove %s", dir)
+		}
+	}
+	return nil
+}
+
+// listStateDirs lists the directories with the lock file in base.
+func listStateDirs(base, prefix string) ([]string, error) {
+	entries, err := ioutil.ReadDir(base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var dirs []string
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		dir := filepath.Join(base, e.Name())
+		if st, err := os.Lstat(filepath.Join(dir, StateFileLock)); err != nil || !st.Mode().IsRegular() {
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+func gcStateDir(dir string, dryRun bool, w io.Writer) error {
+	lock := flock.NewFlock(filepath.Join(dir, StateFileLock))
+	locked, err := lock.TryLock()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		logrus.Debugf("%s is locked by a running RootlessKit", dir)
+		return nil
+	}
+	defer lock.Unlock()
+	if pid, ok := readChildPID(dir); ok && pidIsAlive(pid) {
+		logrus.Debugf("%s has a live child PID %d", dir, pid)
+		return nil
+	}
+	if dryRun {
+		fmt.Fprintln(w, dir)
+		return nil
+	}
+	mounts, err := mountsUnder(dir)
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		if err := unix.Unmount(m, unix.MNT_DETACH); err != nil {
+			return errors.Wrapf(err, "failed to unmount %s", m)
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, dir)
+	return nil
+}
+
+func readChildPID(dir string) (int, bool) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, StateFileChildPID))
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	return pid, true
+}
+
+// pidIsAlive returns true unless pid is known to be dead.
+func pidIsAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// mountsUnder returns the mount points under dir (including dir), the deepest first.
+func mountsUnder(dir string) ([]string, error) {
+	mountPoints, err := mountinfo.SelfMountPoints()
+	if err != nil {
+		return nil, err
+	}
+	var mounts []string
+	for _, mp := range mountPoints {
+		if mp == dir || strings.HasPrefix(mp, dir+"/") {
+			mounts = append(mounts, mp)
+		}
+	}
+	sort.Slice(mounts, func(i, j int) bool { return len(mounts[i]) > len(mounts[j]) })
+	return mounts, nil
+}
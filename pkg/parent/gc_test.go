@@ -0,0 +1,91 @@
+package parent
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/theckman/go-flock"
+)
+
+func deadPID(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestGC(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	base := filepath.Join(tmp, "base")
+
+	mkStateDir := func(dir string, lock bool, pid int) string {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if lock {
+			if err := ioutil.WriteFile(filepath.Join(dir, StateFileLock), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if pid != 0 {
+			if err := ioutil.WriteFile(filepath.Join(dir, StateFileChildPID), []byte(strconv.Itoa(pid)), 0444); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+	dead := mkStateDir(filepath.Join(tmp, "rootlesskit-dead"), true, deadPID(t))
+	noPID := mkStateDir(filepath.Join(tmp, "rootlesskit-nopid"), true, 0)
+	live := mkStateDir(filepath.Join(tmp, "rootlesskit-live"), true, os.Getpid())
+	noLock := mkStateDir(filepath.Join(tmp, "rootlesskit-nolock"), false, deadPID(t))
+	otherPrefix := mkStateDir(filepath.Join(tmp, "foo"), true, deadPID(t))
+	locked := mkStateDir(filepath.Join(tmp, "rootlesskit-locked"), true, 0)
+	inBase := mkStateDir(filepath.Join(base, "default"), true, deadPID(t))
+
+	lock := flock.NewFlock(filepath.Join(locked, StateFileLock))
+	if ok, err := lock.TryLock(); !ok || err != nil {
+		t.Fatalf("failed to lock: %v", err)
+	}
+	defer lock.Unlock()
+
+	var dryRunOut bytes.Buffer
+	if err := GC(GCOpt{TempDir: tmp, BaseDirs: []string{base}, DryRun: true}, &dryRunOut); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{dead, noPID, inBase} {
+		if _, err := os.Stat(d); err != nil {
+			t.Errorf("expected %s to be kept on dry run: %v", d, err)
+		}
+		if !bytes.Contains(dryRunOut.Bytes(), []byte(d)) {
+			t.Errorf("expected %s to be printed on dry run, got %q", d, dryRunOut.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := GC(GCOpt{TempDir: tmp, BaseDirs: []string{base}}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{dead, noPID, inBase} {
+		if _, err := os.Stat(d); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", d, err)
+		}
+	}
+	for _, d := range []string{live, noLock, otherPrefix, locked} {
+		if _, err := os.Stat(d); err != nil {
+			t.Errorf("expected %s to be kept: %v", d, err)
+		}
+	}
+	if out.String() != dryRunOut.String() {
+		t.Errorf("expected %q, got %q", dryRunOut.String(), out.String())
+	}
+}
@@ -0,0 +1,61 @@
+package parent
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// ValidateNetNSPath returns an error if path is not a namespace file, such as "/var/run/netns/foo" created by `ip netns add`.
+func ValidateNetNSPath(path string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return errors.Wrapf(err, "failed to stat the netns %s", path)
+	}
+	if st.Type != unix.NSFS_MAGIC {
+		return errors.Errorf("%s is not a netns (not on nsfs, missing `ip netns add`?)", path)
+	}
+	return nil
+}
+
+// startInNetNS starts cmd in the network namespace specified by path.
+// The calling thread joins the netns only during forking cmd, and restores its netns afterward.
+func startInNetNS(cmd *exec.Cmd, path string) error {
+	errCh := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		restored, err := withNetNS(path, cmd.Start)
+		if restored {
+			// the thread must be kept alive for Pdeathsig of cmd, so it is unlocked only when the netns is restored.
+			runtime.UnlockOSThread()
+		}
+		errCh <- err
+	}()
+	return <-errCh
+}
+
+// withNetNS calls f in the netns of path. The current thread needs to be locked.
+func withNetNS(path string, f func() error) (restored bool, err error) {
+	cur, err := os.Open("/proc/self/task/" + strconv.Itoa(unix.Gettid()) + "/ns/net")
+	if err != nil {
+		return true, err
+	}
+	defer cur.Close()
+	target, err := os.Open(path)
+	if err != nil {
+		return true, err
+	}
+	defer target.Close()
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		return true, errors.Wrapf(err, "failed to join the netns %s", path)
+	}
+	fErr := f()
+	if err := unix.Setns(int(cur.Fd()), unix.CLONE_NEWNET); err != nil {
+		return false, errors.Wrap(err, "failed to restore the netns")
+	}
+	return true, fErr
+}
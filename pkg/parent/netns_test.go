@@ -0,0 +1,25 @@
+package parent
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestValidateNetNSPath(t *testing.T) {
+	if err := ValidateNetNSPath("/proc/self/ns/net"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "netns-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := ValidateNetNSPath(f.Name()); err == nil {
+		t.Errorf("expected %s not to be a netns", f.Name())
+	}
+	if err := ValidateNetNSPath("/nonexistent"); err == nil {
+		t.Error("expected an error for a nonexistent path")
+	}
+}
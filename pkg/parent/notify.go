@@ -0,0 +1,134 @@
+package parent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/common"
+)
+
+// NotifyType is the transport of the ready notification.
+type NotifyType string
+
+const (
+	// NotifyFD writes the notification to the inherited FD, and closes the FD.
+	NotifyFD = NotifyType("fd")
+	// NotifyFile writes the notification to the file atomically.
+	NotifyFile = NotifyType("file")
+	// NotifyExec executes the command with "/bin/sh -c", with NotifyEnvChildPID.
+	NotifyExec = NotifyType("exec")
+)
+
+// NotifyEnvChildPID is the environment variable of the child PID for NotifyExec.
+const NotifyEnvChildPID = "ROOTLESSKIT_CHILD_PID"
+
+// NotifySpec is the ready notification, sent when the network and the ports are ready and the API is being served.
+type NotifySpec struct {
+	Type NotifyType
+	// FD for NotifyFD, the absolute path for NotifyFile, or the command for NotifyExec.
+	Target string
+}
+
+func (s NotifySpec) String() string {
+	return string(s.Type) + ":" + s.Target
+}
+
+// ParseNotifySpec parses "fd:N", "file:PATH", or "exec:CMD".
+// For "fd:N", the FD needs to be open, and it is marked as close-on-exec so that it is not inherited by the child.
+func ParseNotifySpec(s string) (*NotifySpec, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return nil, errors.Errorf("invalid notify spec %q, expected \"fd:N\", \"file:PATH\", or \"exec:CMD\"", s)
+	}
+	spec := &NotifySpec{Type: NotifyType(s[:i]), Target: s[i+1:]}
+	switch spec.Type {
+	case NotifyFD:
+		fd, err := strconv.Atoi(spec.Target)
+		if err != nil || fd < 3 {
+			return nil, errors.Errorf("invalid notify spec %q, expected FD >= 3", s)
+		}
+		if _, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0); err != nil {
+			return nil, errors.Wrapf(err, "invalid notify spec %q: FD %d is not open", s, fd)
+		}
+		unix.CloseOnExec(fd)
+	case NotifyFile:
+		if !filepath.IsAbs(spec.Target) {
+			return nil, errors.Errorf("invalid notify spec %q, expected an absolute path", s)
+		}
+	case NotifyExec:
+		if spec.Target == "" {
+			return nil, errors.Errorf("invalid notify spec %q, expected a command", s)
+		}
+	default:
+		return nil, errors.Errorf("unknown notify type %q in %q, expected \"fd\", \"file\", or \"exec\"", spec.Type, s)
+	}
+	return spec, nil
+}
+
+// notifyMessage returns the message written for NotifyFD and NotifyFile, in the format of sd_notify(3).
+func notifyMessage(childPID int) []byte {
+	return []byte(fmt.Sprintf("READY=1\nCHILD_PID=%d\n", childPID))
+}
+
+// notify sends the ready notifications one by one, and returns the first error.
+// env is appended to the current environment for NotifyExec.
+func notify(specs []NotifySpec, childPID int, env []string) error {
+	for _, spec := range specs {
+		if err := notifyOne(spec, childPID, env); err != nil {
+			return errors.Wrapf(err, "failed to send the ready notification %s", spec)
+		}
+	}
+	return nil
+}
+
+func notifyOne(spec NotifySpec, childPID int, env []string) error {
+	switch spec.Type {
+	case NotifyFD:
+		fd, err := strconv.Atoi(spec.Target)
+		if err != nil {
+			return err
+		}
+		f := os.NewFile(uintptr(fd), "notify")
+		_, err = f.Write(notifyMessage(childPID))
+		// closing the FD is the end of the notification, e.g. for the readiness protocol of s6
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	case NotifyFile:
+		tmp, err := ioutil.TempFile(filepath.Dir(spec.Target), "."+filepath.Base(spec.Target)+".tmp")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(notifyMessage(childPID)); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Chmod(0644); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), spec.Target)
+	case NotifyExec:
+		cmd := exec.Command("/bin/sh", "-c", spec.Target)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(append(os.Environ(), env...), NotifyEnvChildPID+"="+strconv.Itoa(childPID))
+		common.LogHelperCmd(cmd)
+		return cmd.Run()
+	default:
+		return errors.Errorf("unknown notify type %q", spec.Type)
+	}
+}
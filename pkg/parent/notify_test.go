@@ -0,0 +1,105 @@
+package parent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestParseNotifySpec(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	fd := strconv.Itoa(int(w.Fd()))
+	testCases := []struct {
+		s        string
+		expected *NotifySpec
+	}{
+		{"fd:" + fd, &NotifySpec{Type: NotifyFD, Target: fd}},
+		{"file:/run/ready", &NotifySpec{Type: NotifyFile, Target: "/run/ready"}},
+		{"exec:echo ready:1", &NotifySpec{Type: NotifyExec, Target: "echo ready:1"}},
+		{"fd:1", nil},
+		{"fd:foo", nil},
+		{"fd:12345", nil},
+		{"file:ready", nil},
+		{"exec:", nil},
+		{"systemd:", nil},
+		{"foo", nil},
+	}
+	for _, tc := range testCases {
+		spec, err := ParseNotifySpec(tc.s)
+		if tc.expected == nil {
+			if err == nil {
+				t.Errorf("expected %q to be invalid, got %+v", tc.s, spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected %q to be valid, got %v", tc.s, err)
+			continue
+		}
+		if *spec != *tc.expected {
+			t.Errorf("%q: expected %+v, got %+v", tc.s, tc.expected, spec)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// the duplicated FD is closed by notify
+	fd, err := syscall.Dup(int(w.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	filePath := filepath.Join(tmpDir, "ready")
+	execPath := filepath.Join(tmpDir, "exec")
+	specs := []NotifySpec{
+		{Type: NotifyFD, Target: strconv.Itoa(fd)},
+		{Type: NotifyFile, Target: filePath},
+		{Type: NotifyExec, Target: "echo $FOO $" + NotifyEnvChildPID + " >" + execPath},
+	}
+	if err := notify(specs, 42, []string{"FOO=foo"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "READY=1\nCHILD_PID=42\n"
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Errorf("fd: expected %q, got %q", expected, string(b))
+	}
+	b, err = ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Errorf("file: expected %q, got %q", expected, string(b))
+	}
+	b, err = ioutil.ReadFile(execPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "foo 42\n" {
+		t.Errorf("exec: expected %q, got %q", "foo 42\n", string(b))
+	}
+	if err := notify([]NotifySpec{{Type: NotifyExec, Target: "false"}}, 42, nil); err == nil {
+		t.Error("expected an error for the failing command")
+	}
+}
@@ -2,6 +2,7 @@ package parent
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -10,39 +11,155 @@ import (
 	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/theckman/go-flock"
+	"golang.org/x/sys/unix"
 
+	"github.com/rootless-containers/rootlesskit/pkg/api"
 	"github.com/rootless-containers/rootlesskit/pkg/api/router"
 	"github.com/rootless-containers/rootlesskit/pkg/common"
+	"github.com/rootless-containers/rootlesskit/pkg/copyup"
+	"github.com/rootless-containers/rootlesskit/pkg/event"
 	"github.com/rootless-containers/rootlesskit/pkg/msgutil"
 	"github.com/rootless-containers/rootlesskit/pkg/network"
+	"github.com/rootless-containers/rootlesskit/pkg/network/hostloopback"
 	"github.com/rootless-containers/rootlesskit/pkg/parent/idtools"
 	"github.com/rootless-containers/rootlesskit/pkg/port"
+	"github.com/rootless-containers/rootlesskit/pkg/tty"
 )
 
 type Opt struct {
-	PipeFDEnvKey   string               // needs to be set
-	StateDir       string               // directory needs to be precreated
-	StateDirEnvKey string               // optional env key to propagate StateDir value
+	PipeFDEnvKey   string // needs to be set
+	StateDir       string // directory needs to be precreated
+	StateDirEnvKey string // optional env key to propagate StateDir value
+	// APISocketEnvKey is the optional env key to propagate the address of the API socket to the child,
+	// either a path or "tcp://host:port".
+	APISocketEnvKey string
+	// APITokenEnvKey is the optional env key of APIToken, removed from the environment of the child,
+	// so that the token is not leaked to the command.
+	APITokenEnvKey string
 	NetworkDriver  network.ParentDriver // nil for HostNetwork
 	PortDriver     port.ParentDriver    // nil for --port-driver=none
 	PublishPorts   []port.Spec
 	CreatePIDNS    bool
+	// StateDirTmpfs mounts a private tmpfs on StateDir, and removes StateDir on exit.
+	// When mounting is not permitted, StateDir needs to be already on tmpfs.
+	StateDirTmpfs bool
+	// APISocket is the address of the REST API, e.g. "tcp://127.0.0.1:8080".
+	// Defaults to StateFileAPISock under StateDir.
+	APISocket string
+	// APIToken is required for "tcp://" APISocket.
+	// Clients need to set "Authorization: Bearer <APIToken>" header.
+	APIToken string
+	// AllowHostLoopback is the list of "ip:port[/proto]" endpoints on the host loopback
+	// that are reachable from the child, via the same address in the child network namespace.
+	// Requires NetworkDriver.
+	AllowHostLoopback []string
+	// CopyUpManager copies up additional directories at runtime.
+	// nil if not supported.
+	CopyUpManager copyup.Manager
+	// TTY allocates a pseudo terminal for the command.
+	// Requires stdin to be a terminal.
+	TTY bool
+	// PreserveFDs are the FDs passed to the command as FD 3, 4, ...
+	PreserveFDs []int
+	// AutoPublish publishes the listening TCP ports of the child on the same ports of the parent, by polling
+	// /proc/<child>/net/tcp{,6}. nil to disable. Requires PortDriver.
+	AutoPublish *AutoPublishOpt
+	// PublishFile is the path of the file that lists the ports to be published, one per line.
+	// The file is reloaded on SIGUSR1. Requires PortDriver.
+	PublishFile string
+	// OOMScoreAdj is written to /proc/<child>/oom_score_adj after the child starts, and inherited by the command.
+	// nil to inherit the value of RootlessKit.
+	OOMScoreAdj *int
+	// InheritUserNS reuses the current user namespace and its mapping, instead of creating a new user namespace.
+	// Only the mount namespace (and the network and PID namespaces if needed) are created.
+	// Requires the current process to be root in the current user namespace, e.g. inside another RootlessKit.
+	InheritUserNS bool
+	// SingleMapping maps only the current user and group to root in the new user namespace, by writing
+	// /proc/<child>/{uid,gid}_map directly. newuidmap, newgidmap, /etc/subuid, and /etc/subgid are not needed,
+	// but setgroups(2) is denied in the namespace. Conflicts with InheritUserNS.
+	SingleMapping bool
+	// NetNS is the path of an existing network namespace to be joined, instead of creating a new one,
+	// e.g. "/var/run/netns/foo". Requires NetworkDriver and InheritUserNS.
+	NetNS string
+	// CreateCgroupNS creates a cgroup namespace, with the current cgroup as the root.
+	CreateCgroupNS bool
+	// RestorePorts re-publishes the ports recorded in StateFilePorts by the previous execution
+	// with the same StateDir, e.g. after a crash. Requires PortDriver.
+	RestorePorts bool
+	// Notify are the ready notifications, sent one by one when the network and the ports are ready
+	// and the API is being served, before waiting for the start (WaitStart).
+	// Parent fails when any of the notifications fails.
+	Notify []NotifySpec
+	// StrictTeardown makes Parent fail when any of the teardown steps (e.g. unmounting the state dir,
+	// tearing down the network, and PostStopCmds) fails, even if the child exited successfully.
+	// Otherwise the errors are just logged.
+	// Either way, all the teardown steps are attempted, and the error of the child takes precedence.
+	StrictTeardown bool
+	// WarnChildIP only logs the warning, instead of failing to add the port,
+	// when ChildIP of the port is outside the subnets of the child (the loopback and the network configured by NetworkDriver),
+	// e.g. for the advanced routing in the child.
+	WarnChildIP bool
+	// PreExecCmds are executed with "/bin/sh -c" one by one in the current (host) namespaces,
+	// before creating the child. The child is not created if any of PreExecCmds fails.
+	// StateDirEnvKey is set for PreExecCmds as well.
+	// Unlike child.Opt.SetupCmds, PreExecCmds run with the privileges of the current user on the host.
+	PreExecCmds []string
+	// PostStopCmds are executed with "/bin/sh -c" one by one in the current (host) namespaces,
+	// after the child exited and the network and the port driver were torn down, but before the state dir is removed.
+	// PostStopCmds are executed even when Parent fails after locking the state dir, including the failure of PreExecCmds.
+	// All of PostStopCmds are executed even if some of them fail.
+	// StateDirEnvKey is set for PostStopCmds as well.
+	PostStopCmds []string
+	// WaitStart delays executing the command in the child until the start is requested via the API or SIGUSR2,
+	// so that ports can be added before the command starts.
+	WaitStart bool
+	// WaitStartTimeout is the timeout for WaitStart. Zero for no timeout.
+	// On timeout, the child exits without executing the command.
+	WaitStartTimeout time.Duration
+	// Drivers are the drivers supported by the binary, returned by the API.
+	Drivers *api.Drivers
+	// MemoryMax is set to memory.max of the cgroup v2 of the child, in bytes. Zero for no limit.
+	// The child is moved into a new cgroup under the current cgroup, which needs to be delegated with the memory controller.
+	MemoryMax int64
+	// Name is the name of the instance. Empty for an unnamed instance.
+	// StateDir needs to be set to the directory of the name (see pkg/instance).
+	Name string
 }
 
 // Documented state files. Undocumented ones are subject to change.
 const (
 	StateFileLock     = "lock"
-	StateFileChildPID = "child_pid" // decimal pid number text
-	StateFileAPISock  = "api.sock"  // REST API Socket
+	StateFileChildPID = "child_pid"  // decimal pid number text
+	StateFileAPISock  = "api.sock"   // REST API Socket
+	StateFilePorts    = "ports.json" // JSON array of the specs of the published ports
 )
 
-func Parent(opt Opt) error {
+// lockStateDir locks the lock file in the state dir.
+func lockStateDir(stateDir, name string) (*flock.Flock, error) {
+	lockPath := filepath.Join(stateDir, StateFileLock)
+	lock := flock.NewFlock(lockPath)
+	locked, err := lock.TryLock()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to lock %s", lockPath)
+	}
+	if !locked {
+		if name != "" {
+			return nil, errors.Errorf("another RootlessKit instance named %q is running (failed to lock %s)", name, lockPath)
+		}
+		return nil, errors.Errorf("failed to lock %s, another RootlessKit is running with the same state directory?", lockPath)
+	}
+	return lock, nil
+}
+
+func Parent(opt Opt) (retErr error) {
 	if opt.PipeFDEnvKey == "" {
 		return errors.New("pipe FD env key is not set")
 	}
@@ -55,56 +172,213 @@ func Parent(opt Opt) error {
 	if stat, err := os.Stat(opt.StateDir); err != nil || !stat.IsDir() {
 		return errors.Wrap(err, "state dir is inaccessible")
 	}
-	lockPath := filepath.Join(opt.StateDir, StateFileLock)
-	lock := flock.NewFlock(lockPath)
-	locked, err := lock.TryLock()
+	var td teardown
+	// executed after all the teardown steps registered below
+	defer func() {
+		retErr = td.result(retErr, opt.StrictTeardown)
+	}()
+	lock, err := lockStateDir(opt.StateDir, opt.Name)
 	if err != nil {
-		return errors.Wrapf(err, "failed to lock %s", lockPath)
+		return err
 	}
-	if !locked {
-		return errors.Errorf("failed to lock %s, another RootlessKit is running with the same state directory?", lockPath)
+	defer td.do("failed to remove the state dir", func() error { return os.RemoveAll(opt.StateDir) })
+	defer td.do("failed to unlock the state dir", lock.Unlock)
+	if opt.StateDirTmpfs {
+		cleanupStateDirTmpfs, mounted, err := setupStateDirTmpfs(opt.StateDir)
+		if err != nil {
+			return err
+		}
+		defer td.do("failed to clean up the state dir", cleanupStateDirTmpfs)
+		if mounted {
+			// the lock file is hidden by the tmpfs, so the lock file on the tmpfs is locked as well,
+			// for the other instances and the tools that check the lock file.
+			tmpfsLock, err := lockStateDir(opt.StateDir, opt.Name)
+			if err != nil {
+				return err
+			}
+			defer td.do("failed to unlock the state dir", tmpfsLock.Unlock)
+		}
 	}
-	defer os.RemoveAll(opt.StateDir)
-	defer lock.Unlock()
 	// when the previous execution crashed, the state dir may not be removed successfully.
 	// explicitly remove everything in the state dir except the lock file here.
-	for _, f := range []string{StateFileChildPID} {
+	var portsToRestore []port.Spec
+	if opt.RestorePorts {
+		portsPath := filepath.Join(opt.StateDir, StateFilePorts)
+		portsToRestore, err = loadPortSpecs(portsPath)
+		if err != nil {
+			logrus.WithError(err).Warnf("failed to load the ports to be restored from %s", portsPath)
+		}
+	}
+	for _, f := range []string{StateFileChildPID, StateFilePorts} {
 		p := filepath.Join(opt.StateDir, f)
 		if err := os.RemoveAll(p); err != nil {
 			return errors.Wrapf(err, "failed to remove %s", p)
 		}
 	}
 
+	var hookEnv []string
+	if opt.StateDirEnvKey != "" {
+		hookEnv = append(hookEnv, opt.StateDirEnvKey+"="+opt.StateDir)
+	}
+	if len(opt.PostStopCmds) != 0 {
+		// executed after the other deferred functions registered below
+		defer func() {
+			td.add(runPostStopCmds(opt.PostStopCmds, hookEnv))
+		}()
+	}
+	if len(opt.PreExecCmds) != 0 {
+		if err := runPreExecCmds(opt.PreExecCmds, hookEnv); err != nil {
+			return err
+		}
+	}
+
 	pipeR, pipeW, err := os.Pipe()
 	if err != nil {
 		return err
 	}
+	var uidMapArgs, gidMapArgs []string
+	if opt.InheritUserNS {
+		if opt.SingleMapping {
+			return errors.New("single mapping conflicts with inheriting the user namespace")
+		}
+		if os.Geteuid() != 0 {
+			return errors.New("--inherit-userns requires the current user to be root in the current user namespace")
+		}
+	} else if !opt.SingleMapping {
+		// detect missing helpers before creating the child, so as to return an actionable error
+		if err := lookPathUIDGIDMap(); err != nil {
+			return err
+		}
+		uidMapArgs, gidMapArgs, err = newugidmapArgs()
+		if err != nil {
+			if RunningInUserNS() {
+				return common.Wrapf(err, "failed to compute uid/gid map (already running in a user namespace, consider --inherit-userns)")
+			}
+			return common.Wrapf(err, "failed to compute uid/gid map")
+		}
+	}
 	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Pdeathsig:    syscall.SIGKILL,
-		Cloneflags:   syscall.CLONE_NEWUSER,
 		Unshareflags: syscall.CLONE_NEWNS,
 	}
-	if opt.NetworkDriver != nil {
+	if !opt.InheritUserNS {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+	}
+	if opt.NetNS != "" {
+		if opt.NetworkDriver == nil {
+			return errors.New("joining a netns requires a network driver")
+		}
+		// the netns is owned by the current user namespace (or its ancestor), so the child cannot join it in a new user namespace.
+		if !opt.InheritUserNS {
+			return errors.New("joining a netns requires inheriting the user namespace")
+		}
+		if err := ValidateNetNSPath(opt.NetNS); err != nil {
+			return err
+		}
+	} else if opt.NetworkDriver != nil {
 		cmd.SysProcAttr.Unshareflags |= syscall.CLONE_NEWNET
 	}
 	if opt.CreatePIDNS {
 		// cannot be Unshareflags (panics)
 		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWPID
 	}
+	if opt.CreateCgroupNS {
+		cmd.SysProcAttr.Cloneflags |= unix.CLONE_NEWCGROUP
+	}
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.ExtraFiles = []*os.File{pipeR}
-	cmd.Env = append(os.Environ(), opt.PipeFDEnvKey+"=3")
+	cmd.Env = append(envWithout(os.Environ(), opt.APITokenEnvKey), opt.PipeFDEnvKey+"=3")
+	var ptyMaster, ptySlave *os.File
+	if opt.TTY {
+		if !tty.IsTerminal(os.Stdin) {
+			return errors.New("--tty requires stdin to be a terminal")
+		}
+		ptyMaster, ptySlave, err = tty.OpenPTY()
+		if err != nil {
+			return errors.Wrap(err, "failed to open pty")
+		}
+		defer ptyMaster.Close()
+		// FD 4 in the child
+		cmd.ExtraFiles = append(cmd.ExtraFiles, ptySlave)
+	}
+	var (
+		startGate *startGate
+		startFD   int
+	)
+	if opt.WaitStart {
+		startR, startW, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		defer startR.Close()
+		startGate = newStartGate(startW)
+		defer startGate.abort()
+		startFD = 3 + len(cmd.ExtraFiles)
+		cmd.ExtraFiles = append(cmd.ExtraFiles, startR)
+	}
+	var preservedFDs []int
+	for _, fd := range opt.PreserveFDs {
+		if _, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0); err != nil {
+			return errors.Wrapf(err, "FD %d to be preserved is not open", fd)
+		}
+		preservedFDs = append(preservedFDs, 3+len(cmd.ExtraFiles))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, os.NewFile(uintptr(fd), "preserved-fd-"+strconv.Itoa(fd)))
+	}
 	if opt.StateDirEnvKey != "" {
 		cmd.Env = append(cmd.Env, opt.StateDirEnvKey+"="+opt.StateDir)
 	}
-	if err := cmd.Start(); err != nil {
+	apiSockPath := opt.APISocket
+	if apiSockPath == "" {
+		apiSockPath = filepath.Join(opt.StateDir, StateFileAPISock)
+	}
+	if opt.APISocketEnvKey != "" {
+		cmd.Env = append(cmd.Env, opt.APISocketEnvKey+"="+apiSockPath)
+	}
+	if opt.NetNS != "" {
+		err = startInNetNS(cmd, opt.NetNS)
+	} else {
+		err = cmd.Start()
+	}
+	if err != nil {
 		return errors.Wrap(err, "failed to start the child")
 	}
-	if err := setupUIDGIDMap(cmd.Process.Pid); err != nil {
-		return errors.Wrap(err, "failed to setup UID/GID map")
+	var console *tty.Console
+	if ptySlave != nil {
+		ptySlave.Close()
+		console, err = tty.NewConsole(ptyMaster, os.Stdin, os.Stdout)
+		if err != nil {
+			return err
+		}
+		// restore the terminal even on error
+		defer console.Restore()
+	}
+	if opt.SingleMapping {
+		if err := setupSingleUIDGIDMap(cmd.Process.Pid); err != nil {
+			return errors.Wrap(err, "failed to setup single UID/GID map")
+		}
+	} else if !opt.InheritUserNS {
+		if err := setupUIDGIDMap(cmd.Process.Pid, uidMapArgs, gidMapArgs); err != nil {
+			return common.Wrapf(err, "failed to setup UID/GID map")
+		}
+	}
+	if opt.MemoryMax != 0 {
+		// the child does not execute the command until message 0 is sent
+		childCgroup, err := setupChildCgroup(cmd.Process.Pid, opt.MemoryMax)
+		if childCgroup != "" {
+			// runs after waiting for the child
+			defer td.do("failed to remove the cgroup of the child", func() error { return removeCgroup(childCgroup) })
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to set up the cgroup of the child")
+		}
+	}
+	if opt.OOMScoreAdj != nil {
+		if err := setOOMScoreAdj(cmd.Process.Pid, *opt.OOMScoreAdj); err != nil {
+			return err
+		}
 	}
 	// send message 0
 	msg := common.Message{
@@ -122,15 +396,37 @@ func Parent(opt Opt) error {
 			StateDir: opt.StateDir,
 		},
 	}
+	if ptySlave != nil {
+		msg.Message1.TTYFD = 4
+	}
+	msg.Message1.PreservedFDs = preservedFDs
+	msg.Message1.StartFD = startFD
+	// the events published before the API is listened are replayed for the subscribers with "since"
+	events := event.NewBus(event.DefaultBacklog)
 	if opt.NetworkDriver != nil {
 		netMsg, cleanupNetwork, err := opt.NetworkDriver.ConfigureNetwork(cmd.Process.Pid, opt.StateDir)
 		if cleanupNetwork != nil {
-			defer cleanupNetwork()
+			defer td.do("failed to tear down the network", cleanupNetwork)
 		}
 		if err != nil {
-			return errors.Wrapf(err, "failed to setup network %+v", opt.NetworkDriver)
+			return &common.NetNotReadyError{Err: errors.Wrapf(err, "failed to setup network %+v", opt.NetworkDriver)}
 		}
 		msg.Message1.Network = *netMsg
+		events.Publish(api.Event{Type: api.EventNetworkReady})
+	}
+
+	// relay the allowed host loopback endpoints
+	for i, addr := range opt.AllowHostLoopback {
+		socketPath := filepath.Join(opt.StateDir, ".hostloopback-"+strconv.Itoa(i)+".sock")
+		closer, err := hostloopback.ListenParent(socketPath, addr)
+		if err != nil {
+			return errors.Wrapf(err, "failed to relay host loopback %s", addr)
+		}
+		defer td.do("failed to stop relaying host loopback "+addr, closer.Close)
+		msg.Message1.HostLoopback = append(msg.Message1.HostLoopback, common.HostLoopbackMessage{
+			Addr:       addr,
+			SocketPath: socketPath,
+		})
 	}
 
 	// configure Port driver
@@ -138,6 +434,11 @@ func Parent(opt Opt) error {
 	portDriverQuit := make(chan struct{})
 	portDriverErr := make(chan error)
 	if opt.PortDriver != nil {
+		if subnets := childSubnets(msg.Network); subnets != nil {
+			opt.PortDriver = newChildIPPortDriver(opt.PortDriver, subnets, opt.WarnChildIP)
+		}
+		opt.PortDriver = newPersistentPortDriver(opt.PortDriver, filepath.Join(opt.StateDir, StateFilePorts))
+		opt.PortDriver = newEventPortDriver(opt.PortDriver, events)
 		msg.Message1.Port.Opaque = opt.PortDriver.OpaqueForChild()
 		cctx := &port.ChildContext{
 			PID: cmd.Process.Pid,
@@ -172,32 +473,95 @@ func Parent(opt Opt) error {
 			logrus.Debugf("published port %v", st)
 		}
 	}
+	if opt.PublishFile != "" {
+		if opt.PortDriver == nil {
+			return errors.New("publish file requires port driver")
+		}
+		stopWatchingPublishFile, err := watchPublishFile(opt.PublishFile, opt.PortDriver)
+		if err != nil {
+			return err
+		}
+		defer stopWatchingPublishFile()
+	}
+	if opt.PortDriver != nil {
+		// restore the ports after PublishPorts and PublishFile, so that they take precedence over the restored ones
+		restorePorts(context.TODO(), opt.PortDriver, portsToRestore)
+	}
+	if opt.AutoPublish != nil {
+		if opt.PortDriver == nil {
+			return errors.New("auto-publish requires port driver")
+		}
+		// after the other ports, so that they take precedence over the auto-published ones
+		stopAutoPublish := startAutoPublish(*opt.AutoPublish, opt.PortDriver, cmd.Process.Pid)
+		defer stopAutoPublish()
+	}
 
 	// after child is fully configured, write PID to child_pid file
 	childPIDPath := filepath.Join(opt.StateDir, StateFileChildPID)
 	if err := ioutil.WriteFile(childPIDPath, []byte(strconv.Itoa(cmd.Process.Pid)), 0444); err != nil {
 		return errors.Wrapf(err, "failed to write the child PID %d to %s", cmd.Process.Pid, childPIDPath)
 	}
+	events.Publish(api.Event{Type: api.EventChildStarted, PID: cmd.Process.Pid})
 	// listens the API
-	apiSockPath := filepath.Join(opt.StateDir, StateFileAPISock)
-	apiCloser, err := listenServeAPI(apiSockPath, &router.Backend{PortDriver: opt.PortDriver})
+	backend := &router.Backend{
+		StateDir:      opt.StateDir,
+		ChildPID:      cmd.Process.Pid,
+		NetworkDriver: opt.NetworkDriver,
+		NetworkDev:    msg.Network.Dev,
+		PortDriver:    opt.PortDriver,
+		CopyUpManager: opt.CopyUpManager,
+		Drivers:       opt.Drivers,
+		Name:          opt.Name,
+		Events:        events,
+	}
+	if startGate != nil {
+		backend.Start = startGate.Start
+	}
+	apiCloser, err := listenServeAPI(apiSockPath, opt.APIToken, backend)
 	if err != nil {
 		return err
 	}
+	if err := notify(opt.Notify, cmd.Process.Pid, hookEnv); err != nil {
+		apiCloser.Close()
+		return err
+	}
+	var childErr error
+	childExited := make(chan struct{})
+	go func() {
+		childErr = cmd.Wait()
+		close(childExited)
+	}()
+	if startGate != nil {
+		if err := startGate.wait(childExited, opt.WaitStartTimeout); err != nil {
+			logrus.WithError(err).Warn("not executing the command")
+		}
+	}
 	// block until the child exits
-	if err := cmd.Wait(); err != nil {
-		return errors.Wrap(err, "child exited")
+	<-childExited
+	if console != nil {
+		if cerr := console.Close(); cerr != nil {
+			logrus.WithError(cerr).Warn("failed to restore the terminal")
+		}
 	}
-	// close the API socket
-	if err := apiCloser.Close(); err != nil {
-		return errors.Wrapf(err, "failed to close %s", apiSockPath)
+	exitCode, ok := common.GetExecExitStatus(childErr)
+	if !ok && childErr != nil {
+		exitCode = -1
 	}
+	events.Publish(api.Event{Type: api.EventChildExited, PID: cmd.Process.Pid, ExitCode: &exitCode})
+	events.Close(eventsCloseTimeout)
+	// close the API socket
+	td.do("failed to close "+apiSockPath, apiCloser.Close)
 	// shut down port driver
 	if opt.PortDriver != nil {
-		portDriverQuit <- struct{}{}
-		err = <-portDriverErr
+		td.do("failed to shut down the port driver", func() error {
+			portDriverQuit <- struct{}{}
+			return <-portDriverErr
+		})
 	}
-	return err
+	if childErr != nil {
+		return errors.Wrap(childErr, "child exited")
+	}
+	return nil
 }
 
 func newugidmapArgs() ([]string, []string, error) {
@@ -222,7 +586,7 @@ func newugidmapArgs() ([]string, []string, error) {
 	// works with external auth, ie sssd, ldap, nis
 	ims, err := idtools.NewIdentityMapping(u.Username, u.Username)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, &common.SubIDNotConfiguredError{User: u.Username, Err: err}
 	}
 
 	uidMapLast := 1
@@ -247,43 +611,135 @@ func newugidmapArgs() ([]string, []string, error) {
 	return uidMap, gidMap, nil
 }
 
-func setupUIDGIDMap(pid int) error {
-	uArgs, gArgs, err := newugidmapArgs()
-	if err != nil {
-		return errors.Wrap(err, "failed to compute uid/gid map")
-	}
+func setupUIDGIDMap(pid int, uArgs, gArgs []string) error {
 	pidS := strconv.Itoa(pid)
 	cmd := exec.Command("newuidmap", append([]string{pidS}, uArgs...)...)
+	common.LogHelperCmd(cmd)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		return errors.Wrapf(err, "newuidmap %s %v failed: %s", pidS, uArgs, string(out))
+		return helperError("newuidmap", errors.Wrapf(err, "newuidmap %s %v failed: %s", pidS, uArgs, string(out)))
 	}
 	cmd = exec.Command("newgidmap", append([]string{pidS}, gArgs...)...)
+	common.LogHelperCmd(cmd)
 	out, err = cmd.CombinedOutput()
 	if err != nil {
-		return errors.Wrapf(err, "newgidmap %s %v failed: %s", pidS, gArgs, string(out))
+		return helperError("newgidmap", errors.Wrapf(err, "newgidmap %s %v failed: %s", pidS, gArgs, string(out)))
+	}
+	return nil
+}
+
+// uidmapHint is the remediation for missing newuidmap and newgidmap.
+const uidmapHint = "install the \"uidmap\" package (Debian, Ubuntu) or the \"shadow-utils\" package (Fedora, RHEL), " +
+	"or use --single-mapping if mapping only the current user to root is enough"
+
+// lookPathUIDGIDMap returns *common.HelperNotFoundError if newuidmap or newgidmap is not installed.
+func lookPathUIDGIDMap() error {
+	for _, helper := range []string{"newuidmap", "newgidmap"} {
+		if _, err := exec.LookPath(helper); err != nil {
+			return &common.HelperNotFoundError{Helper: helper, Err: errors.Wrapf(err, "%s is not installed: %s", helper, uidmapHint)}
+		}
+	}
+	return nil
+}
+
+// setupSingleUIDGIDMap maps the current euid and egid to root in the user namespace of pid.
+// Unprivileged users can write a single-line map of their own IDs, but gid_map requires setgroups(2) to be denied first.
+func setupSingleUIDGIDMap(pid int) error {
+	uidMap := fmt.Sprintf("0 %d 1\n", os.Geteuid())
+	if err := ioutil.WriteFile(fmt.Sprintf("/proc/%d/uid_map", pid), []byte(uidMap), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write uid_map %q", uidMap)
+	}
+	if err := ioutil.WriteFile(fmt.Sprintf("/proc/%d/setgroups", pid), []byte("deny"), 0644); err != nil {
+		return errors.Wrap(err, "failed to deny setgroups")
+	}
+	gidMap := fmt.Sprintf("0 %d 1\n", os.Getegid())
+	if err := ioutil.WriteFile(fmt.Sprintf("/proc/%d/gid_map", pid), []byte(gidMap), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write gid_map %q", gidMap)
 	}
 	return nil
 }
 
+// helperError returns *common.HelperNotFoundError if the cause of err is that helper is not found.
+func helperError(helper string, err error) error {
+	if execErr, ok := errors.Cause(err).(*exec.Error); ok && execErr.Err == exec.ErrNotFound {
+		return &common.HelperNotFoundError{Helper: helper, Err: err}
+	}
+	return err
+}
+
 // apiCloser is implemented by *http.Server
 type apiCloser interface {
 	Close() error
 	Shutdown(context.Context) error
 }
 
-func listenServeAPI(socketPath string, backend *router.Backend) (apiCloser, error) {
+// envWithout returns env without the variable of key. env is returned as is for an empty key.
+func envWithout(env []string, key string) []string {
+	if key == "" {
+		return env
+	}
+	var res []string
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, key+"=") {
+			res = append(res, kv)
+		}
+	}
+	return res
+}
+
+// listenServeAPI listens on socketPath, which can be either a path of UNIX socket, "unix:///path", or "tcp://host:port".
+// token is mandatory for TCP.
+// When socketPath is a custom UNIX socket path, StateFileAPISock in the state dir is created as a symlink to socketPath,
+// so that the clients can find the socket in the state dir.
+func listenServeAPI(socketPath, token string, backend *router.Backend) (apiCloser, error) {
 	r := mux.NewRouter()
 	router.AddRoutes(r, backend)
+	if token != "" {
+		r.Use(router.NewTokenAuthMiddleware(token))
+	}
 	srv := &http.Server{Handler: r}
-	err := os.RemoveAll(socketPath)
+	network, addr, err := api.ParseSocket(socketPath)
 	if err != nil {
 		return nil, err
 	}
-	l, err := net.Listen("unix", socketPath)
+	switch network {
+	case "unix":
+		if err := os.RemoveAll(addr); err != nil {
+			return nil, err
+		}
+	case "tcp":
+		if token == "" {
+			return nil, errors.Errorf("API token is required for listening on %s", socketPath)
+		}
+	}
+	l, err := net.Listen(network, addr)
 	if err != nil {
 		return nil, err
 	}
+	if defaultPath := filepath.Join(backend.StateDir, StateFileAPISock); network == "unix" && addr != defaultPath {
+		if err := os.Symlink(addr, defaultPath); err != nil {
+			l.Close()
+			return nil, errors.Wrapf(err, "failed to create the symlink %s to the API socket %s", defaultPath, addr)
+		}
+	}
 	go srv.Serve(l)
 	return srv, nil
 }
+
+// setOOMScoreAdj writes /proc/<pid>/oom_score_adj.
+// Unprivileged processes can raise the value, but cannot lower it below the value of RootlessKit.
+func setOOMScoreAdj(pid, score int) error {
+	if score < -1000 || score > 1000 {
+		return errors.Errorf("invalid oom_score_adj %d, expected -1000..1000", score)
+	}
+	p := fmt.Sprintf("/proc/%d/oom_score_adj", pid)
+	if err := ioutil.WriteFile(p, []byte(strconv.Itoa(score)), 0644); err != nil {
+		if os.IsPermission(err) {
+			current, _ := ioutil.ReadFile("/proc/self/oom_score_adj")
+			return errors.Wrapf(err, "failed to set oom_score_adj to %d; lowering oom_score_adj below the value of RootlessKit (%s) requires CAP_SYS_RESOURCE",
+				score, strings.TrimSpace(string(current)))
+		}
+		return errors.Wrapf(err, "failed to write %s", p)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package parent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvWithout(t *testing.T) {
+	env := []string{"FOO=1", "ROOTLESSKIT_API_TOKEN=secret", "ROOTLESSKIT_API_TOKEN_X=2", "BAR="}
+	got := envWithout(env, "ROOTLESSKIT_API_TOKEN")
+	expected := []string{"FOO=1", "ROOTLESSKIT_API_TOKEN_X=2", "BAR="}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if got := envWithout(env, ""); !reflect.DeepEqual(got, env) {
+		t.Fatalf("expected %v, got %v", env, got)
+	}
+}
@@ -0,0 +1,128 @@
+package parent
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+	"github.com/rootless-containers/rootlesskit/pkg/port/portutil"
+)
+
+// persistentPortDriver wraps port.ParentDriver, and writes the specs of the active ports
+// to path on each AddPort and RemovePort, so that they can be restored after a crash.
+type persistentPortDriver struct {
+	port.ParentDriver
+	path string
+	// mu serializes writing the file
+	mu sync.Mutex
+}
+
+func newPersistentPortDriver(d port.ParentDriver, path string) *persistentPortDriver {
+	return &persistentPortDriver{
+		ParentDriver: d,
+		path:         path,
+	}
+}
+
+func (d *persistentPortDriver) AddPort(ctx context.Context, spec port.Spec) (*port.Status, error) {
+	st, err := d.ParentDriver.AddPort(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.save(ctx); err != nil {
+		logrus.WithError(err).Warnf("failed to save the ports to %s", d.path)
+	}
+	return st, nil
+}
+
+func (d *persistentPortDriver) RemovePort(ctx context.Context, id int) error {
+	if err := d.ParentDriver.RemovePort(ctx, id); err != nil {
+		return err
+	}
+	if err := d.save(ctx); err != nil {
+		logrus.WithError(err).Warnf("failed to save the ports to %s", d.path)
+	}
+	return nil
+}
+
+// save writes the specs of the current ports atomically.
+func (d *persistentPortDriver) save(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ports, err := d.ParentDriver.ListPorts(ctx)
+	if err != nil {
+		return err
+	}
+	specs := make([]port.Spec, 0, len(ports))
+	for _, st := range ports {
+		specs = append(specs, st.Spec)
+	}
+	b, err := json.Marshal(specs)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(d.path), "."+filepath.Base(d.path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path)
+}
+
+// loadPortSpecs reads the specs written by persistentPortDriver.
+// loadPortSpecs returns nil without an error when the file does not exist.
+func loadPortSpecs(path string) ([]port.Spec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var specs []port.Spec
+	if err := json.Unmarshal(b, &specs); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", path)
+	}
+	return specs, nil
+}
+
+// restorePorts adds the ports in specs.
+// The invalid specs and the specs that conflict with the existing ports are skipped with warnings.
+func restorePorts(ctx context.Context, driver port.Manager, specs []port.Spec) {
+	for _, sp := range specs {
+		current, err := driver.ListPorts(ctx)
+		if err != nil {
+			logrus.WithError(err).Warn("restore-ports: failed to list the ports")
+			return
+		}
+		existing := make(map[int]*port.Status, len(current))
+		for i := range current {
+			existing[current[i].ID] = &current[i]
+		}
+		if err := portutil.ValidatePortSpec(sp, existing); err != nil {
+			logrus.WithError(err).Warnf("restore-ports: skipping port %+v", sp)
+			continue
+		}
+		st, err := driver.AddPort(ctx, sp)
+		if err != nil {
+			logrus.WithError(err).Warnf("restore-ports: failed to add port %+v", sp)
+			continue
+		}
+		logrus.Infof("restore-ports: restored port %+v (ID %d)", sp, st.ID)
+	}
+}
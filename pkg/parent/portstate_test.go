@@ -0,0 +1,123 @@
+package parent
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+// fakePortDriver records the ports without listening
+type fakePortDriver struct {
+	port.ParentDriver
+	mu     sync.Mutex
+	nextID int
+	ports  map[int]port.Spec
+}
+
+func (d *fakePortDriver) AddPort(ctx context.Context, spec port.Spec) (*port.Status, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextID++
+	d.ports[d.nextID] = spec
+	return &port.Status{ID: d.nextID, Spec: spec}, nil
+}
+
+func (d *fakePortDriver) ListPorts(ctx context.Context) ([]port.Status, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var ports []port.Status
+	for id, sp := range d.ports {
+		ports = append(ports, port.Status{ID: id, Spec: sp})
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].ID < ports[j].ID })
+	return ports, nil
+}
+
+func (d *fakePortDriver) RemovePort(ctx context.Context, id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.ports, id)
+	return nil
+}
+
+func newFakePortDriver() *fakePortDriver {
+	return &fakePortDriver{ports: make(map[int]port.Spec)}
+}
+
+func TestPersistentPortDriver(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "portstate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, StateFilePorts)
+	ctx := context.TODO()
+
+	specA := port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80}
+	specB := port.Spec{Proto: "udp", ParentPort: 5353, ChildPort: 53}
+	d := newPersistentPortDriver(newFakePortDriver(), path)
+	stA, err := d.AddPort(ctx, specA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.AddPort(ctx, specB); err != nil {
+		t.Fatal(err)
+	}
+	specs, err := loadPortSpecs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []port.Spec{specA, specB}; !reflect.DeepEqual(specs, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, specs)
+	}
+	if err := d.RemovePort(ctx, stA.ID); err != nil {
+		t.Fatal(err)
+	}
+	specs, err = loadPortSpecs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []port.Spec{specB}; !reflect.DeepEqual(specs, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, specs)
+	}
+
+	specs, err = loadPortSpecs(filepath.Join(tmp, "nonexistent"))
+	if err != nil || specs != nil {
+		t.Fatalf("expected nil without an error, got %+v, %v", specs, err)
+	}
+}
+
+func TestRestorePorts(t *testing.T) {
+	ctx := context.TODO()
+	published := port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80}
+	d := newFakePortDriver()
+	if _, err := d.AddPort(ctx, published); err != nil {
+		t.Fatal(err)
+	}
+	valid := port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8443, ChildPort: 443}
+	specs := []port.Spec{
+		published, // conflicts with the already published one
+		{Proto: "sctp", ParentPort: 9000, ChildPort: 9000}, // invalid
+		valid,
+		valid, // conflicts with the restored one
+	}
+	restorePorts(ctx, d, specs)
+	ports, err := d.ListPorts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []port.Spec
+	for _, st := range ports {
+		got = append(got, st.Spec)
+	}
+	if expected := []port.Spec{published, valid}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, got)
+	}
+}
@@ -0,0 +1,54 @@
+package parent
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rootless-containers/rootlesskit/pkg/common"
+)
+
+// runPreExecCmds executes cmds with "/bin/sh -c" one by one in the current namespaces,
+// with the stdio of RootlessKit. env is appended to the current environment.
+// runPreExecCmds returns the error of the first failed command, without executing the rest.
+func runPreExecCmds(cmds []string, env []string) error {
+	for _, s := range cmds {
+		cmd := exec.Command("/bin/sh", "-c", s)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), env...)
+		common.LogHelperCmd(cmd)
+		if err := cmd.Run(); err != nil {
+			return errors.Wrapf(err, "parent pre-exec command %q failed", s)
+		}
+	}
+	return nil
+}
+
+// runPostStopCmds executes cmds with "/bin/sh -c" one by one in the current namespaces,
+// with the stdio of RootlessKit. env is appended to the current environment.
+// Unlike runPreExecCmds, runPostStopCmds executes all the commands even if some of them fail,
+// and returns the error of the first failed command.
+func runPostStopCmds(cmds []string, env []string) error {
+	var firstErr error
+	for _, s := range cmds {
+		cmd := exec.Command("/bin/sh", "-c", s)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), env...)
+		common.LogHelperCmd(cmd)
+		if err := cmd.Run(); err != nil {
+			err = errors.Wrapf(err, "parent post-stop command %q failed", s)
+			if firstErr == nil {
+				firstErr = err
+			} else {
+				logrus.Warn(err)
+			}
+		}
+	}
+	return firstErr
+}
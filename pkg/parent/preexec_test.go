@@ -0,0 +1,70 @@
+package parent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunPreExecCmds(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-preexec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	out := filepath.Join(tmp, "out")
+	env := []string{"TEST_PREEXEC_OUT=" + out}
+	cmds := []string{
+		"echo foo >$TEST_PREEXEC_OUT",
+		"false",
+		"echo bar >>$TEST_PREEXEC_OUT",
+	}
+	if err := runPreExecCmds(cmds, env); err == nil {
+		t.Fatal("expected an error")
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the commands after the failed one are not executed
+	if got := string(b); got != "foo\n" {
+		t.Fatalf("expected \"foo\\n\", got %q", got)
+	}
+	if err := runPreExecCmds(cmds[:1], env); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunPostStopCmds(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-poststop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	out := filepath.Join(tmp, "out")
+	env := []string{"TEST_POSTSTOP_OUT=" + out}
+	cmds := []string{
+		"echo foo >$TEST_POSTSTOP_OUT",
+		"exit 42",
+		"false",
+		"echo bar >>$TEST_POSTSTOP_OUT",
+	}
+	err = runPostStopCmds(cmds, env)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	// the error of the first failed command is returned
+	if !strings.Contains(err.Error(), "exit 42") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the commands after the failed one are executed as well
+	if got := string(b); got != "foo\nbar\n" {
+		t.Fatalf("expected \"foo\\nbar\\n\", got %q", got)
+	}
+}
@@ -0,0 +1,99 @@
+package parent
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+	"github.com/rootless-containers/rootlesskit/pkg/port/portutil"
+)
+
+// publishFile manages the ports listed in the publish file.
+// The ports published via other ways (PublishPorts and the API) are not affected.
+type publishFile struct {
+	path   string
+	driver port.Manager
+	mu     sync.Mutex
+	// ids are the port IDs of the specs in the file
+	ids map[port.Spec]int
+}
+
+// reload reads the file, and applies the diff against the previous content.
+// reload continues applying the rest of the diff on an error, and returns the first error.
+func (p *publishFile) reload(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+	desired, err := portutil.ParsePortSpecs(f)
+	f.Close()
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse %s", p.path)
+	}
+	var current []port.Spec
+	for sp := range p.ids {
+		current = append(current, sp)
+	}
+	added, removed := portutil.DiffPortSpecs(current, desired)
+	var firstErr error
+	for _, sp := range removed {
+		if err := p.driver.RemovePort(ctx, p.ids[sp]); err != nil {
+			logrus.WithError(err).Warnf("publish-file: failed to remove port %+v", sp)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		delete(p.ids, sp)
+		logrus.Infof("publish-file: removed port %+v", sp)
+	}
+	for _, sp := range added {
+		st, err := p.driver.AddPort(ctx, sp)
+		if err != nil {
+			logrus.WithError(err).Warnf("publish-file: failed to add port %+v", sp)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		p.ids[sp] = st.ID
+		logrus.Infof("publish-file: added port %+v (ID %d)", sp, st.ID)
+	}
+	return firstErr
+}
+
+// watchPublishFile publishes the ports in the file, and reloads the file on SIGUSR1.
+// The returned function stops watching.
+func watchPublishFile(path string, driver port.Manager) (func(), error) {
+	p := &publishFile{
+		path:   path,
+		driver: driver,
+		ids:    make(map[port.Spec]int),
+	}
+	if err := p.reload(context.TODO()); err != nil {
+		return nil, errors.Wrapf(err, "failed to publish ports in %s", path)
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1)
+	go func() {
+		for range sigCh {
+			logrus.Infof("publish-file: reloading %s", path)
+			if err := p.reload(context.TODO()); err != nil {
+				logrus.WithError(err).Warnf("publish-file: failed to reload %s", path)
+			}
+		}
+	}()
+	stop := func() {
+		signal.Stop(sigCh)
+		close(sigCh)
+	}
+	return stop, nil
+}
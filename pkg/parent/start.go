@@ -0,0 +1,73 @@
+package parent
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// startGate holds the write end of the pipe that the child reads before executing the command.
+type startGate struct {
+	w    *os.File
+	once sync.Once
+	done chan struct{}
+}
+
+func newStartGate(w *os.File) *startGate {
+	return &startGate{
+		w:    w,
+		done: make(chan struct{}),
+	}
+}
+
+// Start lets the child execute the command. Start can be called multiple times.
+func (g *startGate) Start() {
+	g.once.Do(func() {
+		// the child distinguishes the start from the parent death by the byte
+		if _, err := g.w.Write([]byte{1}); err != nil {
+			logrus.WithError(err).Warn("failed to notify the child to start")
+		}
+		g.w.Close()
+		close(g.done)
+	})
+}
+
+// abort closes the pipe without letting the child execute the command, unless Start has been already called.
+func (g *startGate) abort() {
+	g.once.Do(func() {
+		g.w.Close()
+	})
+}
+
+// wait blocks until Start is called, either via the API or SIGUSR2, or until childExited is closed.
+// When the start is not requested within timeout, wait aborts the gate so that the child exits
+// without executing the command. timeout is ignored if zero.
+// wait returns an error if the command is not going to be executed.
+func (g *startGate) wait(childExited <-chan struct{}, timeout time.Duration) error {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR2)
+	defer signal.Stop(sigCh)
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	logrus.Debug("waiting for the start request (API or SIGUSR2)")
+	select {
+	case <-g.done:
+	case <-sigCh:
+		g.Start()
+	case <-childExited:
+		return errors.New("the child exited before the start was requested")
+	case <-timeoutCh:
+		g.abort()
+		return errors.Errorf("the start was not requested within %v", timeout)
+	}
+	return nil
+}
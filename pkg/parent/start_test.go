@@ -0,0 +1,116 @@
+package parent
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStartGate(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	g := newStartGate(w)
+	go g.Start()
+	if err := g.wait(nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	// idempotent
+	g.Start()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 1 {
+		t.Fatalf("expected exactly one byte, got %v", b)
+	}
+}
+
+func TestStartGateSignal(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	g := newStartGate(w)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	}()
+	if err := g.wait(nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-g.done:
+	default:
+		t.Fatal("expected the gate to be started")
+	}
+}
+
+func TestStartGateAbort(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	g := newStartGate(w)
+	g.abort()
+	// no-op after abort
+	g.Start()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 0 {
+		t.Fatalf("expected no byte, got %v", b)
+	}
+}
+
+func TestStartGateChildExited(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	g := newStartGate(w)
+	defer g.abort()
+	childExited := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(childExited)
+	}()
+	if err := g.wait(childExited, 0); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestStartGateTimeout(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	g := newStartGate(w)
+	if err := g.wait(nil, 100*time.Millisecond); err == nil {
+		t.Fatal("expected an error")
+	}
+	// the child reads EOF without the start byte
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 0 {
+		t.Fatalf("expected no byte, got %v", b)
+	}
+	// no-op after the timeout
+	g.Start()
+	select {
+	case <-g.done:
+		t.Fatal("expected the gate not to be started")
+	default:
+	}
+}
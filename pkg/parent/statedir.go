@@ -0,0 +1,51 @@
+package parent
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// isTmpfs returns true if dir is on tmpfs.
+func isTmpfs(dir string) (bool, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return false, errors.Wrapf(err, "failed to statfs %s", dir)
+	}
+	return st.Type == unix.TMPFS_MAGIC, nil
+}
+
+// setupStateDirTmpfs mounts a private tmpfs on dir, so that the sockets and the files in the state dir are kept off the disk.
+// When dir is already on tmpfs (e.g. under $XDG_RUNTIME_DIR), tmpfs is not mounted, so as not to hide the files of
+// another instance that might have mounted it.
+// When mounting is not permitted (i.e. without the root in the current user namespace), dir needs to be already on tmpfs.
+// A mount namespace is not created for mounting, as the parent needs to stay in the mount namespace of the caller.
+//
+// The caller needs to lock dir in advance, so that concurrent instances do not mount tmpfs over each other.
+// mounted is true if tmpfs was mounted. The returned function unmounts the tmpfs, if mounted.
+func setupStateDirTmpfs(dir string) (cleanup func() error, mounted bool, err error) {
+	nop := func() error { return nil }
+	tmpfs, err := isTmpfs(dir)
+	if err != nil {
+		return nil, false, err
+	}
+	if tmpfs {
+		logrus.Debugf("the state dir %s is already on tmpfs, not mounting tmpfs", dir)
+		return nop, false, os.Chmod(dir, 0700)
+	}
+	if err := unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "mode=0700"); err != nil {
+		if err == unix.EPERM {
+			return nil, false, errors.Wrapf(err, "failed to mount tmpfs on the state dir %s, and %s is not on tmpfs (hint: use a directory under $XDG_RUNTIME_DIR)", dir, dir)
+		}
+		return nil, false, errors.Wrapf(err, "failed to mount tmpfs on the state dir %s", dir)
+	}
+	cleanup = func() error {
+		if err := unix.Unmount(dir, unix.MNT_DETACH); err != nil {
+			return errors.Wrapf(err, "failed to unmount the state dir %s", dir)
+		}
+		return nil
+	}
+	return cleanup, true, nil
+}
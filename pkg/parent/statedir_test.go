@@ -0,0 +1,61 @@
+package parent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetupStateDirTmpfs(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-statedir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "state")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cleanup, mounted, err := setupStateDirTmpfs(dir)
+	if err != nil {
+		// neither permitted to mount, nor already on tmpfs
+		t.Skip(err)
+	}
+	tmpfs, err := isTmpfs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tmpfs {
+		t.Fatalf("%s is not on tmpfs", dir)
+	}
+	st, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := st.Mode().Perm(); perm != 0700 {
+		t.Fatalf("expected 0700, got %o", perm)
+	}
+	if !mounted {
+		// already on tmpfs
+		return
+	}
+	// tmpfs is not mounted again over the mounted tmpfs
+	cleanup2, mounted2, err := setupStateDirTmpfs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mounted2 {
+		cleanup2()
+		t.Fatalf("tmpfs was mounted again on %s", dir)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, StateFileLock), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, StateFileLock)); !os.IsNotExist(err) {
+		t.Fatalf("expected the content of the tmpfs to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package parent
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// TeardownError is returned by Parent when some of the teardown steps failed, with Opt.StrictTeardown.
+type TeardownError struct {
+	Errs []error
+}
+
+func (e *TeardownError) Error() string {
+	var ss []string
+	for _, err := range e.Errs {
+		ss = append(ss, err.Error())
+	}
+	return "teardown failed: " + strings.Join(ss, "; ")
+}
+
+// teardown collects the errors of the teardown steps, so that all the steps are attempted even when some of them fail
+// (e.g. unmounting a busy mount), and the exit status of the child is not obscured by them.
+type teardown struct {
+	errs []error
+}
+
+// add records err, unless nil. The error is logged immediately.
+func (t *teardown) add(err error) {
+	if err == nil {
+		return
+	}
+	logrus.WithError(err).Warn("teardown step failed")
+	t.errs = append(t.errs, err)
+}
+
+// do executes f, and records the error wrapped with msg.
+func (t *teardown) do(msg string, f func() error) {
+	t.add(errors.Wrap(f(), msg))
+}
+
+// result returns the error of Parent. The recorded errors are returned only when strict is true and retErr is nil,
+// so that the error of the child (propagated as the exit status) takes precedence over them.
+func (t *teardown) result(retErr error, strict bool) error {
+	if len(t.errs) == 0 || retErr != nil {
+		return retErr
+	}
+	if strict {
+		return &TeardownError{Errs: t.errs}
+	}
+	logrus.Warnf("%d teardown step(s) failed, ignoring (strict teardown is not enabled)", len(t.errs))
+	return nil
+}
@@ -0,0 +1,68 @@
+package parent
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestTeardown(t *testing.T) {
+	childErr := errors.New("child exited: exit status 42")
+	testCases := []struct {
+		steps    []error
+		retErr   error
+		strict   bool
+		expected func(error) bool
+	}{
+		{
+			expected: func(err error) bool { return err == nil },
+		},
+		{
+			steps:    []error{nil, nil},
+			strict:   true,
+			expected: func(err error) bool { return err == nil },
+		},
+		{
+			steps:    []error{errors.New("busy"), nil},
+			expected: func(err error) bool { return err == nil },
+		},
+		{
+			steps:  []error{errors.New("busy"), nil, errors.New("exit status 1")},
+			strict: true,
+			expected: func(err error) bool {
+				tdErr, ok := err.(*TeardownError)
+				return ok && len(tdErr.Errs) == 2
+			},
+		},
+		{
+			steps:    []error{errors.New("busy")},
+			retErr:   childErr,
+			expected: func(err error) bool { return err == childErr },
+		},
+		{
+			steps:    []error{errors.New("busy")},
+			retErr:   childErr,
+			strict:   true,
+			expected: func(err error) bool { return err == childErr },
+		},
+	}
+	for i, tc := range testCases {
+		var (
+			td       teardown
+			executed int
+		)
+		for _, err := range tc.steps {
+			err := err
+			td.do("step", func() error {
+				executed++
+				return err
+			})
+		}
+		if executed != len(tc.steps) {
+			t.Errorf("#%d: expected %d steps to be executed, got %d", i, len(tc.steps), executed)
+		}
+		if err := td.result(tc.retErr, tc.strict); !tc.expected(err) {
+			t.Errorf("#%d: unexpected error %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package parent
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// RunningInUserNS returns true if the current process is running in a user namespace other than the initial one,
+// e.g. inside another RootlessKit or a rootless container.
+func RunningInUserNS() bool {
+	f, err := os.Open("/proc/self/uid_map")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return !isInitialUIDMap(f)
+}
+
+// isInitialUIDMap returns true if r is the uid_map of the initial user namespace ("0 0 4294967295").
+func isInitialUIDMap(r io.Reader) bool {
+	scanner := bufio.NewScanner(r)
+	var lines [][]string
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) != 0 {
+			lines = append(lines, fields)
+		}
+	}
+	if len(lines) != 1 || len(lines[0]) != 3 {
+		return false
+	}
+	return lines[0][0] == "0" && lines[0][1] == "0" && lines[0][2] == "4294967295"
+}
@@ -0,0 +1,58 @@
+package parent
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rootless-containers/rootlesskit/pkg/common"
+)
+
+func TestIsInitialUIDMap(t *testing.T) {
+	testCases := map[string]bool{
+		"         0          0 4294967295\n":                                   true,
+		"         0       1001          1\n         1     231072      65536\n": false,
+		"         0          0      65536\n":                                   false,
+		"":                                                                     false,
+	}
+	for s, expected := range testCases {
+		if got := isInitialUIDMap(strings.NewReader(s)); got != expected {
+			t.Errorf("%q: expected %v, got %v", s, expected, got)
+		}
+	}
+}
+
+func TestLookPathUIDGIDMap(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-lookpath-uidgidmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	oldPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", oldPath)
+	os.Setenv("PATH", tmp)
+
+	err = lookPathUIDGIDMap()
+	helperErr, ok := err.(*common.HelperNotFoundError)
+	if !ok {
+		t.Fatalf("expected *common.HelperNotFoundError, got %T (%v)", err, err)
+	}
+	if helperErr.Helper != "newuidmap" {
+		t.Errorf("expected newuidmap, got %q", helperErr.Helper)
+	}
+	for _, s := range []string{"uidmap", "--single-mapping"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in the error, got %q", s, err.Error())
+		}
+	}
+
+	for _, helper := range []string{"newuidmap", "newgidmap"} {
+		if err := ioutil.WriteFile(tmp+"/"+helper, []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lookPathUIDGIDMap(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -6,9 +6,10 @@ import (
 	"github.com/rootless-containers/rootlesskit/pkg/port"
 	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/child"
 	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/parent"
+	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/parent/accesslog"
 )
 
 var (
-	NewParentDriver func(logWriter io.Writer, stateDir string) (port.ParentDriver, error) = parent.NewDriver
-	NewChildDriver  func(logWriter io.Writer) port.ChildDriver                            = child.NewDriver
+	NewParentDriver func(logWriter io.Writer, stateDir string, backlog, relayWorkers int, accessLog *accesslog.Logger) (port.ParentDriver, error) = parent.NewDriver
+	NewChildDriver  func(logWriter io.Writer) port.ChildDriver                                                                                    = child.NewDriver
 )
@@ -1,10 +1,12 @@
 package child
 
 import (
-	"fmt"
 	"io"
 	"net"
 	"os"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
@@ -13,6 +15,7 @@ import (
 	"github.com/rootless-containers/rootlesskit/pkg/port"
 	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/msg"
 	opaquepkg "github.com/rootless-containers/rootlesskit/pkg/port/builtin/opaque"
+	"github.com/rootless-containers/rootlesskit/pkg/port/portutil"
 )
 
 func NewDriver(logWriter io.Writer) port.ChildDriver {
@@ -70,12 +73,14 @@ func (d *childDriver) RunChildDriver(opaque map[string]string, quit <-chan struc
 				rep := msg.Reply{
 					Error: rerr.Error(),
 				}
+				if ne, ok := errors.Cause(rerr).(net.Error); ok && ne.Timeout() {
+					rep.Timeout = true
+				}
 				msgutil.MarshalToWriter(c, &rep)
 			}
 			c.Close()
 		}()
 	}
-	return nil
 }
 
 func (d *childDriver) routine(c *net.UnixConn) error {
@@ -88,6 +93,10 @@ func (d *childDriver) routine(c *net.UnixConn) error {
 		return d.handleConnectInit(c, &req)
 	case msg.RequestTypeConnect:
 		return d.handleConnectRequest(c, &req)
+	case msg.RequestTypeListen:
+		return d.handleListenRequest(c, &req)
+	case msg.RequestTypeValidateIP:
+		return d.handleValidateIPRequest(c, &req)
 	default:
 		return errors.Errorf("unknown request type %q", req.Type)
 	}
@@ -105,30 +114,134 @@ func (d *childDriver) handleConnectRequest(c *net.UnixConn, req *msg.Request) er
 	default:
 		return errors.Errorf("unknown proto: %q", req.Proto)
 	}
-	var dialer net.Dialer
-	targetConn, err := dialer.Dial(req.Proto, fmt.Sprintf("127.0.0.1:%d", req.Port))
+	if req.Socket != "" {
+		if req.Proto != "tcp" {
+			return errors.Errorf("UNIX socket is supported only for tcp, got %q", req.Proto)
+		}
+		dialer := net.Dialer{Timeout: dialTimeout(req)}
+		targetConn, err := dialer.Dial("unix", req.Socket)
+		if err != nil {
+			return err
+		}
+		defer targetConn.Close() // no effect on duplicated FD
+		return sendConn(c, targetConn)
+	}
+	ip := net.ParseIP("127.0.0.1")
+	if req.IP != "" {
+		ip = net.ParseIP(req.IP)
+		if ip == nil {
+			return errors.Errorf("invalid IP: %q", req.IP)
+		}
+		if err := validateChildIP(ip); err != nil {
+			return err
+		}
+	}
+	dialer := net.Dialer{Timeout: dialTimeout(req)}
+	if req.Proto == "tcp" && req.TCPFastOpen {
+		if enabled, err := portutil.TCPFastOpenEnabled(portutil.TCPFastOpenClient); err == nil && enabled {
+			dialer.Control = controlFastOpenConnect
+		}
+	}
+	targetConn, err := dialer.Dial(req.Proto, net.JoinHostPort(ip.String(), strconv.Itoa(req.Port)))
 	if err != nil {
 		return err
 	}
 	defer targetConn.Close() // no effect on duplicated FD
+	return sendConn(c, targetConn)
+}
+
+func (d *childDriver) handleValidateIPRequest(c *net.UnixConn, req *msg.Request) error {
+	ip := net.ParseIP(req.IP)
+	if ip == nil {
+		return errors.Errorf("invalid IP: %q", req.IP)
+	}
+	if err := validateChildIP(ip); err != nil {
+		return err
+	}
+	_, err := msgutil.MarshalToWriter(c, &msg.Reply{})
+	return err
+}
+
+// handleListenRequest listens on the abstract UNIX socket, and sends the FD of the listener.
+// Abstract sockets are scoped by the network namespace, so the listener needs to be created in the child.
+func (d *childDriver) handleListenRequest(c *net.UnixConn, req *msg.Request) error {
+	if req.Proto != "tcp" {
+		return errors.Errorf("abstract UNIX socket is supported only for tcp, got %q", req.Proto)
+	}
+	if req.AbstractSocket == "" {
+		return errors.New("abstract socket name not set")
+	}
+	// the leading "@" is translated to NUL by the net package
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: "@" + req.AbstractSocket, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	// the socket remains open in the parent
+	defer ln.Close()
+	return sendFile(c, ln)
+}
+
+func dialTimeout(req *msg.Request) time.Duration {
+	timeout := req.DialTimeout
+	if timeout == 0 {
+		timeout = port.DefaultDialTimeout
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// controlFastOpenConnect sets TCP_FASTOPEN_CONNECT. Errors are ignored, so as to fall back to the regular TCP
+// on kernels prior to 4.11.
+func controlFastOpenConnect(network, address string, c syscall.RawConn) error {
+	return c.Control(func(fd uintptr) {
+		unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1)
+	})
+}
+
+// sendConn sends the FD of targetConn to c as an SCM_RIGHTS cmsg
+func sendConn(c *net.UnixConn, targetConn net.Conn) error {
 	targetConnFiler, ok := targetConn.(filer)
 	if !ok {
 		return errors.Errorf("unknown target connection: %+v", targetConn)
 	}
-	targetConnFile, err := targetConnFiler.File()
+	return sendFile(c, targetConnFiler)
+}
+
+// sendFile sends the FD of target to c as an SCM_RIGHTS cmsg
+func sendFile(c *net.UnixConn, target filer) error {
+	targetFile, err := target.File()
 	if err != nil {
 		return err
 	}
-	oob := unix.UnixRights(int(targetConnFile.Fd()))
+	// the duplicated FD is no longer needed in the child after sending
+	defer targetFile.Close()
+	oob := unix.UnixRights(int(targetFile.Fd()))
 	f, err := c.File()
 	if err != nil {
 		return err
 	}
-	err = unix.Sendmsg(int(f.Fd()), []byte("dummy"), oob, nil, 0)
-	return err
+	defer f.Close()
+	return unix.Sendmsg(int(f.Fd()), []byte("dummy"), oob, nil, 0)
+}
+
+// validateChildIP returns an error unless ip is a loopback address
+// or within the networks configured in the child.
+func validateChildIP(ip net.IP) error {
+	if ip.IsLoopback() {
+		return nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.Contains(ip) {
+			return nil
+		}
+	}
+	return errors.Errorf("IP %s is not within the networks of the child", ip)
 }
 
-// filer is implemented by *net.TCPConn and *net.UDPConn
+// filer is implemented by *net.TCPConn, *net.UDPConn, *net.UnixConn, and *net.UnixListener
 type filer interface {
 	File() (f *os.File, err error)
 }
@@ -14,18 +14,42 @@ import (
 const (
 	RequestTypeInit    = "init"
 	RequestTypeConnect = "connect"
+	RequestTypeListen  = "listen"
+	// RequestTypeValidateIP validates that IP is within the networks of the child. No FD is sent.
+	RequestTypeValidateIP = "validate-ip"
 )
 
 // Request and Response are encoded as JSON with uint32le length header.
 type Request struct {
-	Type  string // "init" or "connect"
+	Type  string // "init", "connect", "listen", or "validate-ip"
 	Proto string // "tcp" or "udp"
+	IP    string // can be empty (127.0.0.1)
 	Port  int
+	// Socket is the path of the UNIX socket in the child. Port and IP are ignored when Socket is set.
+	Socket string
+	// TCPFastOpen sets TCP_FASTOPEN_CONNECT on the connection in the child, if supported.
+	TCPFastOpen bool
+	// DialTimeout is the timeout in seconds for connecting to the target in the child. 0 for port.DefaultDialTimeout.
+	DialTimeout int
+	// AbstractSocket is the name of the abstract UNIX socket to listen on in the child, without the leading "@".
+	// Used only for "listen".
+	AbstractSocket string
 }
 
 // Reply may contain FD as OOB
 type Reply struct {
 	Error string
+	// Timeout is set when connecting to the target in the child timed out.
+	Timeout bool
+}
+
+// DialTimeoutError is returned by ConnectToChild when connecting to the target in the child timed out.
+type DialTimeoutError struct {
+	Message string
+}
+
+func (e *DialTimeoutError) Error() string {
+	return e.Message
 }
 
 // Initiate sends "init" request to the child UNIX socket.
@@ -50,11 +74,67 @@ func Initiate(c *net.UnixConn) error {
 // that corresponds to the port spec.
 func ConnectToChild(c *net.UnixConn, spec port.Spec) (int, error) {
 	req := Request{
-		Type:  RequestTypeConnect,
-		Proto: spec.Proto,
-		Port:  spec.ChildPort,
+		Type:        RequestTypeConnect,
+		Proto:       spec.Proto,
+		IP:          spec.ChildIP,
+		Port:        spec.ChildPort,
+		Socket:      spec.ChildSocket,
+		TCPFastOpen: spec.TCPFastOpen,
+		DialTimeout: spec.DialTimeout,
+	}
+	return request(c, &req)
+}
+
+// ListenInChild connects to the child UNIX socket, and obtains the FD of the listener
+// on the abstract UNIX socket spec.ChildAbstractSocket, created in the network namespace of the child.
+func ListenInChild(socketPath string, spec port.Spec) (int, error) {
+	var dialer net.Dialer
+	conn, err := dialer.Dial("unix", socketPath)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	req := Request{
+		Type:           RequestTypeListen,
+		Proto:          spec.Proto,
+		AbstractSocket: spec.ChildAbstractSocket,
+	}
+	return request(conn.(*net.UnixConn), &req)
+}
+
+// ValidateChildIP connects to the child UNIX socket, and validates that ip is a loopback address
+// or within the networks of the child, so that the spec can be rejected on AddPort rather than on connecting.
+func ValidateChildIP(socketPath, ip string) error {
+	var dialer net.Dialer
+	conn, err := dialer.Dial("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c := conn.(*net.UnixConn)
+	req := Request{
+		Type: RequestTypeValidateIP,
+		IP:   ip,
 	}
 	if _, err := msgutil.MarshalToWriter(c, &req); err != nil {
+		return err
+	}
+	if err := c.CloseWrite(); err != nil {
+		return err
+	}
+	var rep Reply
+	if _, err := msgutil.UnmarshalFromReader(c, &rep); err != nil {
+		return err
+	}
+	if rep.Error != "" {
+		return errors.New(rep.Error)
+	}
+	return nil
+}
+
+// request sends req, and receives the FD as an SCM_RIGHTS cmsg.
+func request(c *net.UnixConn, req *Request) (int, error) {
+	if _, err := msgutil.MarshalToWriter(c, req); err != nil {
 		return 0, err
 	}
 	if err := c.CloseWrite(); err != nil {
@@ -62,10 +142,21 @@ func ConnectToChild(c *net.UnixConn, spec port.Spec) (int, error) {
 	}
 	oobSpace := unix.CmsgSpace(4)
 	oob := make([]byte, oobSpace)
-	_, oobN, _, _, err := c.ReadMsgUnix(nil, oob)
+	// the child sends either "dummy" with the FD, or Reply without the FD
+	b := make([]byte, 4096)
+	n, oobN, _, _, err := c.ReadMsgUnix(b, oob)
 	if err != nil {
 		return 0, err
 	}
+	if oobN == 0 {
+		var rep Reply
+		if err := msgutil.Unmarshal(b[:n], &rep); err == nil && rep.Error != "" {
+			if rep.Timeout {
+				return 0, &DialTimeoutError{Message: rep.Error}
+			}
+			return 0, errors.New(rep.Error)
+		}
+	}
 	if oobN != oobSpace {
 		return 0, errors.Errorf("expected OOB space %d, got %d", oobSpace, oobN)
 	}
@@ -93,9 +184,13 @@ func ConnectToChildWithSocketPath(socketPath string, spec port.Spec) (int, error
 }
 
 // ConnectToChildWithRetry retries ConnectToChild every (i*5) milliseconds.
+// *DialTimeoutError is not retried.
 func ConnectToChildWithRetry(socketPath string, spec port.Spec, retries int) (int, error) {
 	for i := 0; i < retries; i++ {
 		fd, err := ConnectToChildWithSocketPath(socketPath, spec)
+		if _, ok := err.(*DialTimeoutError); ok {
+			return 0, err
+		}
 		if i == retries-1 && err != nil {
 			return 0, err
 		}
@@ -0,0 +1,137 @@
+// Package accesslog provides the access log of the forwarded connections, rotated by size.
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Logger appends the records to the file, and rotates the file when the size exceeds MaxSize.
+// Logger is thread-safe. The methods of nil *Logger are no-op.
+type Logger struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	mu       sync.Mutex
+	f        *os.File
+	size     int64
+}
+
+// New opens the access log file.
+// maxSize is the size in bytes for rotating the file. 0 disables the rotation.
+// maxFiles is the number of the rotated files to be kept as path.1, path.2, ...
+func New(path string, maxSize int64, maxFiles int) (*Logger, error) {
+	if maxSize < 0 {
+		return nil, errors.Errorf("invalid max size %d", maxSize)
+	}
+	if maxFiles < 0 {
+		return nil, errors.Errorf("invalid max files %d", maxFiles)
+	}
+	l := &Logger{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open the access log %s", l.path)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, st.Size()
+	return nil
+}
+
+// rotate renames path.N-1 to path.N, ..., path to path.1, and opens the new file.
+func (l *Logger) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if l.maxFiles == 0 {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return l.open()
+	}
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		old := l.path + "." + strconv.Itoa(i)
+		if err := os.Rename(old, l.path+"."+strconv.Itoa(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return l.open()
+}
+
+// write writes the line, rotating the file before exceeding maxSize.
+func (l *Logger) write(line string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return errors.Errorf("the access log %s is already closed", l.path)
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return errors.Wrapf(err, "failed to rotate the access log %s", l.path)
+		}
+	}
+	n, err := l.f.WriteString(line)
+	l.size += int64(n)
+	return err
+}
+
+func (l *Logger) record(event, local, remote, extra string) error {
+	if l == nil {
+		return nil
+	}
+	line := fmt.Sprintf("%s event=%s local=%s remote=%s%s\n", time.Now().UTC().Format(time.RFC3339Nano), event, local, remote, extra)
+	return l.write(line)
+}
+
+// Accept records an accepted connection.
+func (l *Logger) Accept(local, remote string) error {
+	return l.record("accept", local, remote, "")
+}
+
+// Reject records a connection rejected by the parent, e.g. due to MaxConnections.
+func (l *Logger) Reject(local, remote, reason string) error {
+	return l.record("reject", local, remote, fmt.Sprintf(" reason=%q", reason))
+}
+
+// Close records a closed connection.
+// rx is the bytes received from the remote, and tx is the bytes sent to the remote.
+func (l *Logger) Close(local, remote string, duration time.Duration, rx, tx int64) error {
+	return l.record("close", local, remote, fmt.Sprintf(" duration=%s rx=%d tx=%d", duration, rx, tx))
+}
+
+// Shutdown closes the file. The records after Shutdown are not written.
+func (l *Logger) Shutdown() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
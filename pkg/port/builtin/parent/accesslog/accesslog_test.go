@@ -0,0 +1,79 @@
+package accesslog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "access.log")
+	l, err := New(path, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Shutdown()
+	for i := 0; i < 20; i++ {
+		if err := l.Close("127.0.0.1:8080", "127.0.0.1:12345", time.Second, 42, 43); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) == 0 || len(b) > 200 {
+			t.Errorf("unexpected size of %s: %d", p, len(b))
+		}
+		if !strings.Contains(string(b), "event=close local=127.0.0.1:8080 remote=127.0.0.1:12345 duration=1s rx=42 tx=43\n") {
+			t.Errorf("unexpected content of %s: %q", p, string(b))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected %s.3 not to exist, got %v", path, err)
+	}
+}
+
+func TestNil(t *testing.T) {
+	var l *Logger
+	if err := l.Accept("127.0.0.1:8080", "127.0.0.1:12345"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	l, err := New(filepath.Join(tmpDir, "access.log"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Accept("127.0.0.1:8080", "127.0.0.1:12345"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	// idempotent
+	if err := l.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Accept("127.0.0.1:8080", "127.0.0.1:12345"); err == nil {
+		t.Fatal("expected an error after Shutdown")
+	}
+}
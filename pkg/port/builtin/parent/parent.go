@@ -15,15 +15,23 @@ import (
 	"github.com/rootless-containers/rootlesskit/pkg/port"
 	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/msg"
 	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/opaque"
+	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/parent/accesslog"
 	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/parent/tcp"
 	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/parent/udp"
+	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/parent/udp/udpproxy"
 	"github.com/rootless-containers/rootlesskit/pkg/port/portutil"
 )
 
 // NewDriver for builtin driver.
-func NewDriver(logWriter io.Writer, stateDir string) (port.ParentDriver, error) {
-	// TODO: consider using socketpair FD instead of socket file
-	socketPath := filepath.Join(stateDir, ".bp.sock")
+// backlog is the listen backlog of the TCP ports. 0 for the default (net.core.somaxconn).
+// relayWorkers caps the number of the TCP connections relayed concurrently on each port (see tcp.WorkerPool).
+// The excess connections are queued until a relayed connection is closed. 0 for unlimited.
+// accessLog records the TCP connections. nil to disable.
+func NewDriver(logWriter io.Writer, stateDir string, backlog, relayWorkers int, accessLog *accesslog.Logger) (port.ParentDriver, error) {
+	if relayWorkers < 0 {
+		return nil, errors.Errorf("invalid relay workers: %d", relayWorkers)
+	}
+	socketPath := SocketPath(stateDir)
 	childReadyPipePath := filepath.Join(stateDir, ".bp-ready.pipe")
 	// remove the path just in case the previous rootlesskit instance crashed
 	if err := os.RemoveAll(childReadyPipePath); err != nil {
@@ -36,19 +44,33 @@ func NewDriver(logWriter io.Writer, stateDir string) (port.ParentDriver, error)
 		logWriter:          logWriter,
 		socketPath:         socketPath,
 		childReadyPipePath: childReadyPipePath,
+		backlog:            backlog,
+		relayWorkers:       relayWorkers,
+		accessLog:          accessLog,
 		ports:              make(map[int]*port.Status, 0),
+		counters:           make(map[int]*tcp.ConnCounter, 0),
 		stoppers:           make(map[int]func() error, 0),
 		nextID:             1,
 	}
 	return &d, nil
 }
 
+// SocketPath returns the path of the socket for connecting to the child driver.
+func SocketPath(stateDir string) string {
+	// TODO: consider using socketpair FD instead of socket file
+	return filepath.Join(stateDir, ".bp.sock")
+}
+
 type driver struct {
 	logWriter          io.Writer
 	socketPath         string
 	childReadyPipePath string
+	backlog            int
+	relayWorkers       int // the cap of each port. 0 for no cap
+	accessLog          *accesslog.Logger
 	mu                 sync.Mutex
 	ports              map[int]*port.Status
+	counters           map[int]*tcp.ConnCounter // only for tcp
 	stoppers           map[int]func() error
 	nextID             int
 }
@@ -81,33 +103,97 @@ func (d *driver) RunParentDriver(initComplete chan struct{}, quit <-chan struct{
 	}
 	initComplete <- struct{}{}
 	<-quit
-	return nil
+	return d.accessLog.Shutdown()
 }
 
 func (d *driver) AddPort(ctx context.Context, spec port.Spec) (*port.Status, error) {
+	if spec.VsockCID != 0 {
+		return nil, errors.New("VsockCID is not supported by builtin port driver")
+	}
+	// the hostname is resolved on every AddPort call, and the status contains the resolved address
+	spec, err := portutil.ResolveParentIP(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
 	d.mu.Lock()
-	err := portutil.ValidatePortSpec(spec, d.ports)
+	err = portutil.ValidatePortSpec(spec, d.ports)
 	d.mu.Unlock()
 	if err != nil {
 		return nil, err
 	}
+	if spec.ChildIP != "" {
+		// the networks of the child are known only to the child
+		if err := msg.ValidateChildIP(d.socketPath, spec.ChildIP); err != nil {
+			return nil, errors.Wrapf(err, "invalid ChildIP %q", spec.ChildIP)
+		}
+	}
 	routineStopCh := make(chan struct{})
 	routineStop := func() error {
 		close(routineStopCh)
 		return nil // FIXME
 	}
-	switch spec.Proto {
-	case "tcp":
-		err = tcp.Run(d.socketPath, spec, routineStopCh, d.logWriter)
-	case "udp":
-		err = udp.Run(d.socketPath, spec, routineStopCh, d.logWriter)
-	default:
-		// NOTREACHED
-		return nil, errors.New("spec was not validated?")
+	var counter *tcp.ConnCounter
+	if spec.Proto == "tcp" {
+		// shared across the range
+		counter = tcp.NewConnCounter(spec.MaxConnections)
 	}
-	if err != nil {
-		return nil, err
+	portCount := spec.PortCount
+	if portCount < 1 {
+		portCount = 1
 	}
+	if portCount > 1 {
+		// a socket per port is bound up front, so fail before binding any of them
+		if err := portutil.CheckNoFile(portCount); err != nil {
+			return nil, errors.Wrapf(err, "cannot bind %d ports", portCount)
+		}
+	}
+	// the sockets of the range are closed together, rather than watching routineStopCh for each port
+	var closers []func()
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+	for i := 0; i < portCount; i++ {
+		sp := spec
+		sp.PortCount = 0
+		sp.ParentPort += i
+		sp.ChildPort += i
+		var pool *tcp.WorkerPool
+		if sp.Proto == "tcp" {
+			// not shared across the ports, so that the long-lived connections on a port do not stall the other ports
+			pool = tcp.NewWorkerPool(d.relayWorkers)
+		}
+		switch {
+		case sp.ChildAbstractSocket != "":
+			// not a range
+			err = tcp.RunAbstract(d.socketPath, sp, routineStopCh, d.logWriter, counter, pool, d.accessLog)
+		case sp.Proto == "tcp":
+			var ln net.Listener
+			if ln, err = tcp.Listen(sp, d.backlog, d.logWriter); err == nil {
+				tcp.Accept(ln, d.socketPath, sp, routineStopCh, d.logWriter, counter, pool, d.accessLog)
+				closers = append(closers, func() { ln.Close() })
+			}
+		case sp.Proto == "udp":
+			var udpp *udpproxy.UDPProxy
+			if udpp, err = udp.Listen(d.socketPath, sp, d.logWriter); err == nil {
+				closers = append(closers, udpp.Close)
+			}
+		default:
+			// NOTREACHED
+			err = errors.New("spec was not validated?")
+		}
+		if err != nil {
+			// stop the ports in the range that have been already started
+			routineStop()
+			closeAll()
+			return nil, err
+		}
+	}
+	go func() {
+		<-routineStopCh
+		closeAll()
+	}()
 	d.mu.Lock()
 	id := d.nextID
 	st := port.Status{
@@ -116,6 +202,9 @@ func (d *driver) AddPort(ctx context.Context, spec port.Spec) (*port.Status, err
 	}
 	d.ports[id] = &st
 	d.stoppers[id] = routineStop
+	if counter != nil {
+		d.counters[id] = counter
+	}
 	d.nextID++
 	d.mu.Unlock()
 	return &st, nil
@@ -124,8 +213,12 @@ func (d *driver) AddPort(ctx context.Context, spec port.Spec) (*port.Status, err
 func (d *driver) ListPorts(ctx context.Context) ([]port.Status, error) {
 	var ports []port.Status
 	d.mu.Lock()
-	for _, p := range d.ports {
-		ports = append(ports, *p)
+	for id, p := range d.ports {
+		st := *p
+		if counter, ok := d.counters[id]; ok {
+			st.Connections = counter.Current()
+		}
+		ports = append(ports, st)
 	}
 	d.mu.Unlock()
 	return ports, nil
@@ -141,5 +234,6 @@ func (d *driver) RemovePort(ctx context.Context, id int) error {
 	err := stop()
 	delete(d.stoppers, id)
 	delete(d.ports, id)
+	delete(d.counters, id)
 	return err
 }
@@ -0,0 +1,506 @@
+package parent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/child"
+)
+
+// listenUDPRange listens on n consecutive UDP ports on 127.0.0.1.
+func listenUDPRange(t *testing.T, n int) (int, []*net.UDPConn) {
+	for base := 20000 + os.Getpid()%20000; base < 60000; base += n {
+		var conns []*net.UDPConn
+		for i := 0; i < n; i++ {
+			c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: base + i})
+			if err != nil {
+				break
+			}
+			conns = append(conns, c)
+		}
+		if len(conns) == n {
+			return base, conns
+		}
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+	t.Fatalf("failed to find %d consecutive free UDP ports", n)
+	return 0, nil
+}
+
+// serveUDPEcho replies "<port>:<payload>"
+func serveUDPEcho(c *net.UDPConn) {
+	port := c.LocalAddr().(*net.UDPAddr).Port
+	b := make([]byte, 1024)
+	for {
+		n, addr, err := c.ReadFromUDP(b)
+		if err != nil {
+			return
+		}
+		c.WriteToUDP([]byte(strconv.Itoa(port)+":"+string(b[:n])), addr)
+	}
+}
+
+func TestAddPortUDPRange(t *testing.T) {
+	const n = 8
+	// the "child" runs in the same network namespace as the parent, with the different port range
+	childBase, childConns := listenUDPRange(t, n)
+	for _, c := range childConns {
+		defer c.Close()
+		go serveUDPEcho(c)
+	}
+	parentBase, parentConns := listenUDPRange(t, n)
+	for _, c := range parentConns {
+		c.Close()
+	}
+
+	stateDir, err := ioutil.TempDir("", "test-builtin-parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	d, err := NewDriver(os.Stderr, stateDir, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	childErr := make(chan error, 1)
+	go func() {
+		childErr <- child.NewDriver(os.Stderr).RunChildDriver(d.OpaqueForChild(), quit)
+	}()
+	initComplete := make(chan struct{})
+	parentErr := make(chan error, 1)
+	go func() {
+		parentErr <- d.RunParentDriver(initComplete, quit, nil)
+	}()
+	select {
+	case <-initComplete:
+	case err := <-childErr:
+		t.Fatal(err)
+	case err := <-parentErr:
+		t.Fatal(err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	spec := port.Spec{
+		Proto:      "udp",
+		ParentIP:   "127.0.0.1",
+		ParentPort: parentBase,
+		ChildPort:  childBase,
+		PortCount:  n,
+	}
+	st, err := d.AddPort(context.TODO(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.RemovePort(context.TODO(), st.ID)
+
+	// a handful of concurrent flows across the range, two flows per port
+	var wg sync.WaitGroup
+	errCh := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func(i, j int) {
+				defer wg.Done()
+				errCh <- udpRoundTrip(parentBase+i, childBase+i, fmt.Sprintf("flow-%d-%d", i, j))
+			}(i, j)
+		}
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func udpRoundTrip(parentPort, childPort int, payload string) error {
+	c, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(parentPort)))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	expected := strconv.Itoa(childPort) + ":" + payload
+	b := make([]byte, 1024)
+	// the first datagram may be dropped before the flow is set up, so retry
+	for retry := 0; retry < 10; retry++ {
+		if _, err := c.Write([]byte(payload)); err != nil {
+			return err
+		}
+		c.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		n, err := c.Read(b)
+		if err != nil {
+			continue
+		}
+		if got := string(b[:n]); got != expected {
+			return fmt.Errorf("expected %q, got %q", expected, got)
+		}
+		return nil
+	}
+	return fmt.Errorf("no reply for %q via port %d", payload, parentPort)
+}
+
+// listenBlackhole returns a TCP port on 127.0.0.1 that never completes the TCP handshake,
+// by filling the accept queue of a socket that listens with the zero backlog and never accepts.
+func listenBlackhole(t *testing.T) (int, func()) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		unix.Close(fd)
+		t.Fatal(err)
+	}
+	if err := unix.Listen(fd, 0); err != nil {
+		unix.Close(fd)
+		t.Fatal(err)
+	}
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		unix.Close(fd)
+		t.Fatal(err)
+	}
+	p := sa.(*unix.SockaddrInet4).Port
+	var conns []net.Conn
+	for i := 0; i < 4; i++ {
+		c, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(p)), 200*time.Millisecond)
+		if err == nil {
+			conns = append(conns, c)
+		}
+	}
+	return p, func() {
+		for _, c := range conns {
+			c.Close()
+		}
+		unix.Close(fd)
+	}
+}
+
+func TestAddPortDialTimeout(t *testing.T) {
+	childPort, closeBlackhole := listenBlackhole(t)
+	defer closeBlackhole()
+	parentBase, parentConns := listenUDPRange(t, 1)
+	parentConns[0].Close()
+
+	stateDir, err := ioutil.TempDir("", "test-builtin-parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	d, err := NewDriver(os.Stderr, stateDir, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	childErr := make(chan error, 1)
+	go func() {
+		childErr <- child.NewDriver(os.Stderr).RunChildDriver(d.OpaqueForChild(), quit)
+	}()
+	initComplete := make(chan struct{})
+	parentErr := make(chan error, 1)
+	go func() {
+		parentErr <- d.RunParentDriver(initComplete, quit, nil)
+	}()
+	select {
+	case <-initComplete:
+	case err := <-childErr:
+		t.Fatal(err)
+	case err := <-parentErr:
+		t.Fatal(err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	spec := port.Spec{
+		Proto:       "tcp",
+		ParentIP:    "127.0.0.1",
+		ParentPort:  parentBase,
+		ChildPort:   childPort,
+		DialTimeout: 1,
+	}
+	st, err := d.AddPort(context.TODO(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.RemovePort(context.TODO(), st.ID)
+
+	c, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(parentBase)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	begin := time.Now()
+	c.SetReadDeadline(begin.Add(5 * time.Second))
+	_, err = c.Read(make([]byte, 1))
+	elapsed := time.Since(begin)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatalf("the connection was not reset within %v", elapsed)
+	}
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("expected ECONNRESET, got %v", err)
+	}
+	if elapsed < 500*time.Millisecond {
+		t.Fatalf("the connection was reset too early (%v), the target is not a blackhole?", elapsed)
+	}
+}
+
+func TestAddPortAbstractSocket(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "test-builtin-parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	// the target on the parent echoes the received bytes
+	targetPath := stateDir + "/target.sock"
+	target, err := net.Listen("unix", targetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				b := make([]byte, 1024)
+				n, _ := c.Read(b)
+				c.Write(b[:n])
+			}()
+		}
+	}()
+
+	d, err := NewDriver(os.Stderr, stateDir, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	childErr := make(chan error, 1)
+	go func() {
+		childErr <- child.NewDriver(os.Stderr).RunChildDriver(d.OpaqueForChild(), quit)
+	}()
+	initComplete := make(chan struct{})
+	parentErr := make(chan error, 1)
+	go func() {
+		parentErr <- d.RunParentDriver(initComplete, quit, nil)
+	}()
+	select {
+	case <-initComplete:
+	case err := <-childErr:
+		t.Fatal(err)
+	case err := <-parentErr:
+		t.Fatal(err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	// the "child" runs in the same network namespace as the parent
+	name := fmt.Sprintf("rootlesskit-test-abstract-%d", os.Getpid())
+	spec := port.Spec{
+		Proto:               "tcp",
+		ChildAbstractSocket: name,
+		ParentTargetSocket:  targetPath,
+	}
+	st, err := d.AddPort(context.TODO(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.AddPort(context.TODO(), spec); err == nil {
+		t.Fatal("expected a conflict")
+	}
+
+	c, err := net.Dial("unix", "@"+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("expected \"hello\", got %q", string(b))
+	}
+
+	if err := d.RemovePort(context.TODO(), st.ID); err != nil {
+		t.Fatal(err)
+	}
+	// the abstract socket is released asynchronously
+	for i := 0; ; i++ {
+		c, err := net.Dial("unix", "@"+name)
+		if err != nil {
+			break
+		}
+		c.Close()
+		if i == 100 {
+			t.Fatal("the abstract socket was not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAddPortChildIP(t *testing.T) {
+	parentBase, parentConns := listenUDPRange(t, 1)
+	parentConns[0].Close()
+
+	stateDir, err := ioutil.TempDir("", "test-builtin-parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	d, err := NewDriver(os.Stderr, stateDir, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	childErr := make(chan error, 1)
+	go func() {
+		childErr <- child.NewDriver(os.Stderr).RunChildDriver(d.OpaqueForChild(), quit)
+	}()
+	initComplete := make(chan struct{})
+	parentErr := make(chan error, 1)
+	go func() {
+		parentErr <- d.RunParentDriver(initComplete, quit, nil)
+	}()
+	select {
+	case <-initComplete:
+	case err := <-childErr:
+		t.Fatal(err)
+	case err := <-parentErr:
+		t.Fatal(err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	spec := port.Spec{
+		Proto:      "tcp",
+		ParentIP:   "127.0.0.1",
+		ParentPort: parentBase,
+		ChildIP:    "203.0.113.1", // TEST-NET-3, not assigned to the interfaces
+		ChildPort:  80,
+	}
+	if _, err := d.AddPort(context.TODO(), spec); err == nil {
+		t.Fatalf("expected an error for ChildIP %s", spec.ChildIP)
+	}
+	spec.ChildIP = "127.0.0.2"
+	st, err := d.AddPort(context.TODO(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RemovePort(context.TODO(), st.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddPortRangeGoroutines(t *testing.T) {
+	const n = 64
+	stateDir, err := ioutil.TempDir("", "test-builtin-parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	d, err := NewDriver(os.Stderr, stateDir, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	childErr := make(chan error, 1)
+	go func() {
+		childErr <- child.NewDriver(os.Stderr).RunChildDriver(d.OpaqueForChild(), quit)
+	}()
+	initComplete := make(chan struct{})
+	parentErr := make(chan error, 1)
+	go func() {
+		parentErr <- d.RunParentDriver(initComplete, quit, nil)
+	}()
+	select {
+	case <-initComplete:
+	case err := <-childErr:
+		t.Fatal(err)
+	case err := <-parentErr:
+		t.Fatal(err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	for _, proto := range []string{"tcp", "udp"} {
+		t.Run(proto, func(t *testing.T) {
+			// free for both TCP and UDP in practice
+			parentBase, parentConns := listenUDPRange(t, n)
+			for _, c := range parentConns {
+				c.Close()
+			}
+			before := runtime.NumGoroutine()
+			spec := port.Spec{
+				Proto:      proto,
+				ParentIP:   "127.0.0.1",
+				ParentPort: parentBase,
+				ChildPort:  parentBase,
+				PortCount:  n,
+			}
+			st, err := d.AddPort(context.TODO(), spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a goroutine per port, and a goroutine per range for closing the sockets
+			if delta := runtime.NumGoroutine() - before; delta > n+1 {
+				t.Errorf("expected at most %d goroutines for %d ports, got %d", n+1, n, delta)
+			}
+			if err := d.RemovePort(context.TODO(), st.ID); err != nil {
+				t.Fatal(err)
+			}
+			// all the sockets of the range are closed
+			for i := 0; ; i++ {
+				if runtime.NumGoroutine() <= before {
+					break
+				}
+				if i == 100 {
+					t.Fatalf("%d goroutines are left after removing the range", runtime.NumGoroutine()-before)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			for i := 0; i < n; i++ {
+				addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(parentBase+i))
+				var c io.Closer
+				if proto == "tcp" {
+					c, err = net.Listen(proto, addr)
+				} else {
+					c, err = net.ListenPacket(proto, addr)
+				}
+				if err != nil {
+					t.Fatalf("port %d was not released: %v", parentBase+i, err)
+				}
+				c.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package tcp
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/msg"
+	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/parent/accesslog"
+)
+
+// RunAbstract listens on the abstract UNIX socket spec.ChildAbstractSocket in the child,
+// and forwards the connections to spec.ParentTargetSocket on the parent.
+// The listener is created by the child, as abstract sockets are scoped by the network namespace,
+// and the connections are accepted and relayed by the parent.
+func RunAbstract(socketPath string, spec port.Spec, stopCh <-chan struct{}, logWriter io.Writer, counter *ConnCounter, pool *WorkerPool, accessLog *accesslog.Logger) error {
+	fd, err := msg.ListenInChild(socketPath, spec)
+	if err != nil {
+		fmt.Fprintf(logWriter, "listen: %v\n", err)
+		return err
+	}
+	f := os.NewFile(uintptr(fd), "")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return err
+	}
+	Serve(ln, socketPath, spec, stopCh, logWriter, counter, pool, accessLog)
+	return nil
+}
+
+// ParentTarget returns the address of the socket on the parent to connect to for spec.ChildAbstractSocket,
+// in the form accepted by net.Dial ("@NAME" or a path).
+func ParentTarget(spec port.Spec) string {
+	if spec.ParentTargetSocket == "" {
+		return "@" + spec.ChildAbstractSocket
+	}
+	return spec.ParentTargetSocket
+}
+
+// dialParentTarget connects to the socket on the parent for spec.ChildAbstractSocket.
+func dialParentTarget(spec port.Spec) (net.Conn, error) {
+	timeout := spec.DialTimeout
+	if timeout == 0 {
+		timeout = port.DefaultDialTimeout
+	}
+	return net.DialTimeout("unix", ParentTarget(spec), time.Duration(timeout)*time.Second)
+}
@@ -0,0 +1,41 @@
+package tcp
+
+import (
+	"sync"
+)
+
+// ConnCounter counts the connections of a port, with the optional limit.
+type ConnCounter struct {
+	max int
+	mu  sync.Mutex
+	n   int
+}
+
+// NewConnCounter creates ConnCounter. max is the maximum number of the connections. 0 for unlimited.
+func NewConnCounter(max int) *ConnCounter {
+	return &ConnCounter{max: max}
+}
+
+// acquire returns false if the number of the connections has reached the limit.
+func (c *ConnCounter) acquire() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.max > 0 && c.n >= c.max {
+		return false
+	}
+	c.n++
+	return true
+}
+
+func (c *ConnCounter) release() {
+	c.mu.Lock()
+	c.n--
+	c.mu.Unlock()
+}
+
+// Current returns the current number of the connections.
+func (c *ConnCounter) Current() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
@@ -0,0 +1,171 @@
+package tcp
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/msgutil"
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/msg"
+)
+
+// serveFakeChild emulates the child of the builtin driver without namespaces:
+// it connects to the requested port on 127.0.0.1, and sends the FD.
+func serveFakeChild(t *testing.T, socketPath string) net.Listener {
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c *net.UnixConn) {
+				defer c.Close()
+				var req msg.Request
+				if _, err := msgutil.UnmarshalFromReader(c, &req); err != nil {
+					return
+				}
+				tc, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(req.Port)))
+				if err != nil {
+					return
+				}
+				defer tc.Close()
+				f, err := tc.(*net.TCPConn).File()
+				if err != nil {
+					return
+				}
+				defer f.Close()
+				c.WriteMsgUnix(nil, unix.UnixRights(int(f.Fd())), nil)
+			}(c.(*net.UnixConn))
+		}
+	}()
+	return ln
+}
+
+// serveEcho serves the echo service on a random port.
+func serveEcho(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return ln
+}
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// echoes returns true if c is relayed to the echo service, false if c is closed by the parent.
+func echoes(t *testing.T, c net.Conn) bool {
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.Write([]byte("x")); err != nil {
+		return false
+	}
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(c, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Fatal(err)
+		}
+		// ECONNRESET
+		return false
+	}
+	return string(b) == "x"
+}
+
+func TestMaxConnections(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-maxconnections")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	socketPath := filepath.Join(tmpDir, "child.sock")
+	childLn := serveFakeChild(t, socketPath)
+	defer childLn.Close()
+	echoLn := serveEcho(t)
+	defer echoLn.Close()
+
+	spec := port.Spec{
+		Proto:          "tcp",
+		ParentIP:       "127.0.0.1",
+		ParentPort:     freePort(t),
+		ChildPort:      echoLn.Addr().(*net.TCPAddr).Port,
+		MaxConnections: 2,
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	counter := NewConnCounter(spec.MaxConnections)
+	if err := Run(socketPath, spec, stopCh, ioutil.Discard, 0, counter, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	parentAddr := net.JoinHostPort(spec.ParentIP, strconv.Itoa(spec.ParentPort))
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", parentAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	var conns []net.Conn
+	for i := 0; i < spec.MaxConnections; i++ {
+		c := dial()
+		defer c.Close()
+		if !echoes(t, c) {
+			t.Fatalf("connection %d was refused", i)
+		}
+		conns = append(conns, c)
+	}
+	if n := counter.Current(); n != spec.MaxConnections {
+		t.Errorf("expected %d connections, got %d", spec.MaxConnections, n)
+	}
+	excess := dial()
+	defer excess.Close()
+	if echoes(t, excess) {
+		t.Fatal("the connection beyond MaxConnections was not refused")
+	}
+
+	// closing a connection allows a new connection
+	conns[0].Close()
+	for i := 0; counter.Current() >= spec.MaxConnections; i++ {
+		if i > 100 {
+			t.Fatal("the connection was not released")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c := dial()
+	defer c.Close()
+	if !echoes(t, c) {
+		t.Fatal("the connection was refused after releasing a connection")
+	}
+}
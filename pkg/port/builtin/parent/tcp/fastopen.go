@@ -0,0 +1,31 @@
+package tcp
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port/portutil"
+)
+
+// setFastOpen sets TCP_FASTOPEN on the listener.
+// Note that the server flag of the net.ipv4.tcp_fastopen sysctl needs to be enabled for accepting Fast Open requests,
+// otherwise the socket option has no effect.
+func setFastOpen(ln net.Listener) error {
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		return errors.Errorf("expected *net.TCPListener, got %T", ln)
+	}
+	rc, err := tl.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := rc.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN, portutil.DefaultTCPFastOpenQueueLen)
+	}); err != nil {
+		return err
+	}
+	return errors.Wrap(sockErr, "setsockopt TCP_FASTOPEN")
+}
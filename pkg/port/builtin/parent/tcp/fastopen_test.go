@@ -0,0 +1,43 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port/portutil"
+)
+
+func TestSetFastOpen(t *testing.T) {
+	if _, err := portutil.TCPFastOpenEnabled(portutil.TCPFastOpenServer); err != nil {
+		t.Skip(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := setFastOpen(ln); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := ln.(*net.TCPListener).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		got    int
+		optErr error
+	)
+	if err := rc.Control(func(fd uintptr) {
+		got, optErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if optErr != nil {
+		t.Fatal(optErr)
+	}
+	if got != portutil.DefaultTCPFastOpenQueueLen {
+		t.Fatalf("expected TCP_FASTOPEN=%d, got %d", portutil.DefaultTCPFastOpenQueueLen, got)
+	}
+}
@@ -0,0 +1,62 @@
+package tcp
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-idletimeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	socketPath := filepath.Join(tmpDir, "child.sock")
+	childLn := serveFakeChild(t, socketPath)
+	defer childLn.Close()
+	echoLn := serveEcho(t)
+	defer echoLn.Close()
+
+	spec := port.Spec{
+		Proto:       "tcp",
+		ParentIP:    "127.0.0.1",
+		ParentPort:  freePort(t),
+		ChildPort:   echoLn.Addr().(*net.TCPAddr).Port,
+		IdleTimeout: 1,
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := Run(socketPath, spec, stopCh, ioutil.Discard, 0, NewConnCounter(0), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	parentAddr := net.JoinHostPort(spec.ParentIP, strconv.Itoa(spec.ParentPort))
+	var conns [2]net.Conn
+	for i := range conns {
+		conns[i], err = net.Dial("tcp", parentAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conns[i].Close()
+		if !echoes(t, conns[i]) {
+			t.Fatalf("connection %d was refused", i)
+		}
+	}
+	idle, active := conns[0], conns[1]
+	// the active connection transfers a byte more frequently than the timeout, for twice as long as the timeout
+	for i := 0; i < 8; i++ {
+		time.Sleep(250 * time.Millisecond)
+		if !echoes(t, active) {
+			t.Fatalf("the active connection was closed after %d ms", (i+1)*250)
+		}
+	}
+	if echoes(t, idle) {
+		t.Fatal("the idle connection was not closed")
+	}
+}
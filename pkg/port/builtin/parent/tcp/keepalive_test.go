@@ -0,0 +1,53 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSetKeepAlive(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := setKeepAlive(c, 42*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := c.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]struct {
+		level, opt, value int
+	}{
+		"SO_KEEPALIVE":  {unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1},
+		"TCP_KEEPIDLE":  {unix.IPPROTO_TCP, unix.TCP_KEEPIDLE, 42},
+		"TCP_KEEPINTVL": {unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, 42},
+	}
+	for name, e := range expected {
+		var (
+			got    int
+			optErr error
+		)
+		if err := rc.Control(func(fd uintptr) {
+			got, optErr = unix.GetsockoptInt(int(fd), e.level, e.opt)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if optErr != nil {
+			t.Fatal(optErr)
+		}
+		if got != e.value {
+			t.Errorf("expected %s=%d, got %d", name, e.value, got)
+		}
+	}
+}
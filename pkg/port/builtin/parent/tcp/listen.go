@@ -0,0 +1,68 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port/portutil"
+)
+
+// listen listens on addr with the specified backlog.
+// backlog <= 0 means the default of Go (net.core.somaxconn).
+// reusePort sets SO_REUSEPORT.
+//
+// The effective backlog is silently capped by the net.core.somaxconn sysctl.
+func listen(addr string, backlog int, reusePort bool) (net.Listener, error) {
+	if backlog <= 0 {
+		var lc net.ListenConfig
+		if reusePort {
+			lc.Control = portutil.ControlReusePort
+		}
+		return lc.Listen(context.Background(), "tcp", addr)
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		family int
+		sa     unix.Sockaddr
+	)
+	if ip4 := tcpAddr.IP.To4(); tcpAddr.IP == nil || ip4 != nil {
+		// empty IP is 0.0.0.0
+		sa4 := &unix.SockaddrInet4{Port: tcpAddr.Port}
+		copy(sa4.Addr[:], ip4)
+		family, sa = unix.AF_INET, sa4
+	} else {
+		sa6 := &unix.SockaddrInet6{Port: tcpAddr.Port}
+		copy(sa6.Addr[:], tcpAddr.IP.To16())
+		family, sa = unix.AF_INET6, sa6
+	}
+	fd, err := unix.Socket(family, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, unix.IPPROTO_TCP)
+	if err != nil {
+		return nil, errors.Wrap(err, "socket")
+	}
+	f := os.NewFile(uintptr(fd), addr)
+	// net.FileListener dups the FD
+	defer f.Close()
+	// same as net.Listen
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		return nil, errors.Wrap(err, "setsockopt SO_REUSEADDR")
+	}
+	if reusePort {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			return nil, errors.Wrap(err, "setsockopt SO_REUSEPORT")
+		}
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		return nil, errors.Wrapf(err, "bind %s", addr)
+	}
+	if err := unix.Listen(fd, backlog); err != nil {
+		return nil, errors.Wrapf(err, "listen %s", addr)
+	}
+	return net.FileListener(f)
+}
@@ -0,0 +1,67 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenWithBacklog(t *testing.T) {
+	for _, backlog := range []int{0, 8} {
+		ln, err := listen("127.0.0.1:0", backlog, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			ln.Close()
+			t.Fatal(err)
+		}
+		ac, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ac.Close()
+		c.Close()
+		ln.Close()
+	}
+}
+
+func TestListenWithReusePort(t *testing.T) {
+	for _, backlog := range []int{0, 8} {
+		ln1, err := listen("127.0.0.1:0", backlog, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln1.Close()
+		ln2, err := listen(ln1.Addr().String(), backlog, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln2.Close()
+		accepted := make(chan int, 256)
+		for i, ln := range []net.Listener{ln1, ln2} {
+			go func(i int, ln net.Listener) {
+				for {
+					c, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					c.Close()
+					accepted <- i
+				}
+			}(i, ln)
+		}
+		var counts [2]int
+		for j := 0; j < 100 && (counts[0] == 0 || counts[1] == 0); j++ {
+			c, err := net.Dial("tcp", ln1.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Close()
+			counts[<-accepted]++
+		}
+		if counts[0] == 0 || counts[1] == 0 {
+			t.Fatalf("expected both listeners to accept connections, got %v", counts)
+		}
+	}
+}
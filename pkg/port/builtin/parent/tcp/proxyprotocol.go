@@ -0,0 +1,57 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// proxyProtocolV2Signature is the 12-byte signature of PROXY protocol v2.
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolHeader generates the HAProxy PROXY protocol header.
+// version is either "v1" (human-readable) or "v2" (binary).
+// src is the address of the client. dst is the address of the parent listener.
+//
+// Spec: https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+func proxyProtocolHeader(version string, src, dst *net.TCPAddr) ([]byte, error) {
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	v4 := srcIP != nil && dstIP != nil
+	if !v4 {
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+		if srcIP == nil || dstIP == nil {
+			return nil, errors.Errorf("unexpected addresses: src=%v, dst=%v", src, dst)
+		}
+	}
+	switch version {
+	case "v1":
+		proto := "TCP6"
+		if v4 {
+			proto = "TCP4"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, srcIP, dstIP, src.Port, dst.Port)), nil
+	case "v2":
+		var b bytes.Buffer
+		b.Write(proxyProtocolV2Signature)
+		// version 2, PROXY command
+		b.WriteByte(0x21)
+		if v4 {
+			// AF_INET, STREAM
+			b.WriteByte(0x11)
+		} else {
+			// AF_INET6, STREAM
+			b.WriteByte(0x21)
+		}
+		binary.Write(&b, binary.BigEndian, uint16(2*len(srcIP)+4))
+		b.Write(srcIP)
+		b.Write(dstIP)
+		binary.Write(&b, binary.BigEndian, uint16(src.Port))
+		binary.Write(&b, binary.BigEndian, uint16(dst.Port))
+		return b.Bytes(), nil
+	default:
+		return nil, errors.Errorf("unknown PROXY protocol version %q", version)
+	}
+}
@@ -0,0 +1,77 @@
+package tcp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestProxyProtocolHeader(t *testing.T) {
+	type testCase struct {
+		version  string
+		src      *net.TCPAddr
+		dst      *net.TCPAddr
+		expected []byte
+	}
+	testCases := []testCase{
+		{
+			version:  "v1",
+			src:      &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 56324},
+			dst:      &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 8080},
+			expected: []byte("PROXY TCP4 192.168.1.10 192.168.1.1 56324 8080\r\n"),
+		},
+		{
+			version:  "v1",
+			src:      &net.TCPAddr{IP: net.ParseIP("2001:db8::10"), Port: 56324},
+			dst:      &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 8080},
+			expected: []byte("PROXY TCP6 2001:db8::10 2001:db8::1 56324 8080\r\n"),
+		},
+		{
+			version: "v2",
+			src:     &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 56324},
+			dst:     &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 8080},
+			expected: []byte{
+				0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A,
+				0x21, 0x11, 0x00, 0x0C,
+				192, 168, 1, 10,
+				192, 168, 1, 1,
+				0xDC, 0x04,
+				0x1F, 0x90,
+			},
+		},
+		{
+			version: "v2",
+			src:     &net.TCPAddr{IP: net.ParseIP("2001:db8::10"), Port: 56324},
+			dst:     &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 8080},
+			expected: []byte{
+				0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A,
+				0x21, 0x21, 0x00, 0x24,
+				0x20, 0x01, 0x0D, 0xB8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x10,
+				0x20, 0x01, 0x0D, 0xB8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+				0xDC, 0x04,
+				0x1F, 0x90,
+			},
+		},
+		{
+			version: "v3",
+			src:     &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 56324},
+			dst:     &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 8080},
+			// nil for error
+		},
+	}
+	for i, tc := range testCases {
+		got, err := proxyProtocolHeader(tc.version, tc.src, tc.dst)
+		if tc.expected == nil {
+			if err == nil {
+				t.Fatalf("#%d: expected error, got no error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("#%d: expected no error, got %q", i, err)
+		}
+		if !bytes.Equal(got, tc.expected) {
+			t.Fatalf("#%d: expected %q, got %q", i, tc.expected, got)
+		}
+	}
+}
@@ -5,100 +5,213 @@ import (
 	"io"
 	"net"
 	"os"
-	"sync"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
 
 	"github.com/rootless-containers/rootlesskit/pkg/port"
 	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/msg"
+	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/parent/accesslog"
+	"github.com/rootless-containers/rootlesskit/pkg/port/portutil"
 )
 
-func Run(socketPath string, spec port.Spec, stopCh <-chan struct{}, logWriter io.Writer) error {
-	ln, err := net.Listen("tcp", fmt.Sprintf("%s:%d", spec.ParentIP, spec.ParentPort))
+// Run listens on the parent port with the backlog (0 for the default), and forwards the connections to the child.
+// counter counts the connections, and limits them to spec.MaxConnections.
+// pool relays the connections. nil for a goroutine per connection.
+// accessLog can be nil.
+func Run(socketPath string, spec port.Spec, stopCh <-chan struct{}, logWriter io.Writer, backlog int, counter *ConnCounter, pool *WorkerPool, accessLog *accesslog.Logger) error {
+	ln, err := Listen(spec, backlog, logWriter)
 	if err != nil {
-		fmt.Fprintf(logWriter, "listen: %v\n", err)
 		return err
 	}
-	newConns := make(chan net.Conn)
+	Serve(ln, socketPath, spec, stopCh, logWriter, counter, pool, accessLog)
+	// no wait
+	return nil
+}
+
+// Listen listens on the parent port with the backlog (0 for the default).
+func Listen(spec port.Spec, backlog int, logWriter io.Writer) (net.Listener, error) {
+	var (
+		ln  net.Listener
+		err error
+	)
+	if spec.ParentSocket != "" {
+		// the socket file is removed on closing ln
+		ln, err = net.Listen("unix", spec.ParentSocket)
+	} else {
+		ln, err = listen(net.JoinHostPort(spec.ParentIP, strconv.Itoa(spec.ParentPort)), backlog, spec.ReusePort)
+	}
+	if err != nil {
+		fmt.Fprintf(logWriter, "listen: %v\n", err)
+		return nil, err
+	}
+	if spec.TCPFastOpen {
+		// degrades to the regular TCP
+		if err := setFastOpen(ln); err != nil {
+			fmt.Fprintf(logWriter, "failed to enable TCP Fast Open: %v\n", err)
+		} else if enabled, err := portutil.TCPFastOpenEnabled(portutil.TCPFastOpenServer); err != nil || !enabled {
+			fmt.Fprintf(logWriter, "TCP Fast Open requests are not accepted, as the server flag (0x2) is not set in %s\n", portutil.TCPFastOpenSysctl)
+		}
+	}
+	return ln, nil
+}
+
+// Serve accepts the connections on ln, and forwards them to the child, until stopCh is closed.
+// ln is closed when Serve stops. Serve does not block.
+// ln does not need to be a TCP listener, e.g. the vsock port driver passes an AF_VSOCK listener.
+func Serve(ln net.Listener, socketPath string, spec port.Spec, stopCh <-chan struct{}, logWriter io.Writer, counter *ConnCounter, pool *WorkerPool, accessLog *accesslog.Logger) {
+	go func() {
+		<-stopCh
+		ln.Close()
+	}()
+	Accept(ln, socketPath, spec, stopCh, logWriter, counter, pool, accessLog)
+}
+
+// Accept accepts the connections on ln, and forwards them to the child, until ln is closed.
+// Unlike Serve, Accept does not close ln when stopCh is closed, so that the caller can close
+// the listeners of a port range together. stopCh stops the connections being forwarded.
+// Accept does not block, and consumes a goroutine per ln, in addition to the goroutines of the connections.
+func Accept(ln net.Listener, socketPath string, spec port.Spec, stopCh <-chan struct{}, logWriter io.Writer, counter *ConnCounter, pool *WorkerPool, accessLog *accesslog.Logger) {
 	go func() {
+		defer ln.Close()
 		for {
 			c, err := ln.Accept()
 			if err != nil {
 				fmt.Fprintf(logWriter, "accept: %v\n", err)
-				close(newConns)
 				return
 			}
-			newConns <- c
-		}
-	}()
-	go func() {
-		defer ln.Close()
-		for {
-			select {
-			case c, ok := <-newConns:
-				if !ok {
+			local, remote := c.LocalAddr().String(), c.RemoteAddr().String()
+			if !counter.acquire() {
+				fmt.Fprintf(logWriter, "rejecting the connection from %s: reached MaxConnections (%d)\n", remote, spec.MaxConnections)
+				writeAccessLog(logWriter, accessLog.Reject(local, remote, "reached MaxConnections"))
+				c.Close()
+				continue
+			}
+			writeAccessLog(logWriter, accessLog.Accept(local, remote))
+			// blocks while all the workers are busy, so that the excess connections are left in the listen backlog
+			submitted := pool.submit(func() {
+				defer counter.release()
+				select {
+				case <-stopCh:
+					// the port was removed while the connection was waiting for a worker
+					c.Close()
+					writeAccessLog(logWriter, accessLog.Close(local, remote, 0, 0, 0))
 					return
+				default:
 				}
-				go func() {
-					if err := copyConnToChild(c, socketPath, spec, stopCh); err != nil {
-						fmt.Fprintf(logWriter, "copyConnToChild: %v\n", err)
-						return
-					}
-				}()
-			case <-stopCh:
+				begin := time.Now()
+				rx, tx, err := copyConnToChild(c, socketPath, spec, stopCh)
+				writeAccessLog(logWriter, accessLog.Close(local, remote, time.Since(begin), rx, tx))
+				if err != nil {
+					fmt.Fprintf(logWriter, "copyConnToChild: %v\n", err)
+					return
+				}
+			}, stopCh)
+			if !submitted {
+				// the port was removed while waiting for a worker
+				counter.release()
+				c.Close()
+				writeAccessLog(logWriter, accessLog.Close(local, remote, 0, 0, 0))
 				return
 			}
 		}
 	}()
-	// no wait
-	return nil
 }
 
-func copyConnToChild(c net.Conn, socketPath string, spec port.Spec, stopCh <-chan struct{}) error {
+func writeAccessLog(logWriter io.Writer, err error) {
+	if err != nil {
+		fmt.Fprintf(logWriter, "access log: %v\n", err)
+	}
+}
+
+// copyConnToChild returns the bytes received from c, and the bytes sent to c.
+// For spec.ChildAbstractSocket, c is a connection accepted in the child, and is relayed to the parent target instead.
+func copyConnToChild(c net.Conn, socketPath string, spec port.Spec, stopCh <-chan struct{}) (int64, int64, error) {
 	defer c.Close()
+	var (
+		fc  net.Conn
+		err error
+	)
+	if spec.ChildAbstractSocket != "" {
+		fc, err = dialParentTarget(spec)
+	} else {
+		fc, err = dialChild(socketPath, spec)
+	}
+	if err != nil {
+		// reset the connection, so that the client does not mistake the failure for an orderly close
+		if tc, ok := c.(*net.TCPConn); ok {
+			tc.SetLinger(0)
+		}
+		return 0, 0, err
+	}
+	defer fc.Close()
+	if spec.TCPKeepAlive {
+		interval := spec.TCPKeepAliveInterval
+		if interval == 0 {
+			interval = port.DefaultTCPKeepAliveInterval
+		}
+		// fc is the socket created in the child, but socket options can be set from the parent as well
+		for _, x := range []net.Conn{c, fc} {
+			if _, ok := x.(*net.TCPConn); !ok {
+				// UNIX socket (ParentSocket or ChildSocket)
+				continue
+			}
+			if err := setKeepAlive(x, time.Duration(interval)*time.Second); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	if spec.ProxyProtocol != "" {
+		hdr, err := proxyProtocolHeader(spec.ProxyProtocol, c.RemoteAddr().(*net.TCPAddr), c.LocalAddr().(*net.TCPAddr))
+		if err != nil {
+			return 0, 0, err
+		}
+		if _, err := fc.Write(hdr); err != nil {
+			return 0, 0, err
+		}
+	}
+	rx, tx := portutil.BiCopy(c, fc, stopCh, time.Duration(spec.IdleTimeout)*time.Second)
+	return rx, tx, nil
+}
+
+// dialChild connects to the target in the child.
+func dialChild(socketPath string, spec port.Spec) (net.Conn, error) {
 	// get fd from the child as an SCM_RIGHTS cmsg
 	fd, err := msg.ConnectToChildWithRetry(socketPath, spec, 10)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	f := os.NewFile(uintptr(fd), "")
 	defer f.Close()
-	fc, err := net.FileConn(f)
+	return net.FileConn(f)
+}
+
+// setKeepAlive enables SO_KEEPALIVE, and sets both TCP_KEEPIDLE and TCP_KEEPINTVL to period.
+func setKeepAlive(c net.Conn, period time.Duration) error {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return errors.Errorf("expected *net.TCPConn, got %T", c)
+	}
+	if err := tc.SetKeepAlive(true); err != nil {
+		return err
+	}
+	// TCPConn.SetKeepAlivePeriod does not set TCP_KEEPINTVL on recent versions of Go
+	rc, err := tc.SyscallConn()
 	if err != nil {
 		return err
 	}
-	defer fc.Close()
-	bicopy(c, fc, stopCh)
-	return nil
-}
-
-// bicopy is based on libnetwork/cmd/proxy/tcp_proxy.go .
-// NOTE: sendfile(2) cannot be used for sockets
-func bicopy(x, y net.Conn, quit <-chan struct{}) {
-	var wg sync.WaitGroup
-	var broker = func(to, from net.Conn) {
-		io.Copy(to, from)
-		if fromTCP, ok := from.(*net.TCPConn); ok {
-			fromTCP.CloseRead()
-		}
-		if toTCP, ok := to.(*net.TCPConn); ok {
-			toTCP.CloseWrite()
+	secs := int(period / time.Second)
+	var sockErr error
+	if err := rc.Control(func(fd uintptr) {
+		for _, opt := range []int{unix.TCP_KEEPIDLE, unix.TCP_KEEPINTVL} {
+			if sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, opt, secs); sockErr != nil {
+				return
+			}
 		}
-		wg.Done()
-	}
-
-	wg.Add(2)
-	go broker(x, y)
-	go broker(y, x)
-	finish := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(finish)
-	}()
-
-	select {
-	case <-quit:
-	case <-finish:
+	}); err != nil {
+		return err
 	}
-	x.Close()
-	y.Close()
-	<-finish
+	return sockErr
 }
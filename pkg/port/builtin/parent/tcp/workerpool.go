@@ -0,0 +1,49 @@
+package tcp
+
+// WorkerPool caps the number of the connections relayed concurrently on a port, so as to bound the memory usage
+// under a large number of the connections. Each relayed connection still runs on its own goroutines
+// (see bicopy), and occupies one of the workers until it is closed, so WorkerPool is a concurrency cap
+// rather than a fixed set of goroutines driving the copy loops.
+//
+// A WorkerPool must not be shared across the ports, as the long-lived connections on a port would stall
+// the accept loops of the other ports.
+//
+// While all the workers are busy, the port stops accepting the connections, after accepting at most two of them
+// (one waiting for a worker, and one waiting in the accept loop), so that the excess connections are left in
+// the listen backlog of the kernel rather than consuming the file descriptors of the parent.
+//
+// A nil *WorkerPool relays each connection without the cap.
+type WorkerPool struct {
+	// slots has a value for each of the busy workers
+	slots chan struct{}
+}
+
+// NewWorkerPool creates WorkerPool with the workers.
+// Returns nil for workers <= 0, i.e. no cap.
+func NewWorkerPool(workers int) *WorkerPool {
+	if workers <= 0 {
+		return nil
+	}
+	return &WorkerPool{
+		slots: make(chan struct{}, workers),
+	}
+}
+
+// submit runs f on a new goroutine, as one of the workers. submit blocks until a worker becomes available, or stopCh is closed.
+// submit returns false if f was not submitted due to stopCh.
+func (p *WorkerPool) submit(f func(), stopCh <-chan struct{}) bool {
+	if p == nil {
+		go f()
+		return true
+	}
+	select {
+	case p.slots <- struct{}{}:
+		go func() {
+			defer func() { <-p.slots }()
+			f()
+		}()
+		return true
+	case <-stopCh:
+		return false
+	}
+}
@@ -0,0 +1,358 @@
+package tcp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/msgutil"
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/msg"
+)
+
+func TestWorkerPool(t *testing.T) {
+	if p := NewWorkerPool(0); p != nil {
+		t.Fatalf("expected nil for 0 workers, got %+v", p)
+	}
+	const workers, tasks = 2, 10
+	p := NewWorkerPool(workers)
+	var (
+		mu             sync.Mutex
+		running, maxed int
+		wg             sync.WaitGroup
+	)
+	wg.Add(tasks)
+	for i := 0; i < tasks; i++ {
+		p.submit(func() {
+			defer wg.Done()
+			mu.Lock()
+			running++
+			if running > maxed {
+				maxed = running
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}, nil)
+	}
+	wg.Wait()
+	if maxed > workers {
+		t.Fatalf("expected at most %d concurrent tasks, got %d", workers, maxed)
+	}
+}
+
+func TestWorkerPoolSubmitStop(t *testing.T) {
+	p := NewWorkerPool(1)
+	release := make(chan struct{})
+	defer close(release)
+	if !p.submit(func() { <-release }, nil) {
+		t.Fatal("expected the task to be submitted")
+	}
+	stopCh := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(stopCh)
+	}()
+	if p.submit(func() { t.Error("unexpectedly executed") }, stopCh) {
+		t.Fatal("expected the task not to be submitted while the worker is busy")
+	}
+}
+
+func TestRelayWorkers(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-relayworkers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	socketPath := filepath.Join(tmpDir, "child.sock")
+	childLn := serveFakeChild(t, socketPath)
+	defer childLn.Close()
+	echoLn := serveEcho(t)
+	defer echoLn.Close()
+
+	spec := port.Spec{
+		Proto:      "tcp",
+		ParentIP:   "127.0.0.1",
+		ParentPort: freePort(t),
+		ChildPort:  echoLn.Addr().(*net.TCPAddr).Port,
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	pool := NewWorkerPool(1)
+	counter := NewConnCounter(0)
+	if err := Run(socketPath, spec, stopCh, ioutil.Discard, 0, counter, pool, nil); err != nil {
+		t.Fatal(err)
+	}
+	parentAddr := net.JoinHostPort(spec.ParentIP, strconv.Itoa(spec.ParentPort))
+	first, err := net.Dial("tcp", parentAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if !echoes(t, first) {
+		t.Fatal("the first connection was refused")
+	}
+	queued, err := net.Dial("tcp", parentAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queued.Close()
+	if _, err := queued.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	queued.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	b := make([]byte, 1)
+	if _, err := queued.Read(b); err == nil {
+		t.Fatal("the connection beyond the workers was relayed")
+	} else if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("the connection beyond the workers was closed: %v", err)
+	}
+	// the excess connections are left in the listen backlog, rather than being accepted
+	for i := 0; i < 5; i++ {
+		c, err := net.Dial("tcp", parentAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := counter.Current(); n != 2 {
+		t.Fatalf("expected 2 accepted connections (relayed and queued), got %d", n)
+	}
+
+	// closing the first connection makes the worker available for the queued connection
+	first.Close()
+	queued.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := queued.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "x" {
+		t.Fatalf("expected \"x\", got %q", b)
+	}
+}
+
+// benchConnections is the number of the concurrent connections in BenchmarkRelayWorkers.
+const benchConnections = 10000
+
+// benchWorkers is the cases of the workers in BenchmarkRelayWorkers. 0 for a goroutine per connection.
+var benchWorkers = []int{0, 100, 1000}
+
+// serveFakeChildPair is like serveFakeChild, but sends an end of a socketpair instead of connecting to a port,
+// so that the benchmark does not count the goroutines of an echo server.
+// The other ends are sent to peers.
+func serveFakeChildPair(b *testing.B, socketPath string, peers chan<- *os.File) net.Listener {
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c *net.UnixConn) {
+				defer c.Close()
+				var req msg.Request
+				if _, err := msgutil.UnmarshalFromReader(c, &req); err != nil {
+					return
+				}
+				fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+				if err != nil {
+					return
+				}
+				c.WriteMsgUnix(nil, unix.UnixRights(fds[0]), nil)
+				unix.Close(fds[0])
+				peers <- os.NewFile(uintptr(fds[1]), "peer")
+			}(c.(*net.UnixConn))
+		}
+	}()
+	return ln
+}
+
+// benchFDsPerConn is the number of the FDs per relayed connection: the client, the accepted connection,
+// the FD received from the child and its duplicate for net.FileConn, the peer in the child,
+// and the pipes for splice(2) in both directions.
+const benchFDsPerConn = 9
+
+// benchConnectionsForNOFILE raises RLIMIT_NOFILE for benchConnections, up to the hard limit,
+// and returns the number of the connections that can be opened within the limit.
+func benchConnectionsForNOFILE() (int, error) {
+	var rlim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0, err
+	}
+	const reserved = 1024
+	if want := uint64(benchConnections*benchFDsPerConn + reserved); rlim.Cur < want {
+		rlim.Cur = want
+		if rlim.Cur > rlim.Max {
+			rlim.Cur = rlim.Max
+		}
+		if err := unix.Setrlimit(unix.RLIMIT_NOFILE, &rlim); err != nil {
+			return 0, err
+		}
+	}
+	n := (int(rlim.Cur) - reserved) / benchFDsPerConn
+	if n > benchConnections {
+		n = benchConnections
+	}
+	return n, nil
+}
+
+// benchConnectionsForBacklog returns the number of the connections that can be opened with the workers,
+// as the connections beyond the workers wait in the listen backlog, which is capped by net.core.somaxconn.
+func benchConnectionsForBacklog(workers int) (int, error) {
+	b, err := ioutil.ReadFile("/proc/sys/net/core/somaxconn")
+	if err != nil {
+		return 0, err
+	}
+	somaxconn, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, err
+	}
+	// one waiting for a worker, and one waiting in the accept loop
+	return workers + somaxconn + 2, nil
+}
+
+// BenchmarkRelayWorkers compares the memory usage of the parent under benchConnections concurrent connections
+// with a goroutine per connection (workers=0), and with the relay workers.
+// Every case opens the same number of the connections. With the relay workers, the connections beyond the workers
+// wait in the listen backlog of the kernel, and are counted as well.
+// The deltas of the heap, the goroutine stacks, and the goroutines are logged, as `-benchmem` only reports the allocations.
+// The number of the connections is reduced for all the cases when the hard limit of RLIMIT_NOFILE
+// or net.core.somaxconn is too low.
+//
+//	go test -run=NONE -bench=RelayWorkers -benchtime=1x -v ./pkg/port/builtin/parent/tcp
+func BenchmarkRelayWorkers(b *testing.B) {
+	conns, err := benchConnectionsForNOFILE()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if conns < benchConnections {
+		b.Logf("RLIMIT_NOFILE allows only %d connections", conns)
+	}
+	minWorkers := 0
+	for _, workers := range benchWorkers {
+		if workers > 0 && (minWorkers == 0 || workers < minWorkers) {
+			minWorkers = workers
+		}
+	}
+	if minWorkers > 0 {
+		n, err := benchConnectionsForBacklog(minWorkers)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n < conns {
+			b.Logf("net.core.somaxconn allows only %d connections with workers=%d (needs to be %d or larger for %d connections)",
+				n, minWorkers, conns-minWorkers-2, conns)
+			conns = n
+		}
+	}
+	for _, workers := range benchWorkers {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				benchmarkRelayWorkers(b, conns, workers)
+			}
+		})
+	}
+}
+
+func benchmarkRelayWorkers(b *testing.B, connections, workers int) {
+	tmpDir, err := ioutil.TempDir("", "bench-relayworkers")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	socketPath := filepath.Join(tmpDir, "child.sock")
+	peers := make(chan *os.File, connections)
+	childLn := serveFakeChildPair(b, socketPath, peers)
+	defer childLn.Close()
+	// the backlog is capped by net.core.somaxconn, see benchConnectionsForBacklog
+	ln, err := listen("127.0.0.1:0", connections, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	spec := port.Spec{Proto: "tcp"}
+	stopCh := make(chan struct{})
+	counter := NewConnCounter(0)
+	pool := NewWorkerPool(workers)
+	before := readBenchMem()
+	Serve(ln, socketPath, spec, stopCh, ioutil.Discard, counter, pool, nil)
+
+	conns := make([]net.Conn, 0, connections)
+	defer func() {
+		close(stopCh)
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for i := 0; i < connections; i++ {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			b.Fatal(err)
+		}
+		conns = append(conns, c)
+	}
+	relayed := connections
+	if workers > 0 && workers < relayed {
+		relayed = workers
+	}
+	// wait for the relays to be established, and make them allocate the copy buffers
+	buf := make([]byte, 1)
+	for i := 0; i < relayed; i++ {
+		if _, err := conns[i].Write([]byte("x")); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for i := 0; i < relayed; i++ {
+		peer := <-peers
+		if _, err := peer.Read(buf); err != nil {
+			b.Fatal(err)
+		}
+		defer peer.Close()
+	}
+	accepted := connections
+	if relayed < connections {
+		// one is waiting for a worker, the others are in the listen backlog
+		accepted = relayed + 1
+	}
+	for counter.Current() < accepted {
+		time.Sleep(10 * time.Millisecond)
+	}
+	after := readBenchMem()
+	heap, stack := after.heap-before.heap, after.stack-before.stack
+	b.Logf("workers=%d: %d connections (%d relayed, %d waiting for a worker, %d in the listen backlog): "+
+		"%+d goroutines, %+d KiB heap, %+d KiB stacks (%d bytes per connection)",
+		workers, connections, relayed, accepted-relayed, connections-accepted,
+		after.goroutines-before.goroutines, heap/1024, stack/1024, (heap+stack)/int64(connections))
+}
+
+type benchMem struct {
+	heap       int64
+	stack      int64
+	goroutines int
+}
+
+// readBenchMem returns the bytes of the heap and the goroutine stacks in use after GC, and the number of the goroutines.
+func readBenchMem() benchMem {
+	runtime.GC()
+	var st runtime.MemStats
+	runtime.ReadMemStats(&st)
+	return benchMem{
+		heap:       int64(st.HeapInuse),
+		stack:      int64(st.StackInuse),
+		goroutines: runtime.NumGoroutine(),
+	}
+}
@@ -1,6 +1,7 @@
 package udp
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -11,17 +12,25 @@ import (
 	"github.com/rootless-containers/rootlesskit/pkg/port"
 	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/msg"
 	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/parent/udp/udpproxy"
+	"github.com/rootless-containers/rootlesskit/pkg/port/portutil"
 )
 
-func Run(socketPath string, spec port.Spec, stopCh <-chan struct{}, logWriter io.Writer) error {
+// Listen listens on the parent port, and forwards the datagrams to the child, until the returned proxy is closed.
+// Listen does not block, and consumes a goroutine per port, in addition to the goroutines of the flows.
+func Listen(socketPath string, spec port.Spec, logWriter io.Writer) (*udpproxy.UDPProxy, error) {
 	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", spec.ParentIP, spec.ParentPort))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	c, err := net.ListenUDP("udp", addr)
+	var lc net.ListenConfig
+	if spec.ReusePort {
+		lc.Control = portutil.ControlReusePort
+	}
+	pc, err := lc.ListenPacket(context.Background(), "udp", addr.String())
 	if err != nil {
-		return err
+		return nil, err
 	}
+	c := pc.(*net.UDPConn)
 	udpp := &udpproxy.UDPProxy{
 		LogWriter: logWriter,
 		Listener:  c,
@@ -45,16 +54,5 @@ func Run(socketPath string, spec port.Spec, stopCh <-chan struct{}, logWriter io
 		},
 	}
 	go udpp.Run()
-	go func() {
-		for {
-			select {
-			case <-stopCh:
-				// udpp.Close closes ln as well
-				udpp.Close()
-				return
-			}
-		}
-	}()
-	// no wait
-	return nil
+	return udpp, nil
 }
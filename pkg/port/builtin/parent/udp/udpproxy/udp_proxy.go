@@ -6,14 +6,16 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 const (
-	// UDPConnTrackTimeout is the timeout used for UDP connection tracking
+	// UDPConnTrackTimeout is the default idle timeout used for UDP connection tracking
 	UDPConnTrackTimeout = 90 * time.Second
 	// UDPBufSize is the buffer size for the UDP proxy
 	UDPBufSize = 65507
@@ -42,29 +44,76 @@ func newConnTrackKey(addr *net.UDPAddr) *connTrackKey {
 	}
 }
 
-type connTrackMap map[connTrackKey]*net.UDPConn
+// connTrackEntry is the backend socket of a client.
+// As BackendDial creates a distinct socket for each client, each client is seen from the backend
+// with a distinct source port, and the replies are sent back to the client that owns the socket.
+type connTrackEntry struct {
+	// lastActive is the time of the last datagram in either direction, in UnixNano.
+	// Accessed atomically, and placed first for the 64-bit alignment on 32-bit platforms.
+	lastActive int64
+	conn       *net.UDPConn
+}
+
+func (e *connTrackEntry) touch() {
+	atomic.StoreInt64(&e.lastActive, time.Now().UnixNano())
+}
+
+func (e *connTrackEntry) lastActiveTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&e.lastActive))
+}
+
+type connTrackMap map[connTrackKey]*connTrackEntry
 
 // UDPProxy is proxy for which handles UDP datagrams.
 // From libnetwork udp_proxy.go .
 type UDPProxy struct {
-	LogWriter      io.Writer
-	Listener       *net.UDPConn
-	BackendDial    func() (*net.UDPConn, error)
+	LogWriter   io.Writer
+	Listener    *net.UDPConn
+	BackendDial func() (*net.UDPConn, error)
+	// IdleTimeout is the duration without any datagram in either direction, after which the backend socket
+	// of the client is closed. Zero for UDPConnTrackTimeout.
+	IdleTimeout    time.Duration
 	connTrackTable connTrackMap
 	connTrackLock  sync.Mutex
 }
 
-func (proxy *UDPProxy) replyLoop(proxyConn *net.UDPConn, clientAddr *net.UDPAddr, clientKey *connTrackKey) {
+func (proxy *UDPProxy) idleTimeout() time.Duration {
+	if proxy.IdleTimeout == 0 {
+		return UDPConnTrackTimeout
+	}
+	return proxy.IdleTimeout
+}
+
+// reclaim removes the entry from the table and returns true, if the entry has been idle for the timeout.
+// The entry is checked under the lock, so that Run never writes a datagram to a reclaimed entry.
+func (proxy *UDPProxy) reclaim(clientKey *connTrackKey, entry *connTrackEntry, timeout time.Duration) bool {
+	proxy.connTrackLock.Lock()
+	defer proxy.connTrackLock.Unlock()
+	if time.Since(entry.lastActiveTime()) < timeout {
+		return false
+	}
+	if proxy.connTrackTable[*clientKey] == entry {
+		delete(proxy.connTrackTable, *clientKey)
+	}
+	return true
+}
+
+func (proxy *UDPProxy) replyLoop(entry *connTrackEntry, clientAddr *net.UDPAddr, clientKey *connTrackKey) {
+	proxyConn := entry.conn
 	defer func() {
 		proxy.connTrackLock.Lock()
-		delete(proxy.connTrackTable, *clientKey)
+		// the key may have been taken by a new entry after reclaiming
+		if proxy.connTrackTable[*clientKey] == entry {
+			delete(proxy.connTrackTable, *clientKey)
+		}
 		proxy.connTrackLock.Unlock()
 		proxyConn.Close()
 	}()
 
+	timeout := proxy.idleTimeout()
 	readBuf := make([]byte, UDPBufSize)
 	for {
-		proxyConn.SetReadDeadline(time.Now().Add(UDPConnTrackTimeout))
+		proxyConn.SetReadDeadline(entry.lastActiveTime().Add(timeout))
 	again:
 		read, err := proxyConn.Read(readBuf)
 		if err != nil {
@@ -72,12 +121,17 @@ func (proxy *UDPProxy) replyLoop(proxyConn *net.UDPConn, clientAddr *net.UDPAddr
 				// This will happen if the last write failed
 				// (e.g: nothing is actually listening on the
 				// proxied port on the container), ignore it
-				// and continue until UDPConnTrackTimeout
+				// and continue until the idle timeout
 				// expires:
 				goto again
 			}
+			if err, ok := err.(net.Error); ok && err.Timeout() && !proxy.reclaim(clientKey, entry, timeout) {
+				// the client has sent datagrams since the deadline was set
+				continue
+			}
 			return
 		}
+		entry.touch()
 		for i := 0; i != read; {
 			written, err := proxy.Listener.WriteToUDP(readBuf[i:read], clientAddr)
 			if err != nil {
@@ -88,12 +142,73 @@ func (proxy *UDPProxy) replyLoop(proxyConn *net.UDPConn, clientAddr *net.UDPAddr
 	}
 }
 
+// bufPool is shared across the listeners, so that thousands of idle listeners
+// (e.g. for a port range) do not hold UDPBufSize bytes each.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, UDPBufSize)
+	},
+}
+
+// readFromUDP is similar to proxy.Listener.ReadFromUDP, but the buffer is taken from bufPool
+// only after the listener becomes readable.
+// The buffer needs to be returned to bufPool by the caller.
+func (proxy *UDPProxy) readFromUDP() ([]byte, int, *net.UDPAddr, error) {
+	rc, err := proxy.Listener.SyscallConn()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	var (
+		buf     []byte
+		read    int
+		from    syscall.Sockaddr
+		recvErr error
+	)
+	err = rc.Read(func(fd uintptr) bool {
+		buf = bufPool.Get().([]byte)
+		read, from, recvErr = syscall.Recvfrom(int(fd), buf, 0)
+		if recvErr == syscall.EAGAIN || recvErr == syscall.EINTR {
+			bufPool.Put(buf)
+			buf = nil
+			return false
+		}
+		return true
+	})
+	if err == nil {
+		err = recvErr
+	}
+	if err != nil {
+		if buf != nil {
+			bufPool.Put(buf)
+		}
+		return nil, 0, nil, err
+	}
+	return buf, read, sockaddrToUDPAddr(from), nil
+}
+
+func sockaddrToUDPAddr(sa syscall.Sockaddr) *net.UDPAddr {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, sa.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: sa.Port}
+	case *syscall.SockaddrInet6:
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, sa.Addr[:])
+		addr := &net.UDPAddr{IP: ip, Port: sa.Port}
+		if sa.ZoneId != 0 {
+			addr.Zone = strconv.Itoa(int(sa.ZoneId))
+		}
+		return addr
+	}
+	return nil
+}
+
 // Run starts forwarding the traffic using UDP.
 func (proxy *UDPProxy) Run() {
 	proxy.connTrackTable = make(connTrackMap)
-	readBuf := make([]byte, UDPBufSize)
 	for {
-		read, from, err := proxy.Listener.ReadFromUDP(readBuf)
+		readBuf, read, from, err := proxy.readFromUDP()
 		if err != nil {
 			// NOTE: Apparently ReadFrom doesn't return
 			// ECONNREFUSED like Read do (see comment in
@@ -103,21 +218,32 @@ func (proxy *UDPProxy) Run() {
 			}
 			break
 		}
+		if from == nil {
+			// unexpected address family
+			bufPool.Put(readBuf)
+			continue
+		}
 
 		fromKey := newConnTrackKey(from)
 		proxy.connTrackLock.Lock()
-		proxyConn, hit := proxy.connTrackTable[*fromKey]
+		entry, hit := proxy.connTrackTable[*fromKey]
 		if !hit {
-			proxyConn, err = proxy.BackendDial()
+			proxyConn, err := proxy.BackendDial()
 			if err != nil {
 				fmt.Fprintf(proxy.LogWriter, "Can't proxy a datagram to udp: %v\n", err)
 				proxy.connTrackLock.Unlock()
+				bufPool.Put(readBuf)
 				continue
 			}
-			proxy.connTrackTable[*fromKey] = proxyConn
-			go proxy.replyLoop(proxyConn, from, fromKey)
+			entry = &connTrackEntry{conn: proxyConn}
+			proxy.connTrackTable[*fromKey] = entry
+			entry.touch()
+			go proxy.replyLoop(entry, from, fromKey)
+		} else {
+			entry.touch()
 		}
 		proxy.connTrackLock.Unlock()
+		proxyConn := entry.conn
 		for i := 0; i != read; {
 			written, err := proxyConn.Write(readBuf[i:read])
 			if err != nil {
@@ -126,6 +252,7 @@ func (proxy *UDPProxy) Run() {
 			}
 			i += written
 		}
+		bufPool.Put(readBuf)
 	}
 }
 
@@ -134,8 +261,8 @@ func (proxy *UDPProxy) Close() {
 	proxy.Listener.Close()
 	proxy.connTrackLock.Lock()
 	defer proxy.connTrackLock.Unlock()
-	for _, conn := range proxy.connTrackTable {
-		conn.Close()
+	for _, entry := range proxy.connTrackTable {
+		entry.conn.Close()
 	}
 }
 
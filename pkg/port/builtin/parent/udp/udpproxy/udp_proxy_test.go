@@ -0,0 +1,176 @@
+package udpproxy
+
+import (
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// noReply is the payload that the echo backend does not reply to.
+const noReply = "noreply"
+
+// startBackend starts the backend that replies with the source address of each datagram, unless the payload is noReply.
+// The source addresses are also sent to the returned channel.
+func startBackend(t *testing.T) (*net.UDPConn, <-chan string) {
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(chan string, 100)
+	go func() {
+		buf := make([]byte, UDPBufSize)
+		for {
+			n, from, err := backend.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			seen <- from.String()
+			if string(buf[:n]) != noReply {
+				backend.WriteToUDP([]byte(from.String()), from)
+			}
+		}
+	}()
+	return backend, seen
+}
+
+func startProxy(t *testing.T, backendAddr *net.UDPAddr, idleTimeout time.Duration) *UDPProxy {
+	ln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &UDPProxy{
+		LogWriter: ioutil.Discard,
+		Listener:  ln,
+		BackendDial: func() (*net.UDPConn, error) {
+			return net.DialUDP("udp", nil, backendAddr)
+		},
+		IdleTimeout: idleTimeout,
+	}
+	go proxy.Run()
+	return proxy
+}
+
+// roundTrip sends a datagram from the client, and returns the source address of the proxied datagram
+// seen by the backend.
+func roundTrip(client *net.UDPConn) (string, error) {
+	if _, err := client.Write([]byte("hello")); err != nil {
+		return "", err
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, UDPBufSize)
+	n, err := client.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+func (proxy *UDPProxy) entries() int {
+	proxy.connTrackLock.Lock()
+	defer proxy.connTrackLock.Unlock()
+	return len(proxy.connTrackTable)
+}
+
+func TestUDPProxyClientIsolation(t *testing.T) {
+	backend, _ := startBackend(t)
+	defer backend.Close()
+	proxy := startProxy(t, backend.LocalAddr().(*net.UDPAddr), 0)
+	defer proxy.Close()
+
+	var clients [2]*net.UDPConn
+	for i := range clients {
+		c, err := net.DialUDP("udp", nil, proxy.Listener.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		clients[i] = c
+	}
+	var (
+		wg      sync.WaitGroup
+		sources [2][]string
+		errs    [2]error
+	)
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c *net.UDPConn) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				s, err := roundTrip(c)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				sources[i] = append(sources[i], s)
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	for i := range clients {
+		if errs[i] != nil {
+			t.Fatalf("client %d: %v", i, errs[i])
+		}
+		for _, s := range sources[i] {
+			if s != sources[i][0] {
+				t.Fatalf("client %d: source address changed from %s to %s", i, sources[i][0], s)
+			}
+		}
+	}
+	if sources[0][0] == sources[1][0] {
+		t.Fatalf("the clients share the source address %s", sources[0][0])
+	}
+	if n := proxy.entries(); n != 2 {
+		t.Fatalf("expected 2 entries, got %d", n)
+	}
+}
+
+func TestUDPProxyIdleTimeout(t *testing.T) {
+	backend, seen := startBackend(t)
+	defer backend.Close()
+	const idleTimeout = 300 * time.Millisecond
+	proxy := startProxy(t, backend.LocalAddr().(*net.UDPAddr), idleTimeout)
+	defer proxy.Close()
+
+	client, err := net.DialUDP("udp", nil, proxy.Listener.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	src, err := roundTrip(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-seen
+
+	// the datagrams from the client without replies keep the entry
+	for i := 0; i < 6; i++ {
+		if _, err := client.Write([]byte(noReply)); err != nil {
+			t.Fatal(err)
+		}
+		if s := <-seen; s != src {
+			t.Fatalf("expected %s, got %s", src, s)
+		}
+		time.Sleep(idleTimeout / 3)
+	}
+	if n := proxy.entries(); n != 1 {
+		t.Fatalf("expected 1 entry, got %d", n)
+	}
+
+	// the idle entry is reclaimed
+	deadline := time.Now().Add(5 * time.Second)
+	for proxy.entries() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the idle entry was not reclaimed")
+		}
+		time.Sleep(idleTimeout / 3)
+	}
+	s, err := roundTrip(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s == src {
+		t.Fatalf("expected a new source address, got %s again", s)
+	}
+}
@@ -7,14 +7,75 @@ import (
 
 type Spec struct {
 	Proto      string `json:"proto,omitempty"`    // either "tcp" or "udp". in future "sctp" will be supported as well.
-	ParentIP   string `json:"parentIP,omitempty"` // IPv4 address. can be empty (0.0.0.0).
+	ParentIP   string `json:"parentIP,omitempty"` // IPv4 address. can be empty (0.0.0.0). The builtin driver also accepts a hostname.
 	ParentPort int    `json:"parentPort,omitempty"`
 	ChildPort  int    `json:"childPort,omitempty"`
+	// ChildIP is an IPv4 address in the child. can be empty (127.0.0.1 for builtin and socat, the tap IP for slirp4netns).
+	// For the builtin driver, ChildIP needs to be either a loopback address or within the networks configured in the child.
+	ChildIP string `json:"childIP,omitempty"`
+	// ProxyProtocol is either "" (disabled), "v1", or "v2". Supported only for the builtin driver with "tcp".
+	ProxyProtocol string `json:"proxyProtocol,omitempty"`
+	// TCPKeepAlive enables SO_KEEPALIVE on both the parent-side and the child-side connections.
+	// Supported only for the builtin driver with "tcp".
+	TCPKeepAlive bool `json:"tcpKeepAlive,omitempty"`
+	// TCPKeepAliveInterval is the interval in seconds, used for both TCP_KEEPIDLE and TCP_KEEPINTVL.
+	// 0 for DefaultTCPKeepAliveInterval. Requires TCPKeepAlive.
+	TCPKeepAliveInterval int `json:"tcpKeepAliveInterval,omitempty"`
+	// ParentSocket is the absolute path of the UNIX socket to listen on the parent, instead of ParentIP and ParentPort.
+	// Supported only for the builtin driver with "tcp".
+	ParentSocket string `json:"parentSocket,omitempty"`
+	// ChildSocket is the absolute path of the UNIX socket to connect to in the child, instead of ChildIP and ChildPort.
+	// Supported only for the builtin driver with "tcp".
+	ChildSocket string `json:"childSocket,omitempty"`
+	// MaxConnections is the maximum number of the simultaneous connections. 0 for unlimited.
+	// The connections beyond the limit are closed immediately.
+	// Supported only for the builtin driver with "tcp".
+	MaxConnections int `json:"maxConnections,omitempty"`
+	// ReusePort sets SO_REUSEPORT on the parent socket, so that multiple instances can listen on the same port.
+	// Supported only for the builtin driver, and not for ParentSocket.
+	ReusePort bool `json:"reusePort,omitempty"`
+	// PortCount is the number of the consecutive ports starting with ParentPort and ChildPort. 0 is same as 1.
+	// e.g. ParentPort=10000, ChildPort=10000, PortCount=10001 for "0.0.0.0:10000-20000:10000/udp".
+	// MaxConnections is applied to the whole range.
+	// Supported only for the builtin driver, and not for ParentSocket and ChildSocket.
+	PortCount int `json:"portCount,omitempty"`
+	// TCPFastOpen sets TCP_FASTOPEN on the parent socket, and TCP_FASTOPEN_CONNECT on the child-side connections.
+	// Falls back to the regular TCP when the kernel or the net.ipv4.tcp_fastopen sysctl does not allow TCP Fast Open.
+	// Supported only for the builtin driver with "tcp", and not for ParentSocket.
+	TCPFastOpen bool `json:"tcpFastOpen,omitempty"`
+	// DialTimeout is the timeout in seconds for connecting to the child target. 0 for DefaultDialTimeout.
+	// TCP connections are reset when the timeout is reached.
+	// Supported only for the builtin driver.
+	DialTimeout int `json:"dialTimeout,omitempty"`
+	// VsockCID is the CID to listen on, for the vsock driver. 0 for any CID (VMADDR_CID_ANY).
+	// The vsock port is ParentPort. Supported only for the vsock driver.
+	VsockCID uint32 `json:"vsockCID,omitempty"`
+	// IdleTimeout is the timeout in seconds for closing the connections that transfer no byte in either direction.
+	// 0 for no timeout. Supported only for the builtin driver (and the vsock driver) with "tcp".
+	IdleTimeout int `json:"idleTimeout,omitempty"`
+	// ChildAbstractSocket is the name of the abstract UNIX socket to listen on in the child, without the leading "@",
+	// e.g. "/tmp/.X11-unix/X0". Unlike the other specs, the connections are forwarded from the child to ParentTargetSocket.
+	// ChildAbstractSocket is exclusive with ParentIP, ParentPort, ParentSocket, ChildIP, ChildPort, and ChildSocket.
+	// Supported only for the builtin driver with "tcp".
+	ChildAbstractSocket string `json:"childAbstractSocket,omitempty"`
+	// ParentTargetSocket is the UNIX socket on the parent to connect to, for ChildAbstractSocket:
+	// either "@NAME" for an abstract socket, or an absolute path.
+	// Empty for the abstract socket with the same name as ChildAbstractSocket.
+	ParentTargetSocket string `json:"parentTargetSocket,omitempty"`
 }
 
+// DefaultTCPKeepAliveInterval is the default of Spec.TCPKeepAliveInterval in seconds.
+const DefaultTCPKeepAliveInterval = 60
+
+// DefaultDialTimeout is the default of Spec.DialTimeout in seconds.
+const DefaultDialTimeout = 10
+
 type Status struct {
 	ID   int  `json:"id"`
 	Spec Spec `json:"spec"`
+	// Connections is the current number of the connections.
+	// Reported only by the builtin driver for "tcp".
+	Connections int `json:"connections,omitempty"`
 }
 
 // Manager MUST be thread-safe.
@@ -0,0 +1,62 @@
+package portutil
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// BiCopy is based on libnetwork/cmd/proxy/tcp_proxy.go .
+// BiCopy returns the bytes copied from x to y, and the bytes copied from y to x.
+// x and y are closed when quit is closed.
+// When idleTimeout is non-zero, x and y are closed when no byte is copied in either direction for idleTimeout.
+// NOTE: sendfile(2) cannot be used for sockets
+func BiCopy(x, y net.Conn, quit <-chan struct{}, idleTimeout time.Duration) (int64, int64) {
+	var (
+		wg     sync.WaitGroup
+		xy, yx int64
+		idle   *idleTimer
+	)
+	if idleTimeout > 0 {
+		idle = newIdleTimer(idleTimeout)
+	}
+	var broker = func(to, from net.Conn, n *int64) {
+		var r io.Reader = from
+		if idle != nil {
+			r = idle.reader(from)
+		}
+		*n, _ = io.Copy(to, r)
+		// *net.TCPConn or *net.UnixConn
+		if fromCR, ok := from.(interface{ CloseRead() error }); ok {
+			fromCR.CloseRead()
+		}
+		if toCW, ok := to.(interface{ CloseWrite() error }); ok {
+			toCW.CloseWrite()
+		}
+		wg.Done()
+	}
+
+	wg.Add(2)
+	go broker(x, y, &yx)
+	go broker(y, x, &xy)
+	finish := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finish)
+	}()
+	var expired <-chan struct{}
+	if idle != nil {
+		expired = idle.watch(finish)
+	}
+
+	select {
+	case <-quit:
+	case <-finish:
+	case <-expired:
+	}
+	x.Close()
+	y.Close()
+	<-finish
+	return xy, yx
+}
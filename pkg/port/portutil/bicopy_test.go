@@ -0,0 +1,113 @@
+package portutil
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpPair returns the both ends of a TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestBiCopy(t *testing.T) {
+	a, x := tcpPair(t)
+	defer a.Close()
+	y, b := tcpPair(t)
+	defer b.Close()
+	type result struct{ xy, yx int64 }
+	resCh := make(chan result)
+	go func() {
+		xy, yx := BiCopy(x, y, nil, 0)
+		resCh <- result{xy, yx}
+	}()
+	if _, err := a.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	a.(*net.TCPConn).CloseWrite()
+	got, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("expected %q, got %q", "hello", got)
+	}
+	// the half-closed connection still relays the other direction
+	if _, err := b.Write([]byte("world!")); err != nil {
+		t.Fatal(err)
+	}
+	b.(*net.TCPConn).CloseWrite()
+	got, err = ioutil.ReadAll(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "world!" {
+		t.Fatalf("expected %q, got %q", "world!", got)
+	}
+	select {
+	case res := <-resCh:
+		if res.xy != 5 || res.yx != 6 {
+			t.Fatalf("expected 5 and 6 bytes, got %d and %d", res.xy, res.yx)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("BiCopy did not return")
+	}
+}
+
+func TestBiCopyQuit(t *testing.T) {
+	a, x := tcpPair(t)
+	defer a.Close()
+	y, b := tcpPair(t)
+	defer b.Close()
+	quit := make(chan struct{})
+	finish := make(chan struct{})
+	go func() {
+		BiCopy(x, y, quit, 0)
+		close(finish)
+	}()
+	close(quit)
+	select {
+	case <-finish:
+	case <-time.After(5 * time.Second):
+		t.Fatal("BiCopy did not return on quit")
+	}
+	// x and y are closed
+	if _, err := ioutil.ReadAll(a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBiCopyIdleTimeout(t *testing.T) {
+	a, x := tcpPair(t)
+	defer a.Close()
+	y, b := tcpPair(t)
+	defer b.Close()
+	finish := make(chan struct{})
+	go func() {
+		BiCopy(x, y, nil, 200*time.Millisecond)
+		close(finish)
+	}()
+	select {
+	case <-finish:
+	case <-time.After(5 * time.Second):
+		t.Fatal("BiCopy did not return on the idle timeout")
+	}
+}
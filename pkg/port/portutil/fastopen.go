@@ -0,0 +1,44 @@
+package portutil
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TCPFastOpenSysctl is the path of the net.ipv4.tcp_fastopen sysctl.
+const TCPFastOpenSysctl = "/proc/sys/net/ipv4/tcp_fastopen"
+
+// The flags of the net.ipv4.tcp_fastopen sysctl.
+const (
+	TCPFastOpenClient = 0x1
+	TCPFastOpenServer = 0x2
+)
+
+// DefaultTCPFastOpenQueueLen is the length of the queue of the pending TCP Fast Open requests for TCP_FASTOPEN.
+const DefaultTCPFastOpenQueueLen = 256
+
+// TCPFastOpenEnabled returns whether flag (TCPFastOpenClient or TCPFastOpenServer) is enabled in the
+// net.ipv4.tcp_fastopen sysctl of the current network namespace.
+// An error is returned when the kernel does not support TCP Fast Open.
+func TCPFastOpenEnabled(flag int) (bool, error) {
+	b, err := ioutil.ReadFile(TCPFastOpenSysctl)
+	if err != nil {
+		return false, errors.Wrap(err, "kernel does not support TCP Fast Open")
+	}
+	v, err := parseTCPFastOpenSysctl(string(b))
+	if err != nil {
+		return false, err
+	}
+	return v&flag == flag, nil
+}
+
+func parseTCPFastOpenSysctl(s string) (int, error) {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", TCPFastOpenSysctl)
+	}
+	return v, nil
+}
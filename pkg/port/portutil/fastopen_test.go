@@ -0,0 +1,32 @@
+package portutil
+
+import (
+	"testing"
+)
+
+func TestParseTCPFastOpenSysctl(t *testing.T) {
+	testCases := []struct {
+		s        string
+		expected int
+		ok       bool
+	}{
+		{"1\n", 1, true},
+		{"3", 3, true},
+		{"0x3", 0, false},
+		{"", 0, false},
+	}
+	for _, tc := range testCases {
+		got, err := parseTCPFastOpenSysctl(tc.s)
+		if tc.ok && err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.s, err)
+			continue
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%q: expected an error", tc.s)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("%q: expected %d, got %d", tc.s, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package portutil
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// idleTimer fires when no byte is transferred in either direction for the timeout.
+type idleTimer struct {
+	timeout time.Duration
+	// last is the time of the last activity in UnixNano
+	last int64
+}
+
+func newIdleTimer(timeout time.Duration) *idleTimer {
+	return &idleTimer{
+		timeout: timeout,
+		last:    time.Now().UnixNano(),
+	}
+}
+
+func (t *idleTimer) touch() {
+	atomic.StoreInt64(&t.last, time.Now().UnixNano())
+}
+
+// reader returns the reader that touches t on every read.
+func (t *idleTimer) reader(r io.Reader) io.Reader {
+	return &idleReader{Reader: r, t: t}
+}
+
+// watch returns the channel that is closed when t expires.
+// watch stops when stopCh is closed.
+func (t *idleTimer) watch(stopCh <-chan struct{}) <-chan struct{} {
+	expired := make(chan struct{})
+	go func() {
+		timer := time.NewTimer(t.timeout)
+		defer timer.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-timer.C:
+				idle := time.Since(time.Unix(0, atomic.LoadInt64(&t.last)))
+				if idle >= t.timeout {
+					close(expired)
+					return
+				}
+				timer.Reset(t.timeout - idle)
+			}
+		}
+	}()
+	return expired
+}
+
+type idleReader struct {
+	io.Reader
+	t *idleTimer
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.t.touch()
+	}
+	return n, err
+}
@@ -0,0 +1,27 @@
+package portutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleTimerWatch(t *testing.T) {
+	timer := newIdleTimer(100 * time.Millisecond)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	expired := timer.watch(stopCh)
+	for i := 0; i < 5; i++ {
+		time.Sleep(50 * time.Millisecond)
+		timer.touch()
+	}
+	select {
+	case <-expired:
+		t.Fatal("expired despite the activity")
+	default:
+	}
+	select {
+	case <-expired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not expired")
+	}
+}
@@ -0,0 +1,62 @@
+package portutil
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+// ParseNAT1to1 parses "HOSTIP:CHILDIP[:START-END]" for forwarding all the TCP and UDP ports
+// of the dedicated host IP to the child IP, and returns the specs for "tcp" and "udp".
+// The range defaults to defaultStart-65535. defaultStart is typically UnprivilegedPortStart(), as the lower ports cannot be bound.
+// Only IPv4 addresses are supported.
+func ParseNAT1to1(s string, defaultStart int) ([]port.Spec, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) != 2 && len(fields) != 3 {
+		return nil, errors.Errorf("invalid 1:1 NAT %q, must be \"HOSTIP:CHILDIP[:START-END]\"", s)
+	}
+	var ips [2]string
+	for i, f := range fields[:2] {
+		ip := net.ParseIP(f)
+		if ip == nil || ip.To4() == nil {
+			return nil, errors.Errorf("invalid 1:1 NAT %q: %q is not an IPv4 address", s, f)
+		}
+		if ip.IsUnspecified() {
+			// 0.0.0.0 would take over all the ports of the host
+			return nil, errors.Errorf("invalid 1:1 NAT %q: the address must not be unspecified", s)
+		}
+		ips[i] = ip.String()
+	}
+	if defaultStart < 1 {
+		defaultStart = 1
+	}
+	start, end := defaultStart, 65535
+	if len(fields) == 3 {
+		r := strings.SplitN(fields[2], "-", 2)
+		var err1, err2 error
+		start, err1 = strconv.Atoi(r[0])
+		end, err2 = start, nil
+		if len(r) == 2 {
+			end, err2 = strconv.Atoi(r[1])
+		}
+		if err1 != nil || err2 != nil || start < 1 || end > 65535 || start > end {
+			return nil, errors.Errorf("invalid 1:1 NAT %q: invalid port range %q", s, fields[2])
+		}
+	}
+	var specs []port.Spec
+	for _, proto := range []string{"tcp", "udp"} {
+		specs = append(specs, port.Spec{
+			Proto:      proto,
+			ParentIP:   ips[0],
+			ParentPort: start,
+			ChildIP:    ips[1],
+			ChildPort:  start,
+			PortCount:  end - start + 1,
+		})
+	}
+	return specs, nil
+}
@@ -0,0 +1,67 @@
+package portutil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+func TestParseNAT1to1(t *testing.T) {
+	testCases := []struct {
+		s        string
+		expected []port.Spec
+	}{
+		{
+			s: "192.168.1.10:10.0.2.100",
+			expected: []port.Spec{
+				{Proto: "tcp", ParentIP: "192.168.1.10", ParentPort: 1024, ChildIP: "10.0.2.100", ChildPort: 1024, PortCount: 64512},
+				{Proto: "udp", ParentIP: "192.168.1.10", ParentPort: 1024, ChildIP: "10.0.2.100", ChildPort: 1024, PortCount: 64512},
+			},
+		},
+		{
+			s: "192.168.1.10:10.0.2.100:1-65535",
+			expected: []port.Spec{
+				{Proto: "tcp", ParentIP: "192.168.1.10", ParentPort: 1, ChildIP: "10.0.2.100", ChildPort: 1, PortCount: 65535},
+				{Proto: "udp", ParentIP: "192.168.1.10", ParentPort: 1, ChildIP: "10.0.2.100", ChildPort: 1, PortCount: 65535},
+			},
+		},
+		{
+			s: "192.168.1.10:10.0.2.100:8080",
+			expected: []port.Spec{
+				{Proto: "tcp", ParentIP: "192.168.1.10", ParentPort: 8080, ChildIP: "10.0.2.100", ChildPort: 8080, PortCount: 1},
+				{Proto: "udp", ParentIP: "192.168.1.10", ParentPort: 8080, ChildIP: "10.0.2.100", ChildPort: 8080, PortCount: 1},
+			},
+		},
+		{s: "192.168.1.10"},
+		{s: "0.0.0.0:10.0.2.100"},
+		{s: "192.168.1.10:0.0.0.0"},
+		{s: "::1:10.0.2.100"},
+		{s: "example.com:10.0.2.100"},
+		{s: "192.168.1.10:10.0.2.100:0-100"},
+		{s: "192.168.1.10:10.0.2.100:2000-1000"},
+		{s: "192.168.1.10:10.0.2.100:1-65536"},
+	}
+	for _, tc := range testCases {
+		// the default of net.ipv4.ip_unprivileged_port_start
+		got, err := ParseNAT1to1(tc.s, 1024)
+		if tc.expected == nil {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", tc.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%q: expected %+v, got %+v", tc.s, tc.expected, got)
+		}
+		for _, sp := range got {
+			if err := ValidatePortSpec(sp, nil); err != nil {
+				t.Errorf("%q: invalid spec %+v: %v", tc.s, sp, err)
+			}
+		}
+	}
+}
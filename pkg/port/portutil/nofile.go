@@ -0,0 +1,43 @@
+package portutil
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// CheckNoFile returns an error if opening n more file descriptors would exceed the soft limit of RLIMIT_NOFILE.
+// Used for binding a large number of the ports up front, rather than failing halfway.
+func CheckNoFile(n int) error {
+	var rlim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &rlim); err != nil {
+		return err
+	}
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return err
+	}
+	if need := uint64(len(fds)) + uint64(n); need > rlim.Cur {
+		return errors.Errorf("needs %d more file descriptors, but RLIMIT_NOFILE is %d (%d in use); raise it with `ulimit -n`",
+			n, rlim.Cur, len(fds))
+	}
+	return nil
+}
+
+// UnprivilegedPortStart returns the net.ipv4.ip_unprivileged_port_start sysctl of the current network namespace.
+// The ports below it cannot be bound without CAP_NET_BIND_SERVICE.
+// Returns 1024 if the sysctl is not available (Linux < 4.11).
+func UnprivilegedPortStart() int {
+	b, err := ioutil.ReadFile("/proc/sys/net/ipv4/ip_unprivileged_port_start")
+	if err != nil {
+		return 1024
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 1024
+	}
+	return start
+}
@@ -0,0 +1,15 @@
+package portutil
+
+import (
+	"testing"
+)
+
+func TestCheckNoFile(t *testing.T) {
+	if err := CheckNoFile(1); err != nil {
+		t.Fatal(err)
+	}
+	// exceeds fs.nr_open
+	if err := CheckNoFile(1 << 30); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -1,9 +1,14 @@
 package portutil
 
 import (
+	"bufio"
+	"context"
+	"io"
 	"net"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 
@@ -11,11 +16,102 @@ import (
 )
 
 // ParsePortSpec parses a Docker-like representation of PortSpec.
-// e.g. "127.0.0.1:8080:80/tcp"
+// e.g. "127.0.0.1:8080:80/tcp", "127.0.0.1:8080:10.0.2.100:80/tcp", "example.com:8080:80/tcp"
+//
+// A range of ports can be specified as "0.0.0.0:10000-20000:10000/udp" or "0.0.0.0:10000-20000:10000-20000/udp".
+//
+// UNIX sockets can be specified for either side of TCP, e.g. "127.0.0.1:2375:unix:///run/docker.sock" (TCP to UNIX socket),
+// "unix:///tmp/foo.sock:80/tcp" (UNIX socket to TCP).
+//
+// vsock ports (for the vsock driver) can be specified as "vsock://any:8080:80/tcp" or "vsock://3:8080:10.0.2.100:80/tcp",
+// where "any" or "3" is the CID to listen on.
+//
+// Abstract UNIX sockets in the child (for the builtin driver) can be specified as "@NAME" or "@NAME=TARGET",
+// where TARGET is the socket on the parent to connect to, either "@NAME" or an absolute path. e.g. "@/tmp/.X11-unix/X0=/tmp/.X11-unix/X0".
+//
+// The same port number can be used for both sides with the shorthand "8080", "8080/udp", "127.0.0.1:8080", or "8000-8010/tcp".
+// The default ParentIP is "0.0.0.0", and the default Proto is "tcp".
 func ParsePortSpec(s string) (*port.Spec, error) {
-	r := regexp.MustCompile("^([0-9a-f\\.]+):([0-9]+):([0-9]+)/([a-z]+)$")
+	if strings.HasPrefix(s, "@") {
+		spec := &port.Spec{
+			Proto:               "tcp",
+			ChildAbstractSocket: strings.TrimPrefix(s, "@"),
+		}
+		if i := strings.Index(s, "="); i >= 0 {
+			spec.ChildAbstractSocket, spec.ParentTargetSocket = s[1:i], s[i+1:]
+			if spec.ParentTargetSocket == "" {
+				return nil, errors.Errorf("unexpected PortSpec string: %q", s)
+			}
+		}
+		return spec, nil
+	}
+	if g := regexp.MustCompile("^(([0-9A-Za-z\\.\\-]+):)?([0-9]+(-[0-9]+)?)(/([a-z]+))?$").FindStringSubmatch(s); len(g) == 7 {
+		parentIP := g[2]
+		proto := g[6]
+		if proto == "" {
+			proto = "tcp"
+		}
+		if regexp.MustCompile("^[0-9]+$").MatchString(parentIP) {
+			// Docker-style "8080:80" is not a hostname
+			return nil, errors.Errorf("invalid port spec %q (did you mean %q?)", s, "0.0.0.0:"+parentIP+":"+g[3]+"/"+proto)
+		}
+		if parentIP == "" {
+			parentIP = "0.0.0.0"
+		}
+		return ParsePortSpec(parentIP + ":" + g[3] + ":" + g[3] + "/" + proto)
+	}
+	if g := regexp.MustCompile("^vsock://(any|[0-9]+):([0-9]+):(([0-9\\.]+):)?([0-9]+)/([a-z]+)$").FindStringSubmatch(s); len(g) == 7 {
+		var cid uint64
+		if g[1] != "any" {
+			var err error
+			cid, err = strconv.ParseUint(g[1], 10, 32)
+			if err != nil || cid == 0 {
+				return nil, errors.Errorf("unexpected CID in PortSpec string: %q", s)
+			}
+		}
+		parentPort, err := strconv.Atoi(g[2])
+		if err != nil {
+			return nil, errors.Wrapf(err, "unexpected ParentPort in PortSpec string: %q", s)
+		}
+		childPort, err := strconv.Atoi(g[5])
+		if err != nil {
+			return nil, errors.Wrapf(err, "unexpected ChildPort in PortSpec string: %q", s)
+		}
+		return &port.Spec{
+			Proto:      g[6],
+			ParentPort: parentPort,
+			ChildIP:    g[4],
+			ChildPort:  childPort,
+			VsockCID:   uint32(cid),
+		}, nil
+	}
+	if g := regexp.MustCompile("^([0-9A-Za-z\\.\\-]+):([0-9]+):unix://(/.+)$").FindStringSubmatch(s); len(g) == 4 {
+		parentPort, err := strconv.Atoi(g[2])
+		if err != nil {
+			return nil, errors.Wrapf(err, "unexpected ParentPort in PortSpec string: %q", s)
+		}
+		return &port.Spec{
+			Proto:       "tcp",
+			ParentIP:    g[1],
+			ParentPort:  parentPort,
+			ChildSocket: g[3],
+		}, nil
+	}
+	if g := regexp.MustCompile("^unix://(/[^:]+):(([0-9\\.]+):)?([0-9]+)/([a-z]+)$").FindStringSubmatch(s); len(g) == 6 {
+		childPort, err := strconv.Atoi(g[4])
+		if err != nil {
+			return nil, errors.Wrapf(err, "unexpected ChildPort in PortSpec string: %q", s)
+		}
+		return &port.Spec{
+			Proto:        g[5],
+			ParentSocket: g[1],
+			ChildIP:      g[3],
+			ChildPort:    childPort,
+		}, nil
+	}
+	r := regexp.MustCompile("^([0-9A-Za-z\\.\\-]+):([0-9]+)(-([0-9]+))?:(([0-9\\.]+):)?([0-9]+)(-([0-9]+))?/([a-z]+)$")
 	g := r.FindStringSubmatch(s)
-	if len(g) != 5 {
+	if len(g) != 11 {
 		return nil, errors.Errorf("unexpected PortSpec string: %q", s)
 	}
 	parentIP := g[1]
@@ -23,45 +119,325 @@ func ParsePortSpec(s string) (*port.Spec, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "unexpected ParentPort in PortSpec string: %q", s)
 	}
-	childPort, err := strconv.Atoi(g[3])
+	childIP := g[6]
+	childPort, err := strconv.Atoi(g[7])
 	if err != nil {
 		return nil, errors.Wrapf(err, "unexpected ChildPort in PortSpec string: %q", s)
 	}
-	proto := g[4]
+	proto := g[10]
+	portCount := 0
+	if g[4] != "" {
+		parentPortEnd, err := strconv.Atoi(g[4])
+		if err != nil {
+			return nil, errors.Wrapf(err, "unexpected ParentPort range in PortSpec string: %q", s)
+		}
+		if parentPortEnd < parentPort {
+			return nil, errors.Errorf("invalid ParentPort range in PortSpec string: %q", s)
+		}
+		portCount = parentPortEnd - parentPort + 1
+	}
+	if g[9] != "" {
+		childPortEnd, err := strconv.Atoi(g[9])
+		if err != nil {
+			return nil, errors.Wrapf(err, "unexpected ChildPort range in PortSpec string: %q", s)
+		}
+		if portCount == 0 || childPortEnd-childPort+1 != portCount {
+			return nil, errors.Errorf("ChildPort range needs to be as large as ParentPort range in PortSpec string: %q", s)
+		}
+	}
+	if portCount == 1 {
+		portCount = 0
+	}
 	// validation is up to the caller (as json.Unmarshal doesn't validate values)
 	return &port.Spec{
 		Proto:      proto,
 		ParentIP:   parentIP,
 		ParentPort: parentPort,
 		ChildPort:  childPort,
+		ChildIP:    childIP,
+		PortCount:  portCount,
 	}, nil
 }
 
+// ParsePortSpecs parses PortSpec strings from r, one per line.
+// Empty lines and lines starting with "#" are ignored.
+func ParsePortSpecs(r io.Reader) ([]port.Spec, error) {
+	var specs []port.Spec
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		spec, err := ParsePortSpec(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNum)
+		}
+		specs = append(specs, *spec)
+	}
+	return specs, scanner.Err()
+}
+
+// DiffPortSpecs returns the specs that are in desired but not in current (added),
+// and the specs that are in current but not in desired (removed).
+func DiffPortSpecs(current, desired []port.Spec) (added, removed []port.Spec) {
+	currentSet := make(map[port.Spec]struct{}, len(current))
+	for _, sp := range current {
+		currentSet[sp] = struct{}{}
+	}
+	desiredSet := make(map[port.Spec]struct{}, len(desired))
+	for _, sp := range desired {
+		desiredSet[sp] = struct{}{}
+		if _, ok := currentSet[sp]; !ok {
+			added = append(added, sp)
+		}
+	}
+	for _, sp := range current {
+		if _, ok := desiredSet[sp]; !ok {
+			removed = append(removed, sp)
+		}
+	}
+	return added, removed
+}
+
+// ResolveParentIP returns a copy of spec with the hostname in spec.ParentIP resolved to an IP address.
+// IPv4 addresses are preferred over IPv6 addresses.
+// spec is returned as-is when spec.ParentIP is empty or already an IP address.
+//
+// The address is resolved only once, and does not follow the DNS changes.
+func ResolveParentIP(ctx context.Context, spec port.Spec) (port.Spec, error) {
+	if spec.ParentIP == "" || net.ParseIP(spec.ParentIP) != nil {
+		return spec, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, spec.ParentIP)
+	if err != nil {
+		return spec, errors.Wrapf(err, "failed to resolve ParentIP hostname %q", spec.ParentIP)
+	}
+	if len(addrs) == 0 {
+		return spec, errors.Errorf("failed to resolve ParentIP hostname %q: no address", spec.ParentIP)
+	}
+	resolved := addrs[0].IP
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			resolved = a.IP
+			break
+		}
+	}
+	spec.ParentIP = resolved.String()
+	return spec, nil
+}
+
 // ValidatePortSpec validates *port.Spec.
 // existingPorts can be optionally passed for detecting conflicts.
 func ValidatePortSpec(spec port.Spec, existingPorts map[int]*port.Status) error {
 	if spec.Proto != "tcp" && spec.Proto != "udp" {
 		return errors.Errorf("unknown proto: %q", spec.Proto)
 	}
-	if spec.ParentIP != "" {
-		if net.ParseIP(spec.ParentIP) == nil {
-			return errors.Errorf("invalid ParentIP: %q", spec.ParentIP)
+	if spec.ChildAbstractSocket != "" {
+		return validateAbstractSocketSpec(spec, existingPorts)
+	}
+	if spec.ParentTargetSocket != "" {
+		return errors.New("ParentTargetSocket requires ChildAbstractSocket")
+	}
+	if (spec.ParentSocket != "" || spec.ChildSocket != "") && spec.Proto != "tcp" {
+		return errors.Errorf("ParentSocket and ChildSocket are supported only for tcp, got %q", spec.Proto)
+	}
+	if spec.ParentSocket != "" {
+		if !filepath.IsAbs(spec.ParentSocket) {
+			return errors.Errorf("ParentSocket must be an absolute path, got %q", spec.ParentSocket)
+		}
+		if spec.ParentIP != "" || spec.ParentPort != 0 {
+			return errors.New("ParentSocket is exclusive with ParentIP and ParentPort")
+		}
+		if spec.ProxyProtocol != "" {
+			return errors.New("ProxyProtocol is not supported for ParentSocket")
+		}
+		if spec.ReusePort {
+			return errors.New("ReusePort is not supported for ParentSocket")
+		}
+		if spec.TCPFastOpen {
+			return errors.New("TCPFastOpen is not supported for ParentSocket")
+		}
+	} else {
+		if spec.ParentIP != "" {
+			if net.ParseIP(spec.ParentIP) == nil {
+				return errors.Errorf("invalid ParentIP: %q", spec.ParentIP)
+			}
+		}
+		if spec.ParentPort <= 0 || spec.ParentPort > 65535 {
+			return errors.Errorf("invalid ParentPort: %q", spec.ParentPort)
+		}
+	}
+	if spec.ChildSocket != "" {
+		if !filepath.IsAbs(spec.ChildSocket) {
+			return errors.Errorf("ChildSocket must be an absolute path, got %q", spec.ChildSocket)
 		}
+		if spec.ChildIP != "" || spec.ChildPort != 0 {
+			return errors.New("ChildSocket is exclusive with ChildIP and ChildPort")
+		}
+	} else {
+		if spec.ChildPort <= 0 || spec.ChildPort > 65535 {
+			return errors.Errorf("invalid ChildPort: %q", spec.ChildPort)
+		}
+		if spec.ChildIP != "" {
+			if ip := net.ParseIP(spec.ChildIP); ip == nil || ip.To4() == nil {
+				return errors.Errorf("invalid ChildIP: %q", spec.ChildIP)
+			}
+		}
+	}
+	switch spec.ProxyProtocol {
+	case "":
+	case "v1", "v2":
+		if spec.Proto != "tcp" {
+			return errors.Errorf("ProxyProtocol is supported only for tcp, got %q", spec.Proto)
+		}
+	default:
+		return errors.Errorf("unknown ProxyProtocol: %q (must be either \"v1\" or \"v2\")", spec.ProxyProtocol)
+	}
+	if spec.TCPKeepAlive && spec.Proto != "tcp" {
+		return errors.Errorf("TCPKeepAlive is supported only for tcp, got %q", spec.Proto)
+	}
+	if spec.TCPFastOpen && spec.Proto != "tcp" {
+		return errors.Errorf("TCPFastOpen is supported only for tcp, got %q", spec.Proto)
 	}
-	if spec.ParentPort <= 0 || spec.ParentPort > 65535 {
-		return errors.Errorf("invalid ParentPort: %q", spec.ParentPort)
+	if spec.TCPKeepAliveInterval < 0 {
+		return errors.Errorf("invalid TCPKeepAliveInterval: %d", spec.TCPKeepAliveInterval)
 	}
-	if spec.ChildPort <= 0 || spec.ChildPort > 65535 {
-		return errors.Errorf("invalid ChildPort: %q", spec.ChildPort)
+	if spec.TCPKeepAliveInterval != 0 && !spec.TCPKeepAlive {
+		return errors.New("TCPKeepAliveInterval requires TCPKeepAlive")
+	}
+	if spec.DialTimeout < 0 {
+		return errors.Errorf("invalid DialTimeout: %d", spec.DialTimeout)
+	}
+	if spec.IdleTimeout < 0 {
+		return errors.Errorf("invalid IdleTimeout: %d", spec.IdleTimeout)
+	}
+	if spec.IdleTimeout != 0 && spec.Proto != "tcp" {
+		return errors.Errorf("IdleTimeout is supported only for tcp, got %q", spec.Proto)
+	}
+	if spec.MaxConnections < 0 {
+		return errors.Errorf("invalid MaxConnections: %d", spec.MaxConnections)
+	}
+	if spec.MaxConnections != 0 && spec.Proto != "tcp" {
+		return errors.Errorf("MaxConnections is supported only for tcp, got %q", spec.Proto)
+	}
+	if spec.PortCount < 0 {
+		return errors.Errorf("invalid PortCount: %d", spec.PortCount)
+	}
+	if spec.PortCount > 1 {
+		if spec.ParentSocket != "" || spec.ChildSocket != "" {
+			return errors.New("PortCount is not supported for ParentSocket and ChildSocket")
+		}
+		if spec.ParentPort+spec.PortCount-1 > 65535 {
+			return errors.Errorf("invalid ParentPort range: %d-%d", spec.ParentPort, spec.ParentPort+spec.PortCount-1)
+		}
+		if spec.ChildPort+spec.PortCount-1 > 65535 {
+			return errors.Errorf("invalid ChildPort range: %d-%d", spec.ChildPort, spec.ChildPort+spec.PortCount-1)
+		}
 	}
 	for id, p := range existingPorts {
 		sp := p.Spec
+		if sp.ChildAbstractSocket != "" {
+			continue
+		}
+		if !vsockCIDsOverlap(sp.VsockCID, spec.VsockCID) {
+			// listening on the different CIDs of the vsock driver, like the different hosts
+			continue
+		}
 		sameProto := sp.Proto == spec.Proto
-		sameParent := sp.ParentIP == spec.ParentIP && sp.ParentPort == spec.ParentPort
-		sameChild := sp.ChildPort == spec.ChildPort
+		sameParent := sp.ParentIP == spec.ParentIP && portRangesOverlap(sp.ParentPort, sp.PortCount, spec.ParentPort, spec.PortCount) && sp.ParentSocket == spec.ParentSocket
+		sameChild := sp.ChildIP == spec.ChildIP && portRangesOverlap(sp.ChildPort, sp.PortCount, spec.ChildPort, spec.PortCount) && sp.ChildSocket == spec.ChildSocket
 		if sameProto && (sameParent || sameChild) {
 			return errors.Errorf("conflict with ID %d", id)
 		}
 	}
 	return nil
 }
+
+// maxAbstractSocketNameLen is the maximum length of the name of an abstract socket, i.e. sizeof(sun_path) without the leading NUL.
+const maxAbstractSocketNameLen = 107
+
+// validateAbstractSocketSpec validates spec with ChildAbstractSocket.
+func validateAbstractSocketSpec(spec port.Spec, existingPorts map[int]*port.Status) error {
+	if spec.Proto != "tcp" {
+		return errors.Errorf("ChildAbstractSocket is supported only for tcp, got %q", spec.Proto)
+	}
+	if err := validateAbstractSocketName(spec.ChildAbstractSocket); err != nil {
+		return errors.Wrap(err, "invalid ChildAbstractSocket")
+	}
+	if target := spec.ParentTargetSocket; strings.HasPrefix(target, "@") {
+		if err := validateAbstractSocketName(strings.TrimPrefix(target, "@")); err != nil {
+			return errors.Wrap(err, "invalid ParentTargetSocket")
+		}
+	} else if target != "" && !filepath.IsAbs(target) {
+		return errors.Errorf("ParentTargetSocket must be either \"@NAME\" or an absolute path, got %q", target)
+	}
+	if spec.ParentIP != "" || spec.ParentPort != 0 || spec.ParentSocket != "" ||
+		spec.ChildIP != "" || spec.ChildPort != 0 || spec.ChildSocket != "" {
+		return errors.New("ChildAbstractSocket is exclusive with ParentIP, ParentPort, ParentSocket, ChildIP, ChildPort, and ChildSocket")
+	}
+	if spec.ProxyProtocol != "" {
+		return errors.New("ProxyProtocol is not supported for ChildAbstractSocket")
+	}
+	if spec.TCPKeepAlive || spec.TCPKeepAliveInterval != 0 {
+		return errors.New("TCPKeepAlive is not supported for ChildAbstractSocket")
+	}
+	if spec.ReusePort {
+		return errors.New("ReusePort is not supported for ChildAbstractSocket")
+	}
+	if spec.PortCount > 1 {
+		return errors.New("PortCount is not supported for ChildAbstractSocket")
+	}
+	if spec.TCPFastOpen {
+		return errors.New("TCPFastOpen is not supported for ChildAbstractSocket")
+	}
+	if spec.VsockCID != 0 {
+		return errors.New("VsockCID is not supported for ChildAbstractSocket")
+	}
+	if spec.DialTimeout < 0 {
+		return errors.Errorf("invalid DialTimeout: %d", spec.DialTimeout)
+	}
+	if spec.IdleTimeout < 0 {
+		return errors.Errorf("invalid IdleTimeout: %d", spec.IdleTimeout)
+	}
+	if spec.MaxConnections < 0 {
+		return errors.Errorf("invalid MaxConnections: %d", spec.MaxConnections)
+	}
+	for id, p := range existingPorts {
+		if p.Spec.ChildAbstractSocket == spec.ChildAbstractSocket {
+			return errors.Errorf("conflict with ID %d", id)
+		}
+	}
+	return nil
+}
+
+func validateAbstractSocketName(name string) error {
+	if name == "" {
+		return errors.New("empty abstract socket name")
+	}
+	if len(name) > maxAbstractSocketNameLen {
+		return errors.Errorf("too long abstract socket name %q (max %d bytes)", name, maxAbstractSocketNameLen)
+	}
+	if strings.ContainsRune(name, 0) {
+		return errors.Errorf("the abstract socket name %q contains NUL", name)
+	}
+	return nil
+}
+
+// vsockCIDsOverlap returns true if the CIDs a and b may accept the same connections.
+// CID 0 is for any CID, and is always 0 for the drivers other than vsock.
+func vsockCIDsOverlap(a, b uint32) bool {
+	return a == b || a == 0 || b == 0
+}
+
+// portRangesOverlap returns true if [a, a+aCount) overlaps with [b, b+bCount).
+// Count 0 is same as 1.
+func portRangesOverlap(a, aCount, b, bCount int) bool {
+	if aCount < 1 {
+		aCount = 1
+	}
+	if bCount < 1 {
+		bCount = 1
+	}
+	return a < b+bCount && b < a+aCount
+}
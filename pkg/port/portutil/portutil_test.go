@@ -1,7 +1,9 @@
 package portutil
 
 import (
+	"context"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/rootless-containers/rootlesskit/pkg/port"
@@ -24,15 +26,180 @@ func TestParsePortSpec(t *testing.T) {
 			},
 		},
 		{
-			s: "bad",
+			s: "127.0.0.1:8080:10.0.2.100:80/tcp",
+			expected: &port.Spec{
+				Proto:      "tcp",
+				ParentIP:   "127.0.0.1",
+				ParentPort: 8080,
+				ChildIP:    "10.0.2.100",
+				ChildPort:  80,
+			},
 		},
 		{
-			s: "127.0.0.1:8080:80/tcp,127.0.0.1:4040:40/tcp",
-			// one entry per one string
+			s: "vsock://any:8080:80/tcp",
+			expected: &port.Spec{
+				Proto:      "tcp",
+				ParentPort: 8080,
+				ChildPort:  80,
+			},
+		},
+		{
+			s: "vsock://3:8080:10.0.2.100:80/tcp",
+			expected: &port.Spec{
+				Proto:      "tcp",
+				ParentPort: 8080,
+				ChildIP:    "10.0.2.100",
+				ChildPort:  80,
+				VsockCID:   3,
+			},
+		},
+		{
+			s: "vsock://0:8080:80/tcp",
+		},
+		{
+			s: "vsock://4294967296:8080:80/tcp",
 		},
 		{
 			s: "8080",
-			// future version may support short formats like this
+			expected: &port.Spec{
+				Proto:      "tcp",
+				ParentIP:   "0.0.0.0",
+				ParentPort: 8080,
+				ChildPort:  8080,
+			},
+		},
+		{
+			s: "8080/udp",
+			expected: &port.Spec{
+				Proto:      "udp",
+				ParentIP:   "0.0.0.0",
+				ParentPort: 8080,
+				ChildPort:  8080,
+			},
+		},
+		{
+			s: "127.0.0.1:8080",
+			expected: &port.Spec{
+				Proto:      "tcp",
+				ParentIP:   "127.0.0.1",
+				ParentPort: 8080,
+				ChildPort:  8080,
+			},
+		},
+		{
+			s: "localhost:8000-8010/udp",
+			expected: &port.Spec{
+				Proto:      "udp",
+				ParentIP:   "localhost",
+				ParentPort: 8000,
+				ChildPort:  8000,
+				PortCount:  11,
+			},
+		},
+		{
+			s: "8080/",
+		},
+		{
+			s: ":8080",
+		},
+		{
+			s: "8080:80",
+			// not ParentIP "8080"
+		},
+		{
+			s: "8080:80/udp",
+		},
+		{
+			s: "localhost:8080:80/tcp",
+			expected: &port.Spec{
+				Proto:      "tcp",
+				ParentIP:   "localhost",
+				ParentPort: 8080,
+				ChildPort:  80,
+			},
+		},
+		{
+			s: "127.0.0.1:2375:unix:///run/docker.sock",
+			expected: &port.Spec{
+				Proto:       "tcp",
+				ParentIP:    "127.0.0.1",
+				ParentPort:  2375,
+				ChildSocket: "/run/docker.sock",
+			},
+		},
+		{
+			s: "unix:///tmp/foo.sock:80/tcp",
+			expected: &port.Spec{
+				Proto:        "tcp",
+				ParentSocket: "/tmp/foo.sock",
+				ChildPort:    80,
+			},
+		},
+		{
+			s: "0.0.0.0:10000-20000:10000/udp",
+			expected: &port.Spec{
+				Proto:      "udp",
+				ParentIP:   "0.0.0.0",
+				ParentPort: 10000,
+				ChildPort:  10000,
+				PortCount:  10001,
+			},
+		},
+		{
+			s: "0.0.0.0:10000-10009:20000-20009/udp",
+			expected: &port.Spec{
+				Proto:      "udp",
+				ParentIP:   "0.0.0.0",
+				ParentPort: 10000,
+				ChildPort:  20000,
+				PortCount:  10,
+			},
+		},
+		{
+			s: "0.0.0.0:8080-8080:80/tcp",
+			expected: &port.Spec{
+				Proto:      "tcp",
+				ParentIP:   "0.0.0.0",
+				ParentPort: 8080,
+				ChildPort:  80,
+			},
+		},
+		{
+			s: "0.0.0.0:10009-10000:10000/udp",
+			// reversed range
+		},
+		{
+			s: "0.0.0.0:10000-10009:20000-20008/udp",
+			// size mismatch
+		},
+		{
+			s: "0.0.0.0:10000:20000-20009/udp",
+			// child range without parent range
+		},
+		{
+			s: "@/tmp/.X11-unix/X0",
+			expected: &port.Spec{
+				Proto:               "tcp",
+				ChildAbstractSocket: "/tmp/.X11-unix/X0",
+			},
+		},
+		{
+			s: "@/tmp/.X11-unix/X0=/tmp/.X11-unix/X0",
+			expected: &port.Spec{
+				Proto:               "tcp",
+				ChildAbstractSocket: "/tmp/.X11-unix/X0",
+				ParentTargetSocket:  "/tmp/.X11-unix/X0",
+			},
+		},
+		{
+			s: "@foo=",
+		},
+		{
+			s: "bad",
+		},
+		{
+			s: "127.0.0.1:8080:80/tcp,127.0.0.1:4040:40/tcp",
+			// one entry per one string
 		},
 	}
 	for _, tc := range testCases {
@@ -50,4 +217,268 @@ func TestParsePortSpec(t *testing.T) {
 			}
 		}
 	}
+	if _, err := ParsePortSpec("8080:80"); err == nil || err.Error() != `invalid port spec "8080:80" (did you mean "0.0.0.0:8080:80/tcp"?)` {
+		t.Fatalf("unexpected error for the Docker-style string: %v", err)
+	}
+}
+
+func TestResolveParentIP(t *testing.T) {
+	testCases := map[string]string{
+		"":          "",
+		"127.0.0.1": "127.0.0.1",
+		"::1":       "::1",
+		"localhost": "127.0.0.1",
+	}
+	for parentIP, expected := range testCases {
+		spec := port.Spec{
+			Proto:      "tcp",
+			ParentIP:   parentIP,
+			ParentPort: 8080,
+			ChildPort:  80,
+		}
+		got, err := ResolveParentIP(context.TODO(), spec)
+		if err != nil {
+			t.Fatalf("got error for %q: %v", parentIP, err)
+		}
+		if got.ParentIP != expected {
+			t.Fatalf("expected %q for %q, got %q", expected, parentIP, got.ParentIP)
+		}
+	}
+}
+
+func TestParsePortSpecs(t *testing.T) {
+	r := strings.NewReader(`# comment
+127.0.0.1:8080:80/tcp
+
+0.0.0.0:5353:53/udp
+`)
+	got, err := ParsePortSpecs(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []port.Spec{
+		{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80},
+		{Proto: "udp", ParentIP: "0.0.0.0", ParentPort: 5353, ChildPort: 53},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, got)
+	}
+	if _, err := ParsePortSpecs(strings.NewReader("bad\n")); err == nil {
+		t.Fatal("error is expected for a bad line")
+	}
+}
+
+func TestDiffPortSpecs(t *testing.T) {
+	a := port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80}
+	b := port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8443, ChildPort: 443}
+	c := port.Spec{Proto: "udp", ParentIP: "0.0.0.0", ParentPort: 5353, ChildPort: 53}
+	added, removed := DiffPortSpecs([]port.Spec{a, b}, []port.Spec{b, c})
+	if !reflect.DeepEqual(added, []port.Spec{c}) {
+		t.Fatalf("unexpected added: %+v", added)
+	}
+	if !reflect.DeepEqual(removed, []port.Spec{a}) {
+		t.Fatalf("unexpected removed: %+v", removed)
+	}
+}
+
+func TestValidatePortSpecSocket(t *testing.T) {
+	testCases := []struct {
+		spec  port.Spec
+		valid bool
+	}{
+		{port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 2375, ChildSocket: "/run/docker.sock"}, true},
+		{port.Spec{Proto: "tcp", ParentSocket: "/tmp/foo.sock", ChildPort: 80}, true},
+		{port.Spec{Proto: "udp", ParentIP: "127.0.0.1", ParentPort: 2375, ChildSocket: "/run/docker.sock"}, false},
+		{port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 2375, ChildSocket: "run/docker.sock"}, false},
+		{port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 2375, ChildPort: 80, ChildSocket: "/run/docker.sock"}, false},
+		{port.Spec{Proto: "tcp", ParentSocket: "/tmp/foo.sock", ParentPort: 8080, ChildPort: 80}, false},
+		{port.Spec{Proto: "tcp", ParentSocket: "/tmp/foo.sock", ChildPort: 80, ProxyProtocol: "v2"}, false},
+	}
+	for _, tc := range testCases {
+		err := ValidatePortSpec(tc.spec, nil)
+		if tc.valid && err != nil {
+			t.Errorf("expected %+v to be valid, got %v", tc.spec, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected %+v to be invalid", tc.spec)
+		}
+	}
+}
+
+func TestValidatePortSpecAbstractSocket(t *testing.T) {
+	testCases := []struct {
+		spec  port.Spec
+		valid bool
+	}{
+		{port.Spec{Proto: "tcp", ChildAbstractSocket: "/tmp/.X11-unix/X0"}, true},
+		{port.Spec{Proto: "tcp", ChildAbstractSocket: "foo", ParentTargetSocket: "@bar"}, true},
+		{port.Spec{Proto: "tcp", ChildAbstractSocket: "/tmp/.X11-unix/X0", ParentTargetSocket: "/tmp/.X11-unix/X0"}, true},
+		{port.Spec{Proto: "tcp", ChildAbstractSocket: "foo", MaxConnections: 10, DialTimeout: 1, IdleTimeout: 1}, true},
+		{port.Spec{Proto: "udp", ChildAbstractSocket: "foo"}, false},
+		{port.Spec{Proto: "tcp", ChildAbstractSocket: strings.Repeat("x", 108)}, false},
+		{port.Spec{Proto: "tcp", ChildAbstractSocket: "foo", ParentTargetSocket: "bar"}, false},
+		{port.Spec{Proto: "tcp", ChildAbstractSocket: "foo", ParentTargetSocket: "@"}, false},
+		{port.Spec{Proto: "tcp", ChildAbstractSocket: "foo", ChildPort: 80}, false},
+		{port.Spec{Proto: "tcp", ChildAbstractSocket: "foo", ParentSocket: "/tmp/foo.sock"}, false},
+		{port.Spec{Proto: "tcp", ChildAbstractSocket: "foo", ProxyProtocol: "v2"}, false},
+		{port.Spec{Proto: "tcp", ParentTargetSocket: "@bar", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80}, false},
+	}
+	for _, tc := range testCases {
+		err := ValidatePortSpec(tc.spec, nil)
+		if tc.valid && err != nil {
+			t.Errorf("expected %+v to be valid, got %v", tc.spec, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected %+v to be invalid", tc.spec)
+		}
+	}
+
+	existing := map[int]*port.Status{
+		1: {ID: 1, Spec: port.Spec{Proto: "tcp", ChildAbstractSocket: "foo"}},
+		2: {ID: 2, Spec: port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80}},
+	}
+	if err := ValidatePortSpec(port.Spec{Proto: "tcp", ChildAbstractSocket: "foo", ParentTargetSocket: "@bar"}, existing); err == nil {
+		t.Error("expected a conflict for the same ChildAbstractSocket")
+	}
+	if err := ValidatePortSpec(port.Spec{Proto: "tcp", ChildAbstractSocket: "bar"}, existing); err != nil {
+		t.Errorf("expected no conflict, got %v", err)
+	}
+	if err := ValidatePortSpec(port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8081, ChildPort: 81}, existing); err != nil {
+		t.Errorf("expected no conflict, got %v", err)
+	}
+}
+
+func TestValidatePortSpecMaxConnections(t *testing.T) {
+	testCases := []struct {
+		spec  port.Spec
+		valid bool
+	}{
+		{port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80, MaxConnections: 100}, true},
+		{port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80, MaxConnections: -1}, false},
+		{port.Spec{Proto: "udp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80, MaxConnections: 100}, false},
+	}
+	for _, tc := range testCases {
+		err := ValidatePortSpec(tc.spec, nil)
+		if tc.valid && err != nil {
+			t.Errorf("expected %+v to be valid, got %v", tc.spec, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected %+v to be invalid", tc.spec)
+		}
+	}
+}
+
+func TestValidatePortSpecDialTimeout(t *testing.T) {
+	testCases := []struct {
+		spec  port.Spec
+		valid bool
+	}{
+		{port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80, DialTimeout: 30}, true},
+		{port.Spec{Proto: "udp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80, DialTimeout: 30}, true},
+		{port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80, DialTimeout: -1}, false},
+	}
+	for _, tc := range testCases {
+		err := ValidatePortSpec(tc.spec, nil)
+		if tc.valid && err != nil {
+			t.Errorf("expected %+v to be valid, got %v", tc.spec, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected %+v to be invalid", tc.spec)
+		}
+	}
+}
+
+func TestValidatePortSpecIdleTimeout(t *testing.T) {
+	testCases := []struct {
+		spec  port.Spec
+		valid bool
+	}{
+		{port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80, IdleTimeout: 300}, true},
+		{port.Spec{Proto: "udp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80, IdleTimeout: 300}, false},
+		{port.Spec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80, IdleTimeout: -1}, false},
+	}
+	for _, tc := range testCases {
+		err := ValidatePortSpec(tc.spec, nil)
+		if tc.valid && err != nil {
+			t.Errorf("expected %+v to be valid, got %v", tc.spec, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected %+v to be invalid", tc.spec)
+		}
+	}
+}
+
+func TestValidatePortSpecPortCount(t *testing.T) {
+	testCases := []struct {
+		spec  port.Spec
+		valid bool
+	}{
+		{port.Spec{Proto: "udp", ParentIP: "0.0.0.0", ParentPort: 10000, ChildPort: 10000, PortCount: 10001}, true},
+		{port.Spec{Proto: "tcp", ParentIP: "0.0.0.0", ParentPort: 8080, ChildPort: 80, PortCount: 1}, true},
+		{port.Spec{Proto: "udp", ParentIP: "0.0.0.0", ParentPort: 10000, ChildPort: 10000, PortCount: -1}, false},
+		{port.Spec{Proto: "udp", ParentIP: "0.0.0.0", ParentPort: 65530, ChildPort: 10000, PortCount: 10}, false},
+		{port.Spec{Proto: "udp", ParentIP: "0.0.0.0", ParentPort: 10000, ChildPort: 65530, PortCount: 10}, false},
+		{port.Spec{Proto: "tcp", ParentSocket: "/tmp/foo.sock", ChildPort: 80, PortCount: 10}, false},
+	}
+	for _, tc := range testCases {
+		err := ValidatePortSpec(tc.spec, nil)
+		if tc.valid && err != nil {
+			t.Errorf("expected %+v to be valid, got %v", tc.spec, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected %+v to be invalid", tc.spec)
+		}
+	}
+
+	existing := map[int]*port.Status{
+		1: {ID: 1, Spec: port.Spec{Proto: "udp", ParentIP: "0.0.0.0", ParentPort: 10000, ChildPort: 10000, PortCount: 100}},
+	}
+	conflicts := []port.Spec{
+		{Proto: "udp", ParentIP: "0.0.0.0", ParentPort: 10050, ChildPort: 20000},
+		{Proto: "udp", ParentIP: "0.0.0.0", ParentPort: 9990, ChildPort: 20000, PortCount: 11},
+		{Proto: "udp", ParentIP: "0.0.0.0", ParentPort: 20000, ChildPort: 10099},
+	}
+	for _, sp := range conflicts {
+		if err := ValidatePortSpec(sp, existing); err == nil {
+			t.Errorf("expected %+v to conflict", sp)
+		}
+	}
+	nonConflicts := []port.Spec{
+		{Proto: "udp", ParentIP: "0.0.0.0", ParentPort: 10100, ChildPort: 10100},
+		{Proto: "udp", ParentIP: "0.0.0.0", ParentPort: 9990, ChildPort: 9990, PortCount: 10},
+		{Proto: "tcp", ParentIP: "0.0.0.0", ParentPort: 10050, ChildPort: 10050},
+	}
+	for _, sp := range nonConflicts {
+		if err := ValidatePortSpec(sp, existing); err != nil {
+			t.Errorf("expected %+v not to conflict, got %v", sp, err)
+		}
+	}
+}
+
+func TestValidatePortSpecVsockCID(t *testing.T) {
+	existing := map[int]*port.Status{
+		1: {ID: 1, Spec: port.Spec{Proto: "tcp", ParentPort: 8080, ChildPort: 80, VsockCID: 3}},
+	}
+	conflicts := []port.Spec{
+		// same CID
+		{Proto: "tcp", ParentPort: 8080, ChildPort: 81, VsockCID: 3},
+		{Proto: "tcp", ParentPort: 8081, ChildPort: 80, VsockCID: 3},
+		// any CID
+		{Proto: "tcp", ParentPort: 8080, ChildPort: 81},
+	}
+	for _, sp := range conflicts {
+		if err := ValidatePortSpec(sp, existing); err == nil {
+			t.Errorf("expected %+v to conflict", sp)
+		}
+	}
+	nonConflicts := []port.Spec{
+		// differs only in CID
+		{Proto: "tcp", ParentPort: 8080, ChildPort: 80, VsockCID: 4},
+		{Proto: "tcp", ParentPort: 8081, ChildPort: 81, VsockCID: 3},
+	}
+	for _, sp := range nonConflicts {
+		if err := ValidatePortSpec(sp, existing); err != nil {
+			t.Errorf("expected %+v not to conflict, got %v", sp, err)
+		}
+	}
 }
@@ -0,0 +1,18 @@
+package portutil
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ControlReusePort sets SO_REUSEPORT. Can be used as net.ListenConfig.Control.
+func ControlReusePort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
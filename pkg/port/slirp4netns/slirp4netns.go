@@ -49,19 +49,66 @@ func (d *driver) RunParentDriver(initComplete chan struct{}, quit <-chan struct{
 }
 
 func (d *driver) AddPort(ctx context.Context, spec port.Spec) (*port.Status, error) {
+	// passed through to the "proto" argument of add_hostfwd
+	switch spec.Proto {
+	case "tcp", "udp":
+	default:
+		return nil, errors.Errorf("slirp4netns port driver supports only tcp and udp, got %q", spec.Proto)
+	}
+	if spec.ProxyProtocol != "" {
+		return nil, errors.New("ProxyProtocol is not supported by slirp4netns port driver")
+	}
+	if spec.TCPKeepAlive {
+		return nil, errors.New("TCPKeepAlive is not supported by slirp4netns port driver")
+	}
+	if spec.ParentSocket != "" || spec.ChildSocket != "" {
+		return nil, errors.New("ParentSocket and ChildSocket are not supported by slirp4netns port driver")
+	}
+	if spec.ChildAbstractSocket != "" {
+		return nil, errors.New("ChildAbstractSocket is not supported by slirp4netns port driver")
+	}
+	if spec.MaxConnections != 0 {
+		return nil, errors.New("MaxConnections is not supported by slirp4netns port driver")
+	}
+	if spec.ReusePort {
+		return nil, errors.New("ReusePort is not supported by slirp4netns port driver")
+	}
+	if spec.TCPFastOpen {
+		return nil, errors.New("TCPFastOpen is not supported by slirp4netns port driver")
+	}
+	if spec.DialTimeout != 0 {
+		return nil, errors.New("DialTimeout is not supported by slirp4netns port driver")
+	}
+	if spec.PortCount > 1 {
+		return nil, errors.New("port range is not supported by slirp4netns port driver")
+	}
+	if spec.VsockCID != 0 {
+		return nil, errors.New("VsockCID is not supported by slirp4netns port driver")
+	}
+	if spec.IdleTimeout != 0 {
+		return nil, errors.New("IdleTimeout is not supported by slirp4netns port driver")
+	}
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	err := portutil.ValidatePortSpec(spec, d.ports)
 	if err != nil {
 		return nil, err
 	}
+	hostAddr, err := hostAddr(spec.ParentIP)
+	if err != nil {
+		return nil, err
+	}
+	guestAddr := d.childIP
+	if spec.ChildIP != "" {
+		guestAddr = spec.ChildIP
+	}
 	req := request{
 		Execute: "add_hostfwd",
 		Arguments: addHostFwdArguments{
 			Proto:     spec.Proto,
-			HostAddr:  spec.ParentIP,
+			HostAddr:  hostAddr,
 			HostPort:  spec.ParentPort,
-			GuestAddr: d.childIP,
+			GuestAddr: guestAddr,
 			GuestPort: spec.ChildPort,
 		},
 	}
@@ -119,6 +166,23 @@ func (d *driver) RemovePort(ctx context.Context, id int) error {
 	return nil
 }
 
+// hostAddr returns the host_addr for add_hostfwd.
+// slirp4netns only supports IPv4 for host_addr. An empty parentIP binds all the addresses ("0.0.0.0").
+func hostAddr(parentIP string) (string, error) {
+	if parentIP == "" {
+		return "0.0.0.0", nil
+	}
+	ip := net.ParseIP(parentIP)
+	if ip == nil {
+		return "", errors.Errorf("invalid ParentIP: %q", parentIP)
+	}
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return "", errors.Errorf("unsupported ParentIP (v6?): %s", parentIP)
+	}
+	return ip4.String(), nil
+}
+
 type addHostFwdArguments struct {
 	Proto     string `json:"proto"`
 	HostAddr  string `json:"host_addr"`
@@ -137,8 +201,8 @@ type request struct {
 }
 
 type reply struct {
-	Return map[string]interface{} `json:"return",omitempty`
-	Error  map[string]interface{} `json:"error",omitempty`
+	Return map[string]interface{} `json:"return,omitempty"`
+	Error  map[string]interface{} `json:"error,omitempty"`
 }
 
 func callAPI(apiSocketPath string, req request) (*reply, error) {
@@ -0,0 +1,163 @@
+package slirp4netns
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+// serveMockAPI serves the slirp4netns API on sock, and sends the raw requests to ch.
+func serveMockAPI(t *testing.T, sock string, ch chan<- json.RawMessage) net.Listener {
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for id := 1; ; id++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req json.RawMessage
+			if err := json.NewDecoder(conn).Decode(&req); err != nil {
+				conn.Close()
+				continue
+			}
+			ch <- req
+			json.NewEncoder(conn).Encode(reply{Return: map[string]interface{}{"id": id}})
+			conn.Close()
+		}
+	}()
+	return ln
+}
+
+func decodeAddHostFwd(t *testing.T, raw json.RawMessage) addHostFwdArguments {
+	var req struct {
+		Execute   string              `json:"execute"`
+		Arguments addHostFwdArguments `json:"arguments"`
+	}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Execute != "add_hostfwd" {
+		t.Fatalf("expected add_hostfwd, got %q", req.Execute)
+	}
+	return req.Arguments
+}
+
+func TestAddPortHostAddr(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-slirp4netns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	sock := filepath.Join(tmp, "api.sock")
+	ch := make(chan json.RawMessage, 1)
+	ln := serveMockAPI(t, sock, ch)
+	defer ln.Close()
+	d, err := NewParentDriver(ioutil.Discard, sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		parentIP string
+		expected string // empty for an error
+	}{
+		{"", "0.0.0.0"},
+		{"127.0.0.1", "127.0.0.1"},
+		{"::ffff:127.0.0.2", "127.0.0.2"},
+		{"::1", ""},
+		{"example.com", ""},
+	}
+	for i, tc := range testCases {
+		spec := port.Spec{
+			Proto:      "tcp",
+			ParentIP:   tc.parentIP,
+			ParentPort: 8080 + i,
+			ChildPort:  80 + i,
+		}
+		st, err := d.AddPort(context.TODO(), spec)
+		if tc.expected == "" {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.parentIP)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.parentIP, err)
+			continue
+		}
+		args := decodeAddHostFwd(t, <-ch)
+		if args.HostAddr != tc.expected {
+			t.Errorf("%q: expected host_addr %q, got %q", tc.parentIP, tc.expected, args.HostAddr)
+		}
+		if args.HostPort != spec.ParentPort || args.GuestPort != spec.ChildPort {
+			t.Errorf("%q: unexpected arguments %+v", tc.parentIP, args)
+		}
+		if st.Spec.ParentIP != tc.parentIP {
+			t.Errorf("%q: expected the spec to be kept, got %+v", tc.parentIP, st.Spec)
+		}
+	}
+}
+
+func TestAddPortProto(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test-slirp4netns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	sock := filepath.Join(tmp, "api.sock")
+	ch := make(chan json.RawMessage, 1)
+	ln := serveMockAPI(t, sock, ch)
+	defer ln.Close()
+	d, err := NewParentDriver(ioutil.Discard, sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, proto := range []string{"tcp", "udp"} {
+		spec := port.Spec{
+			Proto:      proto,
+			ParentIP:   "127.0.0.1",
+			ParentPort: 8080 + i,
+			ChildIP:    "10.0.2.100",
+			ChildPort:  80 + i,
+		}
+		if _, err := d.AddPort(context.TODO(), spec); err != nil {
+			t.Fatalf("%s: %v", proto, err)
+		}
+		var req map[string]interface{}
+		if err := json.Unmarshal(<-ch, &req); err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]interface{}{
+			"execute": "add_hostfwd",
+			"arguments": map[string]interface{}{
+				"proto":      proto,
+				"host_addr":  "127.0.0.1",
+				"host_port":  float64(8080 + i),
+				"guest_addr": "10.0.2.100",
+				"guest_port": float64(80 + i),
+			},
+		}
+		if !reflect.DeepEqual(req, expected) {
+			t.Errorf("%s: expected %+v, got %+v", proto, expected, req)
+		}
+	}
+	for _, proto := range []string{"sctp", ""} {
+		spec := port.Spec{
+			Proto:      proto,
+			ParentPort: 9090,
+			ChildPort:  90,
+		}
+		if _, err := d.AddPort(context.TODO(), spec); err == nil {
+			t.Errorf("%q: expected an error", proto)
+		}
+	}
+}
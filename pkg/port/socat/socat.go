@@ -7,39 +7,73 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/rootless-containers/rootlesskit/pkg/common"
 	"github.com/rootless-containers/rootlesskit/pkg/port"
 	"github.com/rootless-containers/rootlesskit/pkg/port/portutil"
 )
 
-func NewParentDriver(logWriter io.Writer) (port.ParentDriver, error) {
-	if _, err := exec.LookPath("socat"); err != nil {
-		return nil, err
+// The ranges of the buffer sizes of NewParentDriver.
+const (
+	// DefaultBlockSize is the default block size of socat ("-b"), i.e. the size of the relay buffer.
+	DefaultBlockSize = 8192
+	MinBlockSize     = 512
+	MaxBlockSize     = 1024 * 1024
+	// DefaultSockBufSize is the default SO_RCVBUF and SO_SNDBUF ("rcvbuf" and "sndbuf" socat options)
+	// of the parent-side sockets.
+	DefaultSockBufSize = 65536
+	MinSockBufSize     = 4096
+	MaxSockBufSize     = 64 * 1024 * 1024
+)
+
+// NewParentDriver instantiates the parent driver.
+// blockSize is the block size of socat ("-b"), in [MinBlockSize..MaxBlockSize]. 0 for DefaultBlockSize.
+// sockBufSize is SO_RCVBUF and SO_SNDBUF of the sockets, in [MinSockBufSize..MaxSockBufSize]. 0 for DefaultSockBufSize.
+// sockBufSize is capped by the net.core.rmem_max and net.core.wmem_max sysctls of the kernel.
+func NewParentDriver(logWriter io.Writer, blockSize, sockBufSize int) (port.ParentDriver, error) {
+	if blockSize == 0 {
+		blockSize = DefaultBlockSize
 	}
-	if _, err := exec.LookPath("nsenter"); err != nil {
-		return nil, err
+	if blockSize < MinBlockSize || blockSize > MaxBlockSize {
+		return nil, errors.Errorf("invalid socat block size %d, expected [%d..%d]", blockSize, MinBlockSize, MaxBlockSize)
+	}
+	if sockBufSize == 0 {
+		sockBufSize = DefaultSockBufSize
+	}
+	if sockBufSize < MinSockBufSize || sockBufSize > MaxSockBufSize {
+		return nil, errors.Errorf("invalid socat socket buffer size %d, expected [%d..%d]", sockBufSize, MinSockBufSize, MaxSockBufSize)
+	}
+	for _, helper := range []string{"socat", "nsenter"} {
+		if _, err := exec.LookPath(helper); err != nil {
+			return nil, &common.HelperNotFoundError{Helper: helper, Err: err}
+		}
 	}
 	d := driver{
-		logWriter: logWriter,
-		ports:     make(map[int]*port.Status, 0),
-		stoppers:  make(map[int]func() error, 0),
-		nextID:    1,
+		logWriter:   logWriter,
+		blockSize:   blockSize,
+		sockBufSize: sockBufSize,
+		ports:       make(map[int]*port.Status, 0),
+		stoppers:    make(map[int]func() error, 0),
+		nextID:      1,
 	}
 	return &d, nil
 }
 
 type driver struct {
-	logWriter io.Writer
-	mu        sync.Mutex
-	childPID  int
-	ports     map[int]*port.Status
-	stoppers  map[int]func() error
-	nextID    int
+	logWriter   io.Writer
+	blockSize   int
+	sockBufSize int
+	mu          sync.Mutex
+	childPID    int
+	ports       map[int]*port.Status
+	stoppers    map[int]func() error
+	nextID      int
 }
 
 func (d *driver) OpaqueForChild() map[string]string {
@@ -58,6 +92,39 @@ func (d *driver) RunParentDriver(initComplete chan struct{}, quit <-chan struct{
 }
 
 func (d *driver) AddPort(ctx context.Context, spec port.Spec) (*port.Status, error) {
+	if spec.ProxyProtocol != "" {
+		return nil, errors.New("ProxyProtocol is not supported by socat port driver")
+	}
+	if spec.TCPKeepAlive {
+		return nil, errors.New("TCPKeepAlive is not supported by socat port driver")
+	}
+	if spec.ParentSocket != "" || spec.ChildSocket != "" {
+		return nil, errors.New("ParentSocket and ChildSocket are not supported by socat port driver")
+	}
+	if spec.ChildAbstractSocket != "" {
+		return nil, errors.New("ChildAbstractSocket is not supported by socat port driver")
+	}
+	if spec.MaxConnections != 0 {
+		return nil, errors.New("MaxConnections is not supported by socat port driver")
+	}
+	if spec.ReusePort {
+		return nil, errors.New("ReusePort is not supported by socat port driver")
+	}
+	if spec.TCPFastOpen {
+		return nil, errors.New("TCPFastOpen is not supported by socat port driver")
+	}
+	if spec.DialTimeout != 0 {
+		return nil, errors.New("DialTimeout is not supported by socat port driver")
+	}
+	if spec.VsockCID != 0 {
+		return nil, errors.New("VsockCID is not supported by socat port driver")
+	}
+	if spec.IdleTimeout != 0 {
+		return nil, errors.New("IdleTimeout is not supported by socat port driver")
+	}
+	if spec.PortCount > 1 {
+		return nil, errors.New("port range is not supported by socat port driver")
+	}
 	if d.childPID <= 0 {
 		return nil, errors.New("child PID not set")
 	}
@@ -68,7 +135,7 @@ func (d *driver) AddPort(ctx context.Context, spec port.Spec) (*port.Status, err
 		return nil, err
 	}
 	cf := func() (*exec.Cmd, error) {
-		return createSocatCmd(ctx, spec, d.logWriter, d.childPID)
+		return createSocatCmd(ctx, spec, d.logWriter, d.childPID, d.blockSize, d.sockBufSize)
 	}
 	routineErrorCh := make(chan error)
 	routineStopCh := make(chan struct{})
@@ -113,7 +180,9 @@ func (d *driver) RemovePort(ctx context.Context, id int) error {
 	return err
 }
 
-func createSocatCmd(ctx context.Context, spec port.Spec, logWriter io.Writer, childPID int) (*exec.Cmd, error) {
+// createSocatCmd creates the socat command for spec.
+// blockSize and sockBufSize are applied to both the parent-side socat and the child-side socat.
+func createSocatCmd(ctx context.Context, spec port.Spec, logWriter io.Writer, childPID, blockSize, sockBufSize int) (*exec.Cmd, error) {
 	if spec.Proto != "tcp" && spec.Proto != "udp" {
 		return nil, errors.Errorf("unsupported proto: %s", spec.Proto)
 	}
@@ -135,27 +204,32 @@ func createSocatCmd(ctx context.Context, spec port.Spec, logWriter io.Writer, ch
 	if spec.ChildPort < 1 || spec.ChildPort > 65535 {
 		return nil, errors.Errorf("unsupported childPort: %d", spec.ChildPort)
 	}
-	var cmd *exec.Cmd
-	switch spec.Proto {
-	case "tcp":
-		cmd = exec.CommandContext(ctx,
-			"socat",
-			fmt.Sprintf("TCP-LISTEN:%d,bind=%s,reuseaddr,fork,rcvbuf=65536,sndbuf=65536", spec.ParentPort, ipStr),
-			fmt.Sprintf("EXEC:\"%s\",nofork",
-				fmt.Sprintf("nsenter -U -n --preserve-credentials -t %d socat STDIN TCP4:127.0.0.1:%d", childPID, spec.ChildPort)))
-	case "udp":
-		cmd = exec.CommandContext(ctx,
-			"socat",
-			fmt.Sprintf("UDP-LISTEN:%d,bind=%s,reuseaddr,fork,rcvbuf=65536,sndbuf=65536", spec.ParentPort, ipStr),
-			fmt.Sprintf("EXEC:\"%s\",nofork",
-				fmt.Sprintf("nsenter -U -n --preserve-credentials -t %d socat STDIN UDP4:127.0.0.1:%d", childPID, spec.ChildPort)))
+	childIPStr := "127.0.0.1"
+	if spec.ChildIP != "" {
+		ip := net.ParseIP(spec.ChildIP).To4()
+		if ip == nil {
+			return nil, errors.Errorf("unsupported childIP (v6?): %s", spec.ChildIP)
+		}
+		childIPStr = ip.String()
+	}
+	listenAddr, connectAddr := "TCP-LISTEN", "TCP4"
+	if spec.Proto == "udp" {
+		listenAddr, connectAddr = "UDP-LISTEN", "UDP4"
 	}
+	bufOpts := fmt.Sprintf("rcvbuf=%d,sndbuf=%d", sockBufSize, sockBufSize)
+	cmd := exec.CommandContext(ctx,
+		"socat", "-b", strconv.Itoa(blockSize),
+		fmt.Sprintf("%s:%d,bind=%s,reuseaddr,fork,%s", listenAddr, spec.ParentPort, ipStr, bufOpts),
+		fmt.Sprintf("EXEC:\"%s\",nofork",
+			fmt.Sprintf("nsenter -U -n --preserve-credentials -t %d socat -b %d STDIN %s:%s:%d,%s",
+				childPID, blockSize, connectAddr, childIPStr, spec.ChildPort, bufOpts)))
 	cmd.Env = os.Environ()
 	cmd.Stdout = logWriter
 	cmd.Stderr = logWriter
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Pdeathsig: syscall.SIGKILL,
 	}
+	common.LogHelperCmd(cmd)
 	return cmd, nil
 }
 
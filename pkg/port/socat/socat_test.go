@@ -1,6 +1,9 @@
 package socat
 
 import (
+	"context"
+	"io/ioutil"
+	"reflect"
 	"testing"
 
 	"github.com/rootless-containers/rootlesskit/pkg/port"
@@ -16,7 +19,7 @@ func TestMain(m *testing.M) {
 
 func TestSocat(t *testing.T) {
 	pf := func() port.ParentDriver {
-		d, err := NewParentDriver(testsuite.TLogWriter(t, "socat.Driver"))
+		d, err := NewParentDriver(testsuite.TLogWriter(t, "socat.Driver"), 0, 0)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -24,3 +27,43 @@ func TestSocat(t *testing.T) {
 	}
 	testsuite.Run(t, pf)
 }
+
+func TestNewParentDriverInvalidBufSize(t *testing.T) {
+	testCases := []struct {
+		blockSize   int
+		sockBufSize int
+	}{
+		{-1, 0},
+		{MinBlockSize - 1, 0},
+		{MaxBlockSize + 1, 0},
+		{0, -1},
+		{0, MinSockBufSize - 1},
+		{0, MaxSockBufSize + 1},
+	}
+	for _, tc := range testCases {
+		if _, err := NewParentDriver(ioutil.Discard, tc.blockSize, tc.sockBufSize); err == nil {
+			t.Errorf("expected an error for blockSize=%d, sockBufSize=%d", tc.blockSize, tc.sockBufSize)
+		}
+	}
+}
+
+func TestCreateSocatCmd(t *testing.T) {
+	spec := port.Spec{
+		Proto:      "tcp",
+		ParentIP:   "127.0.0.1",
+		ParentPort: 8080,
+		ChildPort:  80,
+	}
+	cmd, err := createSocatCmd(context.TODO(), spec, ioutil.Discard, 42, 131072, 1048576)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"socat", "-b", "131072",
+		"TCP-LISTEN:8080,bind=127.0.0.1,reuseaddr,fork,rcvbuf=1048576,sndbuf=1048576",
+		`EXEC:"nsenter -U -n --preserve-credentials -t 42 socat -b 131072 STDIN TCP4:127.0.0.1:80,rcvbuf=1048576,sndbuf=1048576",nofork`,
+	}
+	if !reflect.DeepEqual(cmd.Args, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmd.Args)
+	}
+}
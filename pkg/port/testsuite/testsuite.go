@@ -75,7 +75,18 @@ func RunUDP(t *testing.T, pf func() port.ParentDriver) {
 	t.Run("TestUDP", func(t *testing.T) { TestProto(t, "udp", pf()) })
 }
 
+// RunTCPChildIP tests forwarding to a non-loopback child IP, which is assigned to a dummy interface.
+func RunTCPChildIP(t *testing.T, pf func() port.ParentDriver) {
+	t.Run("TestTCPChildIP", func(t *testing.T) { TestProtoWithChildIP(t, "tcp", pf(), "10.0.99.1") })
+}
+
 func TestProto(t *testing.T, proto string, d port.ParentDriver) {
+	TestProtoWithChildIP(t, proto, d, "")
+}
+
+// TestProtoWithChildIP tests the port driver with port.Spec.ChildIP.
+// When childIP is not empty, a dummy interface with childIP/24 is created in the child.
+func TestProtoWithChildIP(t *testing.T, proto string, d port.ParentDriver, childIP string) {
 	ensureDeps(t, "nsenter")
 	t.Logf("creating USER+NET namespace")
 	opaque := d.OpaqueForChild()
@@ -124,10 +135,22 @@ func TestProto(t *testing.T, proto string, d port.ParentDriver) {
 	if out, err := nsenterExec(childPID, "ip", "link", "set", "lo", "up"); err != nil {
 		t.Fatalf("%v, out=%s", err, string(out))
 	}
-	testProtoWithPID(t, proto, d, childPID)
+	if childIP != "" {
+		cmdss := [][]string{
+			{"ip", "link", "add", "dummy0", "type", "dummy"},
+			{"ip", "addr", "add", childIP + "/24", "dev", "dummy0"},
+			{"ip", "link", "set", "dummy0", "up"},
+		}
+		for _, cmds := range cmdss {
+			if out, err := nsenterExec(childPID, cmds...); err != nil {
+				t.Fatalf("%v, out=%s", err, string(out))
+			}
+		}
+	}
+	testProtoWithPID(t, proto, d, childPID, childIP)
 }
 
-func testProtoWithPID(t *testing.T, proto string, d port.ParentDriver, childPID int) {
+func testProtoWithPID(t *testing.T, proto string, d port.ParentDriver, childPID int, childIP string) {
 	ensureDeps(t, "nsenter", "ip", "nc")
 	// [child]parent
 	pairs := map[int]int{
@@ -167,7 +190,7 @@ func testProtoWithPID(t *testing.T, proto string, d port.ParentDriver, childPID
 		childP, parentP := c, p
 		wg.Add(1)
 		go func() {
-			testProtoRoutine(t, proto, d, childPID, childP, parentP)
+			testProtoRoutine(t, proto, d, childPID, childIP, childP, parentP)
 			wg.Done()
 		}()
 	}
@@ -189,7 +212,7 @@ func nsenterExec(pid int, cmdss ...string) ([]byte, error) {
 	return cmd.CombinedOutput()
 }
 
-func testProtoRoutine(t *testing.T, proto string, d port.ParentDriver, childPID, childP, parentP int) {
+func testProtoRoutine(t *testing.T, proto string, d port.ParentDriver, childPID int, childIP string, childP, parentP int) {
 	stdoutR, stdoutW := io.Pipe()
 	var ncFlags []string
 	switch proto {
@@ -200,6 +223,9 @@ func testProtoRoutine(t *testing.T, proto string, d port.ParentDriver, childPID,
 	default:
 		panic("invalid proto")
 	}
+	if childIP != "" {
+		ncFlags = append(ncFlags, "-s", childIP)
+	}
 	cmd := exec.Command("nsenter", append(
 		[]string{"-U", "--preserve-credential", "-n", "-t", strconv.Itoa(childPID),
 			"nc"}, append(ncFlags, []string{"-l", strconv.Itoa(childP)}...)...)...)
@@ -218,6 +244,7 @@ func testProtoRoutine(t *testing.T, proto string, d port.ParentDriver, childPID,
 			Proto:      proto,
 			ParentIP:   "127.0.0.1",
 			ParentPort: parentP,
+			ChildIP:    childIP,
 			ChildPort:  childP,
 		})
 	if err != nil {
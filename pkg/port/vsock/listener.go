@@ -0,0 +1,141 @@
+package vsock
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Addr is an AF_VSOCK address.
+type Addr struct {
+	CID  uint32
+	Port uint32
+}
+
+func (a *Addr) Network() string {
+	return "vsock"
+}
+
+func (a *Addr) String() string {
+	return fmt.Sprintf("vsock:%d:%d", a.CID, a.Port)
+}
+
+// Available returns nil if AF_VSOCK sockets can be created, e.g. the vsock transport of the VM is loaded.
+func Available() error {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return errors.Wrap(err, "AF_VSOCK is not available")
+	}
+	unix.Close(fd)
+	return nil
+}
+
+// listener implements net.Listener for AF_VSOCK, as the net package does not support AF_VSOCK.
+// The non-blocking socket is registered to the runtime poller via os.File, so that Close interrupts Accept.
+type listener struct {
+	f    *os.File
+	rc   syscall.RawConn
+	addr *Addr
+}
+
+// listen listens on the CID and the port. Use unix.VMADDR_CID_ANY for listening on any CID.
+func listen(cid, port uint32) (net.Listener, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create AF_VSOCK socket")
+	}
+	addr := &Addr{CID: cid, Port: port}
+	if err := unix.Bind(fd, &unix.SockaddrVM{CID: cid, Port: port}); err != nil {
+		unix.Close(fd)
+		return nil, errors.Wrapf(err, "failed to bind %s", addr)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		unix.Close(fd)
+		return nil, errors.Wrapf(err, "failed to listen on %s", addr)
+	}
+	f := os.NewFile(uintptr(fd), addr.String())
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &listener{f: f, rc: rc, addr: addr}, nil
+}
+
+// Accept blocks until a connection arrives, or the listener is closed.
+func (l *listener) Accept() (net.Conn, error) {
+	var (
+		nfd   int
+		sa    unix.Sockaddr
+		opErr error
+	)
+	err := l.rc.Read(func(fd uintptr) bool {
+		nfd, sa, opErr = unix.Accept4(int(fd), unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK)
+		// false for waiting for the readiness
+		return opErr != unix.EAGAIN && opErr != unix.EINTR
+	})
+	if err == nil {
+		err = opErr
+	}
+	if err != nil {
+		return nil, &net.OpError{Op: "accept", Net: "vsock", Addr: l.addr, Err: err}
+	}
+	remote := &Addr{}
+	if vm, ok := sa.(*unix.SockaddrVM); ok {
+		remote.CID, remote.Port = vm.CID, vm.Port
+	}
+	f := os.NewFile(uintptr(nfd), remote.String())
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &conn{File: f, rc: rc, local: l.addr, remote: remote}, nil
+}
+
+func (l *listener) Close() error {
+	return l.f.Close()
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+// conn implements net.Conn for AF_VSOCK.
+// CloseRead and CloseWrite are implemented for the half-close in the builtin port driver.
+type conn struct {
+	*os.File
+	rc     syscall.RawConn
+	local  *Addr
+	remote *Addr
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *conn) shutdown(how int) error {
+	var opErr error
+	if err := c.rc.Control(func(fd uintptr) {
+		opErr = unix.Shutdown(int(fd), how)
+	}); err != nil {
+		return err
+	}
+	return opErr
+}
+
+func (c *conn) CloseRead() error {
+	return c.shutdown(unix.SHUT_RD)
+}
+
+func (c *conn) CloseWrite() error {
+	return c.shutdown(unix.SHUT_WR)
+}
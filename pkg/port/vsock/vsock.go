@@ -0,0 +1,159 @@
+// Package vsock provides the port driver that listens on AF_VSOCK in the parent, and forwards the connections to the child,
+// e.g. for exposing the ports to the host agent when RootlessKit runs inside a VM.
+//
+// The connections are forwarded to the child via the child driver of the builtin port driver.
+package vsock
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+	builtinchild "github.com/rootless-containers/rootlesskit/pkg/port/builtin/child"
+	builtinparent "github.com/rootless-containers/rootlesskit/pkg/port/builtin/parent"
+	"github.com/rootless-containers/rootlesskit/pkg/port/builtin/parent/tcp"
+	"github.com/rootless-containers/rootlesskit/pkg/port/portutil"
+)
+
+// NewParentDriver instantiates the parent driver. Fails if AF_VSOCK is not available.
+// Spec.ParentPort is used as the vsock port, and Spec.VsockCID is used as the CID to listen on.
+func NewParentDriver(logWriter io.Writer, stateDir string) (port.ParentDriver, error) {
+	if err := Available(); err != nil {
+		return nil, err
+	}
+	// the builtin driver is used for the connection to the child driver.
+	// its ports are never used.
+	builtinDriver, err := builtinparent.NewDriver(logWriter, stateDir, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	d := driver{
+		ParentDriver: builtinDriver,
+		logWriter:    logWriter,
+		socketPath:   builtinparent.SocketPath(stateDir),
+		ports:        make(map[int]*port.Status, 0),
+		counters:     make(map[int]*tcp.ConnCounter, 0),
+		stoppers:     make(map[int]func(), 0),
+		nextID:       1,
+	}
+	return &d, nil
+}
+
+// NewChildDriver instantiates the child driver, i.e. the child driver of the builtin port driver.
+func NewChildDriver(logWriter io.Writer) port.ChildDriver {
+	return builtinchild.NewDriver(logWriter)
+}
+
+// driver uses OpaqueForChild and RunParentDriver of the builtin driver.
+type driver struct {
+	port.ParentDriver
+	logWriter  io.Writer
+	socketPath string
+	mu         sync.Mutex
+	ports      map[int]*port.Status
+	counters   map[int]*tcp.ConnCounter
+	stoppers   map[int]func()
+	nextID     int
+}
+
+// validateSpec validates the fields that are not supported by the vsock driver.
+func validateSpec(spec port.Spec) error {
+	if spec.Proto != "tcp" {
+		return errors.Errorf("vsock port driver supports only tcp, got %q", spec.Proto)
+	}
+	if spec.ParentIP != "" {
+		return errors.New("ParentIP is not supported by vsock port driver (use VsockCID)")
+	}
+	if spec.ParentSocket != "" {
+		return errors.New("ParentSocket is not supported by vsock port driver")
+	}
+	if spec.ChildAbstractSocket != "" {
+		return errors.New("ChildAbstractSocket is not supported by vsock port driver")
+	}
+	if spec.ProxyProtocol != "" {
+		return errors.New("ProxyProtocol is not supported by vsock port driver")
+	}
+	if spec.TCPKeepAlive {
+		return errors.New("TCPKeepAlive is not supported by vsock port driver")
+	}
+	if spec.ReusePort {
+		return errors.New("ReusePort is not supported by vsock port driver")
+	}
+	if spec.TCPFastOpen {
+		return errors.New("TCPFastOpen is not supported by vsock port driver")
+	}
+	if spec.PortCount > 1 {
+		return errors.New("port range is not supported by vsock port driver")
+	}
+	return nil
+}
+
+// listenCID returns the CID to listen on. 0 (the hypervisor CID, which cannot be listened on) is mapped to VMADDR_CID_ANY.
+func listenCID(cid uint32) uint32 {
+	if cid == 0 {
+		return unix.VMADDR_CID_ANY
+	}
+	return cid
+}
+
+func (d *driver) AddPort(ctx context.Context, spec port.Spec) (*port.Status, error) {
+	if err := validateSpec(spec); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	err := portutil.ValidatePortSpec(spec, d.ports)
+	d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	ln, err := listen(listenCID(spec.VsockCID), uint32(spec.ParentPort))
+	if err != nil {
+		return nil, err
+	}
+	stopCh := make(chan struct{})
+	counter := tcp.NewConnCounter(spec.MaxConnections)
+	tcp.Serve(ln, d.socketPath, spec, stopCh, d.logWriter, counter, nil, nil)
+	d.mu.Lock()
+	id := d.nextID
+	st := port.Status{
+		ID:   id,
+		Spec: spec,
+	}
+	d.ports[id] = &st
+	d.counters[id] = counter
+	// Serve closes ln on closing stopCh
+	d.stoppers[id] = func() { close(stopCh) }
+	d.nextID++
+	d.mu.Unlock()
+	return &st, nil
+}
+
+func (d *driver) ListPorts(ctx context.Context) ([]port.Status, error) {
+	var ports []port.Status
+	d.mu.Lock()
+	for id, p := range d.ports {
+		st := *p
+		st.Connections = d.counters[id].Current()
+		ports = append(ports, st)
+	}
+	d.mu.Unlock()
+	return ports, nil
+}
+
+func (d *driver) RemovePort(ctx context.Context, id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stop, ok := d.stoppers[id]
+	if !ok {
+		return errors.Errorf("unknown id: %d", id)
+	}
+	stop()
+	delete(d.stoppers, id)
+	delete(d.ports, id)
+	delete(d.counters, id)
+	return nil
+}
@@ -0,0 +1,132 @@
+package vsock
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/rootless-containers/rootlesskit/pkg/port"
+)
+
+func TestValidateSpec(t *testing.T) {
+	valid := port.Spec{Proto: "tcp", ParentPort: 8080, ChildPort: 80, VsockCID: 3, MaxConnections: 10, DialTimeout: 5}
+	if err := validateSpec(valid); err != nil {
+		t.Fatal(err)
+	}
+	invalid := []port.Spec{
+		{Proto: "udp", ParentPort: 8080, ChildPort: 80},
+		{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 80},
+		{Proto: "tcp", ParentSocket: "/tmp/foo.sock", ChildPort: 80},
+		{Proto: "tcp", ChildAbstractSocket: "foo"},
+		{Proto: "tcp", ParentPort: 8080, ChildPort: 80, ProxyProtocol: "v1"},
+		{Proto: "tcp", ParentPort: 8080, ChildPort: 80, TCPKeepAlive: true},
+		{Proto: "tcp", ParentPort: 8080, ChildPort: 80, ReusePort: true},
+		{Proto: "tcp", ParentPort: 8080, ChildPort: 80, TCPFastOpen: true},
+		{Proto: "tcp", ParentPort: 8080, ChildPort: 80, PortCount: 2},
+	}
+	for _, sp := range invalid {
+		if err := validateSpec(sp); err == nil {
+			t.Errorf("expected an error for %+v", sp)
+		}
+	}
+}
+
+func TestListenCID(t *testing.T) {
+	if got := listenCID(0); got != unix.VMADDR_CID_ANY {
+		t.Errorf("expected VMADDR_CID_ANY, got %d", got)
+	}
+	if got := listenCID(3); got != 3 {
+		t.Errorf("expected 3, got %d", got)
+	}
+}
+
+// dialLocal connects to the port via the vsock loopback (VMADDR_CID_LOCAL).
+func dialLocal(p uint32) (*os.File, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	const vmaddrCIDLocal = 1
+	if err := unix.Connect(fd, &unix.SockaddrVM{CID: vmaddrCIDLocal, Port: p}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "vsock"), nil
+}
+
+func TestListener(t *testing.T) {
+	if err := Available(); err != nil {
+		t.Skip(err)
+	}
+	if _, err := os.Stat("/sys/module/vsock_loopback"); err != nil {
+		t.Skip("vsock_loopback module is not loaded")
+	}
+	const p = 41234
+	ln, err := listen(unix.VMADDR_CID_ANY, p)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	client, err := dialLocal(p)
+	if err != nil {
+		t.Skipf("vsock loopback is not available: %v", err)
+	}
+	defer client.Close()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Shutdown(int(client.Fd()), unix.SHUT_WR); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("expected \"hello\", got %q", string(b))
+	}
+	// the half-close is required by the builtin port driver
+	if err := c.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(client); err != nil || len(b) != 0 {
+		t.Fatalf("expected EOF, got %q, %v", string(b), err)
+	}
+
+}
+
+func TestListenerClose(t *testing.T) {
+	if err := Available(); err != nil {
+		t.Skip(err)
+	}
+	ln, err := listen(unix.VMADDR_CID_ANY, 41235)
+	if err != nil {
+		t.Skip(err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		errCh <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errCh:
+		if _, ok := err.(*net.OpError); !ok {
+			t.Fatalf("expected *net.OpError, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept was not interrupted by Close")
+	}
+}
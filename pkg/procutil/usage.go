@@ -0,0 +1,199 @@
+// Package procutil reads the resource usage of the processes from /proc.
+package procutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rootless-containers/rootlesskit/pkg/api"
+)
+
+// userHZ is the unit of the CPU time in /proc/PID/stat. It is fixed to 100 on Linux, regardless of CONFIG_HZ.
+const userHZ = 100
+
+// HelperUsage reads the resource usage of the helper process from /proc/PID/stat and /proc/PID/status.
+func HelperUsage(pid int) (*api.HelperInfo, error) {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	utime, stime, err := parseProcStatCPUTime(string(stat))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rss, err := parseProcStatusRSS(f)
+	if err != nil {
+		return nil, err
+	}
+	return &api.HelperInfo{
+		PID:              pid,
+		RSS:              rss,
+		UserCPUSeconds:   float64(utime) / userHZ,
+		SystemCPUSeconds: float64(stime) / userHZ,
+	}, nil
+}
+
+// TreeUsage reads the aggregate resource usage of the process and its running descendants from /proc/PID/stat.
+//
+// As the children of a process cannot be listed without CONFIG_PROC_CHILDREN, TreeUsage reads /proc/PID/stat
+// of all the processes visible in /proc. So the cost is proportional to the number of the processes on the host,
+// not only to the number of the processes in the tree.
+//
+// The descendants that are reparented out of the tree (e.g. daemonized without a PID namespace) are not counted.
+//
+// The processes whose /proc/PID/stat cannot be read or parsed (e.g. exiting) are skipped.
+func TreeUsage(pid int) (*api.ProcessTreeUsage, error) {
+	return treeUsage("/proc", pid, uint64(os.Getpagesize()))
+}
+
+func treeUsage(procDir string, pid int, pageSize uint64) (*api.ProcessTreeUsage, error) {
+	d, err := os.Open(procDir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[int]*procStat)
+	children := make(map[int][]int)
+	for _, name := range names {
+		p, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(procDir, name, "stat"))
+		if err != nil {
+			// exited
+			continue
+		}
+		st, err := parseProcStat(string(b))
+		if err != nil {
+			// e.g. truncated while exiting. Unrelated to pid in most cases.
+			logrus.WithError(err).Debugf("skipping process %d", p)
+			continue
+		}
+		stats[p] = st
+		children[st.ppid] = append(children[st.ppid], p)
+	}
+	if _, ok := stats[pid]; !ok {
+		return nil, errors.Errorf("process %d not found", pid)
+	}
+	return sumTreeUsage(pid, stats, children, pageSize), nil
+}
+
+// sumTreeUsage sums the usage of the tree rooted at pid.
+// The CPU time of the exited descendants is counted in cutime and cstime of the processes that have waited for them.
+func sumTreeUsage(pid int, stats map[int]*procStat, children map[int][]int, pageSize uint64) *api.ProcessTreeUsage {
+	var (
+		usage        api.ProcessTreeUsage
+		utime, stime uint64
+	)
+	queue := []int{pid}
+	for len(queue) != 0 {
+		p := queue[0]
+		queue = queue[1:]
+		st := stats[p]
+		usage.Processes++
+		usage.RSS += st.rss * pageSize
+		utime += st.utime + st.cutime
+		stime += st.stime + st.cstime
+		queue = append(queue, children[p]...)
+	}
+	usage.UserCPUSeconds = float64(utime) / userHZ
+	usage.SystemCPUSeconds = float64(stime) / userHZ
+	return &usage
+}
+
+// procStat is the subset of the fields of /proc/PID/stat.
+type procStat struct {
+	ppid int
+	// utime, stime, cutime, and cstime are in clock ticks (userHZ)
+	utime, stime, cutime, cstime uint64
+	// rss is in pages
+	rss uint64
+}
+
+// parseProcStat parses /proc/PID/stat.
+func parseProcStat(s string) (*procStat, error) {
+	// the comm (the 2nd field) is enclosed in the parentheses, and may contain spaces and parentheses
+	i := strings.LastIndex(s, ")")
+	if i < 0 {
+		return nil, errors.Errorf("unexpected stat %q", s)
+	}
+	// fields[0] is the 3rd field (state)
+	fields := strings.Fields(s[i+1:])
+	if len(fields) < 22 {
+		return nil, errors.Errorf("unexpected stat %q", s)
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, errors.Wrapf(err, "unexpected ppid in stat %q", s)
+	}
+	st := &procStat{ppid: ppid}
+	for _, f := range []struct {
+		name  string
+		index int
+		p     *uint64
+	}{
+		{"utime", 11, &st.utime},
+		{"stime", 12, &st.stime},
+		{"cutime", 13, &st.cutime},
+		{"cstime", 14, &st.cstime},
+		{"rss", 21, &st.rss},
+	} {
+		if *f.p, err = strconv.ParseUint(fields[f.index], 10, 64); err != nil {
+			return nil, errors.Wrapf(err, "unexpected %s in stat %q", f.name, s)
+		}
+	}
+	return st, nil
+}
+
+// parseProcStatCPUTime parses utime and stime (the 14th and the 15th fields) of /proc/PID/stat.
+func parseProcStatCPUTime(s string) (uint64, uint64, error) {
+	st, err := parseProcStat(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	return st.utime, st.stime, nil
+}
+
+// parseProcStatusRSS parses VmRSS of /proc/PID/status, and returns it in bytes.
+func parseProcStatusRSS(r io.Reader) (uint64, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "VmRSS:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "VmRSS:"))
+		if len(fields) != 2 || fields[1] != "kB" {
+			return 0, errors.Errorf("unexpected line %q", line)
+		}
+		kb, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "unexpected line %q", line)
+		}
+		return kb * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	// e.g. zombie
+	return 0, errors.New("VmRSS not found")
+}
@@ -0,0 +1,181 @@
+package procutil
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseProcStatCPUTime(t *testing.T) {
+	testCases := []struct {
+		s     string
+		utime uint64
+		stime uint64
+		err   bool
+	}{
+		{
+			s:     "4242 (slirp4netns) S 4200 4242 4200 0 -1 4194560 1234 0 0 0 123 456 0 0 20 0 1 0 98765 12345678 2048 18446744073709551615\n",
+			utime: 123,
+			stime: 456,
+		},
+		{
+			// comm with spaces and parentheses
+			s:     "4242 (a) b (c) S 4200 4242 4200 0 -1 4194560 1234 0 0 0 7 8 0 0 20 0 1 0 98765 12345678 2048\n",
+			utime: 7,
+			stime: 8,
+		},
+		{s: "4242 (slirp4netns) S 4200", err: true},
+		{s: "", err: true},
+	}
+	for i, tc := range testCases {
+		utime, stime, err := parseProcStatCPUTime(tc.s)
+		if tc.err {
+			if err == nil {
+				t.Errorf("#%d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if utime != tc.utime || stime != tc.stime {
+			t.Errorf("#%d: expected (%d, %d), got (%d, %d)", i, tc.utime, tc.stime, utime, stime)
+		}
+	}
+}
+
+func TestParseProcStatusRSS(t *testing.T) {
+	s := "Name:\tslirp4netns\nVmPeak:\t   12345 kB\nVmRSS:\t    2048 kB\nThreads:\t1\n"
+	rss, err := parseProcStatusRSS(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rss != 2048*1024 {
+		t.Fatalf("unexpected RSS %d", rss)
+	}
+	if _, err := parseProcStatusRSS(strings.NewReader("Name:\tzombie\nState:\tZ (zombie)\n")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestHelperUsage(t *testing.T) {
+	info, err := HelperUsage(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PID != os.Getpid() || info.RSS == 0 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+}
+
+func TestParseProcStat(t *testing.T) {
+	s := "4242 (sh) S 4200 4242 4200 0 -1 4194560 1234 0 0 0 7 8 9 10 20 0 1 0 98765 12345678 2048\n"
+	st, err := parseProcStat(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := procStat{ppid: 4200, utime: 7, stime: 8, cutime: 9, cstime: 10, rss: 2048}
+	if *st != expected {
+		t.Fatalf("expected %+v, got %+v", expected, *st)
+	}
+	// lacks rss
+	if _, err := parseProcStat("4242 (sh) S 4200 4242 4200 0 -1 4194560 1234 0 0 0 7 8 9 10 20 0 1 0 98765 12345678\n"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestSumTreeUsage(t *testing.T) {
+	stats := map[int]*procStat{
+		1:  {ppid: 0, utime: 100, stime: 100, rss: 1000},
+		10: {ppid: 1, utime: 1, stime: 2, cutime: 30, cstime: 40, rss: 1},
+		11: {ppid: 10, utime: 100, stime: 200, rss: 2},
+		12: {ppid: 10, utime: 300, stime: 400, rss: 3},
+		13: {ppid: 12, utime: 500, stime: 600, rss: 4},
+		20: {ppid: 1, utime: 1000, stime: 1000, rss: 1000},
+	}
+	children := make(map[int][]int)
+	for pid, st := range stats {
+		children[st.ppid] = append(children[st.ppid], pid)
+	}
+	usage := sumTreeUsage(10, stats, children, 4096)
+	if usage.Processes != 4 {
+		t.Errorf("expected 4 processes, got %d", usage.Processes)
+	}
+	if usage.RSS != 10*4096 {
+		t.Errorf("unexpected RSS %d", usage.RSS)
+	}
+	if usage.UserCPUSeconds != 9.31 || usage.SystemCPUSeconds != 12.42 {
+		t.Errorf("unexpected CPU time %f, %f", usage.UserCPUSeconds, usage.SystemCPUSeconds)
+	}
+}
+
+func TestTreeUsage(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 10 & sleep 10 & wait")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	for i := 0; ; i++ {
+		usage, err := TreeUsage(cmd.Process.Pid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if usage.Processes == 3 {
+			if usage.RSS == 0 {
+				t.Fatalf("unexpected usage %+v", usage)
+			}
+			break
+		}
+		if i == 100 {
+			t.Fatalf("expected 3 processes, got %+v", usage)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, err := TreeUsage(-1); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestTreeUsageSkipsUnparsable(t *testing.T) {
+	procDir, err := ioutil.TempDir("", "test-procutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(procDir)
+	for pid, stat := range map[string]string{
+		"10": "10 (sh) S 1 10 10 0 -1 4194560 1234 0 0 0 100 200 0 0 20 0 1 0 98765 12345678 1000\n",
+		"11": "11 (sleep) S 10 10 10 0 -1 4194560 1234 0 0 0 1 2 0 0 20 0 1 0 98765 12345678 10\n",
+		// truncated, e.g. exiting
+		"12": "12 (exiting) Z 1",
+		// not a process
+		"self": "",
+	} {
+		if err := os.Mkdir(filepath.Join(procDir, pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(procDir, pid, "stat"), []byte(stat), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usage, err := treeUsage(procDir, 10, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Processes != 2 || usage.RSS != 1010*4096 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+	if _, err := treeUsage(procDir, 12, 4096); err == nil {
+		t.Fatal("expected an error for the unparsable process")
+	}
+}
@@ -0,0 +1,254 @@
+// Package seccomp provides the minimal seccomp filter for the helper processes such as slirp4netns,
+// without depending on libseccomp.
+//
+// The filter is installed by re-executing RootlessKit as a shim (see Shim) just before executing the helper,
+// so the helper is confined even when its own seccomp support is unavailable.
+package seccomp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+//go:generate ../../hack/generate-seccomp-syscalls.sh
+
+// ShimEnvKey is set to the profile path when RootlessKit is re-executed as the shim for installing the seccomp filter.
+const ShimEnvKey = "_ROOTLESSKIT_SECCOMP_SHIM_UNDOCUMENTED"
+
+// Action is the action of the profile, in the format of the OCI runtime spec.
+type Action string
+
+const (
+	ActKill        Action = "SCMP_ACT_KILL"
+	ActKillProcess Action = "SCMP_ACT_KILL_PROCESS"
+	ActTrap        Action = "SCMP_ACT_TRAP"
+	ActErrno       Action = "SCMP_ACT_ERRNO"
+	ActLog         Action = "SCMP_ACT_LOG"
+	ActAllow       Action = "SCMP_ACT_ALLOW"
+)
+
+// the return values of seccomp filters, from <linux/seccomp.h>
+const (
+	retKillProcess = 0x80000000
+	retKillThread  = 0x00000000
+	retTrap        = 0x00030000
+	retErrno       = 0x00050000
+	retLog         = 0x7ffc0000
+	retAllow       = 0x7fff0000
+)
+
+// auditArches are the AUDIT_ARCH_* values of <linux/audit.h>
+var auditArches = map[string]uint32{
+	"amd64": 0xc000003e,
+	"arm64": 0xc00000b7,
+}
+
+// scmpArches maps the SCMP_ARCH_* names of the profile to GOARCH.
+// Only the native architecture of RootlessKit is supported.
+var scmpArches = map[string]string{
+	"SCMP_ARCH_X86_64":  "amd64",
+	"SCMP_ARCH_AARCH64": "arm64",
+}
+
+// x32SyscallBit is set in the syscall numbers of the x32 ABI on amd64.
+const x32SyscallBit = 0x40000000
+
+// Profile is the subset of the seccomp profile of the OCI runtime spec (and Docker).
+// Conditions on the syscall arguments are not supported.
+// Architectures may contain only the native architecture, as the filter is compiled for the native syscall table.
+type Profile struct {
+	DefaultAction   Action    `json:"defaultAction"`
+	DefaultErrnoRet *uint     `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string  `json:"architectures,omitempty"`
+	Syscalls        []Syscall `json:"syscalls,omitempty"`
+}
+
+// Syscall is the rule for the syscalls.
+type Syscall struct {
+	Names    []string      `json:"names"`
+	Action   Action        `json:"action"`
+	ErrnoRet *uint         `json:"errnoRet,omitempty"`
+	Args     []interface{} `json:"args,omitempty"`
+}
+
+// LoadProfile loads the profile from the JSON file.
+func LoadProfile(path string) (*Profile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read seccomp profile %q", path)
+	}
+	var p Profile
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse seccomp profile %q", path)
+	}
+	if _, err := p.compile(); err != nil {
+		return nil, errors.Wrapf(err, "invalid seccomp profile %q", path)
+	}
+	return &p, nil
+}
+
+func ret(act Action, errnoRet *uint) (uint32, error) {
+	switch act {
+	case ActKill:
+		return retKillThread, nil
+	case ActKillProcess:
+		return retKillProcess, nil
+	case ActTrap:
+		return retTrap, nil
+	case ActErrno:
+		errno := uint(unix.EPERM)
+		if errnoRet != nil {
+			errno = *errnoRet
+		}
+		if errno > 0xffff {
+			return 0, errors.Errorf("invalid errno %d", errno)
+		}
+		return retErrno | uint32(errno), nil
+	case ActLog:
+		return retLog, nil
+	case ActAllow:
+		return retAllow, nil
+	default:
+		return 0, errors.Errorf("unsupported action %q", act)
+	}
+}
+
+func stmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func jump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// compile compiles the profile to the BPF program for the native architecture.
+// The syscall names unknown to the architecture are ignored, as in libseccomp.
+func (p *Profile) compile() ([]unix.SockFilter, error) {
+	arch, ok := auditArches[runtime.GOARCH]
+	if !ok || syscalls == nil {
+		return nil, errors.Errorf("seccomp profiles are not supported on %s", runtime.GOARCH)
+	}
+	defaultRet, err := ret(p.DefaultAction, p.DefaultErrnoRet)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid defaultAction")
+	}
+	for _, a := range p.Architectures {
+		if scmpArches[a] != runtime.GOARCH {
+			return nil, errors.Errorf("unsupported architecture %q (only the native architecture of %s is supported)", a, runtime.GOARCH)
+		}
+	}
+	const (
+		offsetNR   = 0 // offsetof(struct seccomp_data, nr)
+		offsetArch = 4 // offsetof(struct seccomp_data, arch)
+	)
+	prog := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, retKillProcess),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetNR),
+	}
+	if runtime.GOARCH == "amd64" {
+		// the x32 syscalls share AUDIT_ARCH_X86_64, with the different numbers.
+		// they are killed as in libseccomp, as the rules for the native numbers would be bypassed otherwise.
+		prog = append(prog,
+			jump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, 0, 1),
+			stmt(unix.BPF_RET|unix.BPF_K, retKillProcess),
+		)
+	}
+	// the first rule wins for the duplicated names
+	seen := make(map[uint32]bool)
+	execveAllowed := defaultRet == retAllow || defaultRet == retLog
+	for _, sc := range p.Syscalls {
+		if len(sc.Args) != 0 {
+			return nil, errors.Errorf("syscall argument conditions are not supported (%v)", sc.Names)
+		}
+		r, err := ret(sc.Action, sc.ErrnoRet)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid action for %v", sc.Names)
+		}
+		for _, name := range sc.Names {
+			nr, ok := syscalls[name]
+			if !ok || seen[nr] {
+				continue
+			}
+			seen[nr] = true
+			if name == "execve" {
+				execveAllowed = r == retAllow || r == retLog
+			}
+			prog = append(prog,
+				jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0, 1),
+				stmt(unix.BPF_RET|unix.BPF_K, r),
+			)
+		}
+	}
+	if !execveAllowed {
+		// the shim executes the helper after installing the filter
+		return nil, errors.New("execve needs to be allowed")
+	}
+	prog = append(prog, stmt(unix.BPF_RET|unix.BPF_K, defaultRet))
+	if len(prog) > unix.BPF_MAXINSNS {
+		return nil, errors.Errorf("too many rules (%d instructions)", len(prog))
+	}
+	return prog, nil
+}
+
+// install installs the filter on the current thread.
+// The caller needs to lock the OS thread.
+func install(prog []unix.SockFilter) error {
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return errors.Wrap(err, "failed to set no_new_privs")
+	}
+	fprog := unix.SockFprog{
+		Len:    uint16(len(prog)),
+		Filter: &prog[0],
+	}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&fprog)), 0, 0); err != nil {
+		return errors.Wrap(err, "failed to install the seccomp filter")
+	}
+	return nil
+}
+
+// Shim installs the seccomp filter of the profile specified in ShimEnvKey, and executes args.
+// Shim does not return on success.
+func Shim(args []string) error {
+	if len(args) == 0 {
+		return errors.New("no command specified")
+	}
+	p, err := LoadProfile(os.Getenv(ShimEnvKey))
+	if err != nil {
+		return err
+	}
+	os.Unsetenv(ShimEnvKey)
+	prog, err := p.compile()
+	if err != nil {
+		return err
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	runtime.LockOSThread()
+	if err := install(prog); err != nil {
+		return err
+	}
+	return syscall.Exec(path, args, os.Environ())
+}
+
+// Wrap wraps cmd with Shim, for installing the seccomp filter of the profile before executing cmd.
+// The profile should be validated with LoadProfile in advance.
+func Wrap(cmd *exec.Cmd, profilePath string) {
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, ShimEnvKey+"="+profilePath)
+	// cmd.Args is kept, and resolved again in the shim
+	cmd.Path = "/proc/self/exe"
+}
@@ -0,0 +1,169 @@
+package seccomp
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func writeProfile(t *testing.T, dir, s string) string {
+	p := filepath.Join(dir, "profile.json")
+	if err := ioutil.WriteFile(p, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadProfile(t *testing.T) {
+	if _, ok := auditArches[runtime.GOARCH]; !ok {
+		t.Skipf("unsupported architecture %s", runtime.GOARCH)
+	}
+	tmp, err := ioutil.TempDir("", "test-seccomp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	testCases := []struct {
+		profile string
+		valid   bool
+	}{
+		{`{"defaultAction": "SCMP_ACT_ALLOW"}`, true},
+		{`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["mkdir", "mkdirat"], "action": "SCMP_ACT_ERRNO", "errnoRet": 13}]}`, true},
+		// unknown names are ignored
+		{`{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"names": ["execve", "nonexistent"], "action": "SCMP_ACT_ALLOW"}]}`, true},
+		// execve is denied
+		{`{"defaultAction": "SCMP_ACT_ERRNO"}`, false},
+		{`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["execve"], "action": "SCMP_ACT_KILL"}]}`, false},
+		{`{"defaultAction": "SCMP_ACT_NOTIFY"}`, false},
+		{`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["personality"], "action": "SCMP_ACT_ERRNO", "args": [{"index": 0, "value": 8, "op": "SCMP_CMP_EQ"}]}]}`, false},
+		{`{"defaultAction": "SCMP_ACT_ERRNO", "defaultErrnoRet": 65536}`, false},
+		{`{"defaultAction": "SCMP_ACT_ALLOW", "architectures": ["` + nativeArch() + `"]}`, true},
+		{`{"defaultAction": "SCMP_ACT_ALLOW", "architectures": ["` + nativeArch() + `", "SCMP_ARCH_X86"]}`, false},
+		{`{"defaultAction": "SCMP_ACT_ALLOW", "architectures": ["SCMP_ARCH_UNKNOWN"]}`, false},
+		{`{`, false},
+	}
+	for i, tc := range testCases {
+		_, err := LoadProfile(writeProfile(t, tmp, tc.profile))
+		if tc.valid && err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("#%d: expected an error", i)
+		}
+	}
+}
+
+func nativeArch() string {
+	for k, v := range scmpArches {
+		if v == runtime.GOARCH {
+			return k
+		}
+	}
+	return ""
+}
+
+// run evaluates the BPF program for the syscall nr, supporting only the instructions emitted by compile.
+func run(t *testing.T, prog []unix.SockFilter, arch, nr uint32) uint32 {
+	var a uint32
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			switch ins.K {
+			case 0:
+				a = nr
+			case 4:
+				a = arch
+			default:
+				t.Fatalf("unexpected offset %d", ins.K)
+			}
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			match := a == ins.K
+			if ins.Code&0xf0 == unix.BPF_JGE {
+				match = a >= ins.K
+			}
+			if match {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		default:
+			t.Fatalf("unexpected instruction %+v", ins)
+		}
+	}
+	t.Fatal("the program did not return")
+	return 0
+}
+
+func TestCompile(t *testing.T) {
+	arch, ok := auditArches[runtime.GOARCH]
+	if !ok {
+		t.Skipf("unsupported architecture %s", runtime.GOARCH)
+	}
+	p := Profile{
+		DefaultAction: ActAllow,
+		Syscalls:      []Syscall{{Names: []string{"mount"}, Action: ActErrno}},
+	}
+	prog, err := p.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mount := syscalls["mount"]
+	if r := run(t, prog, arch, mount); r != retErrno|uint32(unix.EPERM) {
+		t.Errorf("mount: expected EPERM, got 0x%x", r)
+	}
+	if r := run(t, prog, arch, syscalls["read"]); r != retAllow {
+		t.Errorf("read: expected to be allowed, got 0x%x", r)
+	}
+	if r := run(t, prog, arch+1, syscalls["read"]); r != retKillProcess {
+		t.Errorf("foreign arch: expected to be killed, got 0x%x", r)
+	}
+	if runtime.GOARCH == "amd64" {
+		// the x32 alias of the denied syscall must not be allowed by the default action
+		if r := run(t, prog, arch, mount|x32SyscallBit); r != retKillProcess {
+			t.Errorf("x32 mount: expected to be killed, got 0x%x", r)
+		}
+	}
+}
+
+// TestShim executes mkdir with the profile that denies mkdir.
+func TestShim(t *testing.T) {
+	if _, ok := auditArches[runtime.GOARCH]; !ok {
+		t.Skipf("unsupported architecture %s", runtime.GOARCH)
+	}
+	if p := os.Getenv(ShimEnvKey); p != "" {
+		// re-executed below
+		err := Shim([]string{"mkdir", os.Getenv("TEST_SECCOMP_DIR")})
+		t.Fatal(err)
+	}
+	if err := unix.Prctl(unix.PR_GET_SECCOMP, 0, 0, 0, 0); err != nil {
+		t.Skipf("seccomp is not available: %v", err)
+	}
+	if _, err := exec.LookPath("mkdir"); err != nil {
+		t.Skip(err)
+	}
+	tmp, err := ioutil.TempDir("", "test-seccomp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	profile := writeProfile(t, tmp, `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["mkdir", "mkdirat"], "action": "SCMP_ACT_ERRNO"}]}`)
+	dir := filepath.Join(tmp, "dir")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestShim$")
+	cmd.Env = append(os.Environ(), ShimEnvKey+"="+profile, "TEST_SECCOMP_DIR="+dir)
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("expected mkdir to fail, output: %q", out)
+	}
+	t.Logf("mkdir failed as expected: %v, output: %q", err, out)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected %s not to be created, got %v", dir, err)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package seccomp
+
+// syscalls is not generated for this architecture, so profiles cannot be loaded.
+var syscalls map[string]uint32
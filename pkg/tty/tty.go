@@ -0,0 +1,159 @@
+// Package tty provides pseudo terminal utilities for --tty.
+package tty
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// IsTerminal returns true if f is a terminal.
+func IsTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// OpenPTY opens a new pseudo terminal pair.
+func OpenPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, errors.Wrap(err, "failed to unlock pty")
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, errors.Wrap(err, "failed to get pty number")
+	}
+	slavePath := "/dev/pts/" + strconv.Itoa(n)
+	slave, err = os.OpenFile(slavePath, os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, errors.Wrapf(err, "failed to open %s", slavePath)
+	}
+	return master, slave, nil
+}
+
+// MakeRaw puts the terminal f into raw mode, and returns the previous state.
+func MakeRaw(f *os.File) (*unix.Termios, error) {
+	fd := int(f.Fd())
+	old, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	// cfmakeraw(3)
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// Restore restores the terminal state returned by MakeRaw.
+func Restore(f *os.File, state *unix.Termios) error {
+	return unix.IoctlSetTermios(int(f.Fd()), unix.TCSETS, state)
+}
+
+// CopyWinsize copies the window size of the terminal src to the terminal dst.
+func CopyWinsize(dst, src *os.File) error {
+	ws, err := unix.IoctlGetWinsize(int(src.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return err
+	}
+	return unix.IoctlSetWinsize(int(dst.Fd()), unix.TIOCSWINSZ, ws)
+}
+
+// Console relays the current terminal to the pty master.
+type Console struct {
+	master   *os.File
+	terminal *os.File
+	state    *unix.Termios
+	sigCh    chan os.Signal
+	outDone  chan struct{}
+	restore  sync.Once
+}
+
+// NewConsole puts terminal into raw mode, and starts relaying terminal to master.
+//
+// SIGTTOU and SIGTTIN are ignored until Close is called, so that RootlessKit is not stopped
+// when it is in a background process group of the terminal.
+// SIGWINCH is propagated to master.
+//
+// Close MUST be called to restore the terminal, even when the child crashed.
+func NewConsole(master, terminal *os.File, out io.Writer) (*Console, error) {
+	signal.Ignore(unix.SIGTTOU, unix.SIGTTIN)
+	if err := CopyWinsize(master, terminal); err != nil {
+		logrus.WithError(err).Debug("failed to copy the window size")
+	}
+	state, err := MakeRaw(terminal)
+	if err != nil {
+		signal.Reset(unix.SIGTTOU, unix.SIGTTIN)
+		return nil, errors.Wrap(err, "failed to put the terminal into raw mode")
+	}
+	c := &Console{
+		master:   master,
+		terminal: terminal,
+		state:    state,
+		sigCh:    make(chan os.Signal, 1),
+		outDone:  make(chan struct{}),
+	}
+	signal.Notify(c.sigCh, unix.SIGWINCH)
+	go func() {
+		for range c.sigCh {
+			if err := CopyWinsize(master, terminal); err != nil {
+				logrus.WithError(err).Debug("failed to copy the window size")
+			}
+		}
+	}()
+	go io.Copy(master, terminal)
+	go func() {
+		// returns EIO when all the slave fds are closed
+		io.Copy(out, master)
+		close(c.outDone)
+	}()
+	return c, nil
+}
+
+// Close waits for the output to be flushed, and restores the terminal.
+// Close needs to be called after the child exits.
+func (c *Console) Close() error {
+	select {
+	case <-c.outDone:
+	case <-time.After(closeTimeout):
+		// the slave may be still opened by orphan processes
+		logrus.Debug("timed out waiting for the pty output")
+	}
+	return c.Restore()
+}
+
+const closeTimeout = time.Second
+
+// Restore restores the terminal without waiting for the output.
+// Restore is safe to be called multiple times.
+func (c *Console) Restore() error {
+	var err error
+	c.restore.Do(func() {
+		signal.Stop(c.sigCh)
+		close(c.sigCh)
+		err = Restore(c.terminal, c.state)
+		signal.Reset(unix.SIGTTOU, unix.SIGTTIN)
+	})
+	return err
+}
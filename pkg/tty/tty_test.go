@@ -0,0 +1,67 @@
+package tty
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func getTermios(t *testing.T, fd uintptr) *unix.Termios {
+	st, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestConsoleRestore(t *testing.T) {
+	// the slave of the pty pair plays the role of the user's terminal
+	master, slave, err := OpenPTY()
+	if err != nil {
+		t.Skipf("pty is not available: %v", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+	if !IsTerminal(slave) {
+		t.Fatal("expected the slave to be a terminal")
+	}
+	orig := getTermios(t, slave.Fd())
+	if orig.Lflag&unix.ICANON == 0 {
+		t.Fatal("expected the new pty to be in canonical mode")
+	}
+
+	// the pty for the child
+	childMaster, childSlave, err := OpenPTY()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer childMaster.Close()
+	c, err := NewConsole(childMaster, slave, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := getTermios(t, slave.Fd())
+	if raw.Lflag&(unix.ICANON|unix.ECHO|unix.ISIG) != 0 {
+		t.Fatalf("expected raw mode, got lflag=0x%x", raw.Lflag)
+	}
+	// simulate the crash of the child
+	childSlave.Close()
+	select {
+	case <-c.outDone:
+	case <-time.After(3 * time.Second):
+		t.Fatal("outDone timeout")
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if restored := getTermios(t, slave.Fd()); !reflect.DeepEqual(orig, restored) {
+		t.Fatalf("expected %+v, got %+v", orig, restored)
+	}
+	// Restore is idempotent
+	if err := c.Restore(); err != nil {
+		t.Fatal(err)
+	}
+}
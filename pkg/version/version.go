@@ -1,3 +1,50 @@
 package version
 
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
 const Version = "0.7.1+dev"
+
+// GitCommit is set via `-ldflags "-X github.com/rootless-containers/rootlesskit/pkg/version.GitCommit=..."`
+var GitCommit = ""
+
+// Info is the structured version information.
+type Info struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit,omitempty"`
+	GoVersion string `json:"goVersion"`
+	// Helpers maps helper names (e.g. "slirp4netns") to their versions.
+	// Helpers that are not installed are omitted.
+	Helpers map[string]string `json:"helpers,omitempty"`
+}
+
+// GetInfo returns Info without Helpers.
+func GetInfo() *Info {
+	return &Info{
+		Version:   Version,
+		GitCommit: GitCommit,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// DetectHelperVersion executes `binary --version` and returns the first line of the output.
+// "<name> version " prefix is trimmed, e.g. "slirp4netns version 0.4.2" is returned as "0.4.2".
+func DetectHelperVersion(binary string) (string, error) {
+	realBinary, err := exec.LookPath(binary)
+	if err != nil {
+		return "", err
+	}
+	b, err := exec.Command(realBinary, "--version").CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "command \"%s --version\" failed: %q", realBinary, string(b))
+	}
+	line := strings.TrimSpace(strings.SplitN(string(b), "\n", 2)[0])
+	line = strings.TrimPrefix(line, filepath.Base(realBinary)+" version ")
+	return line, nil
+}